@@ -6,6 +6,7 @@ import (
 	"xl/fs"
 	"xl/fs/bufcsv"
 	"xl/fs/bufxlsx"
+	"xl/script"
 	"xl/ui"
 
 	"errors"
@@ -30,9 +31,27 @@ type App struct {
 	doc     *document.Document
 	file    fs.FileInterface
 	hotKeys map[Key]string
-
-	// Keeps the cell for copy/cut/paste operations.
-	cellBuffer *sheet.Cell
+	script  *script.Engine
+
+	// Keeps the cells for copy/cut/paste operations, it is the unnamed register as well.
+	cellBuffer *cellBuffer
+	// Named registers "a-"z.
+	registers map[rune]*cellBuffer
+	// State of vim-like command being typed.
+	keymap keymap
+	// The last search repeated by n and N.
+	search searchState
+	// The current window and the layout of all windows on the screen.
+	window *window
+	layout *windowLayout
+	// State of the mouse button being held.
+	mouse mouseState
+	// Sheets whose cells breaking validation rules are marked, see :validate.
+	validating map[*sheet.Sheet]bool
+	// Options of fitting the widths of columns.
+	autofit autofitOptions
+	// References of the formula being edited, nil if no formula is edited.
+	pointer *formulaPointer
 }
 
 type Config struct {
@@ -44,20 +63,44 @@ type Config struct {
 
 func New(config *Config) *App {
 	a := &App{
-		screen:  config.Screen,
-		logger:  config.Logger,
-		input:   config.Input,
-		output:  config.Output,
-		hotKeys: make(map[Key]string),
+		screen:     config.Screen,
+		logger:     config.Logger,
+		input:      config.Input,
+		output:     config.Output,
+		hotKeys:    make(map[Key]string),
+		registers:  make(map[rune]*cellBuffer),
+		validating: make(map[*sheet.Sheet]bool),
+		// patterns are regular expressions like in vim
+		search:  searchState{opts: document.SearchOptions{Regexp: true}},
+		autofit: autofitOptions{max: defaultAutofitMax},
 	}
+	a.resetWindows()
+	a.script = script.New(a)
 	a.output.SetDataDelegate(a)
+	a.bindDefaultHotKeys()
 	a.loadRC()
 	return a
 }
 
+// Close frees resources held by application.
+func (a *App) Close() {
+	a.script.Close()
+}
+
+// Document returns the document being edited.
+func (a *App) Document() *document.Document {
+	return a.doc
+}
+
+// ShowStatus displays a message in status line.
+func (a *App) ShowStatus(msg string) {
+	a.output.SetStatus(msg, 0)
+}
+
 // ResetDocument creates a new empty document.
 func (a *App) ResetDocument() {
 	a.doc = document.NewWithEmptySheet()
+	a.resetWindows()
 	a.output.SetDataDelegate(a)
 	a.output.RefreshView()
 }
@@ -77,6 +120,10 @@ func (a *App) OpenDocument(filename string) error {
 		a.doc.CurrentSheet = a.doc.Sheets[0]
 		a.doc.CurrentSheetN = 0
 	}
+	a.autofitSheets()
+	// reading the file is not a change which can be undone
+	a.doc.ClearHistory()
+	a.resetWindows()
 	a.output.RefreshView()
 	return nil
 }
@@ -115,7 +162,9 @@ func (a *App) Loop() {
 		case *tcell.EventResize:
 			a.output.RefreshView()
 		case *tcell.EventMouse:
-			//handling event mouse
+			x, y := ev.Position()
+			a.processMouseEvent(ui.MouseEvent{X: x, Y: y, Buttons: ev.Buttons(), Mod: ev.Modifiers()})
+			a.output.RefreshView()
 		case *tcell.EventError:
 			a.logger.Error("unknown input event")
 			return
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"xl/document"
+	"xl/document/sheet"
+	"xl/ui"
+)
+
+// Вывод для тестов: запоминает строку состояния, находит точки экрана функцией locate
+// и показывает 20 строк на 10 колонок, остальные методы не должны вызываться.
+type testOutput struct {
+	ui.OutputInterface
+	status      string
+	statusFlags int
+	locate      func(x, y int) ui.Location
+}
+
+func (o *testOutput) SetStatus(msg string, flags int) {
+	o.status, o.statusFlags = msg, flags
+}
+
+func (o *testOutput) SetDirty(ui.DirtyFlag) {}
+
+func (o *testOutput) Locate(x, y int) ui.Location {
+	return o.locate(x, y)
+}
+
+func (o *testOutput) ViewportHeight() int { return 20 }
+
+func (o *testOutput) ViewportWidth() int { return 10 }
+
+// newTestApp returns the application showing the document without a screen.
+func newTestApp(d *document.Document) (*App, *testOutput) {
+	o := &testOutput{}
+	a := &App{
+		output:     o,
+		doc:        d,
+		validating: make(map[*sheet.Sheet]bool),
+		autofit:    autofitOptions{max: defaultAutofitMax},
+	}
+	a.resetWindows()
+	return a, o
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"xl/document/eval"
+	"xl/document/sheet"
+	"xl/ui"
+
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Подбор размеров строк и колонок по значениям ячеек. Высота строки - число строк текста
+// самого длинного значения: перенесенного по словам, если у ячейки включен перенос, или
+// разбитого на строки переводами строк. Ширина колонки - ширина самого широкого
+// отображаемого значения в знакоместах терминала, где иероглифы и другие широкие символы
+// занимают по два знакоместа.
+
+// Ширина колонки по умолчанию ограничена 40 знаками.
+const defaultAutofitMax = 40
+
+// Параметры подбора ширины колонок, задаваемые :autofitOptions.
+type autofitOptions struct {
+	// Наибольшая ширина колонки в знаках.
+	max int
+	// Подбирать ширину всех колонок при открытии файла.
+	onOpen bool
+}
+
+// cmdAutofit sets the width of the column under cursor, of the columns the selection
+// spans, or of all columns of the sheet with "sheet" argument, so their values fit.
+func (a *App) cmdAutofit(arg string) {
+	s := a.doc.CurrentSheet
+	r := s.SelectedRect()
+	switch arg {
+	case "":
+	case "sheet":
+		r = s.Size
+	default:
+		a.output.SetStatus(fmt.Sprintf("unknown autofit argument %s", arg), ui.StatusFlagError)
+		return
+	}
+	for x := r.X; x <= r.MaxX(); x++ {
+		if width, ok := a.columnWidth(s, x); ok {
+			a.doc.SetColSize(x, width)
+		}
+	}
+	s.Unselect()
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyGrid)
+}
+
+// cmdAutofitOptions sets the options of :autofit: "max N" limits the width of a column
+// by N chars, "open" and "noopen" turn on and off fitting of all columns when a file
+// is opened. Without arguments shows the current options.
+func (a *App) cmdAutofitOptions(args []string) {
+	opts := &a.autofit
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "open":
+			opts.onOpen = true
+		case "noopen":
+			opts.onOpen = false
+		case "max":
+			if i+1 >= len(args) {
+				a.output.SetStatus("max width is not given", ui.StatusFlagError)
+				return
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 || n*colSizeIncrementStep > sheet.CellMaxWidth {
+				a.output.SetStatus(fmt.Sprintf("invalid max width %s", args[i]), ui.StatusFlagError)
+				return
+			}
+			opts.max = n
+		default:
+			a.output.SetStatus(fmt.Sprintf("unknown autofit option %s", args[i]), ui.StatusFlagError)
+			return
+		}
+	}
+	status := fmt.Sprintf("max %d noopen", opts.max)
+	if opts.onOpen {
+		status = fmt.Sprintf("max %d open", opts.max)
+	}
+	a.output.SetStatus(status, 0)
+}
+
+// autofitSheets fits the widths of all columns of the document if the options ask
+// to do it on open. The widths are not recorded in the history.
+func (a *App) autofitSheets() {
+	if !a.autofit.onOpen {
+		return
+	}
+	for _, s := range a.doc.Sheets {
+		for x := s.Size.X; x < s.Size.X+s.Size.Width; x++ {
+			if width, ok := a.columnWidth(s, x); ok {
+				s.SetColSize(x, width)
+			}
+		}
+	}
+}
+
+// columnWidth returns the width of the column in pixels its widest displayed value
+// needs, limited by the options. Wrapped and merged cells do not count. Reports false
+// if the column has no values to fit.
+func (a *App) columnWidth(s *sheet.Sheet, x int) (int, bool) {
+	ec := eval.NewContext(a.doc, s.Idx)
+	chars := 0
+	for y := s.Size.Y; y < s.Size.Y+s.Size.Height; y++ {
+		c := s.Cell(x, y)
+		if c == nil {
+			continue
+		}
+		if _, ok := s.MergeAt(x, y); ok {
+			continue
+		}
+		st := s.CellStyle(x, y)
+		if st.Wrap {
+			continue
+		}
+		text := ""
+		if v, err := c.DisplayValue(ec); err != nil {
+			text = err.Error()
+		} else {
+			text = v.Text
+		}
+		for _, line := range strings.Split(text, "\n") {
+			w := runewidth.StringWidth(line)
+			// borders take a char of the cell each
+			if st.Borders&sheet.BorderLeft != 0 {
+				w++
+			}
+			if st.Borders&sheet.BorderRight != 0 {
+				w++
+			}
+			if w > chars {
+				chars = w
+			}
+		}
+	}
+	if chars == 0 {
+		return 0, false
+	}
+	// one more char separates the value from the next column
+	chars++
+	if chars > a.autofit.max {
+		chars = a.autofit.max
+	}
+	return chars * colSizeIncrementStep, true
+}
+
+// cmdFitHeight sets the height of the rows the selection spans so the values of their
+// cells fit.
+func (a *App) cmdFitHeight() {
+	s := a.doc.CurrentSheet
+	r := s.SelectedRect().Intersect(sheet.Rect{Width: s.Size.X + s.Size.Width, Height: s.Size.Y + s.Size.Height})
+	for y := r.Y; y < r.Y+r.Height; y++ {
+		a.doc.SetRowSize(y, sheet.LinesHeight(a.rowTextLines(s, y)))
+	}
+	s.Unselect()
+	a.output.SetDirty(ui.DirtyVRuler | ui.DirtyGrid)
+}
+
+// growRowHeight makes the row of the current sheet higher if the values of its cells
+// do not fit. Rows are never made lower, so the height set by user is kept.
+func (a *App) growRowHeight(y int) {
+	s := a.doc.CurrentSheet
+	if n := a.rowTextLines(s, y); n > sheet.RowLines(s.RowSize(y)) {
+		a.doc.SetRowSize(y, sheet.LinesHeight(n))
+	}
+}
+
+// rowTextLines returns the number of lines of text the values of the row take.
+// Merged cells are skipped as their values span several rows or columns.
+func (a *App) rowTextLines(s *sheet.Sheet, y int) int {
+	ec := eval.NewContext(a.doc, s.Idx)
+	lines := 1
+	for x := s.Size.X; x < s.Size.X+s.Size.Width; x++ {
+		c := s.Cell(x, y)
+		if c == nil {
+			continue
+		}
+		if _, ok := s.MergeAt(x, y); ok {
+			continue
+		}
+		v, err := c.DisplayValue(ec)
+		if err != nil {
+			continue
+		}
+		n := strings.Count(v.Text, "\n") + 1
+		if s.CellStyle(x, y).Wrap {
+			n = len(ui.WrapText(v.Text, s.ColSize(x)/colSizeIncrementStep))
+		}
+		if n > lines {
+			lines = n
+		}
+	}
+	return lines
+}
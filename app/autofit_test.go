@@ -0,0 +1,99 @@
+package app
+
+import (
+	"xl/document"
+	"xl/document/sheet"
+	"xl/ui"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColumnWidth(t *testing.T) {
+	d := document.NewWithEmptySheet()
+	s := d.CurrentSheet
+	a, _ := newTestApp(d)
+	// wide characters take two chars each
+	d.SetCellValue(s, 0, 0, "abc")
+	d.SetCellValue(s, 0, 1, "汉字表")
+	// borders take a char each
+	d.SetCellValue(s, 1, 0, "abcd")
+	d.SetStyle(s, sheet.Rect{X: 1, Y: 0, Width: 1, Height: 1}, func(st *sheet.Style) {
+		st.Borders = sheet.BorderLeft | sheet.BorderRight
+	})
+	// wrapped and merged cells do not count
+	d.SetCellValue(s, 2, 0, "a very long wrapped value")
+	d.SetStyle(s, sheet.Rect{X: 2, Y: 0, Width: 1, Height: 1}, func(st *sheet.Style) {
+		st.Wrap = true
+	})
+	d.SetCellValue(s, 2, 1, "ab")
+	d.SetCellValue(s, 3, 0, "a very long merged value")
+	d.Merge(s, sheet.Rect{X: 3, Y: 0, Width: 2, Height: 1})
+	d.SetCellValue(s, 3, 1, "x")
+	// the widest line of multi-line value
+	d.SetCellValue(s, 5, 0, "ab\nabcdefgh\nabc")
+	d.SetCellValue(s, 6, 0, "abcdefghijklmnopqrstuvwxyz abcdefghijklmnopqrstuvwxyz")
+
+	testCases := []struct {
+		x     int
+		chars int
+	}{
+		{0, 7},
+		{1, 7},
+		{2, 3},
+		{3, 2},
+		{5, 9},
+		{6, defaultAutofitMax},
+	}
+	for _, c := range testCases {
+		width, ok := a.columnWidth(s, c.x)
+		assert.Truef(t, ok, "column %d", c.x)
+		assert.Equalf(t, c.chars*colSizeIncrementStep, width, "column %d", c.x)
+	}
+	_, ok := a.columnWidth(s, 4)
+	assert.False(t, ok)
+
+	a.autofit.max = 5
+	width, _ := a.columnWidth(s, 0)
+	assert.Equal(t, 5*colSizeIncrementStep, width)
+}
+
+func TestAutofitOptions(t *testing.T) {
+	a, o := newTestApp(document.NewWithEmptySheet())
+	a.cmdAutofitOptions(nil)
+	assert.Equal(t, "max 40 noopen", o.status)
+	a.cmdAutofitOptions([]string{"max", "10", "open"})
+	assert.Equal(t, autofitOptions{max: 10, onOpen: true}, a.autofit)
+	assert.Equal(t, "max 10 open", o.status)
+	a.cmdAutofitOptions([]string{"noopen"})
+	assert.Equal(t, autofitOptions{max: 10}, a.autofit)
+
+	for _, args := range [][]string{{"max"}, {"max", "0"}, {"max", "x"}, {"max", "134"}, {"wide"}} {
+		o.statusFlags = 0
+		a.cmdAutofitOptions(args)
+		assert.Equal(t, ui.StatusFlagError, o.statusFlags, args)
+		assert.Equal(t, 10, a.autofit.max, args)
+	}
+}
+
+func TestAutofitSheetsOnOpen(t *testing.T) {
+	d := document.NewWithEmptySheet()
+	s1 := d.CurrentSheet
+	s2, _ := d.NewSheet("")
+	d.SetCellValue(s1, 1, 0, "abcdefghij")
+	d.SetCellValue(s2, 0, 0, "abc")
+	d.ClearHistory()
+	a, _ := newTestApp(d)
+
+	a.autofitSheets()
+	assert.Equal(t, sheet.CellDefaultWidth, s1.ColSize(1))
+
+	a.autofit.onOpen = true
+	a.autofitSheets()
+	assert.Equal(t, 11*colSizeIncrementStep, s1.ColSize(1))
+	assert.Equal(t, sheet.CellDefaultWidth, s1.ColSize(0))
+	assert.Equal(t, 4*colSizeIncrementStep, s2.ColSize(0))
+	// fitting on open is not a change of the document
+	assert.False(t, d.Undo())
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"xl/document"
+	"xl/document/eval"
+	"xl/document/sheet"
+
+	"bytes"
+	"encoding/csv"
+	"strings"
+)
+
+// Буфер для операций копирования, вырезания и вставки. Хранит исходные и вычисленные
+// значения прямоугольного диапазона ячеек, так что вставленные ячейки не разделяют
+// Ссылки с ячейками, из которых скопированы. При вставке относительные ссылки в формулах
+// сдвигаются на расстояние между скопированной ячейкой и той, в которую она вставляется.
+//
+// Буфер может быть заполнен из системного буфера обмена, тогда значения вставляются как есть.
+
+// Режимы специальной вставки.
+const (
+	// Вставлять вычисленные значения вместо формул.
+	pasteValues = 1 << iota
+	// Вставлять только ячейки с формулами.
+	pasteFormulas
+	// Поменять местами строки и колонки.
+	pasteTranspose
+)
+
+type cellBuffer struct {
+	// Положение левой верхней скопированной ячейки.
+	x int
+	y int
+
+	width  int
+	height int
+
+	// Исходные и вычисленные значения ячеек построчно.
+	raw    [][]string
+	values [][]string
+
+	// Буфер заполнен из системного буфера обмена, ссылки в формулах не сдвигаются.
+	external bool
+	// Скопированы строки целиком, при вставке для них создаются новые строки.
+	linewise bool
+}
+
+// newCellBuffer copies values of the cells within rect of the sheet.
+func newCellBuffer(d *document.Document, s *sheet.Sheet, r sheet.Rect) *cellBuffer {
+	b := &cellBuffer{
+		x:      r.X,
+		y:      r.Y,
+		width:  r.Width,
+		height: r.Height,
+		raw:    make([][]string, r.Height),
+		values: make([][]string, r.Height),
+	}
+	ec := eval.NewContext(d, s.Idx)
+	for y := 0; y < r.Height; y++ {
+		b.raw[y] = make([]string, r.Width)
+		b.values[y] = make([]string, r.Width)
+		for x := 0; x < r.Width; x++ {
+			c := s.Cell(r.X+x, r.Y+y)
+			if c == nil {
+				continue
+			}
+			b.raw[y][x] = d.CellRawValue(s, r.X+x, r.Y+y)
+			v, err := c.StringValue(ec)
+			if err != nil {
+				v = err.Error()
+			}
+			b.values[y][x] = v
+		}
+	}
+	return b
+}
+
+// newCellBufferFromTSV fills the buffer with tab separated values.
+func newCellBufferFromTSV(text string) (*cellBuffer, error) {
+	r := csv.NewReader(strings.NewReader(text))
+	r.Comma = '\t'
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	b := &cellBuffer{
+		height:   len(records),
+		raw:      make([][]string, len(records)),
+		values:   make([][]string, len(records)),
+		external: true,
+	}
+	for _, rec := range records {
+		if len(rec) > b.width {
+			b.width = len(rec)
+		}
+	}
+	for y, rec := range records {
+		b.raw[y] = make([]string, b.width)
+		copy(b.raw[y], rec)
+		b.values[y] = b.raw[y]
+	}
+	return b, nil
+}
+
+// tsv returns values of the buffer as tab separated values.
+func (b *cellBuffer) tsv() string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = '\t'
+	_ = w.WriteAll(b.values)
+	return buf.String()
+}
+
+// isEmpty reports whether the buffer has no cells.
+func (b *cellBuffer) isEmpty() bool {
+	return b.width == 0 || b.height == 0
+}
+
+// paste writes values of the buffer to the sheet starting from the top left cell of rect.
+// A single cell in the buffer fills the whole rect.
+func (b *cellBuffer) paste(d *document.Document, s *sheet.Sheet, r sheet.Rect, mode int) {
+	fill := b.width == 1 && b.height == 1
+	if !fill {
+		r.Width, r.Height = b.width, b.height
+		if mode&pasteTranspose != 0 {
+			r.Width, r.Height = b.height, b.width
+		}
+	}
+	ec := eval.NewContext(d, s.Idx)
+	for y := 0; y < r.Height; y++ {
+		for x := 0; x < r.Width; x++ {
+			bx, by := x, y
+			if fill {
+				bx, by = 0, 0
+			} else if mode&pasteTranspose != 0 {
+				bx, by = y, x
+			}
+			raw := b.raw[by][bx]
+			if mode&pasteFormulas != 0 && !sheet.IsFormula(raw) {
+				continue
+			}
+			value := raw
+			if mode&pasteValues != 0 {
+				value = b.values[by][bx]
+			} else if !b.external {
+				value = sheet.OffsetRawValue(ec, raw, r.X+x-(b.x+bx), r.Y+y-(b.y+by))
+			}
+			if value == "" && s.Cell(r.X+x, r.Y+y) == nil {
+				continue
+			}
+			d.SetCellValue(s, r.X+x, r.Y+y, value)
+		}
+	}
+}
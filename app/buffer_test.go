@@ -0,0 +1,32 @@
+package app
+
+import (
+	"xl/document"
+	"xl/document/eval"
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCellBufferFilledRange(t *testing.T) {
+	d := document.NewWithEmptySheet()
+	s := d.CurrentSheet
+	for y, v := range []string{"1", "2", "3"} {
+		d.SetCellValue(s, 0, y, v)
+	}
+	d.SetCellValue(s, 1, 0, "=A1*10")
+	d.Fill(s, sheet.Rect{X: 1, Y: 0, Width: 1, Height: 3}, document.FillDown)
+
+	// the copies of the key cell of the extrapolation segment keep their formulas
+	b := newCellBuffer(d, s, sheet.Rect{X: 1, Y: 0, Width: 1, Height: 3})
+	assert.Equal(t, [][]string{{"=A1*10"}, {"=A2*10"}, {"=A3*10"}}, b.raw)
+	assert.Equal(t, [][]string{{"10"}, {"20"}, {"30"}}, b.values)
+
+	b.paste(d, s, sheet.Rect{X: 2, Y: 0, Width: 1, Height: 1}, 0)
+	assert.Equal(t, "=B3*10", d.CellRawValue(s, 2, 2))
+	v, err := s.Cell(2, 2).StringValue(eval.NewContext(d, s.Idx))
+	assert.NoError(t, err)
+	assert.Equal(t, "300", v)
+}
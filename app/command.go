@@ -1,13 +1,17 @@
 package app
 
 import (
+	"xl/document"
+	"xl/document/numfmt"
 	"xl/document/sheet"
+	"xl/formula"
 	"xl/ui"
 
 	"fmt"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 )
 
@@ -16,20 +20,64 @@ const colSizeIncrementStep = 6
 // processCommand do the job associated with the command.
 // If no such command found, shows the error in status line.
 func (a *App) processCommand(c string) bool {
+	if a.doc != nil {
+		// all changes made by a command are undone at once
+		a.doc.BeginGroup()
+		defer a.doc.EndGroup()
+	}
+	if substituteCommand.MatchString(c) {
+		a.cmdSubstitute(c)
+		return false
+	}
 	c, args := parseArgs(c)
 	switch c {
 	case "q", "quit":
-		return true
+		// the last window closes the application
+		return !a.closeWindow()
 	case "w", "write":
 		a.cmdWrite(arg1(args))
 	case "wider":
 		a.cmdResizeColumn(1)
 	case "narrower":
 		a.cmdResizeColumn(-1)
+	case "taller":
+		a.cmdResizeRow(1)
+	case "shorter":
+		a.cmdResizeRow(-1)
+	case "fitHeight":
+		a.cmdFitHeight()
+	case "autofit":
+		a.cmdAutofit(arg1(args))
+	case "autofitOptions":
+		a.cmdAutofitOptions(args)
+	case "format":
+		a.cmdFormat(strings.Join(args, " "))
+	case "style":
+		a.cmdStyle(args)
+	case "cond":
+		a.cmdCond(args)
+	case "validate":
+		a.cmdValidate(args)
+	case "merge":
+		a.cmdMerge()
+	case "unmerge":
+		a.cmdUnmerge()
 	case "newSheet":
 		a.cmdNewSheet(arg1(args))
 	case "nextSheet":
 		a.cmdNextSheet()
+	case "prevSheet":
+		a.cmdPrevSheet()
+	case "sheet":
+		a.cmdSheet(strings.Join(args, " "))
+	case "renameSheet":
+		a.cmdRenameSheet(strings.Join(args, " "))
+	case "deleteSheet":
+		a.cmdDeleteSheet()
+	case "moveSheet":
+		a.cmdMoveSheet(arg1(args))
+	case "copySheet":
+		a.cmdCopySheet(strings.Join(args, " "))
 	case "bind":
 		a.cmdBind(args)
 	case "cutCell":
@@ -38,6 +86,16 @@ func (a *App) processCommand(c string) bool {
 		a.cmdPasteCell()
 	case "copyCell":
 		a.cmdCopyCell()
+	case "pasteSpecial":
+		a.cmdPasteSpecial(args)
+	case "pasteClipboard":
+		a.cmdPasteClipboard()
+	case "clearCells":
+		a.cmdClearCells()
+	case "visual":
+		a.cmdSelect(sheet.SelectionBlock)
+	case "visualRows":
+		a.cmdSelect(sheet.SelectionRows)
 	case "insertRow":
 		a.cmdInsertRow(0)
 	case "insertRowAfter":
@@ -57,8 +115,52 @@ func (a *App) processCommand(c string) bool {
 	case "go":
 		a.cmdGo(arg1(args))
 	case "xDown":
-		a.cmdXDown()
+		a.cmdFill("down", "")
+	case "fill":
+		a.cmdFill(arg1(args), argN(args, 2))
+	case "materialize":
+		a.cmdMaterialize()
+	case "sort":
+		a.cmdSort(args)
+	case "hide":
+		a.cmdHide(arg1(args), true)
+	case "unhide":
+		a.cmdHide(arg1(args), false)
+	case "filter":
+		a.cmdFilter(args)
+	case "freeze":
+		a.cmdFreeze(arg1(args))
+	case "sp", "split":
+		a.cmdSplit(false, strings.Join(args, " "))
+	case "vs", "vsplit":
+		a.cmdSplit(true, strings.Join(args, " "))
+	case "clo", "close":
+		a.cmdClose()
+	case "on", "only":
+		a.cmdOnly()
+	case "searchNext":
+		a.cmdSearchNext(false)
+	case "searchPrev":
+		a.cmdSearchNext(true)
+	case "searchOptions":
+		a.cmdSearchOptions(args)
+	case "help":
+		a.cmdHelp(arg1(args))
+	case "source":
+		a.cmdSource(arg1(args))
+	case "lua":
+		a.cmdLua(strings.Join(args, " "))
+	case "notation":
+		a.cmdNotation(arg1(args))
+	case "u", "undo":
+		a.cmdUndo()
+	case "redo":
+		a.cmdRedo()
 	default:
+		if a.script.HasCommand(c) {
+			a.cmdScript(c, args)
+			break
+		}
 		a.output.SetStatus(fmt.Sprintf("unknown command %s", c), ui.StatusFlagError)
 	}
 	return false
@@ -89,10 +191,46 @@ func parseArgs(cmd string) (string, []string) {
 func (a *App) cmdResizeColumn(n int) {
 	col := a.doc.CurrentSheet.Cursor.X
 	size := a.doc.CurrentSheet.ColSize(col)
-	a.doc.CurrentSheet.SetColSize(col, size+n*colSizeIncrementStep)
+	a.doc.SetColSize(col, size+n*colSizeIncrementStep)
 	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyGrid)
 }
 
+// cmdResizeRow changes the height of the rows the selection spans by N lines of text.
+func (a *App) cmdResizeRow(n int) {
+	s := a.doc.CurrentSheet
+	r := s.SelectedRect()
+	for y := r.Y; y <= r.MaxY(); y++ {
+		a.doc.ResizeRow(y, n)
+	}
+	a.output.SetDirty(ui.DirtyVRuler | ui.DirtyGrid)
+}
+
+// cmdFormat sets the number format of the selection or the cell under cursor, General
+// resets it. Without the code shows the format of the cell under cursor.
+func (a *App) cmdFormat(code string) {
+	s := a.doc.CurrentSheet
+	if code == "" {
+		f := a.doc.CellFormat(s, s.Cursor.X, s.Cursor.Y)
+		if f == nil {
+			a.output.SetStatus("General", 0)
+		} else {
+			a.output.SetStatus(f.String(), 0)
+		}
+		return
+	}
+	var f *numfmt.Format
+	if !strings.EqualFold(code, "general") {
+		var err error
+		if f, err = numfmt.Parse(code); err != nil {
+			a.showError(err)
+			return
+		}
+	}
+	a.doc.SetFormat(s, s.SelectedRect(), f)
+	s.Unselect()
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyStatusLine)
+}
+
 // cmdWrite saves document to file.
 func (a *App) cmdWrite(filename string) {
 	var err error
@@ -119,14 +257,106 @@ func (a *App) cmdNewSheet(title string) {
 // cmdNextSheet switches the current sheet to next one.
 // If current sheet is the last one, it switches to first.
 func (a *App) cmdNextSheet() {
-	a.doc.CurrentSheetN++
-	if a.doc.CurrentSheetN >= len(a.doc.Sheets) {
-		a.doc.CurrentSheetN = 0
-	}
+	a.switchSheet(1)
+}
+
+// cmdPrevSheet switches the current sheet to previous one.
+// If current sheet is the first one, it switches to last.
+func (a *App) cmdPrevSheet() {
+	a.switchSheet(-1)
+}
+
+// switchSheet makes current the sheet following the current one on N positions,
+// or preceding it if N is negative, going round the list of sheets.
+func (a *App) switchSheet(n int) {
+	count := len(a.doc.Sheets)
+	a.doc.CurrentSheetN = ((a.doc.CurrentSheetN+n)%count + count) % count
 	a.doc.CurrentSheet = a.doc.Sheets[a.doc.CurrentSheetN]
+	a.output.SetDirty(ui.DirtyStatusLine | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyHRuler | ui.DirtyVRuler)
+}
+
+// cmdSheet switches to the sheet with the title.
+func (a *App) cmdSheet(title string) {
+	s := a.doc.SheetByTitle(title)
+	if s == nil {
+		a.output.SetStatus(fmt.Sprintf("no sheet %s", title), ui.StatusFlagError)
+		return
+	}
+	a.setCurrentSheet(s)
+	a.output.SetDirty(ui.DirtyStatusLine | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyHRuler | ui.DirtyVRuler)
+}
+
+// cmdRenameSheet gives the current sheet a new title.
+func (a *App) cmdRenameSheet(title string) {
+	if err := a.doc.RenameSheet(a.doc.CurrentSheet, title); err != nil {
+		a.showError(err)
+		return
+	}
 	a.output.SetDirty(ui.DirtyStatusLine | ui.DirtyGrid | ui.DirtyFormulaLine)
 }
 
+// cmdDeleteSheet deletes the current sheet. Other windows showing it switch to the new
+// current sheet.
+func (a *App) cmdDeleteSheet() {
+	if err := a.doc.DeleteSheet(a.doc.CurrentSheet); err != nil {
+		a.showError(err)
+		return
+	}
+	a.output.SetDirty(ui.DirtyStatusLine | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyHRuler | ui.DirtyVRuler)
+}
+
+// cmdMoveSheet moves the current sheet to the position, counting from 1, or on N positions
+// left or right if the argument is -N or +N.
+func (a *App) cmdMoveSheet(arg string) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		a.output.SetStatus("usage: moveSheet N|+N|-N", ui.StatusFlagError)
+		return
+	}
+	pos := n - 1
+	if strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-") {
+		pos = a.doc.CurrentSheetN + n
+	}
+	if err := a.doc.MoveSheet(a.doc.CurrentSheet, pos); err != nil {
+		a.showError(err)
+		return
+	}
+	a.output.SetDirty(ui.DirtyStatusLine)
+}
+
+// cmdCopySheet creates the copy of the current sheet and switches to it.
+func (a *App) cmdCopySheet(title string) {
+	s, err := a.doc.CopySheet(a.doc.CurrentSheet, title)
+	if err != nil {
+		a.showError(err)
+		return
+	}
+	a.setCurrentSheet(s)
+	a.output.SetDirty(ui.DirtyStatusLine | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyHRuler | ui.DirtyVRuler)
+}
+
+// completeCommand returns the variants of the command line with the sheet title completed
+// for the commands taking it.
+func (a *App) completeCommand(text string) []string {
+	i := strings.Index(text, " ")
+	if i < 0 {
+		return nil
+	}
+	switch text[:i] {
+	case "sheet", "sp", "split", "vs", "vsplit":
+	default:
+		return nil
+	}
+	prefix := strings.ToLower(strings.TrimLeft(text[i:], " "))
+	var variants []string
+	for _, s := range a.doc.Sheets {
+		if strings.HasPrefix(strings.ToLower(s.Title), prefix) {
+			variants = append(variants, text[:i+1]+s.Title)
+		}
+	}
+	return variants
+}
+
 // cmdBind binds a command to a hot key.
 func (a *App) cmdBind(args []string) {
 	if len(args) < 2 {
@@ -142,35 +372,109 @@ func (a *App) cmdBind(args []string) {
 	a.hotKeys[k] = strings.Join(args[1:], " ")
 }
 
-// cmdCutCell erases the cell (but puts its value to buffer first).
+// cmdCutCell erases the selected cells (but puts their values to buffer first).
 func (a *App) cmdCutCell() {
 	a.cmdCopyCell()
-	a.doc.CurrentSheet.CellUnderCursor().SetValueEmpty()
-	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+	a.cmdClearCells()
 }
 
-// cmdCopyCell copies cell value to the buffer.
+// cmdCopyCell copies values of the selected cells to the buffer and to the system clipboard.
 func (a *App) cmdCopyCell() {
-	cell := a.doc.CurrentSheet.CellUnderCursor()
-	if cell == nil {
-		cell = sheet.NewCellEmpty()
-	}
-	cellCopy := *cell
-	a.cellBuffer = &cellCopy
+	s := a.doc.CurrentSheet
+	a.cellBuffer = newCellBuffer(a.doc, s, s.SelectedRect())
+	a.output.SetClipboard(a.cellBuffer.tsv())
 }
 
-// cmdPasteCell replace cell under cursor with the value of previously copied or cut cell.
+// cmdPasteCell replaces cells starting from the top left selected one with the values
+// of previously copied or cut cells. A single copied cell fills the whole selection.
+// Relative references in formulas are moved to the place of paste.
 func (a *App) cmdPasteCell() {
-	if a.cellBuffer == nil {
+	a.pasteBuffer(0)
+}
+
+// cmdPasteSpecial pastes cells in given modes: values, formulas and transpose.
+func (a *App) cmdPasteSpecial(args []string) {
+	mode := 0
+	for _, arg := range args {
+		switch arg {
+		case "values":
+			mode |= pasteValues
+		case "formulas":
+			mode |= pasteFormulas
+		case "transpose":
+			mode |= pasteTranspose
+		default:
+			a.output.SetStatus(fmt.Sprintf("unknown paste mode %s", arg), ui.StatusFlagError)
+			return
+		}
+	}
+	a.pasteBuffer(mode)
+}
+
+// cmdPasteClipboard pastes tab separated values from the system clipboard.
+func (a *App) cmdPasteClipboard() {
+	text, err := a.input.ReadClipboard()
+	if err != nil {
+		a.showError(err)
+		return
+	}
+	b, err := newCellBufferFromTSV(text)
+	if err != nil {
+		a.showError(err)
+		return
+	}
+	a.cellBuffer = b
+	a.pasteBuffer(0)
+}
+
+// pasteBuffer pastes cells of the buffer to the selection.
+func (a *App) pasteBuffer(mode int) {
+	if a.cellBuffer == nil || a.cellBuffer.isEmpty() {
 		a.output.SetStatus("buffer is empty", ui.StatusFlagError)
 		return
 	}
-	cellCopy := *a.cellBuffer
 	s := a.doc.CurrentSheet
-	s.SetCell(s.Cursor.X, s.Cursor.Y, &cellCopy)
+	a.cellBuffer.paste(a.doc, s, s.SelectedRect(), mode)
+	s.Unselect()
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// cmdClearCells erases values of the selected cells.
+func (a *App) cmdClearCells() {
+	s := a.doc.CurrentSheet
+	a.clearRect(s.SelectedRect())
+	s.Unselect()
 	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
 }
 
+// clearRect erases values of the cells within rect of the current sheet.
+func (a *App) clearRect(r sheet.Rect) {
+	s := a.doc.CurrentSheet
+	r = r.Intersect(s.Size)
+	for y := r.Y; y <= r.MaxY(); y++ {
+		for x := r.X; x <= r.MaxX(); x++ {
+			if !isEmptyCell(s, x, y) {
+				a.doc.SetCellValue(s, x, y, "")
+			}
+		}
+	}
+}
+
+// cmdSelect starts selection of given mode. If the selection of this mode
+// is already started, drops it.
+func (a *App) cmdSelect(mode int) {
+	s := a.doc.CurrentSheet
+	switch s.Selection.Mode {
+	case mode:
+		s.Unselect()
+	case sheet.SelectionNone:
+		s.Select(mode)
+	default:
+		s.Selection.Mode = mode
+	}
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyStatusLine)
+}
+
 func (a *App) cmdInsertRow(n int) {
 	a.doc.InsertEmptyRow(n)
 	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
@@ -181,13 +485,55 @@ func (a *App) cmdInsertCol(n int) {
 	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
 }
 
+// cmdDeleteRow deletes rows the selection spans.
+// When columns are selected, only the row under cursor is deleted.
 func (a *App) cmdDeleteRow() {
-	a.doc.DeleteRow()
+	s := a.doc.CurrentSheet
+	r := s.SelectedRect()
+	if s.Selection.Mode == sheet.SelectionCols {
+		r.Y, r.Height = s.Cursor.Y, 1
+	}
+	s.Cursor.Y = r.Y
+	for i := 0; i < r.Height; i++ {
+		a.doc.DeleteRow()
+	}
+	s.Unselect()
 	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
 }
 
+// cmdDeleteCol deletes columns the selection spans.
+// When rows are selected, only the column under cursor is deleted.
 func (a *App) cmdDeleteCol() {
-	a.doc.DeleteCol()
+	s := a.doc.CurrentSheet
+	r := s.SelectedRect()
+	if s.Selection.Mode == sheet.SelectionRows {
+		r.X, r.Width = s.Cursor.X, 1
+	}
+	s.Cursor.X = r.X
+	for i := 0; i < r.Width; i++ {
+		a.doc.DeleteCol()
+	}
+	s.Unselect()
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// cmdMerge merges the selected cells into one showing the value of the top left cell.
+func (a *App) cmdMerge() {
+	s := a.doc.CurrentSheet
+	if !s.IsSelected() {
+		a.output.SetStatus("select cells to merge", ui.StatusFlagError)
+		return
+	}
+	a.doc.Merge(s, s.SelectedRect())
+	s.Unselect()
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// cmdUnmerge splits the merged cells under the selection or cursor.
+func (a *App) cmdUnmerge() {
+	s := a.doc.CurrentSheet
+	a.doc.Unmerge(s, s.SelectedRect())
+	s.Unselect()
 	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
 }
 
@@ -227,15 +573,364 @@ func (a *App) cmdGo(cellName string) {
 	a.moveCursorTo(x, y)
 }
 
-func (a *App) cmdXDown() {
-	a.doc.CurrentSheet.AddXSegment(
-		a.doc.CurrentSheet.Cursor.X,
-		a.doc.CurrentSheet.Cursor.Y,
-		1,
-		a.doc.CurrentSheet.Size.Height-a.doc.CurrentSheet.Cursor.Y,
-		0,
-		0,
-		*a.doc.CurrentSheet.CellUnderCursor(),
-	)
-	a.output.SetDirty(ui.DirtyGrid)
+// cmdFill continues values of the cells in the direction: down, up, right or left.
+// Each line of the selection continues its leading non-empty cells. Without selection
+// the block of non-empty cells ending at the cursor is continued N cells further,
+// or up to the border of the sheet.
+func (a *App) cmdFill(direction, count string) {
+	s := a.doc.CurrentSheet
+	var dir, dx, dy int
+	switch direction {
+	case "down", "":
+		dir, dy = document.FillDown, 1
+	case "up":
+		dir, dy = document.FillUp, -1
+	case "right":
+		dir, dx = document.FillRight, 1
+	case "left":
+		dir, dx = document.FillLeft, -1
+	default:
+		a.output.SetStatus(fmt.Sprintf("unknown fill direction %s", direction), ui.StatusFlagError)
+		return
+	}
+	n := 0
+	if count != "" {
+		var err error
+		if n, err = strconv.Atoi(count); err != nil || n < 1 {
+			a.output.SetStatus("fill count must be a positive number", ui.StatusFlagError)
+			return
+		}
+	}
+	r := s.SelectedRect()
+	if !s.IsSelected() {
+		// the seed block ends at the cursor
+		x, y := s.Cursor.X, s.Cursor.Y
+		for x-dx >= 0 && y-dy >= 0 && !isEmptyCell(s, x-dx, y-dy) {
+			x, y = x-dx, y-dy
+		}
+		endX, endY := s.Cursor.X+dx*n, s.Cursor.Y+dy*n
+		if n == 0 {
+			switch dir {
+			case document.FillDown:
+				endY = s.Size.MaxY()
+			case document.FillUp:
+				endY = 0
+			case document.FillRight:
+				endX = s.Size.MaxX()
+			case document.FillLeft:
+				endX = 0
+			}
+		}
+		if endX < 0 {
+			endX = 0
+		}
+		if endY < 0 {
+			endY = 0
+		}
+		if (endX-s.Cursor.X)*dx+(endY-s.Cursor.Y)*dy <= 0 {
+			a.output.SetStatus("nothing to fill", ui.StatusFlagError)
+			return
+		}
+		r = cellsRect(x, y, endX, endY)
+	}
+	a.doc.Fill(s, r, dir)
+	s.Unselect()
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// cmdMaterialize replaces the extrapolated cells under cursor with static cells of the same values.
+func (a *App) cmdMaterialize() {
+	s := a.doc.CurrentSheet
+	if !a.doc.MaterializeXSegment(s, s.Cursor.X, s.Cursor.Y) {
+		a.output.SetStatus("cell is not extrapolated", ui.StatusFlagError)
+		return
+	}
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// cmdSort sorts rows of the selection, or of the block of data around the cursor.
+// Arguments are optional: the range (A1:D20), key columns each followed by asc or desc
+// (by default the cursor column ascending), header to keep the first row in place and
+// collation: natural (default), numeric or locale (language of LC_COLLATE or LANG).
+func (a *App) cmdSort(args []string) {
+	s := a.doc.CurrentSheet
+	r := s.SelectedRect()
+	if !s.IsSelected() {
+		r = dataBlock(s, s.Cursor.X, s.Cursor.Y)
+	}
+	var opts document.SortOptions
+	for _, arg := range args {
+		switch arg {
+		case "":
+		case "header":
+			opts.Header = true
+		case "natural":
+			opts.Collation = document.CollationNatural
+		case "numeric":
+			opts.Collation = document.CollationNumeric
+		case "locale":
+			opts.Collation, opts.Locale = document.CollationLocale, systemLocale()
+		case "asc", "desc":
+			if len(opts.Keys) == 0 {
+				a.output.SetStatus(fmt.Sprintf("%s must follow a key column", arg), ui.StatusFlagError)
+				return
+			}
+			opts.Keys[len(opts.Keys)-1].Desc = arg == "desc"
+		default:
+			if cells := strings.Split(arg, ":"); len(cells) == 2 {
+				x1, y1, _, _, err1 := document.CellAxis(cells[0])
+				x2, y2, _, _, err2 := document.CellAxis(cells[1])
+				if err1 == nil && err2 == nil {
+					r = cellsRect(x1, y1, x2, y2)
+					continue
+				}
+			}
+			x, _, _, _, err := document.CellAxis(arg + "1")
+			if err != nil {
+				a.output.SetStatus(fmt.Sprintf("unknown sort argument %s", arg), ui.StatusFlagError)
+				return
+			}
+			opts.Keys = append(opts.Keys, document.SortKey{Col: x})
+		}
+	}
+	if len(opts.Keys) == 0 {
+		opts.Keys = []document.SortKey{{Col: s.Cursor.X}}
+	}
+	if err := a.doc.Sort(s, r, opts); err != nil {
+		a.showError(err)
+		return
+	}
+	s.Unselect()
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// cmdHide hides or shows the rows ("row") or columns ("col") of the selection, or the line
+// under cursor. Unhide without selection shows all the hidden lines of that kind.
+func (a *App) cmdHide(kind string, hidden bool) {
+	s := a.doc.CurrentSheet
+	if kind != "row" && kind != "col" {
+		a.output.SetStatus("row or col expected", ui.StatusFlagError)
+		return
+	}
+	r := sheet.Rect{X: s.Cursor.X, Y: s.Cursor.Y, Width: 1, Height: 1}
+	switch {
+	case s.IsSelected():
+		r = s.SelectedRect()
+	case !hidden:
+		a.doc.UnhideAll(s, kind == "col")
+		a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid)
+		return
+	}
+	if kind == "row" {
+		a.doc.HideRows(s, r.Y, r.MaxY(), hidden)
+	} else {
+		a.doc.HideCols(s, r.X, r.MaxX(), hidden)
+	}
+	s.Unselect()
+	// cursor must not stay on a hidden line
+	if !s.IsRowVisible(s.Cursor.Y) {
+		a.moveCursorTo(s.Cursor.X, s.NextVisibleRow(s.Cursor.Y, 1))
+	}
+	if s.IsColHidden(s.Cursor.X) {
+		a.moveCursorTo(s.NextVisibleCol(s.Cursor.X, 1), s.Cursor.Y)
+	}
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// cmdFilter manages the autofilter of the sheet. Without arguments creates the filter over
+// the selection or the data block around the cursor; "off" removes it and "apply" checks
+// the rows again after values have changed. With a column (B) shows distinct values of the
+// column, "B values a,b" leaves the rows with one of the values, "B > 10" leaves the rows
+// matching the condition (= <> < <= > >= contains !contains begins ends), and "B clear"
+// removes the conditions of the column.
+func (a *App) cmdFilter(args []string) {
+	s := a.doc.CurrentSheet
+	defer a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyStatusLine)
+	switch arg1(args) {
+	case "":
+		r := s.SelectedRect()
+		if !s.IsSelected() {
+			r = dataBlock(s, s.Cursor.X, s.Cursor.Y)
+		}
+		if r.Height < 2 {
+			a.output.SetStatus("filter needs a header and data rows", ui.StatusFlagError)
+			return
+		}
+		a.doc.SetAutoFilter(s, r)
+		s.Unselect()
+		return
+	case "off":
+		a.doc.RemoveAutoFilter(s)
+		return
+	case "apply":
+		a.doc.ApplyFilter(s)
+		return
+	}
+	if s.Filter == nil {
+		a.output.SetStatus("sheet has no filter", ui.StatusFlagError)
+		return
+	}
+	col, _, _, _, err := document.CellAxis(arg1(args) + "1")
+	if err != nil {
+		a.output.SetStatus(fmt.Sprintf("invalid column %s", arg1(args)), ui.StatusFlagError)
+		return
+	}
+	switch op := argN(args, 2); {
+	case op == "":
+		var values []string
+		for _, v := range a.doc.FilterValues(s, col) {
+			values = append(values, fmt.Sprintf("%s (%d)", v.Value, v.Count))
+		}
+		a.output.SetStatus(strings.Join(values, ", "), 0)
+	case op == "clear":
+		a.doc.ClearFilterCondition(s, col)
+	case op == "values":
+		cond := sheet.FilterCondition{Values: strings.Split(strings.Join(args[2:], " "), ",")}
+		if err := a.doc.SetFilterCondition(s, col, cond); err != nil {
+			a.showError(err)
+		}
+	case document.IsFilterOp(op):
+		cond := sheet.FilterCondition{Op: op, Operand: strings.Join(args[2:], " ")}
+		if err := a.doc.SetFilterCondition(s, col, cond); err != nil {
+			a.showError(err)
+		}
+	default:
+		a.output.SetStatus(fmt.Sprintf("unknown filter operation %s", op), ui.StatusFlagError)
+	}
+	if !s.IsRowVisible(s.Cursor.Y) {
+		a.moveCursorTo(s.Cursor.X, s.NextVisibleRow(s.Cursor.Y, 1))
+	}
+}
+
+// dataBlock returns the rect of non-empty cells around the cell, which is surrounded by
+// empty rows and columns.
+func dataBlock(s *sheet.Sheet, x, y int) sheet.Rect {
+	r := sheet.Rect{X: x, Y: y, Width: 1, Height: 1}
+	// whether any cell of the line next to the rect is non-empty
+	rowHasData := func(y, x1, x2 int) bool {
+		if y < 0 || y > s.Size.MaxY() {
+			return false
+		}
+		for x := x1; x <= x2; x++ {
+			if x >= 0 && !isEmptyCell(s, x, y) {
+				return true
+			}
+		}
+		return false
+	}
+	colHasData := func(x, y1, y2 int) bool {
+		if x < 0 || x > s.Size.MaxX() {
+			return false
+		}
+		for y := y1; y <= y2; y++ {
+			if y >= 0 && !isEmptyCell(s, x, y) {
+				return true
+			}
+		}
+		return false
+	}
+	for grown := true; grown; {
+		grown = false
+		if rowHasData(r.Y-1, r.X-1, r.MaxX()+1) {
+			r.Y, r.Height, grown = r.Y-1, r.Height+1, true
+		}
+		if rowHasData(r.MaxY()+1, r.X-1, r.MaxX()+1) {
+			r.Height, grown = r.Height+1, true
+		}
+		if colHasData(r.X-1, r.Y-1, r.MaxY()+1) {
+			r.X, r.Width, grown = r.X-1, r.Width+1, true
+		}
+		if colHasData(r.MaxX()+1, r.Y-1, r.MaxY()+1) {
+			r.Width, grown = r.Width+1, true
+		}
+	}
+	return r
+}
+
+// systemLocale returns the language used for collation in BCP 47 form.
+func systemLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_COLLATE", "LANG"} {
+		if v := os.Getenv(name); v != "" && v != "C" && v != "POSIX" {
+			v = strings.SplitN(v, ".", 2)[0]
+			return strings.Replace(v, "_", "-", -1)
+		}
+	}
+	return "en"
+}
+
+// cmdHelp shows signature and description of the formula function.
+func (a *App) cmdHelp(name string) {
+	def, ok := formula.LookupFunction(name)
+	if !ok {
+		a.output.SetStatus(fmt.Sprintf("function %s does not exist", name), ui.StatusFlagError)
+		return
+	}
+	a.output.SetStatus(fmt.Sprintf("%s: %s", def.Signature(name), def.Help), 0)
+}
+
+// cmdUndo reverts the last change of the document.
+func (a *App) cmdUndo() {
+	if !a.doc.Undo() {
+		a.output.SetStatus("nothing to undo", ui.StatusFlagError)
+		return
+	}
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyStatusLine)
+}
+
+// cmdRedo performs again the last undone change.
+func (a *App) cmdRedo() {
+	if !a.doc.Redo() {
+		a.output.SetStatus("nothing to redo", ui.StatusFlagError)
+		return
+	}
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyStatusLine)
+}
+
+// cmdNotation switches notation in which formulas are displayed and entered.
+// Without argument shows the current notation.
+func (a *App) cmdNotation(notation string) {
+	switch strings.ToUpper(notation) {
+	case "":
+		name := "A1"
+		if a.doc.Notation == formula.NotationR1C1 {
+			name = "R1C1"
+		}
+		a.output.SetStatus(fmt.Sprintf("notation %s", name), 0)
+		return
+	case "A1":
+		a.doc.Notation = formula.NotationA1
+	case "R1C1":
+		a.doc.Notation = formula.NotationR1C1
+	default:
+		a.output.SetStatus(fmt.Sprintf("unknown notation %s", notation), ui.StatusFlagError)
+		return
+	}
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// cmdSource executes Lua script from the file.
+func (a *App) cmdSource(filename string) {
+	if strings.HasPrefix(filename, "~/") {
+		filename = os.Getenv("HOME") + filename[1:]
+	}
+	if err := a.script.DoFile(filename); err != nil {
+		a.showError(err)
+	}
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyStatusLine)
+}
+
+// cmdLua executes Lua code given in command line.
+func (a *App) cmdLua(code string) {
+	if err := a.script.DoString(code); err != nil {
+		a.showError(err)
+	}
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyStatusLine)
+}
+
+// cmdScript runs the command registered by a script.
+func (a *App) cmdScript(c string, args []string) {
+	if err := a.script.RunCommand(c, args); err != nil {
+		a.showError(err)
+	}
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyStatusLine)
 }
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"xl/document"
+	"xl/document/sheet"
+	"xl/ui"
+
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Команда :cond добавляет правило условного форматирования к выделенным ячейкам или
+// ячейке под курсором. Условие отделяется от оформления словом then:
+//   :cond > 100 then fg red bold
+//   :cond formula =A1>B1 then bg yellow
+//   :cond top 3 then bold
+//   :cond scale #ffffff #ff0000
+// ":cond clear" удаляет правила, задевающие выделение, ":cond" без аргументов показывает
+// правила ячейки под курсором.
+
+// cmdCond adds the conditional formatting rule described by the arguments.
+func (a *App) cmdCond(args []string) {
+	s := a.doc.CurrentSheet
+	defer a.output.SetDirty(ui.DirtyGrid | ui.DirtyStatusLine)
+	switch arg1(args) {
+	case "":
+		var rules []string
+		for _, rule := range s.CondRules() {
+			if rule.Rect.Contains(s.Cursor.X, s.Cursor.Y) {
+				rules = append(rules, describeCondRule(rule))
+			}
+		}
+		if len(rules) == 0 {
+			a.output.SetStatus("no conditional formatting", 0)
+		} else {
+			a.output.SetStatus(strings.Join(rules, "; "), 0)
+		}
+		return
+	case "clear":
+		a.doc.ClearCondRules(s, s.SelectedRect())
+		s.Unselect()
+		return
+	}
+	rule, err := parseCondRule(args)
+	if err != nil {
+		a.showError(err)
+		return
+	}
+	rule.Rect = s.SelectedRect()
+	if err := a.doc.AddCondRule(s, rule); err != nil {
+		a.showError(err)
+		return
+	}
+	s.Unselect()
+}
+
+// parseCondRule returns the rule the arguments of :cond command describe, the rect
+// of the rule is not set.
+func parseCondRule(args []string) (sheet.CondRule, error) {
+	var rule sheet.CondRule
+	cond, styleArgs := args, []string(nil)
+	for i, arg := range args {
+		if strings.EqualFold(arg, "then") {
+			cond, styleArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	if len(cond) < 2 {
+		return rule, fmt.Errorf("incomplete condition")
+	}
+	operand := strings.Join(cond[1:], " ")
+	switch kind := strings.ToLower(cond[0]); {
+	case kind == "formula":
+		rule.Kind, rule.Operand = sheet.CondFormula, operand
+	case kind == "top" || kind == "bottom":
+		rule.Kind = sheet.CondTop
+		if kind == "bottom" {
+			rule.Kind = sheet.CondBottom
+		}
+		n, err := strconv.Atoi(operand)
+		if err != nil {
+			return rule, fmt.Errorf("invalid number %s", operand)
+		}
+		rule.N = n
+	case kind == "scale":
+		if len(cond) != 3 {
+			return rule, fmt.Errorf("scale needs two colors")
+		}
+		rule.Kind = sheet.CondScale
+		for i, color := range []*string{&rule.MinColor, &rule.MaxColor} {
+			var ok bool
+			if *color, ok = parseColor(strings.ToLower(cond[1+i])); !ok || *color == "" {
+				return rule, fmt.Errorf("unknown color %s", cond[1+i])
+			}
+		}
+		return rule, nil
+	case document.IsFilterOp(kind):
+		rule.Kind, rule.Op, rule.Operand = sheet.CondValue, kind, operand
+	default:
+		return rule, fmt.Errorf("unknown condition %s", cond[0])
+	}
+	if len(styleArgs) == 0 {
+		return rule, fmt.Errorf("style is not given, use: then <style>")
+	}
+	change, err := parseStyleArgs(styleArgs)
+	if err != nil {
+		return rule, err
+	}
+	change(&rule.Style)
+	return rule, nil
+}
+
+// describeCondRule returns the rule as the arguments of :cond command along with
+// the cells it applies to.
+func describeCondRule(rule sheet.CondRule) string {
+	var b bytes.Buffer
+	r := rule.Rect
+	b.WriteString(document.CellName(r.X, r.Y) + ":" + document.CellName(r.MaxX(), r.MaxY()) + " ")
+	switch rule.Kind {
+	case sheet.CondValue:
+		b.WriteString(rule.Op + " " + rule.Operand)
+	case sheet.CondFormula:
+		b.WriteString("formula " + rule.Operand)
+	case sheet.CondTop:
+		b.WriteString("top " + strconv.Itoa(rule.N))
+	case sheet.CondBottom:
+		b.WriteString("bottom " + strconv.Itoa(rule.N))
+	case sheet.CondScale:
+		b.WriteString("scale " + rule.MinColor + " " + rule.MaxColor)
+		return b.String()
+	}
+	b.WriteString(" then " + describeStyle(rule.Style))
+	return b.String()
+}
@@ -3,60 +3,154 @@ package app
 import (
 	"xl/document"
 	"xl/document/eval"
+	"xl/document/sheet"
+	"xl/formula"
 	"xl/ui"
+
+	"fmt"
 )
 
 // Callbacks collection providing data to be displayed.
 
+// Данные листа, показанного в окне, с курсором и областью просмотра этого окна.
+type sheetDelegate struct {
+	a        *App
+	sheet    *sheet.Sheet
+	cursor   sheet.Cursor
+	viewport sheet.Viewport
+}
+
 func (a *App) CellView(x, y int) *ui.CellView {
-	c := a.doc.CurrentSheet.Cell(x, y)
+	return a.windowDelegate(a.window).CellView(x, y)
+}
+
+func (a *App) RowView(n int) *ui.RowView {
+	return a.windowDelegate(a.window).RowView(n)
+}
+
+func (a *App) ColView(n int) *ui.ColView {
+	return a.windowDelegate(a.window).ColView(n)
+}
+
+func (a *App) SheetView() *ui.SheetView {
+	return a.windowDelegate(a.window).SheetView()
+}
+
+func (a *App) WindowDelegate(n int) ui.SheetDelegateInterface {
+	return a.windowDelegate(a.windows()[n])
+}
+
+// windowDelegate returns the data of the window. The current window shows the current
+// sheet with its own cursor and viewport.
+func (a *App) windowDelegate(w *window) *sheetDelegate {
+	if w == a.window {
+		s := a.doc.CurrentSheet
+		return &sheetDelegate{a: a, sheet: s, cursor: s.Cursor, viewport: s.Viewport}
+	}
+	a.checkWindow(w)
+	return &sheetDelegate{a: a, sheet: w.sheet, cursor: w.cursor, viewport: w.viewport}
+}
+
+func (d *sheetDelegate) CellView(x, y int) *ui.CellView {
+	var v *ui.CellView
+	if m, ok := d.sheet.MergeAt(x, y); ok {
+		v = d.cellView(m.X, m.Y)
+		v.Merge = &m
+	} else {
+		v = d.cellView(x, y)
+	}
+	if p := d.a.pointer; p != nil && d.sheet == d.a.doc.CurrentSheet {
+		v.Ref, v.Point = p.marks(x, y)
+	}
+	return v
+}
+
+// cellView returns the view of the cell as if it was not merged with others.
+func (d *sheetDelegate) cellView(x, y int) *ui.CellView {
+	st := d.sheet.CellStyle(x, y).Overlay(d.a.doc.CondStyle(d.sheet, x, y))
+	c := d.sheet.Cell(x, y)
 	if c == nil {
 		return &ui.CellView{
-			Name: document.CellName(x, y),
+			Name:  d.a.cellName(x, y),
+			Style: st,
 		}
 	}
-	v, err := c.StringValue(eval.NewContext(a.doc, a.doc.CurrentSheet.Idx))
+	v, err := c.DisplayValue(eval.NewContext(d.a.doc, d.sheet.Idx))
 	if err != nil {
 		t := err.Error()
 		return &ui.CellView{
-			Name:  document.CellName(x, y),
+			Name:  d.a.cellName(x, y),
+			Style: st,
 			Error: &t,
 		}
 	}
+	if st.Align == sheet.AlignGeneral {
+		st.Align = sheet.AlignLeft
+		if v.Number {
+			st.Align = sheet.AlignRight
+		}
+	}
 	return &ui.CellView{
-		Name:        document.CellName(x, y),
-		DisplayText: v,
-		Expression:  c.Expression(eval.NewContext(a.doc, a.doc.CurrentSheet.Idx)),
+		Name:        d.a.cellName(x, y),
+		DisplayText: v.Text,
+		Color:       v.Color,
+		Style:       st,
+		Invalid:     d.a.validating[d.sheet] && !d.a.doc.IsCellValid(d.sheet, x, y),
+		Expression:  c.Expression(eval.NewContext(d.a.doc, d.sheet.Idx)),
 	}
 }
 
-func (a *App) RowView(n int) *ui.RowView {
+func (d *sheetDelegate) RowView(n int) *ui.RowView {
 	return &ui.RowView{
 		Name:   document.RowName(n),
-		Height: a.doc.CurrentSheet.RowSize(n),
+		Height: d.sheet.RowSize(n),
+		Hidden: !d.sheet.IsRowVisible(n),
 	}
 }
 
-func (a *App) ColView(n int) *ui.ColView {
-	return &ui.ColView{
-		Name:  document.ColName(n),
-		Width: a.doc.CurrentSheet.ColSize(n),
+func (d *sheetDelegate) ColView(n int) *ui.ColView {
+	name := document.ColName(n)
+	if d.a.doc.Notation == formula.NotationR1C1 {
+		name = document.RowName(n)
 	}
+	cv := &ui.ColView{
+		Name:   name,
+		Width:  d.sheet.ColSize(n),
+		Hidden: d.sheet.IsColHidden(n),
+	}
+	if f := d.sheet.Filter; f != nil {
+		_, cv.Filtered = f.Conditions[n]
+	}
+	return cv
 }
 
-func (a *App) SheetView() *ui.SheetView {
-	c := a.doc.CurrentSheet.CellUnderCursor()
+func (d *sheetDelegate) SheetView() *ui.SheetView {
+	c := d.sheet.Cell(d.cursor.X, d.cursor.Y)
 	sv := &ui.SheetView{
-		Name:     a.doc.CurrentSheet.Title,
-		Cursor:   a.doc.CurrentSheet.Cursor,
-		Viewport: a.doc.CurrentSheet.Viewport,
+		Name:     d.sheet.Title,
+		Cursor:   d.cursor,
+		Viewport: d.viewport,
+
+		FrozenRows: d.sheet.FrozenRows,
+		FrozenCols: d.sheet.FrozenCols,
 	}
 	if c != nil {
 		sv.FormulaLineView = ui.FormulaLineView{
 			DisplayText: c.RawValue(),
-			Expression:  c.Expression(eval.NewContext(a.doc, a.doc.CurrentSheet.Idx)),
+			Expression:  c.Expression(eval.NewContext(d.a.doc, d.sheet.Idx)),
+			R1C1:        d.a.doc.Notation == formula.NotationR1C1,
 		}
 	}
+	if d.sheet.IsSelected() {
+		r := d.sheet.SelectedRect()
+		stat := d.a.doc.RangeStat(d.sheet, r)
+		sv.Selection = &r
+		sv.SelectionStat = fmt.Sprintf("SUM: %s COUNT: %d AVG: %s", stat.Sum, stat.Count, stat.Avg().Round(10))
+	}
+	if f := d.sheet.Filter; f != nil {
+		total := f.DataRows().Height
+		sv.FilterStat = fmt.Sprintf("FILTER %d/%d", total-d.sheet.FilteredRows(), total)
+	}
 	return sv
 }
 
@@ -72,5 +166,15 @@ func (a *App) DocView() *ui.DocView {
 	return &ui.DocView{
 		Sheets:          sheetNames,
 		CurrentSheetIdx: currentSheetIdx,
+		Layout:          a.layout.view(a.windows()),
+		CurrentWindow:   a.windowIndex(a.window),
+	}
+}
+
+// cellName returns name of the cell in notation chosen for the document.
+func (a *App) cellName(x, y int) string {
+	if a.doc.Notation == formula.NotationR1C1 {
+		return "R" + document.RowName(y) + "C" + document.RowName(x)
 	}
+	return document.CellName(x, y)
 }
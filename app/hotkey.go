@@ -4,6 +4,15 @@ import (
 	"github.com/gdamore/tcell"
 )
 
+// Команды, привязанные к клавишам по умолчанию. Привязки можно переопределить в .xlrc.
+var defaultHotKeys = map[string]string{
+	"u": "undo",
+	"v": "visual",
+	"V": "visualRows",
+	"n": "searchNext",
+	"N": "searchPrev",
+}
+
 type Key struct {
 	Mod tcell.ModMask
 	Key tcell.Key
@@ -37,7 +46,40 @@ var HotKeys = map[string]Key{
 	"x": {tcell.ModNone, tcell.KeyRune, 'x'},
 	"y": {tcell.ModNone, tcell.KeyRune, 'y'},
 	"z": {tcell.ModNone, tcell.KeyRune, 'z'},
+	"A": {tcell.ModNone, tcell.KeyRune, 'A'},
+	"B": {tcell.ModNone, tcell.KeyRune, 'B'},
+	"C": {tcell.ModNone, tcell.KeyRune, 'C'},
+	"D": {tcell.ModNone, tcell.KeyRune, 'D'},
+	"E": {tcell.ModNone, tcell.KeyRune, 'E'},
+	"F": {tcell.ModNone, tcell.KeyRune, 'F'},
+	"G": {tcell.ModNone, tcell.KeyRune, 'G'},
+	"H": {tcell.ModNone, tcell.KeyRune, 'H'},
+	"I": {tcell.ModNone, tcell.KeyRune, 'I'},
+	"J": {tcell.ModNone, tcell.KeyRune, 'J'},
+	"K": {tcell.ModNone, tcell.KeyRune, 'K'},
+	"L": {tcell.ModNone, tcell.KeyRune, 'L'},
+	"M": {tcell.ModNone, tcell.KeyRune, 'M'},
+	"N": {tcell.ModNone, tcell.KeyRune, 'N'},
+	"O": {tcell.ModNone, tcell.KeyRune, 'O'},
+	"P": {tcell.ModNone, tcell.KeyRune, 'P'},
+	"Q": {tcell.ModNone, tcell.KeyRune, 'Q'},
+	"R": {tcell.ModNone, tcell.KeyRune, 'R'},
+	"S": {tcell.ModNone, tcell.KeyRune, 'S'},
+	"T": {tcell.ModNone, tcell.KeyRune, 'T'},
+	"U": {tcell.ModNone, tcell.KeyRune, 'U'},
+	"V": {tcell.ModNone, tcell.KeyRune, 'V'},
+	"W": {tcell.ModNone, tcell.KeyRune, 'W'},
+	"X": {tcell.ModNone, tcell.KeyRune, 'X'},
+	"Y": {tcell.ModNone, tcell.KeyRune, 'Y'},
+	"Z": {tcell.ModNone, tcell.KeyRune, 'Z'},
 	">": {tcell.ModNone, tcell.KeyRune, '>'},
 	"}": {tcell.ModNone, tcell.KeyRune, '}'},
 	"{": {tcell.ModNone, tcell.KeyRune, '{'},
 }
+
+// bindDefaultHotKeys binds default commands to hot keys.
+func (a *App) bindDefaultHotKeys() {
+	for k, c := range defaultHotKeys {
+		a.hotKeys[HotKeys[k]] = c
+	}
+}
@@ -1,21 +1,35 @@
 package app
 
 import (
+	"xl/document"
+	"xl/document/eval"
 	"xl/document/sheet"
+	"xl/formula"
 	"xl/ui"
 
-	"fmt"
+	"strings"
 
 	"github.com/gdamore/tcell"
 )
 
 // processKeyEvent does the job associated with the key press.
 func (a *App) processKeyEvent(event ui.KeyEvent) bool {
+	if a.doc.CurrentSheet.IsSelected() {
+		// summary of the selection changes as it follows the cursor
+		a.output.SetDirty(ui.DirtyStatusLine)
+	}
 	switch event.Ch {
 	case ':':
+		a.keymap.reset()
 		stop := a.inputCommand()
 		a.output.RefreshView()
 		return stop
+	case '/', '?':
+		a.keymap.reset()
+		a.inputSearch(event.Ch == '?')
+		a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyStatusLine)
+		a.output.RefreshView()
+		return false
 	case ' ':
 		a.pageDown()
 		a.output.RefreshView()
@@ -25,6 +39,14 @@ func (a *App) processKeyEvent(event ui.KeyEvent) bool {
 	switch event.Key {
 	case tcell.KeyCtrlC:
 		return true
+	case tcell.KeyEsc:
+		a.keymap.reset()
+		a.doc.CurrentSheet.Unselect()
+		a.output.SetDirty(ui.DirtyGrid | ui.DirtyStatusLine)
+		a.output.RefreshView()
+	case tcell.KeyCtrlR:
+		a.processCommand("redo")
+		a.output.RefreshView()
 	case tcell.KeyUp:
 		a.moveCursorUp()
 		a.output.RefreshView()
@@ -41,56 +63,65 @@ func (a *App) processKeyEvent(event ui.KeyEvent) bool {
 		a.editCell()
 		a.output.RefreshView()
 	default:
-		a.output.SetStatus(fmt.Sprintf("ch: %v, key: %v", event.Ch, event.Key), 0)
-		a.runHotKey(Key{event.Mod, event.Key, event.Ch})
+		a.processVimKey(event)
 		a.output.RefreshView()
 	}
 
 	return false
 }
 
-// moveCursorLeft moves cursor up on one cell.
+// moveCursorUp moves cursor up on one cell. Hidden rows are skipped.
 func (a *App) moveCursorUp() bool {
-	if a.doc.CurrentSheet.Cursor.Y <= 0 {
+	s := a.doc.CurrentSheet
+	y := s.NextVisibleRow(s.Cursor.Y, -1)
+	if y == s.Cursor.Y {
 		return false
 	}
-	a.doc.CurrentSheet.Cursor.Y--
-	if a.doc.CurrentSheet.Cursor.Y < a.doc.CurrentSheet.Viewport.Top {
-		a.doc.CurrentSheet.Viewport.Top--
-	}
+	s.Cursor.Y = y
+	a.snapCursor()
+	a.scrollToCursor()
 	a.output.SetDirty(ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine)
 	return true
 }
 
-// moveCursorLeft moves cursor down on one cell.
+// moveCursorDown moves cursor down on one cell. Hidden rows are skipped.
 func (a *App) moveCursorDown() bool {
-	a.doc.CurrentSheet.Cursor.Y++
-	if a.doc.CurrentSheet.Cursor.Y >= a.doc.CurrentSheet.Viewport.Top+a.output.ViewportHeight() {
-		a.doc.CurrentSheet.Viewport.Top++
+	s := a.doc.CurrentSheet
+	y := s.Cursor.Y
+	if m, ok := s.MergeAt(s.Cursor.X, y); ok {
+		y = m.MaxY()
 	}
+	s.Cursor.Y = s.NextVisibleRow(y, 1)
+	a.snapCursor()
+	a.scrollToCursor()
 	a.output.SetDirty(ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine)
 	return true
 }
 
-// moveCursorLeft moves cursor left on one cell.
+// moveCursorLeft moves cursor left on one cell. Hidden columns are skipped.
 func (a *App) moveCursorLeft() bool {
-	if a.doc.CurrentSheet.Cursor.X <= 0 {
+	s := a.doc.CurrentSheet
+	x := s.NextVisibleCol(s.Cursor.X, -1)
+	if x == s.Cursor.X {
 		return false
 	}
-	a.doc.CurrentSheet.Cursor.X--
-	if a.doc.CurrentSheet.Cursor.X < a.doc.CurrentSheet.Viewport.Left {
-		a.doc.CurrentSheet.Viewport.Left--
-	}
+	s.Cursor.X = x
+	a.snapCursor()
+	a.scrollToCursor()
 	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyGrid | ui.DirtyFormulaLine)
 	return true
 }
 
-// moveCursorRight moves cursor right on one cell.
+// moveCursorRight moves cursor right on one cell. Hidden columns are skipped.
 func (a *App) moveCursorRight() bool {
-	a.doc.CurrentSheet.Cursor.X++
-	if a.doc.CurrentSheet.Cursor.X >= a.doc.CurrentSheet.Viewport.Left+a.output.ViewportWidth() {
-		a.doc.CurrentSheet.Viewport.Left++
+	s := a.doc.CurrentSheet
+	x := s.Cursor.X
+	if m, ok := s.MergeAt(x, s.Cursor.Y); ok {
+		x = m.MaxX()
 	}
+	s.Cursor.X = s.NextVisibleCol(x, 1)
+	a.snapCursor()
+	a.scrollToCursor()
 	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyGrid | ui.DirtyFormulaLine)
 	return true
 }
@@ -103,29 +134,69 @@ func (a *App) pageDown() bool {
 	return true
 }
 
-// moveCursorRight moves cursor right on one cell.
+// moveCursorTo moves cursor to the cell.
 func (a *App) moveCursorTo(x, y int) {
 	a.doc.CurrentSheet.Cursor.X = x
-	if a.doc.CurrentSheet.Cursor.X >= a.doc.CurrentSheet.Viewport.Left+a.output.ViewportWidth() {
-		a.doc.CurrentSheet.Viewport.Left = a.doc.CurrentSheet.Cursor.X - a.output.ViewportWidth() + 1
+	a.doc.CurrentSheet.Cursor.Y = y
+	a.snapCursor()
+	a.scrollToCursor()
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// snapCursor moves the cursor which is inside a merged region to its top left cell,
+// so the region behaves as a single cell.
+func (a *App) snapCursor() {
+	s := a.doc.CurrentSheet
+	if m, ok := s.MergeAt(s.Cursor.X, s.Cursor.Y); ok {
+		s.Cursor = sheet.Cursor{X: m.X, Y: m.Y}
 	}
-	if a.doc.CurrentSheet.Cursor.X < a.doc.CurrentSheet.Viewport.Left {
-		a.doc.CurrentSheet.Viewport.Left = a.doc.CurrentSheet.Cursor.X
+}
+
+// scrollToCursor moves the viewport so the cursor is visible. Frozen rows and columns
+// are always on the screen, so the viewport scrolls only the rest of the sheet.
+func (a *App) scrollToCursor() {
+	s := a.doc.CurrentSheet
+	a.scrollTo(s.Cursor.X, s.Cursor.Y)
+}
+
+// scrollTo moves the viewport so the cell is visible.
+func (a *App) scrollTo(x, y int) {
+	s := a.doc.CurrentSheet
+	height, width := a.output.ViewportHeight(), a.output.ViewportWidth()
+	if height < 1 {
+		height = 1
 	}
-	a.doc.CurrentSheet.Cursor.Y = y
-	if a.doc.CurrentSheet.Cursor.Y >= a.doc.CurrentSheet.Viewport.Top+a.output.ViewportHeight() {
-		a.doc.CurrentSheet.Viewport.Top = a.doc.CurrentSheet.Cursor.Y - a.output.ViewportHeight() + 1
+	if width < 1 {
+		width = 1
 	}
-	if a.doc.CurrentSheet.Cursor.Y < a.doc.CurrentSheet.Viewport.Top {
-		a.doc.CurrentSheet.Viewport.Top = a.doc.CurrentSheet.Cursor.Y
+	if s.Viewport.Top < s.FrozenRows {
+		s.Viewport.Top = s.FrozenRows
+	}
+	if y >= s.FrozenRows {
+		if y < s.Viewport.Top {
+			s.Viewport.Top = y
+		}
+		if y >= s.Viewport.Top+height {
+			s.Viewport.Top = y - height + 1
+		}
+	}
+	if s.Viewport.Left < s.FrozenCols {
+		s.Viewport.Left = s.FrozenCols
+	}
+	if x >= s.FrozenCols {
+		if x < s.Viewport.Left {
+			s.Viewport.Left = x
+		}
+		if x >= s.Viewport.Left+width {
+			s.Viewport.Left = x - width + 1
+		}
 	}
-	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine)
 }
 
 // inputCommand opens inline editor in status line, with ':' prompt.
 // Once user finishes command input, processes the command.
 func (a *App) inputCommand() bool {
-	command, err := a.output.InputCommand()
+	command, err := a.output.InputCommand(a.completeCommand)
 	if err != nil {
 		a.showError(err)
 		return false
@@ -144,14 +215,94 @@ func (a *App) editCell() {
 	if cell == nil {
 		cell = sheet.NewCellEmpty()
 	}
-	newValue, err := a.output.EditCellValue(cell.RawValue())
+	// extrapolated cells have no raw value of their own
+	value := a.doc.CellRawValue(a.doc.CurrentSheet, cur.X, cur.Y)
+	r1c1 := a.doc.Notation == formula.NotationR1C1
+	if r1c1 {
+		if expr := cell.Expression(eval.NewContext(a.doc, a.doc.CurrentSheet.Idx)); expr != nil {
+			value = expr.R1C1String(cur.X, cur.Y)
+		}
+	}
+	opts := ui.EditOptions{
+		Choices:  a.doc.ValidationChoices(a.doc.CurrentSheet, cur.X, cur.Y),
+		Complete: a.completeFormula,
+	}
+	if !r1c1 {
+		// references are pointed in A1 notation only
+		a.pointer = &formulaPointer{a: a, x: cur.X, y: cur.Y}
+		opts.Point = a.pointer
+	}
+	newValue, err := a.output.EditCellValue(value, opts)
+	a.pointer = nil
+	// pointing may scroll the cell out of the screen
+	a.scrollToCursor()
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid)
 	if err != nil {
 		a.logger.Error(err.Error())
 		return
 	}
-	cell.SetValueUntyped(newValue)
-	a.doc.CurrentSheet.SetCell(cur.X, cur.Y, cell)
-	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+	if r1c1 && strings.HasPrefix(newValue, "=") {
+		// formula is stored in A1 notation, if it can not be parsed it is stored as is
+		// so the error is shown on evaluation
+		if expr, err := formula.ParseR1C1(newValue, cur.X, cur.Y); err == nil {
+			newValue = expr.String()
+		}
+	}
+	defer a.output.SetDirty(ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyStatusLine)
+	// the row grows to show the new value at once with the change of the value
+	a.doc.BeginGroup()
+	defer a.doc.EndGroup()
+	err = a.doc.SetCellValueChecked(a.doc.CurrentSheet, cur.X, cur.Y, newValue)
+	if verr, ok := err.(*document.ValidationError); ok && verr.Rule.Warning {
+		a.output.SetStatus("warning: "+verr.Error(), 0)
+	} else if err != nil {
+		a.showError(err)
+		return
+	}
+	a.growRowHeight(cur.Y)
+}
+
+// completeFormula returns the variants of the formula text with the function name or
+// the sheet title at its end completed.
+func (a *App) completeFormula(text string) []string {
+	if !strings.HasPrefix(text, "=") {
+		return nil
+	}
+	var variants []string
+	// odd number of quotes means the title of the sheet is being typed in quotes
+	if i := strings.LastIndex(text, "'"); strings.Count(text, "'")%2 == 1 {
+		prefix := strings.ToLower(text[i+1:])
+		for _, s := range a.doc.Sheets {
+			if strings.HasPrefix(strings.ToLower(s.Title), prefix) {
+				variants = append(variants, text[:i]+formula.QuoteSheet(s.Title)+"!")
+			}
+		}
+		return variants
+	}
+	i := len(text)
+	for i > 1 && isNameChar(text[i-1]) {
+		i--
+	}
+	prefix := strings.ToUpper(text[i:])
+	if prefix == "" {
+		return nil
+	}
+	for _, name := range formula.FunctionNames() {
+		if strings.HasPrefix(name, prefix) {
+			variants = append(variants, text[:i]+name+"(")
+		}
+	}
+	for _, s := range a.doc.Sheets {
+		if strings.HasPrefix(strings.ToUpper(s.Title), prefix) {
+			variants = append(variants, text[:i]+formula.QuoteSheet(s.Title)+"!")
+		}
+	}
+	return variants
+}
+
+// isNameChar reports whether the byte can be a part of a function name or a sheet title.
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.'
 }
 
 func (a *App) runHotKey(k Key) bool {
@@ -0,0 +1,413 @@
+package app
+
+import (
+	"xl/document/sheet"
+	"xl/ui"
+
+	"fmt"
+
+	"github.com/gdamore/tcell"
+)
+
+// Обработка нажатий в стиле vim. Клавиши, из которых складывается команда, копятся
+// в состоянии keymap: регистр ("a), счетчик (5), оператор (d, y, c) и префикс (g, Ctrl-W).
+// Команда выполняется, как только нажато перемещение (motion) или повторен оператор
+// (dd, yy, cc), после чего состояние сбрасывается. Для клавиш с привязкой (см. HotKeys
+// и :bind) выполняется привязанная команда, если не ожидается продолжение другой команды;
+// счетчик в этом случае задает число повторов.
+//
+// Перемещения j, k, gg, G, { и } построчные: оператор с ними действует на строки целиком.
+// Остальные перемещения действуют на ячейки между курсором и местом перемещения.
+
+// Регистр, связанный с системным буфером обмена.
+const clipboardRegister = '+'
+
+// Префикс оконных команд, вводимых после Ctrl-W.
+const windowPrefix = rune(tcell.KeyCtrlW)
+
+type keymap struct {
+	// Нажатые клавиши еще не выполненной команды, выводятся в строке статуса.
+	keys []rune
+
+	register      rune
+	awaitRegister bool
+
+	// Счетчик, введенный до оператора, и счетчик, вводимый сейчас.
+	opCount int
+	count   int
+
+	operator rune
+	prefix   rune
+}
+
+// reset drops the pending command.
+func (k *keymap) reset() {
+	*k = keymap{}
+}
+
+// pending reports whether the next key continues the command.
+func (k *keymap) pending() bool {
+	return k.awaitRegister || k.operator != 0 || k.prefix != 0
+}
+
+// n returns the number of repetitions for the command, counts before and after
+// the operator are multiplied.
+func (k *keymap) n() int {
+	n := 1
+	if k.opCount > 0 {
+		n *= k.opCount
+	}
+	if k.count > 0 {
+		n *= k.count
+	}
+	return n
+}
+
+// hasCount reports whether any count was entered.
+func (k *keymap) hasCount() bool {
+	return k.opCount > 0 || k.count > 0
+}
+
+// processVimKey handles the key as a part of vim-like command.
+func (a *App) processVimKey(event ui.KeyEvent) {
+	k := &a.keymap
+	if event.Key == tcell.KeyCtrlW && k.operator == 0 && !k.awaitRegister {
+		// window command follows, the count is kept for it
+		k.prefix = windowPrefix
+		a.output.SetStatus("^W", 0)
+		return
+	}
+	if event.Key != tcell.KeyRune || event.Mod&(tcell.ModAlt|tcell.ModCtrl) != 0 {
+		k.reset()
+		if !a.runHotKeyN(Key{event.Mod, event.Key, event.Ch}, 1) {
+			a.output.SetStatus(fmt.Sprintf("ch: %v, key: %v", event.Ch, event.Key), 0)
+		}
+		return
+	}
+	ch := event.Ch
+	k.keys = append(k.keys, ch)
+	done := true
+	switch {
+	case k.awaitRegister:
+		k.awaitRegister = false
+		if ch != clipboardRegister && (ch < 'a' || ch > 'z') {
+			a.output.SetStatus(fmt.Sprintf("invalid register %c", ch), ui.StatusFlagError)
+			break
+		}
+		k.register = ch
+		done = false
+	case k.prefix == windowPrefix:
+		a.windowKey(ch, k.n())
+	case k.prefix == 'g':
+		k.prefix = 0
+		if ch != 'g' {
+			break
+		}
+		a.doMotion(ch, true)
+	case (ch >= '1' && ch <= '9') || (ch == '0' && k.count > 0):
+		k.count = k.count*10 + int(ch-'0')
+		done = false
+	case !k.pending() && a.runHotKeyN(Key{event.Mod, event.Key, ch}, k.n()):
+	case ch == '"' && k.operator == 0:
+		k.awaitRegister = true
+		done = false
+	case ch == 'd' || ch == 'y' || ch == 'c':
+		s := a.doc.CurrentSheet
+		switch {
+		case s.IsSelected():
+			r := s.SelectedRect()
+			linewise := s.Selection.Mode == sheet.SelectionRows
+			s.Unselect()
+			a.applyOperator(ch, r, linewise)
+		case k.operator == ch:
+			// doubled operator works on N rows
+			a.applyOperator(ch, a.linesRect(s.Cursor.Y, s.Cursor.Y+k.n()-1), true)
+		case k.operator == 0:
+			k.operator, k.opCount, k.count = ch, k.count, 0
+			done = false
+		}
+	case ch == 'x':
+		s := a.doc.CurrentSheet
+		r := sheet.Rect{X: s.Cursor.X, Y: s.Cursor.Y, Width: k.n(), Height: 1}
+		if s.IsSelected() {
+			r = s.SelectedRect()
+			s.Unselect()
+		}
+		a.applyOperator('d', r, false)
+	case ch == 'p' || ch == 'P':
+		if k.operator == 0 {
+			a.putRegister(ch == 'P')
+		}
+	case ch == 'g':
+		k.prefix = ch
+		done = false
+	default:
+		a.doMotion(ch, false)
+	}
+	if done {
+		k.reset()
+		return
+	}
+	a.output.SetStatus(string(k.keys), 0)
+}
+
+// runHotKeyN runs the command bound to the key N times.
+// Returns false if no command is bound.
+func (a *App) runHotKeyN(k Key, n int) bool {
+	if _, ok := a.hotKeys[k]; !ok {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		a.runHotKey(k)
+	}
+	return true
+}
+
+// doMotion moves the cursor, or applies pending operator to the cells between the cursor
+// and the place it would be moved to.
+func (a *App) doMotion(ch rune, prefixed bool) {
+	k := &a.keymap
+	s := a.doc.CurrentSheet
+	x, y, linewise, ok := a.motion(ch, prefixed, k.n(), k.hasCount())
+	if !ok {
+		if k.operator != 0 {
+			a.output.SetStatus(fmt.Sprintf("unknown motion %c", ch), ui.StatusFlagError)
+		}
+		return
+	}
+	if k.operator == 0 {
+		a.moveCursorTo(x, y)
+		return
+	}
+	if linewise {
+		a.applyOperator(k.operator, a.linesRect(s.Cursor.Y, y), true)
+		return
+	}
+	a.applyOperator(k.operator, cellsRect(s.Cursor.X, s.Cursor.Y, x, y), false)
+}
+
+// motion returns the position the cursor is moved to by the key repeated N times
+// and whether the motion is linewise. Returns false if the key is not a motion.
+func (a *App) motion(ch rune, prefixed bool, n int, hasCount bool) (int, int, bool, bool) {
+	s := a.doc.CurrentSheet
+	x, y := s.Cursor.X, s.Cursor.Y
+	switch ch {
+	case 'h':
+		x = s.NextVisibleCol(x, -n)
+	case 'l':
+		x = s.NextVisibleCol(x, n)
+	case 'j':
+		return x, s.NextVisibleRow(y, n), true, true
+	case 'k':
+		return x, s.NextVisibleRow(y, -n), true, true
+	case '0':
+		x = 0
+	case '$':
+		x = lastCellInRow(s, y)
+	case 'w', 'b':
+		step := 1
+		if ch == 'b' {
+			step = -1
+		}
+		for i := 0; i < n; i++ {
+			next, ok := nextCellInRow(s, x, y, step)
+			if !ok {
+				break
+			}
+			x = next
+		}
+	case '}', '{':
+		step := 1
+		if ch == '{' {
+			step = -1
+		}
+		for i := 0; i < n; i++ {
+			y = blockBoundary(s, x, y, step)
+		}
+		return x, y, true, true
+	case 'G':
+		y = s.Size.MaxY()
+		if hasCount {
+			y = n - 1
+		}
+		if y < 0 {
+			y = 0
+		}
+		return x, y, true, true
+	case 'g':
+		if !prefixed {
+			return x, y, false, false
+		}
+		y = 0
+		if hasCount {
+			y = n - 1
+		}
+		return x, y, true, true
+	default:
+		return x, y, false, false
+	}
+	return x, y, false, true
+}
+
+// isEmptyCell reports whether the cell of the sheet has no value.
+func isEmptyCell(s *sheet.Sheet, x, y int) bool {
+	c := s.Cell(x, y)
+	return c == nil || c.IsEmpty()
+}
+
+// nextCellInRow finds the next (step 1) or previous (step -1) non-empty cell in the row.
+func nextCellInRow(s *sheet.Sheet, x, y, step int) (int, bool) {
+	for x += step; x >= 0 && x <= s.Size.MaxX(); x += step {
+		if !isEmptyCell(s, x, y) {
+			return x, true
+		}
+	}
+	return 0, false
+}
+
+// lastCellInRow returns X of the last non-empty cell in the row, or 0 if the row is empty.
+func lastCellInRow(s *sheet.Sheet, y int) int {
+	for x := s.Size.MaxX(); x > 0; x-- {
+		if !isEmptyCell(s, x, y) {
+			return x
+		}
+	}
+	return 0
+}
+
+// blockBoundary returns Y of the empty cell which follows (step 1) or precedes (step -1)
+// the nearest block of non-empty cells in the column. If there is no such cell, returns
+// the border of the sheet.
+func blockBoundary(s *sheet.Sheet, x, y, step int) int {
+	maxY := s.Size.MaxY()
+	y += step
+	// skip empty cells before the block, then the block itself
+	for y >= 0 && y <= maxY && isEmptyCell(s, x, y) {
+		y += step
+	}
+	for y >= 0 && y <= maxY && !isEmptyCell(s, x, y) {
+		y += step
+	}
+	if y < 0 {
+		return 0
+	}
+	if y > maxY {
+		if maxY < 0 {
+			return 0
+		}
+		return maxY
+	}
+	return y
+}
+
+// cellsRect returns the rect with given corners.
+func cellsRect(x1, y1, x2, y2 int) sheet.Rect {
+	if x2 < x1 {
+		x1, x2 = x2, x1
+	}
+	if y2 < y1 {
+		y1, y2 = y2, y1
+	}
+	return sheet.Rect{X: x1, Y: y1, Width: x2 - x1 + 1, Height: y2 - y1 + 1}
+}
+
+// linesRect returns the rect of the whole rows from y1 to y2.
+func (a *App) linesRect(y1, y2 int) sheet.Rect {
+	s := a.doc.CurrentSheet
+	r := cellsRect(0, y1, s.Size.MaxX(), y2)
+	if r.Width < 1 {
+		r.Width = 1
+	}
+	return r
+}
+
+// applyOperator yanks (y), deletes (d) or changes (c) the cells of the rect.
+// Linewise delete removes the rows, others just clear the cells.
+func (a *App) applyOperator(op rune, r sheet.Rect, linewise bool) {
+	s := a.doc.CurrentSheet
+	b := newCellBuffer(a.doc, s, r)
+	b.linewise = linewise
+	a.setRegister(b)
+
+	// all changes are undone at once
+	a.doc.BeginGroup()
+	defer a.doc.EndGroup()
+	x := r.X
+	if linewise {
+		x = s.Cursor.X
+	}
+	switch op {
+	case 'y':
+		a.moveCursorTo(x, r.Y)
+	case 'd':
+		if linewise {
+			s.Cursor.Y = r.Y
+			for i := 0; i < r.Height; i++ {
+				a.doc.DeleteRow()
+			}
+		} else {
+			a.clearRect(r)
+		}
+		a.moveCursorTo(x, r.Y)
+	case 'c':
+		a.clearRect(r)
+		a.moveCursorTo(x, r.Y)
+		a.output.RefreshView()
+		a.editCell()
+	}
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// setRegister puts the cells to the register selected for the command.
+// The unnamed register always gets the cells too.
+func (a *App) setRegister(b *cellBuffer) {
+	switch reg := a.keymap.register; {
+	case reg == clipboardRegister:
+		a.output.SetClipboard(b.tsv())
+	case reg != 0:
+		a.registers[reg] = b
+	}
+	a.cellBuffer = b
+}
+
+// putRegister pastes the cells from the register selected for the command to the cursor,
+// or over the selection. Rows are put to new rows below the cursor, or above it.
+func (a *App) putRegister(above bool) {
+	var b *cellBuffer
+	switch reg := a.keymap.register; {
+	case reg == clipboardRegister:
+		text, err := a.input.ReadClipboard()
+		if err != nil {
+			a.showError(err)
+			return
+		}
+		if b, err = newCellBufferFromTSV(text); err != nil {
+			a.showError(err)
+			return
+		}
+	case reg != 0:
+		b = a.registers[reg]
+	default:
+		b = a.cellBuffer
+	}
+	if b == nil || b.isEmpty() {
+		a.output.SetStatus("register is empty", ui.StatusFlagError)
+		return
+	}
+	s := a.doc.CurrentSheet
+	a.doc.BeginGroup()
+	defer a.doc.EndGroup()
+	r := s.SelectedRect()
+	if b.linewise && !s.IsSelected() {
+		if !above {
+			s.Cursor.Y++
+		}
+		for i := 0; i < b.height; i++ {
+			a.doc.InsertEmptyRow(0)
+		}
+		r = sheet.Rect{X: 0, Y: s.Cursor.Y, Width: 1, Height: 1}
+	}
+	b.paste(a.doc, s, r, 0)
+	s.Unselect()
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+}
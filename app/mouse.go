@@ -0,0 +1,194 @@
+package app
+
+import (
+	"xl/document/sheet"
+	"xl/ui"
+
+	"github.com/gdamore/tcell"
+)
+
+// Мышь. Щелчок по ячейке перемещает курсор, перетаскивание выделяет ячейки, щелчок
+// по заголовку выделяет строку или колонку, перетаскивание правой границы заголовка
+// колонки меняет ее ширину. Колесо прокручивает окно под указателем, щелчок по ярлыку
+// листа делает лист текущим.
+
+// Что делает перетаскивание мышью.
+const (
+	dragNone = iota
+	dragCells
+	dragRows
+	dragCols
+	dragColBorder
+)
+
+// Состояние нажатой кнопки мыши.
+type mouseState struct {
+	drag int
+	// Изменяемая колонка, ее ширина и позиция указателя в начале перетаскивания границы.
+	col       int
+	colSize   int
+	startX    int
+	startSize int
+}
+
+// Число строк и колонок, на которое прокручивает окно колесо мыши.
+const wheelStep = 3
+
+// processMouseEvent handles the mouse event.
+func (a *App) processMouseEvent(ev ui.MouseEvent) {
+	switch {
+	case ev.Buttons&(tcell.WheelUp|tcell.WheelDown|tcell.WheelLeft|tcell.WheelRight) != 0:
+		a.mouseWheel(ev)
+	case ev.Buttons&tcell.Button1 == 0:
+		a.mouseRelease()
+	case a.mouse.drag == dragNone:
+		a.mousePress(ev)
+	default:
+		a.mouseDrag(ev)
+	}
+}
+
+// mousePress starts a click or a drag at the pointer.
+func (a *App) mousePress(ev ui.MouseEvent) {
+	loc := a.output.Locate(ev.X, ev.Y)
+	if loc.Kind == ui.LocationNone {
+		return
+	}
+	a.keymap.reset()
+	if loc.Kind == ui.LocationSheetTab {
+		a.setCurrentSheet(a.doc.Sheets[loc.Sheet])
+		a.output.SetDirty(ui.DirtyStatusLine | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyHRuler | ui.DirtyVRuler)
+		return
+	}
+	if w := a.windows()[loc.Window]; w != a.window {
+		a.switchWindow(w)
+	}
+	s := a.doc.CurrentSheet
+	s.Unselect()
+	switch loc.Kind {
+	case ui.LocationCell:
+		a.mouse.drag = dragCells
+		a.moveCursorTo(loc.X, loc.Y)
+	case ui.LocationRowHeader:
+		a.mouse.drag = dragRows
+		a.moveCursorTo(s.Cursor.X, loc.Y)
+		s.Select(sheet.SelectionRows)
+	case ui.LocationColHeader:
+		a.mouse.drag = dragCols
+		a.moveCursorTo(loc.X, s.Cursor.Y)
+		s.Select(sheet.SelectionCols)
+	case ui.LocationColBorder:
+		a.mouse.drag = dragColBorder
+		a.mouse.col, a.mouse.startX = loc.X, ev.X
+		a.mouse.startSize = s.ColSize(loc.X)
+		a.mouse.colSize = a.mouse.startSize
+	}
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyStatusLine)
+}
+
+// mouseDrag extends the selection to the cell under the pointer or resizes the column
+// while the button is held.
+func (a *App) mouseDrag(ev ui.MouseEvent) {
+	s := a.doc.CurrentSheet
+	if a.mouse.drag == dragColBorder {
+		size := dragColSize(a.mouse.startSize, ev.X-a.mouse.startX)
+		// the column is resized without journal until the button is released
+		s.SetColSize(a.mouse.col, size)
+		a.mouse.colSize = size
+		a.output.SetDirty(ui.DirtyHRuler | ui.DirtyGrid)
+		return
+	}
+	loc := a.output.Locate(ev.X, ev.Y)
+	if loc.Window != a.windowIndex(a.window) {
+		return
+	}
+	x, y, ok := dragTarget(a.mouse.drag, loc, s.Cursor.X, s.Cursor.Y)
+	if !ok || x == s.Cursor.X && y == s.Cursor.Y {
+		return
+	}
+	if !s.IsSelected() {
+		s.Select(sheet.SelectionBlock)
+	}
+	a.moveCursorTo(x, y)
+	a.output.SetDirty(ui.DirtyStatusLine)
+}
+
+// mouseRelease finishes the drag. The resized column gets its width through the document,
+// so the resize can be undone at once.
+func (a *App) mouseRelease() {
+	if a.mouse.drag == dragColBorder {
+		a.doc.CurrentSheet.SetColSize(a.mouse.col, a.mouse.startSize)
+		a.doc.SetColSize(a.mouse.col, a.mouse.colSize)
+		a.output.SetDirty(ui.DirtyHRuler | ui.DirtyGrid)
+	}
+	a.mouse.drag = dragNone
+}
+
+// mouseWheel scrolls the window under the pointer, Shift scrolls it horizontally.
+// The cursor stays where it is.
+func (a *App) mouseWheel(ev ui.MouseEvent) {
+	loc := a.output.Locate(ev.X, ev.Y)
+	if loc.Kind == ui.LocationNone || loc.Kind == ui.LocationSheetTab {
+		return
+	}
+	w := a.windows()[loc.Window]
+	a.checkWindow(w)
+	s, v := w.sheet, &w.viewport
+	if w == a.window {
+		s, v = a.doc.CurrentSheet, &a.doc.CurrentSheet.Viewport
+	}
+	dx, dy := wheelDelta(ev)
+	v.Top += dy
+	if v.Top < s.FrozenRows {
+		v.Top = s.FrozenRows
+	}
+	v.Left += dx
+	if v.Left < s.FrozenCols {
+		v.Left = s.FrozenCols
+	}
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid)
+}
+
+// dragColSize returns the width of the column which border is dragged by dx chars
+// from the width size. The column doesn't get narrower than one step.
+func dragColSize(size, dx int) int {
+	size += dx * colSizeIncrementStep
+	if size < colSizeIncrementStep {
+		size = colSizeIncrementStep
+	}
+	return size
+}
+
+// dragTarget returns the cell the cursor moves to when the pointer is dragged
+// to the location with the cursor at x, y. Selecting rows or columns changes only
+// one coordinate. Returns false if the location doesn't fit the drag.
+func dragTarget(drag int, loc ui.Location, x, y int) (int, int, bool) {
+	switch {
+	case drag == dragCells && loc.Kind == ui.LocationCell:
+		return loc.X, loc.Y, true
+	case drag == dragRows && loc.Y >= 0:
+		return x, loc.Y, true
+	case drag == dragCols && loc.X >= 0:
+		return loc.X, y, true
+	}
+	return x, y, false
+}
+
+// wheelDelta returns how many columns and rows the wheel event scrolls the window by.
+// Shift turns vertical scrolling into horizontal and vice versa.
+func wheelDelta(ev ui.MouseEvent) (dx, dy int) {
+	switch {
+	case ev.Buttons&tcell.WheelLeft != 0:
+		dx = -wheelStep
+	case ev.Buttons&tcell.WheelRight != 0:
+		dx = wheelStep
+	case ev.Buttons&tcell.WheelUp != 0:
+		dy = -wheelStep
+	case ev.Buttons&tcell.WheelDown != 0:
+		dy = wheelStep
+	}
+	if ev.Mod&tcell.ModShift != 0 {
+		dx, dy = dy, dx
+	}
+	return dx, dy
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"xl/document"
+	"xl/document/sheet"
+	"xl/ui"
+
+	"testing"
+
+	"github.com/gdamore/tcell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDragTarget(t *testing.T) {
+	cell := ui.Location{Kind: ui.LocationCell, X: 4, Y: 7}
+	rowHeader := ui.Location{Kind: ui.LocationRowHeader, X: -1, Y: 7}
+	colHeader := ui.Location{Kind: ui.LocationColHeader, X: 4, Y: -1}
+	testCases := []struct {
+		drag int
+		loc  ui.Location
+		x, y int
+		ok   bool
+	}{
+		{dragCells, cell, 4, 7, true},
+		{dragCells, rowHeader, 1, 2, false},
+		// rows and columns are selected by the pointer over cells and headers
+		{dragRows, cell, 1, 7, true},
+		{dragRows, rowHeader, 1, 7, true},
+		{dragRows, colHeader, 1, 2, false},
+		{dragCols, cell, 4, 2, true},
+		{dragCols, colHeader, 4, 2, true},
+		{dragCols, rowHeader, 1, 2, false},
+		{dragCells, ui.Location{}, 1, 2, false},
+	}
+	for _, c := range testCases {
+		x, y, ok := dragTarget(c.drag, c.loc, 1, 2)
+		assert.Equal(t, []int{c.x, c.y}, []int{x, y}, "%d %+v", c.drag, c.loc)
+		assert.Equal(t, c.ok, ok, "%d %+v", c.drag, c.loc)
+	}
+}
+
+func TestDragColSize(t *testing.T) {
+	assert.Equal(t, 30+2*colSizeIncrementStep, dragColSize(30, 2))
+	assert.Equal(t, 30-colSizeIncrementStep, dragColSize(30, -1))
+	assert.Equal(t, colSizeIncrementStep, dragColSize(30, -10))
+}
+
+func TestWheelDelta(t *testing.T) {
+	testCases := []struct {
+		buttons tcell.ButtonMask
+		mod     tcell.ModMask
+		dx, dy  int
+	}{
+		{tcell.WheelUp, 0, 0, -wheelStep},
+		{tcell.WheelDown, 0, 0, wheelStep},
+		{tcell.WheelLeft, 0, -wheelStep, 0},
+		{tcell.WheelRight, 0, wheelStep, 0},
+		{tcell.WheelDown, tcell.ModShift, wheelStep, 0},
+		{tcell.WheelLeft, tcell.ModShift, 0, -wheelStep},
+	}
+	for _, c := range testCases {
+		dx, dy := wheelDelta(ui.MouseEvent{Buttons: c.buttons, Mod: c.mod})
+		assert.Equal(t, []int{c.dx, c.dy}, []int{dx, dy}, "%v %v", c.buttons, c.mod)
+	}
+}
+
+// Экран для тестов мыши: в строке 0 заголовки колонок, в колонке 0 заголовки строк,
+// ячейка X, Y находится в точке X+1, Y+1. Последний символ заголовка колонки 5 —
+// ее граница. В строке 30 ярлыки листов по 10 символов.
+func testLocate(x, y int) ui.Location {
+	switch {
+	case y == 30:
+		return ui.Location{Kind: ui.LocationSheetTab, Sheet: x / 10}
+	case y == 0 && x == 6:
+		return ui.Location{Kind: ui.LocationColBorder, X: 5, Y: -1}
+	case y == 0 && x > 0:
+		return ui.Location{Kind: ui.LocationColHeader, X: x - 1, Y: -1}
+	case x == 0 && y > 0:
+		return ui.Location{Kind: ui.LocationRowHeader, X: -1, Y: y - 1}
+	case x > 0 && y > 0:
+		return ui.Location{Kind: ui.LocationCell, X: x - 1, Y: y - 1}
+	}
+	return ui.Location{}
+}
+
+func mouseEvent(x, y int, buttons tcell.ButtonMask) ui.MouseEvent {
+	return ui.MouseEvent{X: x, Y: y, Buttons: buttons}
+}
+
+func TestMouseSelect(t *testing.T) {
+	d := document.NewWithEmptySheet()
+	a, o := newTestApp(d)
+	o.locate = testLocate
+	s := d.CurrentSheet
+
+	// click and drag over cells selects the block
+	a.processMouseEvent(mouseEvent(2, 3, tcell.Button1))
+	assert.Equal(t, sheet.Cursor{X: 1, Y: 2}, s.Cursor)
+	a.processMouseEvent(mouseEvent(4, 6, tcell.Button1))
+	a.processMouseEvent(mouseEvent(4, 6, 0))
+	assert.Equal(t, sheet.Cursor{X: 3, Y: 5}, s.Cursor)
+	assert.Equal(t, sheet.Rect{X: 1, Y: 2, Width: 3, Height: 4}, s.SelectedRect())
+
+	// click on the header of a row selects the row, dragging extends it
+	a.processMouseEvent(mouseEvent(0, 8, tcell.Button1))
+	a.processMouseEvent(mouseEvent(5, 10, tcell.Button1))
+	a.processMouseEvent(mouseEvent(5, 10, 0))
+	assert.Equal(t, sheet.SelectionRows, s.Selection.Mode)
+	assert.Equal(t, 7, s.Selection.Anchor.Y)
+	assert.Equal(t, 9, s.Cursor.Y)
+
+	// click on the sheet tab makes the sheet current
+	s2, err := d.NewSheet("Two")
+	assert.NoError(t, err)
+	a.processMouseEvent(mouseEvent(12, 30, tcell.Button1))
+	a.processMouseEvent(mouseEvent(12, 30, 0))
+	assert.Equal(t, s2, d.CurrentSheet)
+}
+
+func TestMouseColBorder(t *testing.T) {
+	d := document.NewWithEmptySheet()
+	a, o := newTestApp(d)
+	o.locate = testLocate
+	s := d.CurrentSheet
+	size := s.ColSize(5)
+
+	a.processMouseEvent(mouseEvent(6, 0, tcell.Button1))
+	a.processMouseEvent(mouseEvent(8, 0, tcell.Button1))
+	assert.Equal(t, size+2*colSizeIncrementStep, s.ColSize(5))
+	a.processMouseEvent(mouseEvent(8, 0, 0))
+	assert.Equal(t, size+2*colSizeIncrementStep, s.ColSize(5))
+
+	// the resize is undone at once
+	d.Undo()
+	assert.Equal(t, size, s.ColSize(5))
+}
+
+func TestMouseWheel(t *testing.T) {
+	d := document.NewWithEmptySheet()
+	a, o := newTestApp(d)
+	o.locate = testLocate
+	s := d.CurrentSheet
+	s.FrozenRows = 2
+	s.Viewport.Top = 2
+
+	a.processMouseEvent(mouseEvent(3, 3, tcell.WheelDown))
+	assert.Equal(t, 2+wheelStep, s.Viewport.Top)
+	a.processMouseEvent(mouseEvent(3, 3, tcell.WheelUp))
+	a.processMouseEvent(mouseEvent(3, 3, tcell.WheelUp))
+	assert.Equal(t, 2, s.Viewport.Top)
+	a.processMouseEvent(ui.MouseEvent{X: 3, Y: 3, Buttons: tcell.WheelDown, Mod: tcell.ModShift})
+	assert.Equal(t, wheelStep, s.Viewport.Left)
+	// the cursor stays where it is
+	assert.Equal(t, sheet.Cursor{}, s.Cursor)
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"xl/document"
+	"xl/document/sheet"
+	"xl/formula"
+
+	"strings"
+)
+
+// Режим указания ссылок. Пока редактируется формула, ячейки текущего листа, на которые
+// она ссылается, отмечаются цветами своих ссылок. Стрелки, нажатые там, где в формуле
+// ожидается операнд, двигают по сетке курсор указания, а ссылка на ячейку под ним
+// вставляется в формулу у курсора редактора; Shift со стрелками растягивает диапазон
+// от ячейки, с которой начато указание.
+
+// Указание ссылок при редактировании формулы ячейки X, Y текущего листа.
+type formulaPointer struct {
+	a    *App
+	x, y int
+	// Ссылки формулы на ячейки текущего листа.
+	refs []pointerRef
+	// Идет ли указание, курсор указания и ячейка, от которой растягивается диапазон.
+	active bool
+	cursor sheet.Cursor
+	anchor sheet.Cursor
+}
+
+// Ссылка формулы и ее номер среди всех ссылок формулы, начиная с 1.
+type pointerRef struct {
+	rect sheet.Rect
+	n    int
+}
+
+// SetFormula marks the cells of the current sheet referenced by the formula text.
+func (p *formulaPointer) SetFormula(text string) {
+	p.refs = nil
+	if !strings.HasPrefix(text, "=") {
+		return
+	}
+	title := p.a.doc.CurrentSheet.Title
+	for i, ref := range formula.References(text) {
+		if ref.Sheet != "" && !strings.EqualFold(ref.Sheet, title) {
+			continue
+		}
+		p.refs = append(p.refs, pointerRef{
+			rect: sheet.Rect{X: ref.X, Y: ref.Y, Width: ref.MaxX - ref.X + 1, Height: ref.MaxY - ref.Y + 1},
+			n:    i + 1,
+		})
+	}
+}
+
+// MovePoint moves the reference cursor over the visible cells and returns the reference
+// to the pointed cell or range. The grid scrolls to keep the cursor on the screen.
+func (p *formulaPointer) MovePoint(ref string, dx, dy int, extend bool) string {
+	s := p.a.doc.CurrentSheet
+	if !p.active {
+		p.active = true
+		p.cursor = sheet.Cursor{X: p.x, Y: p.y}
+		p.anchor = p.cursor
+		// the range being replaced is extended from its first corner
+		if refs := formula.References("=" + ref); len(refs) == 1 && (refs[0].Sheet == "" || strings.EqualFold(refs[0].Sheet, s.Title)) {
+			p.anchor = sheet.Cursor{X: refs[0].X, Y: refs[0].Y}
+			p.cursor = sheet.Cursor{X: refs[0].MaxX, Y: refs[0].MaxY}
+		}
+	}
+	if dx != 0 {
+		p.cursor.X = s.NextVisibleCol(p.cursor.X, dx)
+	}
+	if dy != 0 {
+		p.cursor.Y = s.NextVisibleRow(p.cursor.Y, dy)
+	}
+	if !extend {
+		p.anchor = p.cursor
+	}
+	p.a.scrollTo(p.cursor.X, p.cursor.Y)
+	r := p.pointed()
+	if r.Width == 1 && r.Height == 1 {
+		return document.CellName(r.X, r.Y)
+	}
+	return document.CellName(r.X, r.Y) + ":" + document.CellName(r.MaxX(), r.MaxY())
+}
+
+// StopPoint hides the reference cursor.
+func (p *formulaPointer) StopPoint() {
+	p.active = false
+}
+
+// pointed returns the rect between the anchor and the reference cursor.
+func (p *formulaPointer) pointed() sheet.Rect {
+	r := sheet.Rect{X: p.cursor.X, Y: p.cursor.Y, Width: 1, Height: 1}
+	if p.anchor.X < r.X {
+		r.X, r.Width = p.anchor.X, r.X-p.anchor.X+1
+	} else {
+		r.Width = p.anchor.X - r.X + 1
+	}
+	if p.anchor.Y < r.Y {
+		r.Y, r.Height = p.anchor.Y, r.Y-p.anchor.Y+1
+	} else {
+		r.Height = p.anchor.Y - r.Y + 1
+	}
+	return r
+}
+
+// marks returns the number of the last reference of the formula to the cell, or 0,
+// and whether the cell is pointed.
+func (p *formulaPointer) marks(x, y int) (int, bool) {
+	n := 0
+	for _, ref := range p.refs {
+		if ref.rect.Contains(x, y) {
+			n = ref.n
+		}
+	}
+	if !p.active {
+		return n, false
+	}
+	r := p.pointed()
+	return n, r.Contains(x, y)
+}
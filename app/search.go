@@ -0,0 +1,344 @@
+package app
+
+import (
+	"xl/document"
+	"xl/document/sheet"
+	"xl/ui"
+
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell"
+)
+
+// Поиск (/, ?, n, N) и замена (:s/pat/repl/flags). Курсор следует за совпадением по мере
+// ввода образца; Esc возвращает курсор на место. Параметры поиска задаются командой
+// :searchOptions и действуют, пока не будут изменены; в самом образце \c включает
+// поиск без учета регистра, а \C - с учетом.
+
+// Последний поиск, который повторяется клавишами n и N.
+type searchState struct {
+	// Параметры поиска, заданные :searchOptions.
+	opts document.SearchOptions
+	// Образец и направление последнего поиска.
+	pattern  string
+	backward bool
+}
+
+// Команда замены: необязательный % и s, за которыми следует разделитель.
+var substituteCommand = regexp.MustCompile(`^(%?)s([^\w\s])`)
+
+// searchOptions returns the options of the search for the pattern. Search in selection
+// is limited by the current selection, or by the one of the previous search.
+func (a *App) searchOptions(pattern string, backward bool) document.SearchOptions {
+	opts := withPattern(a.search.opts, pattern)
+	opts.Backward = backward
+	if opts.Scope == document.SearchSelection {
+		switch {
+		case a.doc.CurrentSheet.IsSelected():
+			opts.Rect = a.doc.CurrentSheet.SelectedRect()
+		case opts.Rect.Width == 0:
+			opts.Scope = document.SearchSheet
+		}
+	}
+	return opts
+}
+
+// withPattern returns the options with the pattern and the case flag it contains.
+func withPattern(opts document.SearchOptions, pattern string) document.SearchOptions {
+	p, ignoreCase := parseSearchPattern(pattern)
+	opts.Pattern = p
+	if ignoreCase != 0 {
+		opts.IgnoreCase = ignoreCase > 0
+	}
+	return opts
+}
+
+// inputSearch reads the pattern in status line and moves the cursor to the matching cell
+// while the pattern is typed.
+func (a *App) inputSearch(backward bool) {
+	start, x, y := a.doc.CurrentSheet, a.doc.CurrentSheet.Cursor.X, a.doc.CurrentSheet.Cursor.Y
+	opts := a.searchOptions("", backward)
+	// cursor moves change the selection, so its rect is taken before the input
+	start.Unselect()
+	find := func(pattern string) (bool, error) {
+		s, fx, fy, err := a.doc.Search(start, x, y, withPattern(opts, pattern))
+		if err != nil || s == nil {
+			a.moveToCell(start, x, y)
+			return false, err
+		}
+		a.moveToCell(s, fx, fy)
+		return true, nil
+	}
+	pattern, err := a.output.InputSearch(searchPrompt(backward), func(pattern string) {
+		if pattern == "" {
+			a.moveToCell(start, x, y)
+		} else {
+			// incomplete regular expressions are not errors while typing
+			find(pattern)
+		}
+		a.output.RefreshView()
+	})
+	a.output.SetStatus("", 0)
+	if err == ui.ErrCancelled || (err == nil && pattern == "") {
+		a.moveToCell(start, x, y)
+		return
+	}
+	if err != nil {
+		a.showError(err)
+		return
+	}
+	a.search.pattern, a.search.backward = pattern, backward
+	a.search.opts.Rect = opts.Rect
+	if ok, err := find(pattern); err != nil {
+		a.showError(err)
+	} else if !ok {
+		a.output.SetStatus(fmt.Sprintf("pattern not found: %s", pattern), ui.StatusFlagError)
+	}
+}
+
+// cmdSearchNext repeats the last search in the same direction, or in the opposite one
+// if reverse is set.
+func (a *App) cmdSearchNext(reverse bool) {
+	if a.search.pattern == "" {
+		a.output.SetStatus("no previous pattern", ui.StatusFlagError)
+		return
+	}
+	s := a.doc.CurrentSheet
+	opts := a.searchOptions(a.search.pattern, a.search.backward != reverse)
+	s.Unselect()
+	found, x, y, err := a.doc.Search(s, s.Cursor.X, s.Cursor.Y, opts)
+	if err != nil {
+		a.showError(err)
+		return
+	}
+	if found == nil {
+		a.output.SetStatus(fmt.Sprintf("pattern not found: %s", a.search.pattern), ui.StatusFlagError)
+		return
+	}
+	a.output.SetStatus(searchPrompt(opts.Backward)+a.search.pattern, 0)
+	a.moveToCell(found, x, y)
+}
+
+// searchPrompt returns the character starting the search in the direction.
+func searchPrompt(backward bool) string {
+	if backward {
+		return "?"
+	}
+	return "/"
+}
+
+// moveToCell makes the sheet current and moves the cursor to the cell.
+func (a *App) moveToCell(s *sheet.Sheet, x, y int) {
+	if s != a.doc.CurrentSheet {
+		a.setCurrentSheet(s)
+		a.output.SetDirty(ui.DirtyStatusLine)
+	}
+	a.moveCursorTo(x, y)
+}
+
+// cmdSearchOptions sets the options of search and replace: regex or literal pattern,
+// case or nocase, values or formulas to look into, and sheet, selection or doc scope.
+// Without arguments shows the current options.
+func (a *App) cmdSearchOptions(args []string) {
+	opts := &a.search.opts
+	for _, arg := range args {
+		switch arg {
+		case "":
+		case "regex":
+			opts.Regexp = true
+		case "literal":
+			opts.Regexp = false
+		case "case":
+			opts.IgnoreCase = false
+		case "nocase":
+			opts.IgnoreCase = true
+		case "values":
+			opts.Formulas = false
+		case "formulas":
+			opts.Formulas = true
+		case "sheet":
+			opts.Scope = document.SearchSheet
+		case "selection":
+			opts.Scope = document.SearchSelection
+		case "doc":
+			opts.Scope = document.SearchDocument
+		default:
+			a.output.SetStatus(fmt.Sprintf("unknown search option %s", arg), ui.StatusFlagError)
+			return
+		}
+	}
+	status := []string{"literal", "case", "values", [...]string{"sheet", "selection", "doc"}[opts.Scope]}
+	if opts.Regexp {
+		status[0] = "regex"
+	}
+	if opts.IgnoreCase {
+		status[1] = "nocase"
+	}
+	if opts.Formulas {
+		status[2] = "formulas"
+	}
+	a.output.SetStatus(strings.Join(status, " "), 0)
+}
+
+// cmdSubstitute replaces the pattern in the cells: ":s/pat/repl/flags" works on the selection
+// or the cell under cursor, ":%s/pat/repl/flags" on the whole scope of search options.
+// Flags: g replaces all matches in a cell, c asks to confirm each replacement, i and I
+// ignore or respect case.
+func (a *App) cmdSubstitute(c string) {
+	m := substituteCommand.FindStringSubmatch(c)
+	parts := splitEscaped(c[len(m[0]):], m[2][0])
+	if len(parts) < 2 || len(parts) > 3 {
+		a.output.SetStatus("usage: s/pattern/replacement/flags", ui.StatusFlagError)
+		return
+	}
+	s := a.doc.CurrentSheet
+	opts := a.searchOptions(parts[0], false)
+	switch {
+	case m[1] == "%" && opts.Scope == document.SearchSelection:
+		opts.Scope = document.SearchSheet
+	case m[1] == "":
+		opts.Scope = document.SearchSelection
+		opts.Rect = sheet.Rect{X: s.Cursor.X, Y: s.Cursor.Y, Width: 1, Height: 1}
+		if s.IsSelected() {
+			opts.Rect = s.SelectedRect()
+		}
+	}
+	all, confirm := false, false
+	if len(parts) == 3 {
+		for _, f := range parts[2] {
+			switch f {
+			case 'g':
+				all = true
+			case 'c':
+				confirm = true
+			case 'i':
+				opts.IgnoreCase = true
+			case 'I':
+				opts.IgnoreCase = false
+			default:
+				a.output.SetStatus(fmt.Sprintf("unknown flag %c", f), ui.StatusFlagError)
+				return
+			}
+		}
+	}
+	repl := parts[1]
+	if opts.Regexp {
+		repl = vimReplacement(repl)
+	}
+	s.Unselect()
+	var ask func(s *sheet.Sheet, x, y int) int
+	if confirm {
+		ask = a.confirmReplace(parts[1])
+	}
+	n, err := a.doc.Replace(s, opts, repl, all, ask)
+	if err != nil {
+		a.showError(err)
+		return
+	}
+	a.search.pattern, a.search.backward = parts[0], false
+	a.output.SetStatus(fmt.Sprintf("%d cells changed", n), 0)
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine)
+}
+
+// confirmReplace returns the function which shows the cell to be changed and asks
+// whether to replace it.
+func (a *App) confirmReplace(repl string) func(s *sheet.Sheet, x, y int) int {
+	answers := map[rune]int{
+		'y': document.ReplaceYes,
+		'n': document.ReplaceNo,
+		'a': document.ReplaceAll,
+		'q': document.ReplaceQuit,
+	}
+	return func(s *sheet.Sheet, x, y int) int {
+		a.moveToCell(s, x, y)
+		a.output.SetStatus(fmt.Sprintf("replace with %s (y/n/a/q)?", repl), 0)
+		a.output.SetDirty(ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyHRuler | ui.DirtyVRuler)
+		a.output.RefreshView()
+		for {
+			event, err := a.input.ReadKey()
+			if err != nil {
+				return document.ReplaceQuit
+			}
+			if k, ok := event.(ui.KeyEvent); ok {
+				if answer, ok := answers[k.Ch]; ok {
+					return answer
+				}
+				if k.Key == tcell.KeyEsc {
+					return document.ReplaceQuit
+				}
+			}
+		}
+	}
+}
+
+// splitEscaped splits the text by the separator which is not escaped with backslash.
+// Escaped separators lose the backslash, other escapes are kept.
+func splitEscaped(text string, sep byte) []string {
+	var parts []string
+	var buf bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && i+1 < len(text) && text[i+1] == sep:
+			buf.WriteByte(sep)
+			i++
+		case text[i] == sep:
+			parts = append(parts, buf.String())
+			buf.Reset()
+		default:
+			buf.WriteByte(text[i])
+		}
+	}
+	return append(parts, buf.String())
+}
+
+// parseSearchPattern splits off vim-like flags of the pattern: \c makes the search
+// ignore case and \C makes it case-sensitive. Returns the pattern without the flags
+// and the case flag, if any: 1 to ignore case, -1 to respect it, 0 if not set.
+func parseSearchPattern(p string) (string, int) {
+	ignoreCase := 0
+	var buf bytes.Buffer
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+1 < len(p) {
+			switch p[i+1] {
+			case 'c':
+				ignoreCase, i = 1, i+1
+				continue
+			case 'C':
+				ignoreCase, i = -1, i+1
+				continue
+			}
+			buf.WriteByte(p[i])
+			i++
+		}
+		buf.WriteByte(p[i])
+	}
+	return buf.String(), ignoreCase
+}
+
+// vimReplacement converts the replacement string of vim substitute command to the form
+// of regexp package: \1 and & become submatches, $ is escaped.
+func vimReplacement(repl string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(repl); i++ {
+		switch c := repl[i]; {
+		case c == '\\' && i+1 < len(repl):
+			i++
+			if n := repl[i]; n >= '0' && n <= '9' {
+				buf.WriteString("${" + strconv.Itoa(int(n-'0')) + "}")
+			} else {
+				buf.WriteByte(n)
+			}
+		case c == '&':
+			buf.WriteString("${0}")
+		case c == '$':
+			buf.WriteString("$$")
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
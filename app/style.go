@@ -0,0 +1,170 @@
+package app
+
+import (
+	"xl/document/sheet"
+	"xl/ui"
+
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell"
+)
+
+// Команда :style меняет оформление выделенных ячеек или ячейки под курсором, например
+// ":style bold fg red align center", ":style nobold bg default", ":style border bottom",
+// ":style clear". Без аргументов показывает оформление ячейки под курсором.
+
+var alignNames = []string{"general", "left", "center", "right"}
+
+var borderNames = map[string]int{
+	"left":   sheet.BorderLeft,
+	"right":  sheet.BorderRight,
+	"top":    sheet.BorderTop,
+	"bottom": sheet.BorderBottom,
+	"all":    sheet.BorderLeft | sheet.BorderRight | sheet.BorderTop | sheet.BorderBottom,
+	"none":   0,
+}
+
+// cmdStyle changes the style of the selected cells according to the arguments.
+func (a *App) cmdStyle(args []string) {
+	s := a.doc.CurrentSheet
+	if len(args) == 0 {
+		a.output.SetStatus(describeStyle(s.CellStyle(s.Cursor.X, s.Cursor.Y)), 0)
+		return
+	}
+	change, err := parseStyleArgs(args)
+	if err != nil {
+		a.showError(err)
+		return
+	}
+	a.doc.SetStyle(s, s.SelectedRect(), change)
+	s.Unselect()
+	a.output.SetDirty(ui.DirtyGrid | ui.DirtyStatusLine)
+}
+
+// parseStyleArgs returns the function making the changes of a style the arguments
+// of :style command describe.
+func parseStyleArgs(args []string) (func(st *sheet.Style), error) {
+	var changes []func(st *sheet.Style)
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(args[i])
+		flag := strings.TrimPrefix(arg, "no")
+		on := flag == arg
+		switch flag {
+		case "bold":
+			changes = append(changes, func(st *sheet.Style) { st.Bold = on })
+			continue
+		case "italic":
+			changes = append(changes, func(st *sheet.Style) { st.Italic = on })
+			continue
+		case "underline":
+			changes = append(changes, func(st *sheet.Style) { st.Underline = on })
+			continue
+		case "wrap":
+			changes = append(changes, func(st *sheet.Style) { st.Wrap = on })
+			continue
+		}
+		if arg == "clear" {
+			changes = append(changes, func(st *sheet.Style) { *st = sheet.Style{} })
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("unknown style argument %s", arg)
+		}
+		i++
+		value := strings.ToLower(args[i])
+		switch arg {
+		case "fg", "bg":
+			color, ok := parseColor(value)
+			if !ok {
+				return nil, fmt.Errorf("unknown color %s", value)
+			}
+			if arg == "fg" {
+				changes = append(changes, func(st *sheet.Style) { st.Fg = color })
+			} else {
+				changes = append(changes, func(st *sheet.Style) { st.Bg = color })
+			}
+		case "align":
+			align := -1
+			for n, name := range alignNames {
+				if name == value {
+					align = n
+				}
+			}
+			if align < 0 {
+				return nil, fmt.Errorf("unknown alignment %s", value)
+			}
+			changes = append(changes, func(st *sheet.Style) { st.Align = align })
+		case "border":
+			borders, ok := borderNames[value]
+			if !ok {
+				return nil, fmt.Errorf("unknown border %s", value)
+			}
+			changes = append(changes, func(st *sheet.Style) {
+				if borders == 0 {
+					st.Borders = 0
+				} else {
+					st.Borders |= borders
+				}
+			})
+		default:
+			return nil, fmt.Errorf("unknown style argument %s", arg)
+		}
+	}
+	return func(st *sheet.Style) {
+		for _, change := range changes {
+			change(st)
+		}
+	}, nil
+}
+
+// parseColor converts the name of the color or its #rrggbb code to the code used
+// by styles. "default" is the empty code.
+func parseColor(name string) (string, bool) {
+	if name == "default" {
+		return "", true
+	}
+	c := tcell.GetColor(name)
+	if c == tcell.ColorDefault || c.Hex() < 0 {
+		return "", false
+	}
+	return fmt.Sprintf("#%06x", c.Hex()), true
+}
+
+// describeStyle returns the style as the arguments of :style command.
+func describeStyle(st sheet.Style) string {
+	if st == (sheet.Style{}) {
+		return "default"
+	}
+	var b bytes.Buffer
+	add := func(s string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s)
+	}
+	for _, flag := range []struct {
+		on   bool
+		name string
+	}{{st.Bold, "bold"}, {st.Italic, "italic"}, {st.Underline, "underline"}, {st.Wrap, "wrap"}} {
+		if flag.on {
+			add(flag.name)
+		}
+	}
+	if st.Fg != "" {
+		add("fg " + st.Fg)
+	}
+	if st.Bg != "" {
+		add("bg " + st.Bg)
+	}
+	if st.Align != sheet.AlignGeneral {
+		add("align " + alignNames[st.Align])
+	}
+	for _, name := range []string{"left", "right", "top", "bottom"} {
+		if st.Borders&borderNames[name] != 0 {
+			add("border " + name)
+		}
+	}
+	return b.String()
+}
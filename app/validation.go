@@ -0,0 +1,165 @@
+package app
+
+import (
+	"xl/document"
+	"xl/document/sheet"
+	"xl/ui"
+
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Команда :validate задает правило проверки выделенных ячеек или ячейки под курсором:
+//   :validate number 0 100
+//   :validate integer 1 *
+//   :validate date 2020-01-01 2020-12-31
+//   :validate length * 10
+//   :validate list yes,no
+//   :validate list A1:A5
+//   :validate formula =A1>B1
+// "*" оставляет границу открытой, слово warn в конце только предупреждает о недопустимом
+// значении, не отменяя ввод. ":validate clear" удаляет правила выделенных ячеек,
+// ":validate" без аргументов отмечает ячейки листа, не прошедшие проверку,
+// ":validate off" снимает отметки. Ячейки списка допустимых значений берутся с того же
+// листа, что и проверяемые ячейки.
+
+// cmdValidate sets the validation rule described by the arguments or marks invalid cells.
+func (a *App) cmdValidate(args []string) {
+	s := a.doc.CurrentSheet
+	defer a.output.SetDirty(ui.DirtyGrid | ui.DirtyStatusLine)
+	switch arg1(args) {
+	case "":
+		a.validating[s] = true
+		a.output.SetStatus(fmt.Sprintf("%d invalid cells", len(a.doc.InvalidCells(s))), 0)
+		return
+	case "off":
+		delete(a.validating, s)
+		return
+	case "clear":
+		if err := a.doc.SetValidation(s, s.SelectedRect(), nil); err != nil {
+			a.showError(err)
+		}
+		s.Unselect()
+		return
+	}
+	rule, err := parseValidation(args)
+	if err != nil {
+		a.showError(err)
+		return
+	}
+	if err := a.doc.SetValidation(s, s.SelectedRect(), &rule); err != nil {
+		a.showError(err)
+		return
+	}
+	s.Unselect()
+	a.output.SetStatus(describeValidation(rule), 0)
+}
+
+// parseValidation returns the rule the arguments of :validate command describe, the rect
+// of the rule is not set.
+func parseValidation(args []string) (sheet.Validation, error) {
+	var rule sheet.Validation
+	if n := len(args); n > 1 && strings.EqualFold(args[n-1], "warn") {
+		rule.Warning = true
+		args = args[:n-1]
+	}
+	if len(args) < 2 {
+		return rule, fmt.Errorf("incomplete validation rule")
+	}
+	switch kind := strings.ToLower(args[0]); kind {
+	case "number", "integer", "date", "length":
+		if len(args) != 3 {
+			return rule, fmt.Errorf("%s needs minimum and maximum", kind)
+		}
+		rule.Kind = map[string]int{
+			"number":  sheet.ValidateNumber,
+			"integer": sheet.ValidateNumber,
+			"date":    sheet.ValidateDate,
+			"length":  sheet.ValidateLength,
+		}[kind]
+		for i, bound := range []*string{&rule.Min, &rule.Max} {
+			if args[1+i] != "*" {
+				*bound = args[1+i]
+			}
+		}
+		rule.Integer = kind == "integer"
+	case "list":
+		values := strings.Join(args[1:], " ")
+		if i := strings.LastIndex(values, "!"); i >= 0 {
+			if _, ok := parseRect(values[i+1:]); ok {
+				return rule, fmt.Errorf("list source must be on the same sheet")
+			}
+		}
+		if src, ok := parseRect(values); ok {
+			rule.Kind, rule.Source = sheet.ValidateList, src
+		} else {
+			rule.Kind, rule.Values = sheet.ValidateList, strings.Split(values, ",")
+		}
+	case "formula":
+		rule.Kind, rule.Formula = sheet.ValidateFormula, strings.Join(args[1:], " ")
+	default:
+		return rule, fmt.Errorf("unknown validation %s", args[0])
+	}
+	return rule, nil
+}
+
+// parseRect returns the rect of cells written as A1:B5, or a single cell.
+func parseRect(s string) (sheet.Rect, bool) {
+	from, to := s, s
+	if i := strings.Index(s, ":"); i >= 0 {
+		from, to = s[:i], s[i+1:]
+	}
+	x1, y1, _, _, err := document.CellAxis(strings.ToUpper(from))
+	if err != nil {
+		return sheet.Rect{}, false
+	}
+	x2, y2, _, _, err := document.CellAxis(strings.ToUpper(to))
+	if err != nil {
+		return sheet.Rect{}, false
+	}
+	if x2 < x1 {
+		x1, x2 = x2, x1
+	}
+	if y2 < y1 {
+		y1, y2 = y2, y1
+	}
+	return sheet.Rect{X: x1, Y: y1, Width: x2 - x1 + 1, Height: y2 - y1 + 1}, true
+}
+
+// describeValidation returns the rule as the arguments of :validate command.
+func describeValidation(rule sheet.Validation) string {
+	var b bytes.Buffer
+	bound := func(v string) string {
+		if v == "" {
+			return "*"
+		}
+		return v
+	}
+	switch rule.Kind {
+	case sheet.ValidateNumber:
+		if rule.Integer {
+			b.WriteString("integer")
+		} else {
+			b.WriteString("number")
+		}
+		b.WriteString(" " + bound(rule.Min) + " " + bound(rule.Max))
+	case sheet.ValidateDate:
+		b.WriteString("date " + bound(rule.Min) + " " + bound(rule.Max))
+	case sheet.ValidateLength:
+		b.WriteString("length " + bound(rule.Min) + " " + bound(rule.Max))
+	case sheet.ValidateList:
+		b.WriteString("list ")
+		if src := rule.Source; src.Width > 0 {
+			b.WriteString(document.CellName(src.X, src.Y) + ":" + document.CellName(src.MaxX(), src.MaxY()))
+		} else {
+			b.WriteString(strings.Join(rule.Values, ","))
+		}
+	case sheet.ValidateFormula:
+		b.WriteString("formula " + rule.Formula)
+	}
+	if rule.Warning {
+		b.WriteString(" warn")
+	}
+	return b.String()
+}
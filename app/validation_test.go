@@ -0,0 +1,25 @@
+package app
+
+import (
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseValidationList(t *testing.T) {
+	rule, err := parseValidation([]string{"list", "a2:A5"})
+	assert.NoError(t, err)
+	assert.Equal(t, sheet.Validation{Kind: sheet.ValidateList, Source: sheet.Rect{X: 0, Y: 1, Width: 1, Height: 4}}, rule)
+
+	rule, err = parseValidation([]string{"list", "yes!,no", "warn"})
+	assert.NoError(t, err)
+	assert.Equal(t, sheet.Validation{Kind: sheet.ValidateList, Values: []string{"yes!", "no"}, Warning: true}, rule)
+
+	// the source is looked up on the sheet of the rule only
+	for _, src := range []string{"Sheet2!A1:A5", "'my sheet'!B2"} {
+		_, err = parseValidation([]string{"list", src})
+		assert.EqualError(t, err, "list source must be on the same sheet", src)
+	}
+}
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"xl/document/sheet"
+	"xl/ui"
+
+	"fmt"
+)
+
+// Окна (:split, :vsplit). Экран делится на окна, каждое из которых показывает лист со своими
+// курсором и областью просмотра, в том числе один и тот же лист в разных окнах. Курсор
+// и область просмотра текущего окна хранятся, как и без деления экрана, в самом листе,
+// а окно запоминает их, когда перестает быть текущим.
+
+// Окно и его состояние на момент, когда оно перестало быть текущим.
+type window struct {
+	sheet    *sheet.Sheet
+	cursor   sheet.Cursor
+	viewport sheet.Viewport
+}
+
+// Дерево деления экрана на окна, см. ui.Layout. Лист дерева содержит окно, узел - дочерние
+// области.
+type windowLayout struct {
+	window   *window
+	vertical bool
+	children []*windowLayout
+	parent   *windowLayout
+}
+
+// leaves appends the windows of the layout in order of display.
+func (l *windowLayout) leaves(windows []*window) []*window {
+	if l.window != nil {
+		return append(windows, l.window)
+	}
+	for _, c := range l.children {
+		windows = c.leaves(windows)
+	}
+	return windows
+}
+
+// find returns the leaf of the layout containing the window.
+func (l *windowLayout) find(w *window) *windowLayout {
+	if l.window == w {
+		return l
+	}
+	for _, c := range l.children {
+		if found := c.find(w); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// view returns the layout for output with the windows numbered by their position in the list.
+func (l *windowLayout) view(windows []*window) *ui.Layout {
+	v := &ui.Layout{Vertical: l.vertical}
+	for i, w := range windows {
+		if w == l.window {
+			v.Window = i
+		}
+	}
+	for _, c := range l.children {
+		v.Children = append(v.Children, c.view(windows))
+	}
+	return v
+}
+
+// resetWindows leaves the only window showing the current sheet.
+func (a *App) resetWindows() {
+	a.window = &window{}
+	a.layout = &windowLayout{window: a.window}
+}
+
+// windows returns all the windows in order of display.
+func (a *App) windows() []*window {
+	return a.layout.leaves(nil)
+}
+
+// windowIndex returns the position of the window in order of display.
+func (a *App) windowIndex(w *window) int {
+	for i, ww := range a.windows() {
+		if ww == w {
+			return i
+		}
+	}
+	return 0
+}
+
+// switchWindow makes the window current. The state of the current window is saved
+// and the sheet of the new one gets its cursor and viewport.
+func (a *App) switchWindow(w *window) {
+	s := a.doc.CurrentSheet
+	s.Unselect()
+	a.window.sheet, a.window.cursor, a.window.viewport = s, s.Cursor, s.Viewport
+	a.window = w
+	a.checkWindow(w)
+	a.setCurrentSheet(w.sheet)
+	w.sheet.Cursor, w.sheet.Viewport = w.cursor, w.viewport
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyStatusLine)
+}
+
+// checkWindow makes the window show the current sheet if its own sheet is deleted
+// while the window is not current.
+func (a *App) checkWindow(w *window) {
+	for _, s := range a.doc.Sheets {
+		if s == w.sheet {
+			return
+		}
+	}
+	s := a.doc.CurrentSheet
+	w.sheet, w.cursor, w.viewport = s, s.Cursor, s.Viewport
+}
+
+// setCurrentSheet makes the sheet current.
+func (a *App) setCurrentSheet(s *sheet.Sheet) {
+	for i := range a.doc.Sheets {
+		if a.doc.Sheets[i] == s {
+			a.doc.CurrentSheet, a.doc.CurrentSheetN = s, i
+		}
+	}
+}
+
+// cmdSplit splits the current window in two, side by side if vertical is set or one above
+// another otherwise. The new window shows the sheet with the given name or the current one
+// and becomes current.
+func (a *App) cmdSplit(vertical bool, name string) {
+	s := a.doc.CurrentSheet
+	if name != "" {
+		if s = a.doc.SheetByTitle(name); s == nil {
+			a.output.SetStatus(fmt.Sprintf("no sheet %s", name), ui.StatusFlagError)
+			return
+		}
+	}
+	w := &window{sheet: s, cursor: s.Cursor, viewport: s.Viewport}
+	l := a.layout.find(a.window)
+	nl := &windowLayout{window: w}
+	if p := l.parent; p != nil && p.vertical == vertical {
+		// the new window shares the area of the parent with the siblings
+		for i, c := range p.children {
+			if c == l {
+				p.children = append(p.children[:i], append([]*windowLayout{nl}, p.children[i:]...)...)
+				break
+			}
+		}
+		nl.parent = p
+	} else {
+		old := &windowLayout{window: l.window, parent: l}
+		nl.parent = l
+		l.window, l.vertical, l.children = nil, vertical, []*windowLayout{nl, old}
+	}
+	a.switchWindow(w)
+}
+
+// closeWindow closes the current window, the previous one becomes current.
+// Returns false if it is the only window.
+func (a *App) closeWindow() bool {
+	l := a.layout.find(a.window)
+	p := l.parent
+	if p == nil {
+		return false
+	}
+	windows := a.windows()
+	i := a.windowIndex(a.window)
+	next := windows[(i+len(windows)-1)%len(windows)]
+	for i, c := range p.children {
+		if c == l {
+			p.children = append(p.children[:i], p.children[i+1:]...)
+			break
+		}
+	}
+	if len(p.children) == 1 {
+		c := p.children[0]
+		p.window, p.vertical, p.children = c.window, c.vertical, c.children
+		for _, cc := range p.children {
+			cc.parent = p
+		}
+	}
+	a.switchWindow(next)
+	return true
+}
+
+// cmdClose closes the current window unless it is the only one.
+func (a *App) cmdClose() {
+	if !a.closeWindow() {
+		a.output.SetStatus("cannot close last window", ui.StatusFlagError)
+	}
+}
+
+// cmdOnly closes all the windows but the current one.
+func (a *App) cmdOnly() {
+	a.layout = &windowLayout{window: a.window}
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid)
+}
+
+// cmdNextWindow makes current the window following the current one on N positions,
+// or preceding it if N is negative.
+func (a *App) cmdNextWindow(n int) {
+	windows := a.windows()
+	i := (a.windowIndex(a.window) + n) % len(windows)
+	if i < 0 {
+		i += len(windows)
+	}
+	a.switchWindow(windows[i])
+}
+
+// windowKey runs the window command typed after Ctrl-W: w and W go to the next and
+// the previous window, s and v split the window, c closes it and o closes all the others.
+func (a *App) windowKey(ch rune, n int) {
+	switch ch {
+	case 'w':
+		a.cmdNextWindow(n)
+	case 'W':
+		a.cmdNextWindow(-n)
+	case 's':
+		a.cmdSplit(false, "")
+	case 'v':
+		a.cmdSplit(true, "")
+	case 'c', 'q':
+		a.cmdClose()
+	case 'o':
+		a.cmdOnly()
+	default:
+		a.output.SetStatus(fmt.Sprintf("unknown window command %c", ch), ui.StatusFlagError)
+	}
+}
+
+// cmdFreeze freezes the rows above the cursor and the columns left of it, "row" and "col"
+// freeze the first row or column only, "off" unfreezes the panes.
+func (a *App) cmdFreeze(arg string) {
+	s := a.doc.CurrentSheet
+	x, y := s.Cursor.X, s.Cursor.Y
+	switch arg {
+	case "":
+	case "row":
+		x, y = 0, 1
+	case "col":
+		x, y = 1, 0
+	case "off":
+		x, y = 0, 0
+	default:
+		a.output.SetStatus(fmt.Sprintf("unknown freeze mode %s", arg), ui.StatusFlagError)
+		return
+	}
+	a.doc.Freeze(s, x, y)
+	a.scrollToCursor()
+	a.output.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid)
+}
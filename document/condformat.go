@@ -0,0 +1,224 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/sheet"
+
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+)
+
+// Условное форматирование. Правила хранит лист, а проверяет их документ. Проверка всех
+// правил листа выполняется один раз после каждого изменения документа, когда лист
+// впервые выводится, и результат запоминается, так что вывод ячеек не вычисляет
+// формулы правил заново.
+
+// Вычисленное условное оформление ячеек по листам.
+type condCache struct {
+	changes int
+	sheets  map[int]map[sheet.Cursor]sheet.Style
+}
+
+// AddCondRule adds the conditional formatting rule to the sheet. The rule gets the lowest
+// priority among the rules of the sheet.
+func (d *Document) AddCondRule(s *sheet.Sheet, rule sheet.CondRule) error {
+	switch rule.Kind {
+	case sheet.CondValue:
+		if !IsFilterOp(rule.Op) {
+			return eval.NewError(eval.ErrorKindName, "unknown comparison %s", rule.Op)
+		}
+	case sheet.CondFormula:
+		c := sheet.NewCellUntyped(rule.Operand)
+		if !sheet.IsFormula(rule.Operand) || c.Expression(eval.NewContext(d, s.Idx)) == nil {
+			return eval.NewError(eval.ErrorKindFormula, "malformed formula %s", rule.Operand)
+		}
+	case sheet.CondTop, sheet.CondBottom:
+		if rule.N < 1 {
+			return eval.NewError(eval.ErrorKindCasting, "number of values must be positive")
+		}
+	case sheet.CondScale:
+		if _, ok := parseRGB(rule.MinColor); !ok {
+			return eval.NewError(eval.ErrorKindCasting, "invalid color %s", rule.MinColor)
+		}
+		if _, ok := parseRGB(rule.MaxColor); !ok {
+			return eval.NewError(eval.ErrorKindCasting, "invalid color %s", rule.MaxColor)
+		}
+	}
+	d.changeCondRules(s, append(s.CondRules(), rule))
+	return nil
+}
+
+// ClearCondRules removes the conditional formatting rules of the sheet which apply
+// to any cell of rect r.
+func (d *Document) ClearCondRules(s *sheet.Sheet, r sheet.Rect) {
+	var rules []sheet.CondRule
+	for _, rule := range s.CondRules() {
+		if rule.Rect.Intersect(r).Width == 0 {
+			rules = append(rules, rule)
+		}
+	}
+	d.changeCondRules(s, rules)
+}
+
+// changeCondRules replaces the rules of the sheet recording the change.
+func (d *Document) changeCondRules(s *sheet.Sheet, rules []sheet.CondRule) {
+	a := &condAction{sheetIdx: s.Idx, before: s.CondRules(), after: rules}
+	s.SetCondRules(rules)
+	d.record(a)
+}
+
+// CondStyle returns the style given to the cell by the conditional formatting rules.
+// Rules of the sheet are checked again only if the document has changed since they
+// were checked last time.
+func (d *Document) CondStyle(s *sheet.Sheet, x, y int) sheet.Style {
+	cache := &d.condCache
+	if cache.sheets == nil || cache.changes != d.changes {
+		cache.changes = d.changes
+		cache.sheets = make(map[int]map[sheet.Cursor]sheet.Style)
+	}
+	styles, ok := cache.sheets[s.Idx]
+	if !ok {
+		styles = d.evalCondRules(s)
+		cache.sheets[s.Idx] = styles
+	}
+	return styles[sheet.Cursor{X: x, Y: y}]
+}
+
+// evalCondRules checks all the rules of the sheet and returns the styles of the cells
+// matching them. Rules are applied from the lowest priority to the highest one, so
+// the attributes set by a rule of higher priority win.
+func (d *Document) evalCondRules(s *sheet.Sheet) map[sheet.Cursor]sheet.Style {
+	styles := make(map[sheet.Cursor]sheet.Style)
+	rules := s.CondRules()
+	ec := eval.NewContext(d, s.Idx)
+	// cells beyond the data are empty, so the rules are checked inside the sheet only
+	bounds := sheet.Rect{Width: s.Size.X + s.Size.Width, Height: s.Size.Y + s.Size.Height}
+	apply := func(x, y int, st sheet.Style) {
+		pos := sheet.Cursor{X: x, Y: y}
+		styles[pos] = styles[pos].Overlay(st)
+	}
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		r := rule.Rect.Intersect(bounds)
+		if r.Width == 0 {
+			continue
+		}
+		switch rule.Kind {
+		case sheet.CondValue:
+			cond := sheet.FilterCondition{Op: rule.Op, Operand: rule.Operand}
+			for x := r.X; x <= r.MaxX(); x++ {
+				for y := r.Y; y <= r.MaxY(); y++ {
+					if v := cellString(ec, s, x, y); v != "" && matchFilter(cond, v) {
+						apply(x, y, rule.Style)
+					}
+				}
+			}
+		case sheet.CondFormula:
+			key := sheet.NewCellUntyped(rule.Operand)
+			if key.Expression(ec) == nil {
+				continue
+			}
+			for x := r.X; x <= r.MaxX(); x++ {
+				for y := r.Y; y <= r.MaxY(); y++ {
+					c := sheet.NewCellAsCopyWithOffset(key, x-rule.Rect.X, y-rule.Rect.Y)
+					if ok, err := c.BoolValue(ec); err == nil && ok {
+						apply(x, y, rule.Style)
+					}
+				}
+			}
+		case sheet.CondTop, sheet.CondBottom:
+			numbers := rangeNumbers(ec, s, r)
+			if len(numbers) == 0 {
+				continue
+			}
+			values := make([]decimal.Decimal, 0, len(numbers))
+			for _, v := range numbers {
+				values = append(values, v)
+			}
+			top := rule.Kind == sheet.CondTop
+			sort.Slice(values, func(i, j int) bool {
+				if top {
+					return values[i].GreaterThan(values[j])
+				}
+				return values[i].LessThan(values[j])
+			})
+			n := rule.N
+			if n > len(values) {
+				n = len(values)
+			}
+			limit := values[n-1]
+			for pos, v := range numbers {
+				// values equal to the last one included are included too
+				if top && v.GreaterThanOrEqual(limit) || !top && v.LessThanOrEqual(limit) {
+					apply(pos.X, pos.Y, rule.Style)
+				}
+			}
+		case sheet.CondScale:
+			numbers := rangeNumbers(ec, s, r)
+			minRGB, _ := parseRGB(rule.MinColor)
+			maxRGB, _ := parseRGB(rule.MaxColor)
+			var lo, hi decimal.Decimal
+			first := true
+			for _, v := range numbers {
+				if first || v.LessThan(lo) {
+					lo = v
+				}
+				if first || v.GreaterThan(hi) {
+					hi = v
+				}
+				first = false
+			}
+			for pos, v := range numbers {
+				t := 0.0
+				if hi.GreaterThan(lo) {
+					t, _ = v.Sub(lo).Div(hi.Sub(lo)).Float64()
+				}
+				apply(pos.X, pos.Y, sheet.Style{Bg: blendRGB(minRGB, maxRGB, t)})
+			}
+		}
+	}
+	return styles
+}
+
+// rangeNumbers returns the numeric values of the cells of the rect by their positions.
+func rangeNumbers(ec *eval.Context, s *sheet.Sheet, r sheet.Rect) map[sheet.Cursor]decimal.Decimal {
+	numbers := make(map[sheet.Cursor]decimal.Decimal)
+	for x := r.X; x <= r.MaxX(); x++ {
+		for y := r.Y; y <= r.MaxY(); y++ {
+			if v, err := decimal.NewFromString(cellString(ec, s, x, y)); err == nil {
+				numbers[sheet.Cursor{X: x, Y: y}] = v
+			}
+		}
+	}
+	return numbers
+}
+
+// parseRGB returns the components of the color written as #rrggbb.
+func parseRGB(color string) ([3]int64, bool) {
+	var rgb [3]int64
+	if len(color) != 7 || color[0] != '#' {
+		return rgb, false
+	}
+	for i := range rgb {
+		c, err := strconv.ParseInt(color[1+2*i:3+2*i], 16, 0)
+		if err != nil {
+			return rgb, false
+		}
+		rgb[i] = c
+	}
+	return rgb, true
+}
+
+// blendRGB returns the color lying at the point t between 0 and 1 of the way from
+// color a to color b.
+func blendRGB(a, b [3]int64, t float64) string {
+	var c [3]int64
+	for i := range c {
+		c[i] = a[i] + int64(math.Round(float64(b[i]-a[i])*t))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+}
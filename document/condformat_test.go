@@ -0,0 +1,124 @@
+package document
+
+import (
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCondRules(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	for y, v := range []string{"5", "20", "15", "1"} {
+		d.SetCellValue(s, 0, y, v)
+		d.SetCellValue(s, 1, y, "10")
+	}
+	col := sheet.Rect{X: 0, Y: 0, Width: 1, Height: 4}
+	bold := sheet.Style{Bold: true}
+	red := sheet.Style{Fg: "#ff0000"}
+	assert.NoError(t, d.AddCondRule(s, sheet.CondRule{Kind: sheet.CondValue, Rect: col, Op: ">", Operand: "10", Style: bold}))
+	assert.NoError(t, d.AddCondRule(s, sheet.CondRule{Kind: sheet.CondFormula, Rect: col, Operand: "=A1<B1", Style: red}))
+	assert.Equal(t, red, d.CondStyle(s, 0, 0))
+	assert.Equal(t, bold, d.CondStyle(s, 0, 1))
+	assert.Equal(t, red, d.CondStyle(s, 0, 3))
+
+	// the styles are checked again after the change
+	d.SetCellValue(s, 1, 1, "30")
+	assert.Equal(t, sheet.Style{Bold: true, Fg: "#ff0000"}, d.CondStyle(s, 0, 1))
+	assert.True(t, d.Undo())
+	assert.Equal(t, bold, d.CondStyle(s, 0, 1))
+
+	// the formula follows the inserted row
+	s.Cursor.Y = 0
+	d.InsertEmptyRow(0)
+	assert.Equal(t, "=A2<B2", s.CondRules()[1].Operand)
+	assert.Equal(t, red, d.CondStyle(s, 0, 1))
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=A1<B1", s.CondRules()[1].Operand)
+
+	assert.Error(t, d.AddCondRule(s, sheet.CondRule{Kind: sheet.CondFormula, Rect: col, Operand: "=A1<"}))
+	d.ClearCondRules(s, sheet.Rect{X: 0, Y: 3, Width: 1, Height: 1})
+	assert.Empty(t, s.CondRules())
+	assert.True(t, d.Undo())
+	assert.Len(t, s.CondRules(), 2)
+}
+
+func TestCondRulesSheetRefs(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	s2, _ := d.NewSheet("sh2")
+	d.SetCellValue(s, 0, 0, "5")
+	d.SetCellValue(s2, 0, 0, "10")
+	col := sheet.Rect{X: 0, Y: 0, Width: 1, Height: 2}
+	red := sheet.Style{Fg: "#ff0000"}
+	assert.NoError(t, d.AddCondRule(s, sheet.CondRule{Kind: sheet.CondFormula, Rect: col, Operand: "=A1<sh2!$A$1", Style: red}))
+
+	// the formula shows the new title of the sheet
+	assert.NoError(t, d.RenameSheet(s2, "other"))
+	assert.Equal(t, "=A1<other!$A$1", s.CondRules()[0].Operand)
+	assert.Equal(t, red, d.CondStyle(s, 0, 0))
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=A1<sh2!$A$1", s.CondRules()[0].Operand)
+	assert.True(t, d.Redo())
+	assert.Equal(t, "=A1<other!$A$1", s.CondRules()[0].Operand)
+
+	// references to the deleted sheet become invalid
+	assert.NoError(t, d.DeleteSheet(s2))
+	assert.Equal(t, "=A1<#REF!", s.CondRules()[0].Operand)
+	assert.Equal(t, sheet.Style{}, d.CondStyle(s, 0, 0))
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=A1<other!$A$1", s.CondRules()[0].Operand)
+	assert.Equal(t, red, d.CondStyle(s, 0, 0))
+}
+
+func TestCondRulesDeleteFirstLine(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	for y, v := range []string{"5", "20", "15", "1"} {
+		d.SetCellValue(s, 0, y, v)
+		d.SetCellValue(s, 1, y, "10")
+	}
+	col := sheet.Rect{X: 0, Y: 0, Width: 1, Height: 4}
+	red := sheet.Style{Fg: "#ff0000"}
+	assert.NoError(t, d.AddCondRule(s, sheet.CondRule{Kind: sheet.CondFormula, Rect: col, Operand: "=A1<B1", Style: red}))
+	assert.NoError(t, d.AddCondRule(s, sheet.CondRule{Kind: sheet.CondFormula, Rect: col, Operand: "=A1<$B$1", Style: red}))
+
+	// relative references are moved to the new top left cell of the rule,
+	// while the absolute one refers to the deleted cell
+	s.Cursor.Y = 0
+	d.DeleteRow()
+	assert.Equal(t, sheet.Rect{X: 0, Y: 0, Width: 1, Height: 3}, s.CondRules()[0].Rect)
+	assert.Equal(t, "=A1<B1", s.CondRules()[0].Operand)
+	assert.Equal(t, "=A1<#REF!", s.CondRules()[1].Operand)
+	assert.Equal(t, sheet.Style{}, d.CondStyle(s, 0, 0))
+	assert.Equal(t, red, d.CondStyle(s, 0, 2))
+	assert.True(t, d.Undo())
+	assert.Equal(t, col, s.CondRules()[0].Rect)
+	assert.Equal(t, "=A1<B1", s.CondRules()[0].Operand)
+	assert.Equal(t, "=A1<$B$1", s.CondRules()[1].Operand)
+
+	s.Cursor.X = 0
+	d.DeleteCol()
+	assert.Empty(t, s.CondRules())
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=A1<B1", s.CondRules()[0].Operand)
+}
+
+func TestCondRulesTopAndScale(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	for y, v := range []string{"1", "3", "2", "3", "abc"} {
+		d.SetCellValue(s, 0, y, v)
+	}
+	col := sheet.Rect{X: 0, Y: 0, Width: 1, Height: 5}
+	assert.NoError(t, d.AddCondRule(s, sheet.CondRule{Kind: sheet.CondTop, Rect: col, N: 1, Style: sheet.Style{Bold: true}}))
+	assert.NoError(t, d.AddCondRule(s, sheet.CondRule{Kind: sheet.CondScale, Rect: col, MinColor: "#000000", MaxColor: "#ff0000"}))
+	assert.Equal(t, sheet.Style{Bg: "#000000"}, d.CondStyle(s, 0, 0))
+	assert.Equal(t, sheet.Style{Bg: "#800000"}, d.CondStyle(s, 0, 2))
+	// both equal values are among the top one
+	assert.Equal(t, sheet.Style{Bg: "#ff0000", Bold: true}, d.CondStyle(s, 0, 1))
+	assert.Equal(t, sheet.Style{Bg: "#ff0000", Bold: true}, d.CondStyle(s, 0, 3))
+	assert.Equal(t, sheet.Style{}, d.CondStyle(s, 0, 4))
+}
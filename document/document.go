@@ -9,6 +9,9 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
 )
 
 const (
@@ -20,10 +23,20 @@ type Document struct {
 	CurrentSheet  *sheet.Sheet
 	CurrentSheetN int
 
+	// Журнал изменений для их отмены и повтора.
+	journal journal
+	// Счетчик изменений документа и условное оформление, вычисленное после изменения
+	// с этим номером.
+	changes   int
+	condCache condCache
+
+	// Нотация, в которой формулы вводятся и отображаются (formula.NotationA1 или formula.NotationR1C1).
+	// На хранение ссылок не влияет.
+	Notation int
+
 	maxSheetIdx int
 
 	eval.RefRegistryInterface
-	refRegistry []eval.CellReference
 }
 
 var cellNamePattern = regexp.MustCompile(`^(\$?)([A-Z]+)(\$?)([0-9]+)$`)
@@ -45,17 +58,8 @@ func NewWithEmptySheet() *Document {
 // NewSheet creates a new sheet in the document. If title is not present, generated one will be used.
 func (d *Document) NewSheet(title string) (*sheet.Sheet, error) {
 	if title != "" {
-		if len(title) > maxSheetTitleLength {
-			return nil, eval.NewError(eval.ErrorKindName, "sheet title must be up to 31 characters long")
-		}
-		if strings.ContainsAny(title, ":\\/?*[]") {
-			return nil, eval.NewError(eval.ErrorKindName, "sheet title can not include : \\ / ? * [ ]")
-		}
-		// ensure title is unique
-		for _, s := range d.Sheets {
-			if s.Title == title {
-				return nil, eval.NewError(eval.ErrorKindName, "duplicating sheet title")
-			}
+		if err := d.checkTitle(title); err != nil {
+			return nil, err
 		}
 	} else {
 		title = fmt.Sprintf("Sheet%d", d.maxSheetIdx+1)
@@ -63,33 +67,272 @@ func (d *Document) NewSheet(title string) (*sheet.Sheet, error) {
 	s := sheet.New(d.maxSheetIdx+1, title)
 	d.Sheets = append(d.Sheets, s)
 	d.maxSheetIdx++
+	d.record(&sheetAction{sheet: s, pos: len(d.Sheets) - 1})
 	return s, nil
 }
 
+// checkTitle checks whether the title can be given to a sheet.
+func (d *Document) checkTitle(title string) error {
+	if title == "" {
+		return eval.NewError(eval.ErrorKindName, "sheet title can not be empty")
+	}
+	if utf8.RuneCountInString(title) > maxSheetTitleLength {
+		return eval.NewError(eval.ErrorKindName, "sheet title must be up to 31 characters long")
+	}
+	if strings.ContainsAny(title, ":\\/?*[]") {
+		return eval.NewError(eval.ErrorKindName, "sheet title can not include : \\ / ? * [ ]")
+	}
+	// ensure title is unique
+	if d.SheetByTitle(title) != nil {
+		return eval.NewError(eval.ErrorKindName, "duplicating sheet title")
+	}
+	return nil
+}
+
+// RenameSheet gives the sheet a new title. Formulas referring to the sheet are changed
+// to show the new title.
+func (d *Document) RenameSheet(s *sheet.Sheet, title string) error {
+	if title == s.Title {
+		return nil
+	}
+	if err := d.checkTitle(title); err != nil {
+		return err
+	}
+	d.record(d.renameSheet(s.Idx, title))
+	return nil
+}
+
+// DeleteSheet deletes the sheet. References to it in formulas of other sheets become #REF!.
+// The only sheet of the document can not be deleted.
+func (d *Document) DeleteSheet(s *sheet.Sheet) error {
+	if len(d.Sheets) == 1 {
+		return eval.NewError(eval.ErrorKindName, "can not delete the only sheet")
+	}
+	d.record(d.deleteSheet(s.Idx))
+	return nil
+}
+
+// MoveSheet moves the sheet to the position pos in the list of sheets.
+func (d *Document) MoveSheet(s *sheet.Sheet, pos int) error {
+	if pos < 0 || pos >= len(d.Sheets) {
+		return eval.NewError(eval.ErrorKindName, "sheet position out of range")
+	}
+	from := d.sheetPos(s.Idx)
+	if from == pos {
+		return nil
+	}
+	a := &moveSheetAction{from: from, to: pos}
+	a.redo(d)
+	d.record(a)
+	return nil
+}
+
+// CopySheet creates the copy of the sheet next to it. If title is not present, the title
+// of the sheet followed by a number is used.
+func (d *Document) CopySheet(s *sheet.Sheet, title string) (*sheet.Sheet, error) {
+	if title == "" {
+		title = d.copyTitle(s.Title)
+	} else if err := d.checkTitle(title); err != nil {
+		return nil, err
+	}
+	c := s.Copy(eval.NewContext(d, s.Idx), d.maxSheetIdx+1, title)
+	d.maxSheetIdx++
+	a := &sheetAction{sheet: c, pos: d.sheetPos(s.Idx) + 1}
+	a.redo(d)
+	// key cells of extrapolation segments are offset in copies only when parsed
+	c.ParseFormulas(eval.NewContext(d, c.Idx))
+	d.record(a)
+	return c, nil
+}
+
+// copyTitle returns the unique title for the copy of the sheet, like "Sheet1 (2)".
+func (d *Document) copyTitle(title string) string {
+	for n := 2; ; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		t := title
+		// the title is cut on the character boundary
+		if runes := []rune(t); len(runes)+len(suffix) > maxSheetTitleLength {
+			t = string(runes[:maxSheetTitleLength-len(suffix)])
+		}
+		if d.SheetByTitle(t+suffix) == nil {
+			return t + suffix
+		}
+	}
+}
+
+// SetCellValue writes new raw value to the cell of the sheet.
+func (d *Document) SetCellValue(s *sheet.Sheet, x, y int, value string) {
+	a := &cellAction{
+		sheetIdx: s.Idx,
+		x:        x,
+		y:        y,
+		after:    value,
+	}
+	a.before = d.CellRawValue(s, x, y)
+	d.BeginGroup()
+	defer d.EndGroup()
+	// the cell stops being a copy of extrapolation segment key
+	if removed, added := s.CutXSegments(sheet.Rect{X: x, Y: y, Width: 1, Height: 1}); len(removed) > 0 {
+		d.record(&segmentsAction{sheetIdx: s.Idx, removed: removed, added: added})
+	}
+	d.setCellValue(s.Idx, x, y, value)
+	d.record(a)
+}
+
+// CellRawValue returns raw value of the cell of the sheet. Unlike Cell.RawValue it works
+// for cells of extrapolation segments too, their formulas are restored from the key cell.
+func (d *Document) CellRawValue(s *sheet.Sheet, x, y int) string {
+	c := s.Cell(x, y)
+	if c == nil {
+		return ""
+	}
+	if raw := c.RawValue(); raw != "" {
+		return raw
+	}
+	if expr := c.Expression(eval.NewContext(d, s.Idx)); expr != nil {
+		return expr.String()
+	}
+	return ""
+}
+
+// setCellValue writes new raw value to the cell without recording it to the journal.
+// The cell keeps its number format.
+func (d *Document) setCellValue(sheetIdx, x, y int, value string) {
+	s := d.sheetByIdx(sheetIdx)
+	c := sheet.NewCellUntyped(value)
+	if old := s.Cell(x, y); old != nil {
+		c.SetFormat(old.Format())
+	}
+	s.SetCell(x, y, c)
+}
+
+// AddXSegment creates extrapolation segment on the current sheet.
+// The key cell is at keyX, keyY relative to the segment and may lay outside of it.
+func (d *Document) AddXSegment(x, y, width, height, keyX, keyY int, keyCell sheet.Cell) {
+	d.addXSegment(d.CurrentSheet, x, y, width, height, keyX, keyY, keyCell)
+}
+
+// addXSegment creates extrapolation segment on the sheet.
+func (d *Document) addXSegment(s *sheet.Sheet, x, y, width, height, keyX, keyY int, keyCell sheet.Cell) {
+	// copies of the key cell are offset only when it is already typed
+	keyCell.Expression(eval.NewContext(d, s.Idx))
+	segment := s.AddXSegment(x, y, width, height, keyX, keyY, keyCell)
+	d.record(&segmentsAction{sheetIdx: s.Idx, added: []sheet.Segment{segment}})
+}
+
+// MaterializeXSegment replaces the extrapolation segment containing the cell of the sheet
+// with static cells of the same values. Returns false if there is no such segment.
+func (d *Document) MaterializeXSegment(s *sheet.Sheet, x, y int) bool {
+	removed, added := s.MaterializeXSegment(eval.NewContext(d, s.Idx), x, y)
+	if len(removed) == 0 {
+		return false
+	}
+	d.record(&segmentsAction{sheetIdx: s.Idx, removed: removed, added: added})
+	return true
+}
+
+// SetColSize sets the new width of the column of the current sheet.
+func (d *Document) SetColSize(col, size int) {
+	a := &colSizeAction{
+		sheetIdx: d.CurrentSheet.Idx,
+		col:      col,
+		before:   d.CurrentSheet.ColSize(col),
+	}
+	d.CurrentSheet.SetColSize(col, size)
+	a.after = d.CurrentSheet.ColSize(col)
+	if a.after != a.before {
+		d.record(a)
+	}
+}
+
+// SetRowSize sets the new height of the row of the current sheet.
+func (d *Document) SetRowSize(row, size int) {
+	a := &rowSizeAction{
+		sheetIdx: d.CurrentSheet.Idx,
+		row:      row,
+		before:   d.CurrentSheet.RowSize(row),
+	}
+	d.CurrentSheet.SetRowSize(row, size)
+	a.after = d.CurrentSheet.RowSize(row)
+	if a.after != a.before {
+		d.record(a)
+	}
+}
+
+// ResizeRow changes the height of the row of the current sheet by N lines of text.
+func (d *Document) ResizeRow(row, n int) {
+	d.SetRowSize(row, sheet.LinesHeight(sheet.RowLines(d.CurrentSheet.RowSize(row))+n))
+}
+
 // InsertEmptyRow inserts new empty row at position of cursor plus N.
 func (d *Document) InsertEmptyRow(n int) {
 	d.CurrentSheet.Cursor.Y += n
-	d.CurrentSheet.InsertEmptyRow(d.CurrentSheet.Cursor.Y)
-	d.moveRefsDown(d.CurrentSheet.Cursor.Y)
+	d.record(d.changeLine(d.CurrentSheet.Idx, sheet.ChangeInsertRow, d.CurrentSheet.Cursor.Y))
 }
 
 // InsertEmptyCol inserts new empty column at position of cursor plus N.
 func (d *Document) InsertEmptyCol(n int) {
 	d.CurrentSheet.Cursor.X += n
-	d.CurrentSheet.InsertEmptyCol(d.CurrentSheet.Cursor.X)
-	d.moveRefsRight(d.CurrentSheet.Cursor.X)
+	d.record(d.changeLine(d.CurrentSheet.Idx, sheet.ChangeInsertCol, d.CurrentSheet.Cursor.X))
 }
 
 // DeleteRow deletes row under cursor.
 func (d *Document) DeleteRow() {
-	d.CurrentSheet.DeleteRow(d.CurrentSheet.Cursor.Y)
-	d.moveRefsUp(d.CurrentSheet.Cursor.Y)
+	d.record(d.changeLine(d.CurrentSheet.Idx, sheet.ChangeDeleteRow, d.CurrentSheet.Cursor.Y))
 }
 
 // DeleteCol deletes column under cursor.
 func (d *Document) DeleteCol() {
-	d.CurrentSheet.DeleteCol(d.CurrentSheet.Cursor.X)
-	d.moveRefsLeft(d.CurrentSheet.Cursor.X)
+	d.record(d.changeLine(d.CurrentSheet.Idx, sheet.ChangeDeleteCol, d.CurrentSheet.Cursor.X))
+}
+
+// Сводка по значениям диапазона, которая выводится в строке статуса.
+type RangeStat struct {
+	Sum decimal.Decimal
+	// Количество непустых ячеек.
+	Count int
+	// Количество числовых ячеек, по ним считается среднее.
+	Numbers int
+}
+
+// Avg returns average of numeric values of the range.
+func (rs RangeStat) Avg() decimal.Decimal {
+	if rs.Numbers == 0 {
+		return decimal.Zero
+	}
+	return rs.Sum.Div(decimal.New(int64(rs.Numbers), 0))
+}
+
+// RangeStat calculates summary of values of the cells within rect on the sheet.
+func (d *Document) RangeStat(s *sheet.Sheet, r sheet.Rect) RangeStat {
+	stat := RangeStat{Sum: decimal.Zero}
+	ec := eval.NewContext(d, s.Idx)
+	r = r.Intersect(s.Size)
+	for y := r.Y; y <= r.MaxY(); y++ {
+		for x := r.X; x <= r.MaxX(); x++ {
+			c := s.Cell(x, y)
+			if c == nil {
+				continue
+			}
+			v, err := c.Value(ec)
+			if err != nil {
+				// cell with error is not empty, but has no number
+				stat.Count++
+				continue
+			}
+			switch v.Type() {
+			case eval.TypeEmpty:
+				continue
+			case eval.TypeDecimal:
+				if dv, err := v.DecimalValue(ec); err == nil {
+					stat.Sum = stat.Sum.Add(dv)
+					stat.Numbers++
+				}
+			}
+			stat.Count++
+		}
+	}
+	return stat
 }
 
 // FindCell finds position of the cell with given name.
@@ -99,6 +342,21 @@ func (d *Document) FindCell(cellName string) (int, int, error) {
 	return x, y, err
 }
 
+// SheetByTitle returns sheet with given title or nil if there is no such sheet.
+func (d *Document) SheetByTitle(title string) *sheet.Sheet {
+	for _, s := range d.Sheets {
+		if s.Title == title {
+			return s
+		}
+	}
+	return nil
+}
+
+// SheetByIdx returns sheet with given index or nil if there is no such sheet.
+func (d *Document) SheetByIdx(idx int) *sheet.Sheet {
+	return d.sheetByIdx(idx)
+}
+
 // sheetByIdx returns sheet by its index.
 func (d *Document) sheetByIdx(idx int) *sheet.Sheet {
 	for _, s := range d.Sheets {
@@ -109,6 +367,21 @@ func (d *Document) sheetByIdx(idx int) *sheet.Sheet {
 	return nil
 }
 
+// SheetPos returns position of the sheet with given index in the list of sheets, or -1.
+func (d *Document) SheetPos(idx int) int {
+	return d.sheetPos(idx)
+}
+
+// sheetPos returns position of the sheet with given index in the list of sheets.
+func (d *Document) sheetPos(idx int) int {
+	for i, s := range d.Sheets {
+		if s.Idx == idx {
+			return i
+		}
+	}
+	return -1
+}
+
 // CellAxis transforms cell name into X, Y coordinates.
 // TODO: support sheet title
 func CellAxis(name string) (int, int, bool, bool, error) {
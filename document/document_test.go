@@ -4,7 +4,9 @@ import (
 	"xl/document/eval"
 	"xl/document/sheet"
 
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -187,3 +189,415 @@ func TestCellRefWithAnchors(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "1", v)
 }
+
+func TestCellWholeColumnRef(t *testing.T) {
+	d := NewWithEmptySheet()
+	d.CurrentSheet.SetCell(0, 0, sheet.NewCellUntyped("1"))
+	d.CurrentSheet.SetCell(0, 1, sheet.NewCellUntyped("2"))
+	d.CurrentSheet.SetCell(1, 0, sheet.NewCellUntyped("10"))
+	d.CurrentSheet.SetCell(2, 5, sheet.NewCellUntyped("20"))
+	d.CurrentSheet.SetCell(3, 0, sheet.NewCellUntyped("=SUM(A:A)+SUM(B:C)"))
+
+	ec := eval.NewContext(d, d.CurrentSheet.Idx)
+	v, err := d.CurrentSheet.Cell(3, 0).StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "33", v)
+	assert.Equal(t, "=SUM(A:A)+SUM(B:C)", d.CurrentSheet.Cell(3, 0).Expression(ec).String())
+}
+
+func TestCellWholeRowRef(t *testing.T) {
+	d := NewWithEmptySheet()
+	d.CurrentSheet.SetCell(0, 1, sheet.NewCellUntyped("1"))
+	d.CurrentSheet.SetCell(4, 1, sheet.NewCellUntyped("2"))
+	d.CurrentSheet.SetCell(0, 2, sheet.NewCellUntyped("3"))
+	d.CurrentSheet.SetCell(0, 3, sheet.NewCellUntyped("=SUM(2:3)"))
+
+	ec := eval.NewContext(d, d.CurrentSheet.Idx)
+	v, err := d.CurrentSheet.Cell(0, 3).StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "6", v)
+	assert.Equal(t, "=SUM(2:3)", d.CurrentSheet.Cell(0, 3).Expression(ec).String())
+}
+
+func TestCell3DRef(t *testing.T) {
+	d := NewWithEmptySheet()
+	for i, title := range []string{"Jan", "Feb", "Mar"} {
+		s, err := d.NewSheet(title)
+		assert.NoError(t, err)
+		s.SetCell(1, 1, sheet.NewCellUntyped(RowName(i)))
+		s.SetCell(0, 0, sheet.NewCellUntyped("10"))
+	}
+	d.CurrentSheet.SetCell(0, 0, sheet.NewCellUntyped("=SUM(Jan:Mar!B2)"))
+	d.CurrentSheet.SetCell(0, 1, sheet.NewCellUntyped("=SUM(Feb:Mar!A1:B2)"))
+
+	ec := eval.NewContext(d, d.CurrentSheet.Idx)
+	v, err := d.CurrentSheet.Cell(0, 0).StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "6", v)
+	assert.Equal(t, "=SUM(Jan:Mar!B2)", d.CurrentSheet.Cell(0, 0).Expression(ec).String())
+
+	v, err = d.CurrentSheet.Cell(0, 1).StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "25", v)
+	assert.Equal(t, "=SUM(Feb:Mar!A1:B2)", d.CurrentSheet.Cell(0, 1).Expression(ec).String())
+}
+
+// newRefsTestDoc creates document with 3x3 values grid in A1:C3 and the formula in E4.
+func newRefsTestDoc(f string) *Document {
+	d := NewWithEmptySheet()
+	d.CurrentSheet.AddStaticSegment(0, 0, 3, 3, [][]sheet.Cell{
+		{*sheet.NewCellUntyped("1"), *sheet.NewCellUntyped("2"), *sheet.NewCellUntyped("3")},
+		{*sheet.NewCellUntyped("10"), *sheet.NewCellUntyped("20"), *sheet.NewCellUntyped("30")},
+		{*sheet.NewCellUntyped("100"), *sheet.NewCellUntyped("200"), *sheet.NewCellUntyped("300")},
+	})
+	d.CurrentSheet.SetCell(4, 3, sheet.NewCellUntyped(f))
+	return d
+}
+
+func TestRefsAdjustment(t *testing.T) {
+	insertRow := func(d *Document) { d.InsertEmptyRow(0) }
+	insertCol := func(d *Document) { d.InsertEmptyCol(0) }
+	deleteRow := func(d *Document) { d.DeleteRow() }
+	deleteCol := func(d *Document) { d.DeleteCol() }
+
+	testCases := []struct {
+		f      string
+		op     func(d *Document)
+		x, y   int
+		fx, fy int
+		raw    string
+		value  string
+		err    string
+	}{
+		// insertion
+		{`=A2`, insertRow, 0, 1, 4, 4, `=A3`, `2`, ``},
+		{`=A2`, insertRow, 0, 2, 4, 4, `=A2`, `2`, ``},
+		{`=$B$2`, insertRow, 0, 0, 4, 4, `=$B$3`, `20`, ``},
+		{`=SUM(A1:A3)`, insertRow, 0, 1, 4, 4, `=SUM(A1:A4)`, `6`, ``},
+		{`=SUM(A1:A3)`, insertRow, 0, 0, 4, 4, `=SUM(A2:A4)`, `6`, ``},
+		{`=SUM(A1:A3)`, insertRow, 0, 3, 4, 4, `=SUM(A1:A3)`, `6`, ``},
+		{`=B1+C1`, insertCol, 1, 0, 5, 3, `=C1+D1`, `110`, ``},
+		{`=SUM(A1:C1)`, insertCol, 2, 0, 5, 3, `=SUM(A1:D1)`, `111`, ``},
+		{`=SUM(B:B)`, insertRow, 0, 0, 4, 4, `=SUM(B:B)`, `60`, ``},
+		{`=SUM(A:A)`, insertRow, 0, 0, 4, 4, `=SUM(A:A)`, `6`, ``},
+		{`=SUM(B:B)`, insertCol, 0, 0, 5, 3, `=SUM(C:C)`, `60`, ``},
+		{`=SUM(2:2)`, insertRow, 0, 0, 4, 4, `=SUM(3:3)`, `222`, ``},
+		{`=SUM(2:2)`, insertCol, 0, 0, 5, 3, `=SUM(2:2)`, `222`, ``},
+		// deletion
+		{`=A3`, deleteRow, 0, 0, 4, 2, `=A2`, `3`, ``},
+		{`=A1`, deleteRow, 0, 1, 4, 2, `=A1`, `1`, ``},
+		{`=A2`, deleteRow, 0, 1, 4, 2, `=#REF!`, ``, `#REF!`},
+		{`=A2+B1`, deleteRow, 0, 1, 4, 2, `=#REF!+B1`, ``, `#REF!`},
+		{`=SUM(A1:A3)`, deleteRow, 0, 1, 4, 2, `=SUM(A1:A2)`, `4`, ``},
+		{`=SUM(A1:A3)`, deleteRow, 0, 0, 4, 2, `=SUM(A1:A2)`, `5`, ``},
+		{`=SUM(A1:A2)`, deleteRow, 0, 1, 4, 2, `=SUM(A1:A1)`, `1`, ``},
+		{`=SUM(A2:C2)`, deleteRow, 0, 1, 4, 2, `=SUM(#REF!)`, ``, `#REF!`},
+		{`=SUM(A1:C1)`, deleteCol, 1, 0, 3, 3, `=SUM(A1:B1)`, `101`, ``},
+		{`=C1`, deleteCol, 1, 0, 3, 3, `=B1`, `100`, ``},
+		{`=SUM(B:B)`, deleteRow, 0, 0, 4, 2, `=SUM(B:B)`, `50`, ``},
+		{`=SUM(B:C)`, deleteCol, 1, 0, 3, 3, `=SUM(B:B)`, `600`, ``},
+		{`=SUM(B:B)`, deleteCol, 1, 0, 3, 3, `=SUM(#REF!)`, ``, `#REF!`},
+		{`=SUM(1:2)`, deleteRow, 0, 0, 4, 2, `=SUM(1:1)`, `222`, ``},
+		{`=SUM(2:2)`, deleteRow, 0, 1, 4, 2, `=SUM(#REF!)`, ``, `#REF!`},
+	}
+	for _, c := range testCases {
+		d := newRefsTestDoc(c.f)
+		d.CurrentSheet.Cursor.X, d.CurrentSheet.Cursor.Y = c.x, c.y
+		c.op(d)
+
+		cell := d.CurrentSheet.Cell(c.fx, c.fy)
+		if !assert.NotNilf(t, cell, "case %s at %d:%d: formula must be moved to %d:%d", c.f, c.x, c.y, c.fx, c.fy) {
+			continue
+		}
+		assert.Equalf(t, c.raw, cell.RawValue(), "case %s at %d:%d", c.f, c.x, c.y)
+		v, err := cell.StringValue(eval.NewContext(d, d.CurrentSheet.Idx))
+		if c.err != "" {
+			assert.EqualErrorf(t, err, c.err, "case %s at %d:%d", c.f, c.x, c.y)
+			continue
+		}
+		assert.NoErrorf(t, err, "case %s at %d:%d", c.f, c.x, c.y)
+		assert.Equalf(t, c.value, v, "case %s at %d:%d", c.f, c.x, c.y)
+	}
+}
+
+func TestRefsAdjustmentOfEvaluatedFormula(t *testing.T) {
+	d := newRefsTestDoc("=SUM(A2:B3)")
+	ec := eval.NewContext(d, d.CurrentSheet.Idx)
+	v, err := d.CurrentSheet.Cell(4, 3).StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "55", v)
+
+	d.CurrentSheet.Cursor.Y = 1
+	d.InsertEmptyRow(0)
+	d.CurrentSheet.Cursor.X = 0
+	d.DeleteCol()
+
+	cell := d.CurrentSheet.Cell(3, 4)
+	assert.Equal(t, "=SUM(A3:A4)", cell.RawValue())
+	assert.Equal(t, "=SUM(A3:A4)", cell.Expression(ec).String())
+	v, err = cell.StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "50", v)
+}
+
+func TestRefsAdjustmentAcrossSheets(t *testing.T) {
+	d := newRefsTestDoc("=A2")
+	s2, _ := d.NewSheet("")
+	s2.SetCell(0, 0, sheet.NewCellUntyped("7"))
+	s2.SetCell(0, 1, sheet.NewCellUntyped("=Sheet1!A2+A1"))
+	s2.SetCell(0, 2, sheet.NewCellUntyped("=SUM(Sheet1!A1:A3)"))
+	s2.SetCell(0, 3, sheet.NewCellUntyped("=Sheet1!B1"))
+	ec2 := eval.NewContext(d, s2.Idx)
+
+	d.CurrentSheet.Cursor.Y = 0
+	d.InsertEmptyRow(0)
+	assert.Equal(t, "=A3", d.CurrentSheet.Cell(4, 4).RawValue())
+	assert.Equal(t, "='Sheet1'!A3+A1", s2.Cell(0, 1).RawValue())
+	assert.Equal(t, "=SUM('Sheet1'!A2:A4)", s2.Cell(0, 2).RawValue())
+
+	d.CurrentSheet.Cursor.Y = 1
+	d.DeleteRow()
+	assert.Equal(t, "=#REF!", s2.Cell(0, 3).RawValue())
+	assert.Equal(t, "=SUM('Sheet1'!A2:A3)", s2.Cell(0, 2).RawValue())
+	_, err := s2.Cell(0, 3).StringValue(ec2)
+	assert.EqualError(t, err, "#REF!")
+
+	v, err := s2.Cell(0, 1).StringValue(ec2)
+	assert.NoError(t, err)
+	assert.Equal(t, "9", v)
+	v, err = s2.Cell(0, 2).StringValue(ec2)
+	assert.NoError(t, err)
+	assert.Equal(t, "5", v)
+}
+
+func TestRefsAdjustmentOfXSegment(t *testing.T) {
+	d := newRefsTestDoc("")
+	d.CurrentSheet.AddXSegment(4, 0, 1, 3, 0, 0, *sheet.NewCellUntyped("=B1*2"))
+
+	d.CurrentSheet.Cursor.X = 0
+	d.InsertEmptyCol(0)
+
+	ec := eval.NewContext(d, d.CurrentSheet.Idx)
+	assert.Equal(t, "=C1*2", d.CurrentSheet.Cell(5, 0).RawValue())
+	assert.Equal(t, "=C3*2", d.CurrentSheet.Cell(5, 2).Expression(ec).String())
+	v, err := d.CurrentSheet.Cell(5, 2).StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "60", v)
+}
+
+func TestCellRefToOtherSheetIsEvaluatedOnThatSheet(t *testing.T) {
+	d := NewWithEmptySheet()
+	s2, _ := d.NewSheet("")
+	s2.SetCell(0, 0, sheet.NewCellUntyped("=A2*2"))
+	s2.SetCell(0, 1, sheet.NewCellUntyped("5"))
+	d.CurrentSheet.SetCell(0, 1, sheet.NewCellUntyped("1"))
+	d.CurrentSheet.SetCell(0, 2, sheet.NewCellUntyped("=Sheet2!A1"))
+
+	v, err := d.CurrentSheet.Cell(0, 2).StringValue(eval.NewContext(d, d.CurrentSheet.Idx))
+	assert.NoError(t, err)
+	assert.Equal(t, "10", v)
+	assert.Equal(t, "=A2*2", s2.Cell(0, 0).RawValue())
+}
+
+func TestRangeStat(t *testing.T) {
+	d := newRefsTestDoc("=A1/0")
+	d.SetCellValue(d.CurrentSheet, 1, 1, "text")
+	d.SetCellValue(d.CurrentSheet, 3, 3, "")
+
+	stat := d.RangeStat(d.CurrentSheet, sheet.Rect{X: 0, Y: 0, Width: 2, Height: 3})
+	assert.Equal(t, "46", stat.Sum.String())
+	assert.Equal(t, 6, stat.Count)
+	assert.Equal(t, "9.2", stat.Avg().String())
+
+	// cells out of the sheet and errors
+	stat = d.RangeStat(d.CurrentSheet, sheet.Rect{X: 3, Y: 3, Width: 1000, Height: 1000})
+	assert.True(t, stat.Sum.IsZero())
+	assert.Equal(t, 1, stat.Count)
+	assert.True(t, stat.Avg().IsZero())
+}
+
+func TestOffsetRawValue(t *testing.T) {
+	d := NewWithEmptySheet()
+	_, _ = d.NewSheet("Sheet2")
+	ec := eval.NewContext(d, d.CurrentSheet.Idx)
+	testCases := []struct {
+		raw    string
+		dx, dy int
+		res    string
+	}{
+		{`=A1+$B$2+$C3+D$4`, 1, 2, `=B3+$B$2+$C5+E$4`},
+		{`=SUM(A1:B2)*Sheet2!C3`, 2, 1, `=SUM(C2:D3)*'Sheet2'!E4`},
+		{`=SUM(A:A)+SUM(1:$2)`, 1, 1, `=SUM(B:B)+SUM(2:$2)`},
+		{`=A2+B2`, -1, -1, `=#REF!+A1`},
+		{`=SUM(A2:B3)`, 0, -2, `=SUM(#REF!)`},
+		{`=#REF!+A1`, 0, 1, `=#REF!+A2`},
+		{`abc`, 1, 1, `abc`},
+		{`=NoSheet!A1`, 1, 1, `=NoSheet!A1`},
+	}
+	for _, c := range testCases {
+		assert.Equalf(t, c.res, sheet.OffsetRawValue(ec, c.raw, c.dx, c.dy), "case %s", c.raw)
+	}
+}
+
+func TestRenameSheet(t *testing.T) {
+	d := newRefsTestDoc("=A2")
+	s1 := d.CurrentSheet
+	s2, _ := d.NewSheet("")
+	s2.SetCell(0, 0, sheet.NewCellUntyped("=Sheet1!A2+1"))
+
+	assert.NoError(t, d.RenameSheet(s1, "My data"))
+	assert.Equal(t, "My data", s1.Title)
+	assert.Equal(t, "='My data'!A2+1", s2.Cell(0, 0).RawValue())
+	assert.Equal(t, "=A2", s1.Cell(4, 3).RawValue())
+	v, err := s2.Cell(0, 0).StringValue(eval.NewContext(d, s2.Idx))
+	assert.NoError(t, err)
+	assert.Equal(t, "3", v)
+
+	assert.Error(t, d.RenameSheet(s1, "Sheet2"))
+	assert.Error(t, d.RenameSheet(s1, "a/b"))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, "Sheet1", s1.Title)
+	assert.Equal(t, "='Sheet1'!A2+1", s2.Cell(0, 0).RawValue())
+	assert.True(t, d.Redo())
+	assert.Equal(t, "='My data'!A2+1", s2.Cell(0, 0).RawValue())
+}
+
+func TestChangeLineIn3DRef(t *testing.T) {
+	d := NewWithEmptySheet()
+	total := d.CurrentSheet
+	var jan *sheet.Sheet
+	for i, title := range []string{"Jan", "Feb", "Mar"} {
+		s, err := d.NewSheet(title)
+		assert.NoError(t, err)
+		s.SetCell(1, 1, sheet.NewCellUntyped(RowName(i)))
+		if jan == nil {
+			jan = s
+		}
+	}
+	total.SetCell(0, 0, sheet.NewCellUntyped("=SUM(Jan:Feb!B2)"))
+	total.SetCell(0, 1, sheet.NewCellUntyped("=SUM(Feb:Mar!B2)"))
+	value := func(y int) string {
+		v, err := total.Cell(0, y).StringValue(eval.NewContext(d, total.Idx))
+		assert.NoError(t, err)
+		return v
+	}
+	assert.Equal(t, "3", value(0))
+
+	// the row is inserted on the first sheet of the first ref only
+	d.CurrentSheet = jan
+	d.InsertEmptyRow(0)
+	assert.Equal(t, "=SUM(Jan:Feb!B3)", total.Cell(0, 0).RawValue())
+	assert.Equal(t, "=SUM(Feb:Mar!B2)", total.Cell(0, 1).RawValue())
+	assert.Equal(t, "1", value(0))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=SUM(Jan:Feb!B2)", total.Cell(0, 0).RawValue())
+	assert.Equal(t, "3", value(0))
+
+	d.CurrentSheet = jan
+	jan.Cursor.Y = 0
+	d.DeleteRow()
+	assert.Equal(t, "=SUM(Jan:Feb!B1)", total.Cell(0, 0).RawValue())
+	assert.Equal(t, "=SUM(Feb:Mar!B2)", total.Cell(0, 1).RawValue())
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=SUM(Jan:Feb!B2)", total.Cell(0, 0).RawValue())
+}
+
+func TestRenameSheetIn3DRef(t *testing.T) {
+	d := NewWithEmptySheet()
+	jan, _ := d.NewSheet("Jan")
+	d.NewSheet("Feb")
+	d.CurrentSheet.SetCell(0, 0, sheet.NewCellUntyped("=SUM(Jan:Feb!A1)"))
+	assert.NoError(t, d.RenameSheet(jan, "Q1"))
+	assert.Equal(t, "=SUM('Q1':Feb!A1)", d.CurrentSheet.Cell(0, 0).RawValue())
+}
+
+func TestDeleteSheet(t *testing.T) {
+	d := newRefsTestDoc("=A2")
+	s1 := d.CurrentSheet
+	s2, _ := d.NewSheet("")
+	s2.SetCell(0, 0, sheet.NewCellUntyped("=Sheet1!A2+A2"))
+	s2.SetCell(0, 1, sheet.NewCellUntyped("5"))
+
+	assert.NoError(t, d.DeleteSheet(s1))
+	assert.Equal(t, []*sheet.Sheet{s2}, d.Sheets)
+	assert.Equal(t, s2, d.CurrentSheet)
+	assert.Equal(t, "=#REF!+A2", s2.Cell(0, 0).RawValue())
+	_, err := s2.Cell(0, 0).StringValue(eval.NewContext(d, s2.Idx))
+	assert.EqualError(t, err, "#REF!")
+	assert.Error(t, d.DeleteSheet(s2))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, []*sheet.Sheet{s1, s2}, d.Sheets)
+	assert.Equal(t, s1, d.CurrentSheet)
+	assert.Equal(t, "='Sheet1'!A2+A2", s2.Cell(0, 0).RawValue())
+	v, err := s2.Cell(0, 0).StringValue(eval.NewContext(d, s2.Idx))
+	assert.NoError(t, err)
+	assert.Equal(t, "7", v)
+}
+
+func TestMoveSheet(t *testing.T) {
+	d := NewWithEmptySheet()
+	s1 := d.CurrentSheet
+	s2, _ := d.NewSheet("")
+	s3, _ := d.NewSheet("")
+
+	assert.NoError(t, d.MoveSheet(s1, 2))
+	assert.Equal(t, []*sheet.Sheet{s2, s3, s1}, d.Sheets)
+	assert.Equal(t, 2, d.CurrentSheetN)
+	assert.Error(t, d.MoveSheet(s1, 3))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, []*sheet.Sheet{s1, s2, s3}, d.Sheets)
+	assert.Equal(t, 0, d.CurrentSheetN)
+}
+
+func TestCopySheet(t *testing.T) {
+	d := newRefsTestDoc("=A2*2")
+	s1 := d.CurrentSheet
+	d.NewSheet("")
+	s1.AddXSegment(5, 0, 1, 3, 0, 0, *sheet.NewCellUntyped("=B1+1"))
+	s1.SetCell(6, 0, sheet.NewCellUntyped("=Sheet2!A1"))
+	s1.SetColSize(1, 100)
+
+	c, err := d.CopySheet(s1, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "Sheet1 (2)", c.Title)
+	assert.Equal(t, c, d.Sheets[1])
+	assert.Equal(t, 100, c.ColSize(1))
+
+	// references without sheet title point to the copy
+	s1.SetCell(0, 1, sheet.NewCellUntyped("50"))
+	ec := eval.NewContext(d, c.Idx)
+	v, err := c.Cell(4, 3).StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "4", v)
+	v, err = c.Cell(5, 2).StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "31", v)
+	assert.Equal(t, "='Sheet2'!A1", c.Cell(6, 0).RawValue())
+
+	_, err = d.CopySheet(s1, "Sheet2")
+	assert.Error(t, err)
+	assert.True(t, d.Undo())
+	assert.Len(t, d.Sheets, 2)
+}
+
+func TestCopySheetMultibyteTitle(t *testing.T) {
+	d := NewWithEmptySheet()
+	title := strings.Repeat("Лист", 7) + "汉字表"
+	assert.NoError(t, d.RenameSheet(d.CurrentSheet, title))
+	assert.Equal(t, title, d.CurrentSheet.Title)
+
+	// the title of the copy is cut to 31 characters, not bytes
+	c, err := d.CopySheet(d.CurrentSheet, "")
+	assert.NoError(t, err)
+	assert.True(t, utf8.ValidString(c.Title))
+	assert.Equal(t, strings.Repeat("Лист", 6)+"Лис (2)", c.Title)
+	c, err = d.CopySheet(d.CurrentSheet, "")
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("Лист", 6)+"Лис (3)", c.Title)
+
+	assert.Error(t, d.RenameSheet(c, title+"x"))
+}
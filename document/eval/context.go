@@ -24,6 +24,14 @@ func NewContext(dp RefRegistryInterface, currentSheetIdx int) *Context {
 	return ec
 }
 
+// SetCurrentSheet changes the sheet in context of which formulas are evaluated.
+// Returns index of the previous sheet.
+func (ec *Context) SetCurrentSheet(sheetIdx int) int {
+	prev := ec.CurrentSheetIdx
+	ec.CurrentSheetIdx = sheetIdx
+	return prev
+}
+
 func (ec *Context) AddVisited(cell CellAddress) int {
 	oldLen := len(ec.visitedCells)
 	ec.visitedCells = append(ec.visitedCells, cell)
@@ -11,6 +11,7 @@ const (
 	ErrorKindRef
 	ErrorKindCasting
 	ErrorKindDiv0
+	ErrorKindNum
 )
 
 type Error struct {
@@ -4,6 +4,13 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// Максимальные размеры листа. Ссылки на целые колонки и строки хранятся как диапазоны,
+// ограниченные этими размерами.
+const (
+	MaxCols = 16384
+	MaxRows = 1048576
+)
+
 // Хранит адрес ячейки.
 type CellAddress struct {
 	SheetIdx int
@@ -21,9 +28,9 @@ type CellReference struct {
 
 type RefRegistryInterface interface {
 	// Работа с Сылками.
-	AddRef(cell CellReference)
 	FromAddress(cell CellReference) (string, string, error)
 	ToAddress(sheetTitle, cellName string) (CellReference, error)
+	SheetTitle(sheetIdx int) (string, error)
 
 	// Получение значений по адресу ячейки.
 	Value(ec *Context, cell CellAddress) (Value, error)
@@ -36,3 +43,28 @@ type RefRegistryInterface interface {
 	IterateDecimalValues(ec *Context, cell, cellTo CellAddress, f func(decimal.Decimal) error) error
 	IterateStringValues(ec *Context, cell, cellTo CellAddress, f func(string) error) error
 }
+
+// Флаги, по которым при переборе значений диапазона пропускаются ячейки.
+const (
+	// Строки, скрытые пользователем.
+	SkipHidden = 1 << iota
+	// Строки, скрытые автофильтром.
+	SkipFiltered
+	// Ячейки с формулами SUBTOTAL и AGGREGATE, чтобы промежуточные итоги не считались дважды.
+	SkipSubtotals
+	// Ячейки, значения которых вычисляются с ошибкой.
+	SkipErrors
+)
+
+// Необязательный интерфейс делегата, который перебирает значения диапазона как есть,
+// без приведения к типу, и умеет пропускать ячейки по флагам. Нужен функциям SUBTOTAL
+// и AGGREGATE.
+type ValuesIterator interface {
+	IterateValues(ec *Context, cell, cellTo CellAddress, flags int, f func(Value) error) error
+}
+
+// Необязательный интерфейс делегата, сообщающий положение листа среди листов книги.
+// По нему определяются листы, которые охватывает 3D-ссылка.
+type SheetPositioner interface {
+	SheetPos(sheetIdx int) int
+}
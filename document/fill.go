@@ -0,0 +1,300 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/sheet"
+
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Заполнение ячеек продолжением значений. Каждая линия заполняемого прямоугольника
+// (колонка при заполнении вверх и вниз, строка при заполнении влево и вправо) заполняется
+// независимо: ее первые непустые ячейки в направлении заполнения служат образцом, по ним
+// определяется ряд, которым заполняются остальные ячейки линии.
+//
+// Ряды: арифметическая прогрессия (линейный тренд для неровных чисел), геометрическая
+// прогрессия, даты с шагом в дни или месяцы, текст с числом на конце. Если ряд не
+// распознан, образец повторяется; формулы при этом копируются со сдвигом ссылок.
+// Одна формула продолжается экстраполяционным сегментом, который не хранит копии ячеек.
+
+// Направления заполнения.
+const (
+	FillDown = iota
+	FillUp
+	FillRight
+	FillLeft
+)
+
+// Форматы дат, которые распознаются в рядах.
+var fillDateLayouts = []string{"2006-01-02", "02.01.2006", "01/02/2006"}
+
+var textWithNumber = regexp.MustCompile(`^(.*?)([0-9]+)$`)
+
+// Число знаков после запятой, с которым хранятся промежуточные значения геометрической
+// прогрессии, чтобы их длина не росла с каждым шагом.
+const fillPrecision = 20
+
+// Fill fills the cells of the rect on the sheet continuing values of the leading cells
+// of each its line in the direction.
+func (d *Document) Fill(s *sheet.Sheet, r sheet.Rect, direction int) {
+	d.BeginGroup()
+	defer d.EndGroup()
+	vertical := direction == FillDown || direction == FillUp
+	lines, length := r.Width, r.Height
+	if !vertical {
+		lines, length = r.Height, r.Width
+	}
+	for l := 0; l < lines; l++ {
+		// position of the cell I of the line
+		pos := func(i int) (int, int) {
+			switch direction {
+			case FillDown:
+				return r.X + l, r.Y + i
+			case FillUp:
+				return r.X + l, r.MaxY() - i
+			case FillRight:
+				return r.X + i, r.Y + l
+			}
+			return r.MaxX() - i, r.Y + l
+		}
+		var seed []string
+		for i := 0; i < length; i++ {
+			x, y := pos(i)
+			raw := d.CellRawValue(s, x, y)
+			if raw == "" {
+				break
+			}
+			seed = append(seed, raw)
+		}
+		if len(seed) == 0 || len(seed) == length {
+			continue
+		}
+		d.fillLine(s, seed, length, pos)
+	}
+}
+
+// fillLine writes the series continuing the seed to the cells of the line.
+func (d *Document) fillLine(s *sheet.Sheet, seed []string, length int, pos func(i int) (int, int)) {
+	kx, ky := pos(len(seed) - 1)
+	if len(seed) == 1 && sheet.IsFormula(seed[0]) {
+		// the formula is extrapolated from the seed cell
+		x1, y1 := pos(len(seed))
+		x2, y2 := pos(length - 1)
+		if x2 < x1 {
+			x1, x2 = x2, x1
+		}
+		if y2 < y1 {
+			y1, y2 = y2, y1
+		}
+		d.addXSegment(s, x1, y1, x2-x1+1, y2-y1+1, kx-x1, ky-y1, *sheet.NewCellUntyped(seed[0]))
+		return
+	}
+	next := fillSeries(seed)
+	ec := eval.NewContext(d, s.Idx)
+	for i := len(seed); i < length; i++ {
+		x, y := pos(i)
+		var value string
+		if next != nil {
+			value = next(i)
+		} else {
+			// the seed is repeated
+			j := i % len(seed)
+			sx, sy := pos(j)
+			value = sheet.OffsetRawValue(ec, seed[j], x-sx, y-sy)
+		}
+		d.SetCellValue(s, x, y, value)
+	}
+}
+
+// fillSeries detects the series of the seed values. Returns the function which gives
+// the value I of the series, or nil if the seed is not a series.
+func fillSeries(seed []string) func(i int) string {
+	for _, v := range seed {
+		if sheet.IsFormula(v) {
+			return nil
+		}
+	}
+	if next := numberSeries(seed); next != nil {
+		return next
+	}
+	if next := dateSeries(seed); next != nil {
+		return next
+	}
+	return textSeries(seed)
+}
+
+// numberSeries continues arithmetic or geometric progression, or the linear trend of
+// the numbers. A single number is not a series.
+func numberSeries(seed []string) func(i int) string {
+	if len(seed) < 2 {
+		return nil
+	}
+	nums := make([]decimal.Decimal, len(seed))
+	for i, v := range seed {
+		n, err := decimal.NewFromString(v)
+		if err != nil {
+			return nil
+		}
+		nums[i] = n
+	}
+	step := nums[1].Sub(nums[0])
+	linear := true
+	for i := 2; i < len(nums); i++ {
+		if !nums[i].Sub(nums[i-1]).Equal(step) {
+			linear = false
+			break
+		}
+	}
+	if linear {
+		return func(i int) string {
+			return nums[0].Add(step.Mul(decimal.New(int64(i), 0))).String()
+		}
+	}
+	if ratio, ok := commonRatio(nums); ok {
+		// values are asked one after another, so the previous one is multiplied once
+		v, at := nums[0], 0
+		return func(i int) string {
+			if i < at {
+				v, at = nums[0], 0
+			}
+			for ; at < i; at++ {
+				v = v.Mul(ratio).Round(fillPrecision)
+			}
+			return v.Round(10).String()
+		}
+	}
+	// least squares line through the points (i, nums[i])
+	n := decimal.New(int64(len(nums)), 0)
+	sumX, sumY := decimal.Zero, decimal.Zero
+	for i, v := range nums {
+		sumX = sumX.Add(decimal.New(int64(i), 0))
+		sumY = sumY.Add(v)
+	}
+	meanX, meanY := sumX.Div(n), sumY.Div(n)
+	num, den := decimal.Zero, decimal.Zero
+	for i, v := range nums {
+		dx := decimal.New(int64(i), 0).Sub(meanX)
+		num = num.Add(dx.Mul(v.Sub(meanY)))
+		den = den.Add(dx.Mul(dx))
+	}
+	slope := num.Div(den)
+	return func(i int) string {
+		return meanY.Add(slope.Mul(decimal.New(int64(i), 0).Sub(meanX))).Round(10).String()
+	}
+}
+
+// commonRatio returns the ratio of geometric progression of the numbers.
+func commonRatio(nums []decimal.Decimal) (decimal.Decimal, bool) {
+	for _, v := range nums {
+		if v.IsZero() {
+			return decimal.Zero, false
+		}
+	}
+	ratio := nums[1].Div(nums[0])
+	for i := 2; i < len(nums); i++ {
+		if !nums[i].Equal(nums[i-1].Mul(ratio)) {
+			return decimal.Zero, false
+		}
+	}
+	return ratio, true
+}
+
+// dateSeries continues the dates with the step of days, or of months when all dates
+// fall on the same day of month. A single date is continued day by day.
+func dateSeries(seed []string) func(i int) string {
+	var layout string
+	for _, l := range fillDateLayouts {
+		if _, err := time.Parse(l, seed[0]); err == nil {
+			layout = l
+			break
+		}
+	}
+	if layout == "" {
+		return nil
+	}
+	dates := make([]time.Time, len(seed))
+	for i, v := range seed {
+		t, err := time.Parse(layout, v)
+		if err != nil {
+			return nil
+		}
+		dates[i] = t
+	}
+	if len(dates) == 1 {
+		return func(i int) string {
+			return dates[0].AddDate(0, 0, i).Format(layout)
+		}
+	}
+	months := func(t time.Time) int {
+		return t.Year()*12 + int(t.Month()) - 1
+	}
+	monthly := true
+	monthStep := months(dates[1]) - months(dates[0])
+	days := func(a, b time.Time) int {
+		return int(b.Sub(a).Hours() / 24)
+	}
+	dayStep := days(dates[0], dates[1])
+	daily := true
+	for i := 1; i < len(dates); i++ {
+		if dates[i].Day() != dates[0].Day() || months(dates[i])-months(dates[i-1]) != monthStep {
+			monthly = false
+		}
+		if days(dates[i-1], dates[i]) != dayStep {
+			daily = false
+		}
+	}
+	switch {
+	case monthly && monthStep != 0:
+		return func(i int) string {
+			return dates[0].AddDate(0, monthStep*i, 0).Format(layout)
+		}
+	case daily:
+		return func(i int) string {
+			return dates[0].AddDate(0, 0, dayStep*i).Format(layout)
+		}
+	}
+	return nil
+}
+
+// textSeries continues text ending with a number, like "Item 1", "Item 2". The prefix must
+// be the same for all the seed values. Leading zeros of the number are kept.
+func textSeries(seed []string) func(i int) string {
+	var prefix string
+	width := 0
+	nums := make([]int, len(seed))
+	for i, v := range seed {
+		m := textWithNumber.FindStringSubmatch(v)
+		if m == nil || m[1] == "" || (i > 0 && m[1] != prefix) {
+			return nil
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			return nil
+		}
+		prefix, nums[i] = m[1], n
+		if m[2][0] == '0' && len(m[2]) > width {
+			width = len(m[2])
+		}
+	}
+	step := 1
+	if len(nums) > 1 {
+		step = nums[1] - nums[0]
+		for i := 2; i < len(nums); i++ {
+			if nums[i]-nums[i-1] != step {
+				return nil
+			}
+		}
+	}
+	return func(i int) string {
+		n := nums[0] + step*i
+		s := strconv.Itoa(n)
+		for len(s) < width {
+			s = "0" + s
+		}
+		return prefix + s
+	}
+}
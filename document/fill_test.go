@@ -0,0 +1,245 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/numfmt"
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFillSeries(t *testing.T) {
+	tests := []struct {
+		seed []string
+		want []string
+	}{
+		{[]string{"1", "2"}, []string{"3", "4"}},
+		{[]string{"10", "7.5"}, []string{"5", "2.5"}},
+		{[]string{"2", "6", "18"}, []string{"54", "162"}},
+		{[]string{"1", "2", "4", "5"}, []string{"6.5", "7.9"}},
+		{[]string{"2020-01-31"}, []string{"2020-02-01", "2020-02-02"}},
+		{[]string{"15.01.2020", "15.03.2020"}, []string{"15.05.2020", "15.07.2020"}},
+		{[]string{"01/01/2020", "01/08/2020"}, []string{"01/15/2020", "01/22/2020"}},
+		{[]string{"Item 1"}, []string{"Item 2", "Item 3"}},
+		{[]string{"Q01", "Q03"}, []string{"Q05", "Q07"}},
+	}
+	for _, test := range tests {
+		next := fillSeries(test.seed)
+		if !assert.NotNil(t, next, test.seed) {
+			continue
+		}
+		for i, want := range test.want {
+			assert.Equal(t, want, next(len(test.seed)+i), test.seed)
+		}
+	}
+	// the progression is extended step by step, but can be asked again from the start
+	next := fillSeries([]string{"4", "2", "1"})
+	for i := 3; i < 10000; i++ {
+		next(i)
+	}
+	assert.Equal(t, "0", next(10000))
+	assert.Equal(t, "0.5", next(3))
+	next = fillSeries([]string{"1", "3", "9"})
+	assert.Equal(t, "59049", next(10))
+	assert.Equal(t, "27", next(3))
+
+	for _, seed := range [][]string{{"1"}, {"a", "b"}, {"a1", "b2"}, {"=A1"}, {"2020-01-01", "x"}} {
+		assert.Nil(t, fillSeries(seed), seed)
+	}
+}
+
+func TestFill(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	d.SetCellValue(s, 0, 0, "1")
+	d.SetCellValue(s, 0, 1, "3")
+	d.SetCellValue(s, 1, 0, "a")
+	d.SetCellValue(s, 1, 1, "=A2*2")
+	d.Fill(s, sheet.Rect{X: 0, Y: 0, Width: 2, Height: 4}, FillDown)
+	assert.Equal(t, "7", cellValue(t, d, 0, 3))
+	// not a series, so the seed is repeated with formula references offset
+	assert.Equal(t, "a", cellValue(t, d, 1, 2))
+	assert.Equal(t, "=A4*2", d.CellRawValue(s, 1, 3))
+	assert.Equal(t, "14", cellValue(t, d, 1, 3))
+
+	d.SetCellValue(s, 4, 0, "5")
+	d.Fill(s, sheet.Rect{X: 2, Y: 0, Width: 3, Height: 1}, FillLeft)
+	assert.Equal(t, "5", cellValue(t, d, 2, 0))
+
+	// the whole fill is undone at once
+	assert.True(t, d.Undo())
+	assert.Equal(t, "", cellValue(t, d, 2, 0))
+	assert.True(t, d.Undo())
+	assert.True(t, d.Undo())
+	assert.Equal(t, "", cellValue(t, d, 0, 3))
+	assert.Equal(t, "", cellValue(t, d, 1, 3))
+}
+
+func TestFillFormulaWithXSegment(t *testing.T) {
+	d := newRefsTestDoc("")
+	s := d.CurrentSheet
+	d.SetCellValue(s, 3, 0, "=A1+B1")
+	d.Fill(s, sheet.Rect{X: 3, Y: 0, Width: 1, Height: 3}, FillDown)
+	assert.Equal(t, "22", cellValue(t, d, 3, 1))
+	assert.Equal(t, "=A3+B3", d.CellRawValue(s, 3, 2))
+
+	d.SetCellValue(s, 4, 2, "=C3")
+	d.Fill(s, sheet.Rect{X: 4, Y: 0, Width: 1, Height: 3}, FillUp)
+	assert.Equal(t, "100", cellValue(t, d, 4, 0))
+}
+
+func TestShownCellsAfterFill(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	d.SetCellValue(s, 0, 0, "1")
+	d.SetCellValue(s, 0, 1, "2")
+	d.SetCellValue(s, 0, 2, "3")
+	d.SetCellValue(s, 1, 0, "=A1*10")
+	d.SetCellValue(s, 1, 2, "stale")
+	f, err := numfmt.Parse("0.00")
+	assert.NoError(t, err)
+	d.SetFormat(s, sheet.Rect{X: 1, Y: 2, Width: 1, Height: 1}, f)
+	d.Fill(s, sheet.Rect{X: 1, Y: 0, Width: 1, Height: 3}, FillDown)
+
+	// the value under the extrapolation segment is not shown, so it is not written
+	values := make(map[string]string)
+	err = s.ShownCells(func(x, y int, c *sheet.Cell) error {
+		name := CellName(x, y)
+		if _, ok := values[name]; ok {
+			t.Errorf("%s is passed twice", name)
+		}
+		values[name], _ = c.StringValue(eval.NewContext(d, s.Idx))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "30", values["B3"])
+	assert.Equal(t, "20", values["B2"])
+	assert.Equal(t, "3", values["A3"])
+
+	// so is the number format of the cell, the formula shares the format of the key cell
+	formats := 0
+	assert.NoError(t, s.ShownCells(func(x, y int, c *sheet.Cell) error {
+		if c.Format() != nil {
+			formats++
+		}
+		return nil
+	}))
+	assert.Equal(t, 0, formats)
+}
+
+func TestXSegmentInsertDeleteRows(t *testing.T) {
+	d := newRefsTestDoc("")
+	s := d.CurrentSheet
+	d.SetCellValue(s, 3, 0, "=A1+B2")
+	d.Fill(s, sheet.Rect{X: 3, Y: 0, Width: 1, Height: 3}, FillDown)
+	assert.Equal(t, "21", cellValue(t, d, 3, 0))
+	assert.Equal(t, "32", cellValue(t, d, 3, 1))
+
+	s.Cursor.Y = 1
+	d.InsertEmptyRow(0)
+	assert.Equal(t, "=A1+B3", d.CellRawValue(s, 3, 0))
+	assert.Equal(t, "", d.CellRawValue(s, 3, 1))
+	assert.Equal(t, "=A3+B4", d.CellRawValue(s, 3, 2))
+	assert.Equal(t, "=A4+B5", d.CellRawValue(s, 3, 3))
+	assert.Equal(t, "3", cellValue(t, d, 3, 3))
+
+	s.Cursor.Y = 1
+	d.DeleteRow()
+	d.DeleteRow()
+	assert.Equal(t, "=A1+#REF!", d.CellRawValue(s, 3, 0))
+	assert.Equal(t, "=A2+B3", d.CellRawValue(s, 3, 1))
+	assert.Equal(t, "3", cellValue(t, d, 3, 1))
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, d.Undo())
+	}
+	assert.Equal(t, "=A2+B3", d.CellRawValue(s, 3, 1))
+	assert.Equal(t, "32", cellValue(t, d, 3, 1))
+	assert.Equal(t, "=A3+B4", d.CellRawValue(s, 3, 2))
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, d.Redo())
+	}
+	assert.Equal(t, "3", cellValue(t, d, 3, 1))
+}
+
+func TestXSegmentInsertRowOnOtherSheet(t *testing.T) {
+	d := NewWithEmptySheet()
+	s1 := d.CurrentSheet
+	for y := 0; y < 6; y++ {
+		s1.SetCell(0, y, sheet.NewCellUntyped(RowName(y)))
+	}
+	s2, _ := d.NewSheet("")
+	d.SetCellValue(s2, 1, 0, "=Sheet1!A1*10")
+	d.Fill(s2, sheet.Rect{X: 1, Y: 0, Width: 1, Height: 5}, FillDown)
+	d.CurrentSheet = s2
+	assert.Equal(t, "30", cellValue(t, d, 1, 2))
+
+	s1.Cursor.Y = 2
+	d.CurrentSheet = s1
+	d.InsertEmptyRow(0)
+	d.CurrentSheet = s2
+	assert.Equal(t, "='Sheet1'!A2*10", d.CellRawValue(s2, 1, 1))
+	assert.Equal(t, "20", cellValue(t, d, 1, 1))
+	assert.Equal(t, "='Sheet1'!A4*10", d.CellRawValue(s2, 1, 2))
+	assert.Equal(t, "30", cellValue(t, d, 1, 2))
+	assert.Equal(t, "50", cellValue(t, d, 1, 4))
+
+	assert.True(t, d.Undo())
+	d.CurrentSheet = s2
+	assert.Equal(t, "='Sheet1'!A3*10", d.CellRawValue(s2, 1, 2))
+	assert.Equal(t, "30", cellValue(t, d, 1, 2))
+	assert.Equal(t, "50", cellValue(t, d, 1, 4))
+}
+
+func TestXSegmentInsertCol(t *testing.T) {
+	d := newRefsTestDoc("")
+	s := d.CurrentSheet
+	d.SetCellValue(s, 0, 4, "=A1*2")
+	d.Fill(s, sheet.Rect{X: 0, Y: 4, Width: 3, Height: 1}, FillRight)
+	assert.Equal(t, "200", cellValue(t, d, 2, 4))
+
+	s.Cursor.X = 1
+	d.InsertEmptyCol(0)
+	assert.Equal(t, "20", cellValue(t, d, 2, 4))
+	assert.Equal(t, "=D1*2", d.CellRawValue(s, 3, 4))
+	assert.Equal(t, "200", cellValue(t, d, 3, 4))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, "200", cellValue(t, d, 2, 4))
+	assert.Equal(t, "", cellValue(t, d, 3, 4))
+}
+
+func TestXSegmentCellEdit(t *testing.T) {
+	d := newRefsTestDoc("")
+	s := d.CurrentSheet
+	d.SetCellValue(s, 3, 0, "=B1*2")
+	d.Fill(s, sheet.Rect{X: 3, Y: 0, Width: 1, Height: 3}, FillDown)
+
+	d.SetCellValue(s, 3, 1, "x")
+	assert.Equal(t, "20", cellValue(t, d, 3, 0))
+	assert.Equal(t, "x", cellValue(t, d, 3, 1))
+	assert.Equal(t, "60", cellValue(t, d, 3, 2))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, "40", cellValue(t, d, 3, 1))
+	assert.Equal(t, "=B2*2", d.CellRawValue(s, 3, 1))
+}
+
+func TestMaterializeXSegment(t *testing.T) {
+	d := newRefsTestDoc("")
+	s := d.CurrentSheet
+	d.SetCellValue(s, 3, 0, "=B1*2")
+	d.Fill(s, sheet.Rect{X: 3, Y: 0, Width: 1, Height: 3}, FillDown)
+
+	assert.False(t, d.MaterializeXSegment(s, 3, 0))
+	assert.True(t, d.MaterializeXSegment(s, 3, 2))
+	assert.Equal(t, "=B3*2", s.Cell(3, 2).RawValue())
+	assert.Equal(t, "60", cellValue(t, d, 3, 2))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, "", s.Cell(3, 2).RawValue())
+	assert.Equal(t, "60", cellValue(t, d, 3, 2))
+}
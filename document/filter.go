@@ -0,0 +1,240 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/sheet"
+
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Скрытие строк и колонок и автофильтр. Все изменения видимости записываются в журнал
+// как снимок состояния листа до и после изменения.
+
+// Операции сравнения в условиях фильтра.
+var filterOps = map[string]bool{
+	"=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true,
+	"contains": true, "!contains": true, "begins": true, "ends": true,
+}
+
+// IsFilterOp reports whether the operation can be used in a filter condition.
+func IsFilterOp(op string) bool {
+	return filterOps[op]
+}
+
+// FilterValue is a distinct value of a filter column and the number of rows holding it.
+type FilterValue struct {
+	Value string
+	Count int
+}
+
+// changeVisibility records the change of hidden lines or the autofilter made by f.
+func (d *Document) changeVisibility(s *sheet.Sheet, f func()) {
+	a := &visibilityAction{sheetIdx: s.Idx, before: s.Visibility()}
+	f()
+	a.after = s.Visibility()
+	d.record(a)
+}
+
+// HideRows hides or shows the rows from y1 to y2.
+func (d *Document) HideRows(s *sheet.Sheet, y1, y2 int, hidden bool) {
+	d.changeVisibility(s, func() {
+		for y := y1; y <= y2; y++ {
+			s.SetRowHidden(y, hidden)
+		}
+	})
+}
+
+// HideCols hides or shows the columns from x1 to x2.
+func (d *Document) HideCols(s *sheet.Sheet, x1, x2 int, hidden bool) {
+	d.changeVisibility(s, func() {
+		for x := x1; x <= x2; x++ {
+			s.SetColHidden(x, hidden)
+		}
+	})
+}
+
+// UnhideAll shows all the rows or columns of the sheet hidden by user.
+func (d *Document) UnhideAll(s *sheet.Sheet, cols bool) {
+	d.changeVisibility(s, func() {
+		s.UnhideAll(cols)
+	})
+}
+
+// Freeze fixes the rows above the cell X, Y and the columns left of it on the screen.
+// Freeze with zero X and Y unfreezes the panes.
+func (d *Document) Freeze(s *sheet.Sheet, x, y int) {
+	d.changeVisibility(s, func() {
+		s.Freeze(x, y)
+	})
+}
+
+// SetAutoFilter creates the autofilter over the rect, the first row of which is the header.
+// The previous filter of the sheet is removed.
+func (d *Document) SetAutoFilter(s *sheet.Sheet, r sheet.Rect) {
+	d.changeVisibility(s, func() {
+		s.ClearFilteredRows()
+		s.Filter = sheet.NewAutoFilter(r)
+	})
+}
+
+// RemoveAutoFilter removes the autofilter and shows the rows hidden by it.
+func (d *Document) RemoveAutoFilter(s *sheet.Sheet) {
+	if s.Filter == nil {
+		return
+	}
+	d.changeVisibility(s, func() {
+		s.ClearFilteredRows()
+		s.Filter = nil
+	})
+}
+
+// SetFilterCondition sets the condition for the column of the autofilter and hides
+// the rows which do not match the conditions.
+func (d *Document) SetFilterCondition(s *sheet.Sheet, col int, cond sheet.FilterCondition) error {
+	f := s.Filter
+	if f == nil {
+		return eval.NewError(eval.ErrorKindName, "sheet has no filter")
+	}
+	if col < f.Rect.X || col > f.Rect.MaxX() {
+		return eval.NewError(eval.ErrorKindRef, "column %s is out of the filter", ColName(col))
+	}
+	if cond.Op != "" && !IsFilterOp(cond.Op) {
+		return eval.NewError(eval.ErrorKindName, "unknown filter operation %s", cond.Op)
+	}
+	d.changeVisibility(s, func() {
+		f.Conditions[col] = cond
+		d.applyFilter(s)
+	})
+	return nil
+}
+
+// ClearFilterCondition removes the condition of the column, or of all the columns
+// if col is negative.
+func (d *Document) ClearFilterCondition(s *sheet.Sheet, col int) {
+	if s.Filter == nil {
+		return
+	}
+	d.changeVisibility(s, func() {
+		if col < 0 {
+			s.Filter.Conditions = make(map[int]sheet.FilterCondition)
+		} else {
+			delete(s.Filter.Conditions, col)
+		}
+		d.applyFilter(s)
+	})
+}
+
+// ApplyFilter checks the rows of the autofilter again after values have changed.
+func (d *Document) ApplyFilter(s *sheet.Sheet) {
+	if s.Filter == nil {
+		return
+	}
+	d.changeVisibility(s, func() {
+		d.applyFilter(s)
+	})
+}
+
+// applyFilter hides the data rows of the filter which do not match its conditions.
+func (d *Document) applyFilter(s *sheet.Sheet) {
+	s.ClearFilteredRows()
+	ec := eval.NewContext(d, s.Idx)
+	r := s.Filter.DataRows()
+	for y := r.Y; y <= r.MaxY(); y++ {
+		for col, cond := range s.Filter.Conditions {
+			if !matchFilter(cond, cellString(ec, s, col, y)) {
+				s.SetRowFiltered(y, true)
+				break
+			}
+		}
+	}
+}
+
+// FilterValues returns distinct values of the column in the data rows of the autofilter
+// with the number of rows holding each of them.
+func (d *Document) FilterValues(s *sheet.Sheet, col int) []FilterValue {
+	if s.Filter == nil {
+		return nil
+	}
+	ec := eval.NewContext(d, s.Idx)
+	r := s.Filter.DataRows()
+	counts := make(map[string]int)
+	for y := r.Y; y <= r.MaxY(); y++ {
+		counts[cellString(ec, s, col, y)]++
+	}
+	values := make([]FilterValue, 0, len(counts))
+	for v, n := range counts {
+		values = append(values, FilterValue{Value: v, Count: n})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return naturalCompare(values[i].Value, values[j].Value) < 0
+	})
+	return values
+}
+
+// cellString returns the value of the cell as string; errors are shown as values.
+func cellString(ec *eval.Context, s *sheet.Sheet, x, y int) string {
+	c := s.Cell(x, y)
+	if c == nil {
+		return ""
+	}
+	v, err := c.StringValue(ec)
+	if err != nil {
+		return err.Error()
+	}
+	return v
+}
+
+// matchFilter reports whether the value matches the condition. Values are compared
+// as numbers if both are numbers, and as case-insensitive text otherwise.
+func matchFilter(cond sheet.FilterCondition, v string) bool {
+	if len(cond.Values) > 0 {
+		found := false
+		for _, allowed := range cond.Values {
+			if allowed == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if cond.Op == "" {
+		return true
+	}
+	lv, lo := strings.ToLower(v), strings.ToLower(cond.Operand)
+	switch cond.Op {
+	case "contains":
+		return strings.Contains(lv, lo)
+	case "!contains":
+		return !strings.Contains(lv, lo)
+	case "begins":
+		return strings.HasPrefix(lv, lo)
+	case "ends":
+		return strings.HasSuffix(lv, lo)
+	}
+	c := strings.Compare(lv, lo)
+	if a, err := decimal.NewFromString(v); err == nil {
+		if b, err := decimal.NewFromString(cond.Operand); err == nil {
+			c = a.Cmp(b)
+		}
+	}
+	switch cond.Op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
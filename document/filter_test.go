@@ -0,0 +1,183 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFilterTestDoc() *Document {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	rows := [][]string{
+		{"name", "qty"},
+		{"apple", "10"},
+		{"pear", "2"},
+		{"Apricot", "7"},
+		{"plum", "30"},
+	}
+	for y, row := range rows {
+		for x, v := range row {
+			d.SetCellValue(s, x, y, v)
+		}
+	}
+	return d
+}
+
+func TestAutoFilter(t *testing.T) {
+	d := newFilterTestDoc()
+	s := d.CurrentSheet
+	d.SetAutoFilter(s, sheet.Rect{X: 0, Y: 0, Width: 2, Height: 5})
+
+	assert.NoError(t, d.SetFilterCondition(s, 1, sheet.FilterCondition{Op: ">", Operand: "5"}))
+	assert.Equal(t, 1, s.FilteredRows())
+	assert.True(t, s.IsRowFiltered(2))
+
+	assert.NoError(t, d.SetFilterCondition(s, 0, sheet.FilterCondition{Op: "begins", Operand: "ap"}))
+	assert.Equal(t, []bool{true, true, false, true, false}, []bool{
+		s.IsRowVisible(0), s.IsRowVisible(1), s.IsRowVisible(2), s.IsRowVisible(3), s.IsRowVisible(4),
+	})
+
+	// conditions of all the columns must match
+	assert.NoError(t, d.SetFilterCondition(s, 0, sheet.FilterCondition{Values: []string{"pear", "plum"}}))
+	assert.Equal(t, 3, s.FilteredRows())
+	assert.True(t, s.IsRowVisible(4))
+
+	assert.Error(t, d.SetFilterCondition(s, 2, sheet.FilterCondition{Op: "="}))
+	assert.Error(t, d.SetFilterCondition(s, 0, sheet.FilterCondition{Op: "~"}))
+
+	assert.Equal(t, []FilterValue{{"2", 1}, {"7", 1}, {"10", 1}, {"30", 1}}, d.FilterValues(s, 1))
+
+	d.ClearFilterCondition(s, -1)
+	assert.Equal(t, 0, s.FilteredRows())
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, 3, s.FilteredRows())
+	d.RemoveAutoFilter(s)
+	assert.Nil(t, s.Filter)
+	assert.True(t, s.IsRowVisible(2))
+}
+
+func TestAutoFilterLinesChange(t *testing.T) {
+	d := newFilterTestDoc()
+	s := d.CurrentSheet
+	d.SetAutoFilter(s, sheet.Rect{X: 0, Y: 0, Width: 2, Height: 5})
+	d.SetFilterCondition(s, 1, sheet.FilterCondition{Op: "<", Operand: "10"})
+	assert.True(t, s.IsRowFiltered(1))
+
+	s.Cursor.X, s.Cursor.Y = 0, 1
+	d.InsertEmptyRow(0)
+	assert.Equal(t, sheet.Rect{X: 0, Y: 0, Width: 2, Height: 6}, s.Filter.Rect)
+	assert.True(t, s.IsRowFiltered(2))
+	d.InsertEmptyCol(0)
+	assert.Equal(t, sheet.Rect{X: 1, Y: 0, Width: 2, Height: 6}, s.Filter.Rect)
+	_, ok := s.Filter.Conditions[2]
+	assert.True(t, ok)
+
+	s.Cursor.Y = 0
+	d.DeleteRow()
+	assert.Nil(t, s.Filter)
+	assert.Equal(t, 0, s.FilteredRows())
+	assert.True(t, d.Undo())
+	assert.NotNil(t, s.Filter)
+}
+
+func TestHiddenLines(t *testing.T) {
+	d := newFilterTestDoc()
+	s := d.CurrentSheet
+	d.HideRows(s, 1, 2, true)
+	d.HideCols(s, 1, 1, true)
+	assert.Equal(t, 3, s.NextVisibleRow(0, 1))
+	assert.Equal(t, 0, s.NextVisibleRow(3, -1))
+	assert.Equal(t, 4, s.NextVisibleRow(0, 2))
+	assert.Equal(t, 2, s.NextVisibleCol(0, 1))
+
+	s.Cursor.Y = 0
+	d.InsertEmptyRow(0)
+	assert.True(t, s.IsRowHidden(2))
+	assert.False(t, s.IsRowHidden(1))
+
+	d.UnhideAll(s, false)
+	assert.True(t, s.IsRowVisible(2))
+	assert.True(t, s.IsColHidden(1))
+	assert.True(t, d.Undo())
+	assert.True(t, s.IsRowHidden(2))
+}
+
+func TestFreezeUndo(t *testing.T) {
+	d := newFilterTestDoc()
+	s := d.CurrentSheet
+	d.Freeze(s, 1, 1)
+	s.Cursor.Y = 0
+	d.DeleteRow()
+	assert.Equal(t, 0, s.FrozenRows)
+	assert.True(t, d.Undo())
+	assert.Equal(t, 1, s.FrozenRows)
+	assert.True(t, d.Undo())
+	assert.Equal(t, 0, s.FrozenRows)
+	assert.Equal(t, 0, s.FrozenCols)
+}
+
+func TestSubtotal(t *testing.T) {
+	d := newFilterTestDoc()
+	s := d.CurrentSheet
+	d.SetCellValue(s, 1, 5, "=SUBTOTAL(9; B2:B5)")
+	d.SetCellValue(s, 1, 6, "=SUBTOTAL(109; B2:B6)")
+	d.SetCellValue(s, 1, 7, "=AGGREGATE(14; 5; B2:B5; 2)")
+	d.SetCellValue(s, 1, 8, "=AGGREGATE(4; 0; B1:B8)")
+	assert.Equal(t, "49", cellValue(t, d, 1, 5))
+	assert.Equal(t, "49", cellValue(t, d, 1, 6))
+	assert.Equal(t, "10", cellValue(t, d, 1, 7))
+	assert.Equal(t, "30", cellValue(t, d, 1, 8))
+
+	d.HideRows(s, 4, 4, true)
+	assert.Equal(t, "49", cellValue(t, d, 1, 5))
+	assert.Equal(t, "19", cellValue(t, d, 1, 6))
+	assert.Equal(t, "7", cellValue(t, d, 1, 7))
+
+	d.SetAutoFilter(s, sheet.Rect{X: 0, Y: 0, Width: 2, Height: 5})
+	d.SetFilterCondition(s, 0, sheet.FilterCondition{Op: "<>", Operand: "apple"})
+	assert.Equal(t, "39", cellValue(t, d, 1, 5))
+	assert.Equal(t, "9", cellValue(t, d, 1, 6))
+}
+
+func TestAggregateFunctions(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	for i, v := range []string{"1", "2", "2", "4", "x", "", "6"} {
+		d.SetCellValue(s, 0, i, v)
+	}
+	d.SetCellValue(s, 1, 0, "=1/0")
+	tests := []struct {
+		f    string
+		want string
+	}{
+		{"=SUBTOTAL(1; A1:A7)", "3"},
+		{"=SUBTOTAL(2; A1:A7)", "5"},
+		{"=SUBTOTAL(3; A1:A7)", "6"},
+		{"=SUBTOTAL(5; A1:A7)", "1"},
+		{"=SUBTOTAL(6; A1:A7)", "96"},
+		{"=SUBTOTAL(8; A1:A7)", "1.7888543819998317"},
+		{"=SUBTOTAL(11; A1:A7)", "3.2"},
+		{"=AGGREGATE(12; 4; A1:A7)", "2"},
+		{"=AGGREGATE(13; 4; A1:A7)", "2"},
+		{"=AGGREGATE(15; 4; A1:A7; 2)", "2"},
+		{"=AGGREGATE(16; 4; A1:A7; 0.25)", "2"},
+		{"=AGGREGATE(17; 4; A1:A7; 3)", "4"},
+		{"=AGGREGATE(18; 4; A1:A7; 0.5)", "2"},
+		{"=AGGREGATE(19; 4; A1:A7; 1)", "1.5"},
+		{"=AGGREGATE(9; 6; A1:B7)", "15"},
+	}
+	for _, test := range tests {
+		d.SetCellValue(s, 3, 0, test.f)
+		assert.Equal(t, test.want, cellValue(t, d, 3, 0), test.f)
+	}
+	for _, f := range []string{"=SUBTOTAL(12; A1:A7)", "=AGGREGATE(9; 4; A1:B7)", "=AGGREGATE(14; 4; A1:A7)", "=AGGREGATE(9; 8; A1)"} {
+		d.SetCellValue(s, 3, 0, f)
+		_, err := s.Cell(3, 0).StringValue(eval.NewContext(d, s.Idx))
+		assert.Error(t, err, f)
+	}
+}
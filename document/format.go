@@ -0,0 +1,40 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/numfmt"
+	"xl/document/sheet"
+)
+
+// Числовые форматы ячеек. Формат хранится в самой ячейке и сохраняется при изменении
+// ее значения; ячейки, которые были пустыми, создаются, чтобы хранить формат.
+
+// SetFormat sets the number format of the cells of rect r of the sheet, nil makes
+// the values be shown as is.
+func (d *Document) SetFormat(s *sheet.Sheet, r sheet.Rect, f *numfmt.Format) {
+	d.BeginGroup()
+	defer d.EndGroup()
+	a := &formatAction{sheetIdx: s.Idx, rect: r, after: f, before: make([][]*numfmt.Format, r.Width)}
+	ec := eval.NewContext(d, s.Idx)
+	for x := 0; x < r.Width; x++ {
+		a.before[x] = make([]*numfmt.Format, r.Height)
+		for y := 0; y < r.Height; y++ {
+			if c := s.Cell(r.X+x, r.Y+y); c != nil {
+				a.before[x][y] = c.Format()
+			}
+			if removed, added := s.SetCellFormat(ec, r.X+x, r.Y+y, f); len(removed) > 0 {
+				d.record(&segmentsAction{sheetIdx: s.Idx, removed: removed, added: added})
+			}
+		}
+	}
+	d.record(a)
+}
+
+// CellFormat returns the number format of the cell of the sheet, or nil if the cell
+// has no format.
+func (d *Document) CellFormat(s *sheet.Sheet, x, y int) *numfmt.Format {
+	if c := s.Cell(x, y); c != nil {
+		return c.Format()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/numfmt"
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// displayValue returns the formatted value of the cell of the current sheet.
+func displayValue(t *testing.T, d *Document, x, y int) string {
+	c := d.CurrentSheet.Cell(x, y)
+	if c == nil {
+		return ""
+	}
+	v, err := c.DisplayValue(eval.NewContext(d, d.CurrentSheet.Idx))
+	if err != nil {
+		return err.Error()
+	}
+	return v.Text
+}
+
+func TestSetFormat(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	d.SetCellValue(s, 0, 0, "1234.5")
+	d.SetCellValue(s, 0, 1, "=A1*2")
+	f, err := numfmt.Parse("#,##0.00")
+	assert.NoError(t, err)
+	d.SetFormat(s, sheet.Rect{X: 0, Y: 0, Width: 1, Height: 3}, f)
+	assert.Equal(t, "1,234.50", displayValue(t, d, 0, 0))
+	assert.Equal(t, "2,469.00", displayValue(t, d, 0, 1))
+	// the empty cell is created to hold the format
+	assert.Equal(t, f, d.CellFormat(s, 0, 2))
+	d.SetCellValue(s, 0, 2, "0.5")
+	assert.Equal(t, "0.50", displayValue(t, d, 0, 2))
+
+	// editing keeps the format
+	d.SetCellValue(s, 0, 0, "10")
+	assert.Equal(t, "10.00", displayValue(t, d, 0, 0))
+	assert.True(t, d.Undo())
+	assert.Equal(t, "1,234.50", displayValue(t, d, 0, 0))
+
+	assert.True(t, d.Undo())
+	assert.True(t, d.Undo())
+	assert.Equal(t, "1234.5", displayValue(t, d, 0, 0))
+	assert.Nil(t, d.CellFormat(s, 0, 1))
+	assert.True(t, d.Redo())
+	assert.Equal(t, "2,469.00", displayValue(t, d, 0, 1))
+}
+
+func TestSetFormatOfXSegment(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	d.SetCellValue(s, 0, 0, "0.25")
+	d.SetCellValue(s, 1, 0, "=A1*2")
+	d.Fill(s, sheet.Rect{X: 1, Y: 0, Width: 1, Height: 4}, FillDown)
+	f, _ := numfmt.Parse("0%")
+	d.SetFormat(s, sheet.Rect{X: 1, Y: 2, Width: 1, Height: 1}, f)
+	assert.Equal(t, "0", displayValue(t, d, 1, 1))
+	assert.Equal(t, "0%", displayValue(t, d, 1, 2))
+	assert.Equal(t, "0", displayValue(t, d, 1, 3))
+	assert.True(t, d.Undo())
+	assert.Equal(t, "0", displayValue(t, d, 1, 2))
+}
+
+func TestDeleteRowKeepsFormatForUndo(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	d.SetCellValue(s, 0, 0, "0.5")
+	f, _ := numfmt.Parse("0%")
+	d.SetFormat(s, sheet.Rect{X: 0, Y: 0, Width: 1, Height: 1}, f)
+	s.Cursor.Y = 0
+	d.DeleteRow()
+	assert.True(t, d.Undo())
+	assert.Equal(t, "50%", displayValue(t, d, 0, 0))
+}
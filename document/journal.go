@@ -0,0 +1,727 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/numfmt"
+	"xl/document/sheet"
+)
+
+// Журнал изменений документа. Каждое изменение, сделанное через методы документа,
+// записывается в журнал в виде действия, которое умеет себя отменить и повторить.
+// Действия объединяются в группы: группа отменяется и повторяется целиком, так что
+// операция над множеством ячеек выглядит для пользователя как один шаг.
+
+const maxUndoLevels = 1000
+
+// Действие, которое можно отменить и повторить.
+type action interface {
+	undo(d *Document)
+	redo(d *Document)
+}
+
+type journal struct {
+	undo [][]action
+	redo [][]action
+	// Группа, которая собирается в данный момент, и глубина вложенности BeginGroup.
+	group      []action
+	groupDepth int
+}
+
+// BeginGroup starts a group of changes which are undone and redone as a single step.
+// Groups can be nested, the outermost one defines the step.
+func (d *Document) BeginGroup() {
+	d.journal.groupDepth++
+}
+
+// EndGroup finishes the group of changes started by BeginGroup.
+func (d *Document) EndGroup() {
+	if d.journal.groupDepth == 0 {
+		return
+	}
+	d.journal.groupDepth--
+	if d.journal.groupDepth == 0 {
+		d.commitGroup()
+	}
+}
+
+// Undo reverts the last step. Returns false if there is nothing to undo.
+func (d *Document) Undo() bool {
+	d.commitGroup()
+	j := &d.journal
+	if len(j.undo) == 0 {
+		return false
+	}
+	group := j.undo[len(j.undo)-1]
+	j.undo = j.undo[:len(j.undo)-1]
+	d.changes++
+	for i := len(group) - 1; i >= 0; i-- {
+		group[i].undo(d)
+	}
+	j.redo = append(j.redo, group)
+	return true
+}
+
+// Redo performs again the last undone step. Returns false if there is nothing to redo.
+func (d *Document) Redo() bool {
+	d.commitGroup()
+	j := &d.journal
+	if len(j.redo) == 0 {
+		return false
+	}
+	group := j.redo[len(j.redo)-1]
+	j.redo = j.redo[:len(j.redo)-1]
+	d.changes++
+	for _, a := range group {
+		a.redo(d)
+	}
+	j.undo = append(j.undo, group)
+	return true
+}
+
+// ClearHistory forgets all the changes made so far, so they can not be undone.
+func (d *Document) ClearHistory() {
+	d.journal = journal{groupDepth: d.journal.groupDepth}
+}
+
+// record puts the action into the journal. New change makes undone steps impossible to redo.
+func (d *Document) record(a action) {
+	d.changes++
+	d.journal.group = append(d.journal.group, a)
+	d.journal.redo = nil
+	if d.journal.groupDepth == 0 {
+		d.commitGroup()
+	}
+}
+
+// rollback reverts and forgets the actions of the current group starting from the action
+// number mark, so they can not be redone.
+func (d *Document) rollback(mark int) {
+	group := d.journal.group
+	for i := len(group) - 1; i >= mark; i-- {
+		group[i].undo(d)
+	}
+	d.journal.group = group[:mark]
+	d.changes++
+}
+
+// commitGroup moves collected actions to the journal as a single step.
+func (d *Document) commitGroup() {
+	j := &d.journal
+	if len(j.group) == 0 {
+		return
+	}
+	j.undo = append(j.undo, j.group)
+	if len(j.undo) > maxUndoLevels {
+		j.undo = j.undo[len(j.undo)-maxUndoLevels:]
+	}
+	j.group = nil
+}
+
+// focus makes the sheet current and moves cursor to the place of undone or redone change.
+func (d *Document) focus(sheetIdx, x, y int) {
+	pos := d.sheetPos(sheetIdx)
+	if pos < 0 {
+		return
+	}
+	d.CurrentSheet, d.CurrentSheetN = d.Sheets[pos], pos
+	d.CurrentSheet.Cursor.X, d.CurrentSheet.Cursor.Y = x, y
+}
+
+// Изменение значения ячейки.
+type cellAction struct {
+	sheetIdx int
+	x        int
+	y        int
+	before   string
+	after    string
+}
+
+func (a *cellAction) undo(d *Document) {
+	d.setCellValue(a.sheetIdx, a.x, a.y, a.before)
+	d.focus(a.sheetIdx, a.x, a.y)
+}
+
+func (a *cellAction) redo(d *Document) {
+	d.setCellValue(a.sheetIdx, a.x, a.y, a.after)
+	d.focus(a.sheetIdx, a.x, a.y)
+}
+
+// Вставка или удаление строки или колонки. Хранит прежнее состояние Ссылок, которые
+// были скорректированы, значения удаленных ячеек и экстраполяционные сегменты,
+// разделенные перед изменением, размер удаленной линии, а также скрытые строки и колонки,
+// автофильтр, оформление, объединенные ячейки, правила условного форматирования
+// и проверки значений листа.
+type lineAction struct {
+	sheetIdx    int
+	change      int
+	n           int
+	refs        map[int][]sheet.RefsState
+	removed     []removedCell
+	lineSize    int
+	splits      []segmentsAction
+	visibility  sheet.Visibility
+	styles      []sheet.StyleRange
+	merges      []sheet.Rect
+	condRules   map[int][]sheet.CondRule
+	validations map[int][]sheet.Validation
+}
+
+type removedCell struct {
+	x    int
+	y    int
+	cell sheet.Cell
+}
+
+func (a *lineAction) undo(d *Document) {
+	s := d.sheetByIdx(a.sheetIdx)
+	switch a.change {
+	case sheet.ChangeInsertRow:
+		s.DeleteRow(a.n)
+	case sheet.ChangeInsertCol:
+		s.DeleteCol(a.n)
+	case sheet.ChangeDeleteRow:
+		s.InsertEmptyRow(a.n)
+		s.SetRowSize(a.n, a.lineSize)
+	case sheet.ChangeDeleteCol:
+		s.InsertEmptyCol(a.n)
+		s.SetColSize(a.n, a.lineSize)
+	}
+	for i := range a.removed {
+		cell := a.removed[i].cell
+		s.SetCell(a.removed[i].x, a.removed[i].y, &cell)
+	}
+	for _, rs := range d.Sheets {
+		if states, ok := a.refs[rs.Idx]; ok {
+			rs.RestoreRefs(states)
+		}
+		if rules, ok := a.condRules[rs.Idx]; ok {
+			rs.SetCondRules(rules)
+		}
+		if rules, ok := a.validations[rs.Idx]; ok {
+			rs.SetValidations(rules)
+		}
+	}
+	for _, split := range a.splits {
+		d.sheetByIdx(split.sheetIdx).ReplaceSegments(split.added, split.removed)
+	}
+	s.SetVisibility(a.visibility)
+	s.SetStyles(a.styles)
+	s.SetMerges(a.merges)
+	d.focusLine(a)
+}
+
+func (a *lineAction) redo(d *Document) {
+	*a = *d.changeLine(a.sheetIdx, a.change, a.n)
+	d.focusLine(a)
+}
+
+// focusLine moves cursor to the inserted or deleted line.
+func (d *Document) focusLine(a *lineAction) {
+	s := d.sheetByIdx(a.sheetIdx)
+	if s == nil {
+		return
+	}
+	x, y := s.Cursor.X, s.Cursor.Y
+	if a.change == sheet.ChangeInsertCol || a.change == sheet.ChangeDeleteCol {
+		x = a.n
+	} else {
+		y = a.n
+	}
+	d.focus(a.sheetIdx, x, y)
+}
+
+// changeLine inserts or deletes row or column N of the sheet correcting references
+// in formulas of all sheets. Returns the action which reverts the change.
+// References are corrected before the change is made, so formulas which have not been
+// parsed yet are parsed against the original cells positions.
+func (d *Document) changeLine(sheetIdx, change, n int) *lineAction {
+	s := d.sheetByIdx(sheetIdx)
+	a := &lineAction{
+		sheetIdx:   sheetIdx,
+		change:     change,
+		n:          n,
+		refs:       make(map[int][]sheet.RefsState),
+		visibility: s.Visibility(),
+		styles:     s.Styles(),
+		merges:     s.Merges(),
+		condRules:  map[int][]sheet.CondRule{sheetIdx: s.CondRules()},
+
+		validations: map[int][]sheet.Validation{sheetIdx: s.Validations()},
+	}
+	// extrapolation segments can not change in the middle, so they are split first,
+	// on other sheets too if their references cross the line
+	for _, rs := range d.Sheets {
+		removed, added := rs.SplitXSegments(eval.NewContext(d, rs.Idx), sheetIdx, change, n)
+		if len(removed) > 0 {
+			a.splits = append(a.splits, segmentsAction{sheetIdx: rs.Idx, removed: removed, added: added})
+		}
+	}
+	// removed cells are saved as they were before the references correction
+	switch change {
+	case sheet.ChangeDeleteRow:
+		a.lineSize = s.RowSize(n)
+		for x := 0; x <= s.Size.MaxX(); x++ {
+			a.saveRemoved(s, x, n)
+		}
+	case sheet.ChangeDeleteCol:
+		a.lineSize = s.ColSize(n)
+		for y := 0; y <= s.Size.MaxY(); y++ {
+			a.saveRemoved(s, n, y)
+		}
+	}
+	for _, rs := range d.Sheets {
+		if states := rs.AdjustRefs(eval.NewContext(d, rs.Idx), change, sheetIdx, n); len(states) > 0 {
+			a.refs[rs.Idx] = states
+		}
+		rules, validations := rs.CondRules(), rs.Validations()
+		if rs.AdjustCondRefs(eval.NewContext(d, rs.Idx), change, sheetIdx, n) {
+			a.condRules[rs.Idx] = rules
+		}
+		if rs.AdjustValidationRefs(eval.NewContext(d, rs.Idx), change, sheetIdx, n) {
+			a.validations[rs.Idx] = validations
+		}
+	}
+	switch change {
+	case sheet.ChangeInsertRow:
+		s.InsertEmptyRow(n)
+	case sheet.ChangeInsertCol:
+		s.InsertEmptyCol(n)
+	case sheet.ChangeDeleteRow:
+		s.DeleteRow(n)
+	case sheet.ChangeDeleteCol:
+		s.DeleteCol(n)
+	}
+	return a
+}
+
+// saveRemoved remembers the cell which is about to be removed.
+// Cells of split extrapolation segments come back with the segments.
+func (a *lineAction) saveRemoved(s *sheet.Sheet, x, y int) {
+	found := s.FindSegment(x, y)
+	for _, split := range a.splits {
+		for _, segment := range split.added {
+			if segment == found {
+				return
+			}
+		}
+	}
+	if c := s.Cell(x, y); c != nil && (c.RawValue() != "" || c.Format() != nil) {
+		removed := sheet.NewCellUntyped(c.RawValue())
+		removed.SetFormat(c.Format())
+		a.removed = append(a.removed, removedCell{x: x, y: y, cell: *removed})
+	}
+}
+
+// Замена одних сегментов листа другими: добавление, разделение или материализация сегментов.
+type segmentsAction struct {
+	sheetIdx int
+	removed  []sheet.Segment
+	added    []sheet.Segment
+}
+
+func (a *segmentsAction) undo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).ReplaceSegments(a.added, a.removed)
+	a.focus(d)
+}
+
+func (a *segmentsAction) redo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).ReplaceSegments(a.removed, a.added)
+	a.focus(d)
+}
+
+// focus moves cursor to the first of replaced segments.
+func (a *segmentsAction) focus(d *Document) {
+	segments := append(a.removed, a.added...)
+	if len(segments) > 0 {
+		size := segments[0].Size()
+		d.focus(a.sheetIdx, size.X, size.Y)
+	}
+}
+
+// Перестановка строк прямоугольника при сортировке. Хранит прежнее состояние Ссылок,
+// которые были перемещены вслед за строками.
+type rowsAction struct {
+	sheetIdx int
+	rect     sheet.Rect
+	rows     map[int]int
+	refs     map[int][]sheet.RefsState
+}
+
+func (a *rowsAction) undo(d *Document) {
+	back := make(map[int]int, len(a.rows))
+	for from, to := range a.rows {
+		back[to] = from
+	}
+	d.sheetByIdx(a.sheetIdx).PermuteRows(a.rect, back)
+	for _, rs := range d.Sheets {
+		if states, ok := a.refs[rs.Idx]; ok {
+			rs.RestoreRefs(states)
+		}
+	}
+	d.focus(a.sheetIdx, a.rect.X, a.rect.Y)
+}
+
+func (a *rowsAction) redo(d *Document) {
+	*a = *d.moveRows(a.sheetIdx, a.rect, a.rows)
+	d.focus(a.sheetIdx, a.rect.X, a.rect.Y)
+}
+
+// moveRows reorders rows of the rect on the sheet, row Y becomes row rows[Y]. References
+// in formulas of all sheets follow the moved cells. Returns the action which reverts the change.
+func (d *Document) moveRows(sheetIdx int, r sheet.Rect, rows map[int]int) *rowsAction {
+	a := &rowsAction{
+		sheetIdx: sheetIdx,
+		rect:     r,
+		rows:     rows,
+		refs:     make(map[int][]sheet.RefsState),
+	}
+	for _, rs := range d.Sheets {
+		if states := rs.MoveRefs(eval.NewContext(d, rs.Idx), sheetIdx, r, rows); len(states) > 0 {
+			a.refs[rs.Idx] = states
+		}
+	}
+	d.sheetByIdx(sheetIdx).PermuteRows(r, rows)
+	return a
+}
+
+// Создание листа, в том числе копированием.
+type sheetAction struct {
+	sheet *sheet.Sheet
+	pos   int
+}
+
+func (a *sheetAction) undo(d *Document) {
+	d.Sheets = append(d.Sheets[:a.pos], d.Sheets[a.pos+1:]...)
+	if len(d.Sheets) == 0 {
+		d.CurrentSheet, d.CurrentSheetN = nil, 0
+	} else if d.CurrentSheet == a.sheet {
+		pos := a.pos
+		if pos >= len(d.Sheets) {
+			pos = len(d.Sheets) - 1
+		}
+		d.CurrentSheet, d.CurrentSheetN = d.Sheets[pos], pos
+	} else {
+		d.CurrentSheetN = d.sheetPos(d.CurrentSheet.Idx)
+	}
+}
+
+func (a *sheetAction) redo(d *Document) {
+	d.Sheets = append(d.Sheets, nil)
+	copy(d.Sheets[a.pos+1:], d.Sheets[a.pos:])
+	d.Sheets[a.pos] = a.sheet
+	if d.CurrentSheet == nil {
+		d.CurrentSheet = a.sheet
+	}
+	d.CurrentSheetN = d.sheetPos(d.CurrentSheet.Idx)
+}
+
+// Удаление листа - действие, обратное созданию. Хранит прежнее состояние Ссылок на лист,
+// которые стали недействительными, и правил с формулами, ссылавшимися на него.
+type deleteSheetAction struct {
+	sheetAction
+	refs        map[int][]sheet.RefsState
+	condRules   map[int][]sheet.CondRule
+	validations map[int][]sheet.Validation
+}
+
+func (a *deleteSheetAction) undo(d *Document) {
+	a.sheetAction.redo(d)
+	for _, rs := range d.Sheets {
+		if states, ok := a.refs[rs.Idx]; ok {
+			rs.RestoreRefs(states)
+		}
+		if rules, ok := a.condRules[rs.Idx]; ok {
+			rs.SetCondRules(rules)
+		}
+		if rules, ok := a.validations[rs.Idx]; ok {
+			rs.SetValidations(rules)
+		}
+	}
+	d.focus(a.sheet.Idx, a.sheet.Cursor.X, a.sheet.Cursor.Y)
+}
+
+func (a *deleteSheetAction) redo(d *Document) {
+	*a = *d.deleteSheet(a.sheet.Idx)
+}
+
+// deleteSheet deletes the sheet making references to it invalid. Returns the action
+// which reverts the change.
+func (d *Document) deleteSheet(sheetIdx int) *deleteSheetAction {
+	s := d.sheetByIdx(sheetIdx)
+	a := &deleteSheetAction{
+		sheetAction: sheetAction{sheet: s, pos: d.sheetPos(sheetIdx)},
+		refs:        make(map[int][]sheet.RefsState),
+		condRules:   make(map[int][]sheet.CondRule),
+		validations: make(map[int][]sheet.Validation),
+	}
+	for _, rs := range d.Sheets {
+		if rs == s {
+			continue
+		}
+		if states := rs.InvalidateRefs(eval.NewContext(d, rs.Idx), sheetIdx); len(states) > 0 {
+			a.refs[rs.Idx] = states
+		}
+		rules, validations := rs.CondRules(), rs.Validations()
+		if rs.InvalidateCondRefs(eval.NewContext(d, rs.Idx), sheetIdx) {
+			a.condRules[rs.Idx] = rules
+		}
+		if rs.InvalidateValidationRefs(eval.NewContext(d, rs.Idx), sheetIdx) {
+			a.validations[rs.Idx] = validations
+		}
+	}
+	a.sheetAction.undo(d)
+	return a
+}
+
+// Переименование листа. Хранит прежнее состояние Ссылок на лист и правил с формулами,
+// в которых изменилось название листа.
+type renameSheetAction struct {
+	sheetIdx    int
+	before      string
+	after       string
+	refs        map[int][]sheet.RefsState
+	condRules   map[int][]sheet.CondRule
+	validations map[int][]sheet.Validation
+}
+
+func (a *renameSheetAction) undo(d *Document) {
+	s := d.sheetByIdx(a.sheetIdx)
+	s.Title = a.before
+	for _, rs := range d.Sheets {
+		if states, ok := a.refs[rs.Idx]; ok {
+			rs.RestoreRefs(states)
+		}
+		if rules, ok := a.condRules[rs.Idx]; ok {
+			rs.SetCondRules(rules)
+		}
+		if rules, ok := a.validations[rs.Idx]; ok {
+			rs.SetValidations(rules)
+		}
+	}
+	d.focus(a.sheetIdx, s.Cursor.X, s.Cursor.Y)
+}
+
+func (a *renameSheetAction) redo(d *Document) {
+	*a = *d.renameSheet(a.sheetIdx, a.after)
+	s := d.sheetByIdx(a.sheetIdx)
+	d.focus(a.sheetIdx, s.Cursor.X, s.Cursor.Y)
+}
+
+// renameSheet changes the title of the sheet and formulas referring to it. Returns
+// the action which reverts the change.
+func (d *Document) renameSheet(sheetIdx int, title string) *renameSheetAction {
+	s := d.sheetByIdx(sheetIdx)
+	a := &renameSheetAction{
+		sheetIdx:    sheetIdx,
+		before:      s.Title,
+		after:       title,
+		refs:        make(map[int][]sheet.RefsState),
+		condRules:   make(map[int][]sheet.CondRule),
+		validations: make(map[int][]sheet.Validation),
+	}
+	// formulas are parsed while they can refer to the sheet by its old title
+	for _, rs := range d.Sheets {
+		ec := eval.NewContext(d, rs.Idx)
+		rs.ParseFormulas(ec)
+		rules, validations := rs.CondRules(), rs.Validations()
+		if rs.RenameCondRefs(ec, s, title) {
+			a.condRules[rs.Idx] = rules
+		}
+		if rs.RenameValidationRefs(ec, s, title) {
+			a.validations[rs.Idx] = validations
+		}
+	}
+	s.Title = title
+	for _, rs := range d.Sheets {
+		if states := rs.RenameRefs(eval.NewContext(d, rs.Idx), sheetIdx); len(states) > 0 {
+			a.refs[rs.Idx] = states
+		}
+	}
+	return a
+}
+
+// Перемещение листа на другое место в списке листов.
+type moveSheetAction struct {
+	from int
+	to   int
+}
+
+func (a *moveSheetAction) undo(d *Document) {
+	d.moveSheet(a.to, a.from)
+}
+
+func (a *moveSheetAction) redo(d *Document) {
+	d.moveSheet(a.from, a.to)
+}
+
+// moveSheet moves the sheet from one position in the list of sheets to another.
+func (d *Document) moveSheet(from, to int) {
+	s := d.Sheets[from]
+	d.Sheets = append(d.Sheets[:from], d.Sheets[from+1:]...)
+	d.Sheets = append(d.Sheets[:to], append([]*sheet.Sheet{s}, d.Sheets[to:]...)...)
+	d.CurrentSheetN = d.sheetPos(d.CurrentSheet.Idx)
+}
+
+// Изменение ширины колонки.
+type colSizeAction struct {
+	sheetIdx int
+	col      int
+	before   int
+	after    int
+}
+
+func (a *colSizeAction) undo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).SetColSize(a.col, a.before)
+	d.focus(a.sheetIdx, a.col, d.sheetByIdx(a.sheetIdx).Cursor.Y)
+}
+
+func (a *colSizeAction) redo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).SetColSize(a.col, a.after)
+	d.focus(a.sheetIdx, a.col, d.sheetByIdx(a.sheetIdx).Cursor.Y)
+}
+
+// Изменение высоты строки.
+type rowSizeAction struct {
+	sheetIdx int
+	row      int
+	before   int
+	after    int
+}
+
+func (a *rowSizeAction) undo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).SetRowSize(a.row, a.before)
+	d.focus(a.sheetIdx, d.sheetByIdx(a.sheetIdx).Cursor.X, a.row)
+}
+
+func (a *rowSizeAction) redo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).SetRowSize(a.row, a.after)
+	d.focus(a.sheetIdx, d.sheetByIdx(a.sheetIdx).Cursor.X, a.row)
+}
+
+// Изменение скрытых строк и колонок или автофильтра листа.
+type visibilityAction struct {
+	sheetIdx int
+	before   sheet.Visibility
+	after    sheet.Visibility
+}
+
+func (a *visibilityAction) undo(d *Document) {
+	s := d.sheetByIdx(a.sheetIdx)
+	s.SetVisibility(a.before)
+	d.focus(a.sheetIdx, s.Cursor.X, s.Cursor.Y)
+}
+
+func (a *visibilityAction) redo(d *Document) {
+	s := d.sheetByIdx(a.sheetIdx)
+	s.SetVisibility(a.after)
+	d.focus(a.sheetIdx, s.Cursor.X, s.Cursor.Y)
+}
+
+// Изменение числового формата ячеек прямоугольника. Хранит прежние форматы ячеек
+// по колонкам.
+type formatAction struct {
+	sheetIdx int
+	rect     sheet.Rect
+	before   [][]*numfmt.Format
+	after    *numfmt.Format
+}
+
+func (a *formatAction) undo(d *Document) {
+	a.apply(d, func(x, y int) *numfmt.Format {
+		return a.before[x][y]
+	})
+}
+
+func (a *formatAction) redo(d *Document) {
+	a.apply(d, func(x, y int) *numfmt.Format {
+		return a.after
+	})
+}
+
+// apply sets the formats to the cells of the rect. Cells which held the formats are
+// static by now: extrapolation segments are materialized before the format is changed.
+func (a *formatAction) apply(d *Document, format func(x, y int) *numfmt.Format) {
+	s := d.sheetByIdx(a.sheetIdx)
+	for x := 0; x < a.rect.Width; x++ {
+		for y := 0; y < a.rect.Height; y++ {
+			if c := s.Cell(a.rect.X+x, a.rect.Y+y); c != nil {
+				c.SetFormat(format(x, y))
+			}
+		}
+	}
+	d.focus(a.sheetIdx, a.rect.X, a.rect.Y)
+}
+
+// Изменение оформления ячеек прямоугольника. Хранит прямоугольники оформления листа
+// до и после изменения.
+type styleAction struct {
+	sheetIdx int
+	rect     sheet.Rect
+	before   []sheet.StyleRange
+	after    []sheet.StyleRange
+}
+
+func (a *styleAction) undo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).SetStyles(a.before)
+	d.focus(a.sheetIdx, a.rect.X, a.rect.Y)
+}
+
+func (a *styleAction) redo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).SetStyles(a.after)
+	d.focus(a.sheetIdx, a.rect.X, a.rect.Y)
+}
+
+// Изменение правил условного форматирования листа.
+type condAction struct {
+	sheetIdx int
+	before   []sheet.CondRule
+	after    []sheet.CondRule
+}
+
+func (a *condAction) undo(d *Document) {
+	s := d.sheetByIdx(a.sheetIdx)
+	s.SetCondRules(a.before)
+	d.focus(a.sheetIdx, s.Cursor.X, s.Cursor.Y)
+}
+
+func (a *condAction) redo(d *Document) {
+	s := d.sheetByIdx(a.sheetIdx)
+	s.SetCondRules(a.after)
+	d.focus(a.sheetIdx, s.Cursor.X, s.Cursor.Y)
+}
+
+// Изменение правил проверки значений листа.
+type validationAction struct {
+	sheetIdx int
+	rect     sheet.Rect
+	before   []sheet.Validation
+	after    []sheet.Validation
+}
+
+func (a *validationAction) undo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).SetValidations(a.before)
+	d.focus(a.sheetIdx, a.rect.X, a.rect.Y)
+}
+
+func (a *validationAction) redo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).SetValidations(a.after)
+	d.focus(a.sheetIdx, a.rect.X, a.rect.Y)
+}
+
+// Объединение или разделение ячеек. Хранит объединения листа до и после изменения.
+type mergeAction struct {
+	sheetIdx int
+	rect     sheet.Rect
+	before   []sheet.Rect
+	after    []sheet.Rect
+}
+
+func (a *mergeAction) undo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).SetMerges(a.before)
+	d.focus(a.sheetIdx, a.rect.X, a.rect.Y)
+}
+
+func (a *mergeAction) redo(d *Document) {
+	d.sheetByIdx(a.sheetIdx).SetMerges(a.after)
+	d.focus(a.sheetIdx, a.rect.X, a.rect.Y)
+}
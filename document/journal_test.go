@@ -0,0 +1,188 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cellValue(t *testing.T, d *Document, x, y int) string {
+	c := d.CurrentSheet.Cell(x, y)
+	if c == nil {
+		return ""
+	}
+	v, err := c.StringValue(eval.NewContext(d, d.CurrentSheet.Idx))
+	if err != nil {
+		return err.Error()
+	}
+	return v
+}
+
+func TestUndoCellEdit(t *testing.T) {
+	d := NewWithEmptySheet()
+	d.SetCellValue(d.CurrentSheet, 0, 0, "1")
+	d.SetCellValue(d.CurrentSheet, 0, 0, "2")
+	d.SetCellValue(d.CurrentSheet, 1, 0, "=A1*10")
+	assert.Equal(t, "20", cellValue(t, d, 1, 0))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, "", cellValue(t, d, 1, 0))
+	assert.True(t, d.Undo())
+	assert.Equal(t, "1", cellValue(t, d, 0, 0))
+	assert.True(t, d.Undo())
+	assert.Equal(t, "", cellValue(t, d, 0, 0))
+	assert.False(t, d.Undo())
+
+	assert.True(t, d.Redo())
+	assert.True(t, d.Redo())
+	assert.True(t, d.Redo())
+	assert.False(t, d.Redo())
+	assert.Equal(t, "20", cellValue(t, d, 1, 0))
+	assert.Equal(t, 1, d.CurrentSheet.Cursor.X)
+
+	// new change makes redo impossible
+	assert.True(t, d.Undo())
+	d.SetCellValue(d.CurrentSheet, 2, 0, "3")
+	assert.False(t, d.Redo())
+}
+
+func TestUndoGroup(t *testing.T) {
+	d := NewWithEmptySheet()
+	d.SetCellValue(d.CurrentSheet, 0, 0, "1")
+	d.BeginGroup()
+	d.SetCellValue(d.CurrentSheet, 0, 1, "2")
+	d.BeginGroup()
+	d.SetCellValue(d.CurrentSheet, 0, 2, "3")
+	d.EndGroup()
+	d.SetCellValue(d.CurrentSheet, 0, 3, "=SUM(A1:A3)")
+	d.EndGroup()
+	assert.Equal(t, "6", cellValue(t, d, 0, 3))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, "1", cellValue(t, d, 0, 0))
+	assert.Equal(t, "", cellValue(t, d, 0, 1))
+	assert.Equal(t, "", cellValue(t, d, 0, 2))
+	assert.Equal(t, "", cellValue(t, d, 0, 3))
+
+	assert.True(t, d.Redo())
+	assert.Equal(t, "6", cellValue(t, d, 0, 3))
+}
+
+func TestUndoInsertDelete(t *testing.T) {
+	d := newRefsTestDoc("=SUM(A1:A3)+B2")
+	d.CurrentSheet.Cursor.Y = 1
+	d.InsertEmptyRow(0)
+	assert.Equal(t, "=SUM(A1:A4)+B3", d.CurrentSheet.Cell(4, 4).RawValue())
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=SUM(A1:A3)+B2", d.CurrentSheet.Cell(4, 3).RawValue())
+	assert.Equal(t, "26", cellValue(t, d, 4, 3))
+
+	d.CurrentSheet.Cursor.Y = 1
+	d.DeleteRow()
+	assert.Equal(t, "=SUM(A1:A2)+#REF!", d.CurrentSheet.Cell(4, 2).RawValue())
+	assert.Equal(t, "#REF!", cellValue(t, d, 4, 2))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=SUM(A1:A3)+B2", d.CurrentSheet.Cell(4, 3).RawValue())
+	assert.Equal(t, "2", cellValue(t, d, 0, 1))
+	assert.Equal(t, "26", cellValue(t, d, 4, 3))
+
+	d.CurrentSheet.Cursor.X = 1
+	d.DeleteCol()
+	assert.Equal(t, "#REF!", cellValue(t, d, 3, 3))
+	assert.True(t, d.Undo())
+	assert.Equal(t, "20", cellValue(t, d, 1, 1))
+	assert.Equal(t, "26", cellValue(t, d, 4, 3))
+
+	assert.True(t, d.Redo())
+	assert.Equal(t, "=SUM(A1:A3)+#REF!", d.CurrentSheet.Cell(3, 3).RawValue())
+	assert.Equal(t, 1, d.CurrentSheet.Cursor.X)
+}
+
+func TestUndoDeleteReferencedFormula(t *testing.T) {
+	d := newRefsTestDoc("=A2*2")
+	d.SetCellValue(d.CurrentSheet, 4, 1, "=E4+A2")
+	d.CurrentSheet.Cursor.Y = 3
+	d.DeleteRow()
+	assert.Equal(t, "=#REF!+A2", d.CurrentSheet.Cell(4, 1).RawValue())
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=E4+A2", d.CurrentSheet.Cell(4, 1).RawValue())
+	assert.Equal(t, "6", cellValue(t, d, 4, 1))
+}
+
+func TestUndoSheetAndColSize(t *testing.T) {
+	d := NewWithEmptySheet()
+	s, _ := d.NewSheet("New")
+	d.CurrentSheet = s
+	d.CurrentSheetN = 1
+	d.SetColSize(0, 100)
+	assert.Equal(t, 100, s.ColSize(0))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, sheet.CellDefaultWidth, s.ColSize(0))
+	assert.True(t, d.Undo())
+	assert.Len(t, d.Sheets, 1)
+	assert.Equal(t, "Sheet1", d.CurrentSheet.Title)
+	assert.Equal(t, 0, d.CurrentSheetN)
+
+	assert.True(t, d.Redo())
+	assert.Len(t, d.Sheets, 2)
+	assert.NotNil(t, d.SheetByTitle("New"))
+}
+
+func TestUndoRowSize(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	d.SetRowSize(2, 60)
+	assert.Equal(t, 60, s.RowSize(2))
+
+	// the size follows the row
+	s.Cursor.Y = 0
+	d.InsertEmptyRow(0)
+	assert.Equal(t, 60, s.RowSize(3))
+	assert.Equal(t, sheet.CellDefaultHeight, s.RowSize(2))
+	s.Cursor.Y = 3
+	d.DeleteRow()
+	assert.Equal(t, sheet.CellDefaultHeight, s.RowSize(3))
+	assert.True(t, d.Undo())
+	assert.Equal(t, 60, s.RowSize(3))
+	assert.True(t, d.Undo())
+	assert.Equal(t, 60, s.RowSize(2))
+	assert.True(t, d.Undo())
+	assert.Equal(t, sheet.CellDefaultHeight, s.RowSize(2))
+
+	// the row of one line has the default height
+	d.ResizeRow(0, 1)
+	assert.Equal(t, 2*sheet.CellLineHeight, s.RowSize(0))
+	d.ResizeRow(0, -1)
+	assert.Equal(t, sheet.CellDefaultHeight, s.RowSize(0))
+	d.ResizeRow(0, -1)
+	assert.Equal(t, sheet.CellDefaultHeight, s.RowSize(0))
+	d.SetRowSize(0, sheet.CellLineHeight)
+	d.ResizeRow(0, -1)
+	assert.Equal(t, sheet.CellDefaultHeight, s.RowSize(0))
+	assert.True(t, d.Undo())
+	assert.Equal(t, sheet.CellLineHeight, s.RowSize(0))
+	for i := 0; i < 10; i++ {
+		d.ResizeRow(0, 1)
+	}
+	assert.Equal(t, sheet.CellMaxHeight, s.RowSize(0))
+}
+
+func TestUndoXSegment(t *testing.T) {
+	d := NewWithEmptySheet()
+	d.SetCellValue(d.CurrentSheet, 0, 2, "5")
+	d.AddXSegment(1, 0, 1, 3, 0, 0, *sheet.NewCellUntyped("=A1+1"))
+	assert.Equal(t, "6", cellValue(t, d, 1, 2))
+
+	assert.True(t, d.Undo())
+	assert.Nil(t, d.CurrentSheet.Cell(1, 2))
+
+	assert.True(t, d.Redo())
+	assert.Equal(t, "6", cellValue(t, d, 1, 2))
+}
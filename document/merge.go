@@ -0,0 +1,26 @@
+package document
+
+import (
+	"xl/document/sheet"
+)
+
+// Объединенные ячейки. Хранятся листом, изменение записывается в журнал как прежний
+// и новый набор объединений листа.
+
+// Merge merges the cells of rect r of the sheet into one. Returns the merged region,
+// which covers the regions r intersects as well.
+func (d *Document) Merge(s *sheet.Sheet, r sheet.Rect) sheet.Rect {
+	a := &mergeAction{sheetIdx: s.Idx, before: s.Merges()}
+	a.rect = s.Merge(r)
+	a.after = s.Merges()
+	d.record(a)
+	return a.rect
+}
+
+// Unmerge splits the merged regions of the sheet rect r intersects into separate cells.
+func (d *Document) Unmerge(s *sheet.Sheet, r sheet.Rect) {
+	a := &mergeAction{sheetIdx: s.Idx, rect: r, before: s.Merges()}
+	s.Unmerge(r)
+	a.after = s.Merges()
+	d.record(a)
+}
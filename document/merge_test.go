@@ -0,0 +1,32 @@
+package document
+
+import (
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	r := sheet.Rect{X: 1, Y: 0, Width: 2, Height: 2}
+	assert.Equal(t, r, d.Merge(s, r))
+	d.Unmerge(s, sheet.Rect{X: 2, Y: 1, Width: 1, Height: 1})
+	assert.Empty(t, s.Merges())
+	assert.True(t, d.Undo())
+	assert.Equal(t, []sheet.Rect{r}, s.Merges())
+
+	// deleted merge comes back with the row
+	s.Cursor.Y = 1
+	d.DeleteRow()
+	s.Cursor.Y = 0
+	d.DeleteRow()
+	assert.Empty(t, s.Merges())
+	assert.True(t, d.Undo())
+	assert.True(t, d.Undo())
+	assert.Equal(t, []sheet.Rect{r}, s.Merges())
+	assert.True(t, d.Undo())
+	assert.Empty(t, s.Merges())
+}
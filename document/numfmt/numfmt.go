@@ -0,0 +1,957 @@
+package numfmt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Числовые форматы, совместимые с кодами форматов Excel, например "#,##0.00", "0%",
+// "yyyy-mm-dd" или "0.00;[Red]-0.00".
+//
+// Код формата состоит из секций, разделенных точкой с запятой: для положительных чисел,
+// отрицательных, нуля и текста. Если секция одна, она применяется ко всем числам, а перед
+// отрицательными ставится минус; если секций две, вторая применяется к отрицательным
+// числам, которые выводятся без знака. Секции могут начинаться с цвета ([Red]) и условия
+// ([>100]), тогда секция выбирается по первому выполненному условию.
+//
+// Секция выводит число с помощью знакомест 0, # и ?, десятичной точки, разделителя
+// разрядов, процентов и степени (0.00E+00), простой дроби (# ?/?) или как дату и время
+// (y, m, d, h, s, AM/PM, [h]). Дата задается числом дней, прошедших с 1900 года,
+// как в Excel. Текст в кавычках и символы после \ выводятся как есть, символ после _
+// заменяется пробелом, символ после * (заполнение) пропускается.
+
+// Format is the parsed number format code.
+type Format struct {
+	code     string
+	sections []*section
+}
+
+// Виды секций.
+const (
+	// Секция без знакомест, которая выводит только текст, или General.
+	sectionGeneral = iota
+	sectionNumber
+	sectionFraction
+	sectionDate
+	sectionText
+)
+
+// Виды элементов секции.
+const (
+	tokenLiteral = iota
+	// Знакоместо цифры: 0, # или ?.
+	tokenDigit
+	tokenPoint
+	tokenComma
+	tokenPercent
+	// E+ или E-.
+	tokenExp
+	// @, место для текста.
+	tokenText
+	tokenGeneral
+	// Часть даты или времени: y, m, d, h, s или min для минут.
+	tokenDate
+	// Доли секунды после точки.
+	tokenSubsec
+	// AM/PM или A/P.
+	tokenAMPM
+	// Прошедшее время: [h], [m] или [s].
+	tokenElapsed
+)
+
+// Части числа, к которым относятся знакоместа.
+const (
+	partInt = iota
+	partFrac
+	partExp
+	partNum
+	partDen
+)
+
+// Элемент секции.
+type token struct {
+	kind int
+	// Текст, символ знакоместа или буква части даты.
+	text string
+	// Число повторений буквы части даты.
+	n    int
+	part int
+}
+
+// Условие выбора секции, например [>100].
+type condition struct {
+	op    string
+	value decimal.Decimal
+}
+
+type section struct {
+	kind   int
+	tokens []token
+	color  string
+	cond   *condition
+
+	// Разделять ли разряды, сколько раз делить на тысячу и умножать на сто.
+	grouping bool
+	scale    int
+	percent  int
+	// Знаменатель дроби, если он задан числом.
+	denominator int
+	// Выводить ли часы в 12-часовом формате.
+	ampm bool
+}
+
+// Цвета, которые могут быть заданы в секции, по номерам [ColorN].
+var colors = []string{"black", "white", "red", "green", "blue", "yellow", "magenta", "cyan"}
+
+// Коды встроенных форматов XLSX по номерам.
+var builtin = map[int]string{
+	0:  "General",
+	1:  "0",
+	2:  "0.00",
+	3:  "#,##0",
+	4:  "#,##0.00",
+	9:  "0%",
+	10: "0.00%",
+	11: "0.00E+00",
+	12: "# ?/?",
+	13: "# ??/??",
+	14: "mm-dd-yy",
+	15: "d-mmm-yy",
+	16: "d-mmm",
+	17: "mmm-yy",
+	18: "h:mm AM/PM",
+	19: "h:mm:ss AM/PM",
+	20: "h:mm",
+	21: "h:mm:ss",
+	22: "m/d/yy h:mm",
+	37: "#,##0 ;(#,##0)",
+	38: "#,##0 ;[Red](#,##0)",
+	39: "#,##0.00;(#,##0.00)",
+	40: "#,##0.00;[Red](#,##0.00)",
+	45: "mm:ss",
+	46: "[h]:mm:ss",
+	47: "mmss.0",
+	48: "##0.0E+0",
+	49: "@",
+}
+
+// Builtin returns the code of the built-in XLSX number format with the id, or an empty
+// string if there is no such format.
+func Builtin(id int) string {
+	return builtin[id]
+}
+
+// Начало отсчета дат: Excel считает 1 января 1900 года первым днем, но также считает
+// 1900 год високосным, поэтому даты до 1 марта 1900 года сдвинуты на день.
+var epoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// Первое число, которое уже не является датой: 10000-01-01.
+var maxDate = decimal.New(2958466, 0)
+
+var (
+	hundred  = decimal.New(100, 0)
+	thousand = decimal.New(1000, 0)
+)
+
+// Parse parses the format code.
+func Parse(code string) (*Format, error) {
+	f := &Format{code: code}
+	s := &section{}
+	runes := []rune(code)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == ';':
+			f.sections = append(f.sections, s)
+			s = &section{}
+		case c == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, errors.New("unterminated quote in number format")
+			}
+			s.literal(string(runes[i+1 : end]))
+			i = end
+		case c == '\\' || c == '_' || c == '*':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("%c at the end of number format", c)
+			}
+			i++
+			switch c {
+			case '\\':
+				s.literal(string(runes[i]))
+			case '_':
+				s.literal(" ")
+			}
+		case c == '[':
+			end := i + 1
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, errors.New("unterminated bracket in number format")
+			}
+			if err := s.bracket(string(runes[i+1 : end])); err != nil {
+				return nil, err
+			}
+			i = end
+		case c == '0' || c == '#' || c == '?':
+			s.add(token{kind: tokenDigit, text: string(c)})
+		case c == '.':
+			s.add(token{kind: tokenPoint, text: "."})
+		case c == ',':
+			s.add(token{kind: tokenComma, text: ","})
+		case c == '%':
+			s.add(token{kind: tokenPercent, text: "%"})
+		case c == '@':
+			s.add(token{kind: tokenText})
+		case (c == 'E' || c == 'e') && i+1 < len(runes) && (runes[i+1] == '+' || runes[i+1] == '-'):
+			s.add(token{kind: tokenExp, text: "E" + string(runes[i+1])})
+			i++
+		case hasPrefix(runes[i:], "general"):
+			s.add(token{kind: tokenGeneral})
+			i += len("general") - 1
+		case hasPrefix(runes[i:], "am/pm") || hasPrefix(runes[i:], "a/p"):
+			n := len("a/p")
+			if hasPrefix(runes[i:], "am/pm") {
+				n = len("am/pm")
+			}
+			s.add(token{kind: tokenAMPM, text: string(runes[i : i+n])})
+			s.ampm = true
+			i += n - 1
+		case strings.ContainsRune("yYmMdDhHsS", c):
+			n := 1
+			for i+n < len(runes) && toLower(runes[i+n]) == toLower(c) {
+				n++
+			}
+			s.add(token{kind: tokenDate, text: string(toLower(c)), n: n})
+			i += n - 1
+		default:
+			s.literal(string(c))
+		}
+	}
+	f.sections = append(f.sections, s)
+	if len(f.sections) > 4 {
+		return nil, errors.New("too many sections in number format")
+	}
+	for _, s := range f.sections {
+		s.classify()
+	}
+	return f, nil
+}
+
+// hasPrefix reports whether the runes start with the lowercase prefix ignoring case.
+func hasPrefix(runes []rune, prefix string) bool {
+	if len(runes) < len(prefix) {
+		return false
+	}
+	return strings.ToLower(string(runes[:len(prefix)])) == prefix
+}
+
+func toLower(c rune) rune {
+	if c >= 'A' && c <= 'Z' {
+		return c - 'A' + 'a'
+	}
+	return c
+}
+
+func (s *section) add(t token) {
+	s.tokens = append(s.tokens, t)
+}
+
+func (s *section) literal(text string) {
+	s.add(token{kind: tokenLiteral, text: text})
+}
+
+// bracket parses the text in square brackets: color, condition, currency or elapsed time.
+func (s *section) bracket(text string) error {
+	lower := strings.ToLower(text)
+	switch {
+	case text == "":
+		return errors.New("empty brackets in number format")
+	case strings.HasPrefix(text, "$"):
+		// currency symbol and locale
+		if i := strings.IndexRune(text, '-'); i > 0 {
+			text = text[:i]
+		}
+		s.literal(text[1:])
+		return nil
+	case strings.ContainsRune("<>=", rune(text[0])):
+		op := strings.TrimRight(text, "-+.0123456789 ")
+		v, err := decimal.NewFromString(strings.TrimSpace(text[len(op):]))
+		if err != nil {
+			return fmt.Errorf("invalid condition [%s] in number format", text)
+		}
+		switch op {
+		case "<", "<=", ">", ">=", "=", "<>":
+		default:
+			return fmt.Errorf("invalid condition [%s] in number format", text)
+		}
+		s.cond = &condition{op: op, value: v}
+		return nil
+	case strings.Trim(lower, "h") == "" || strings.Trim(lower, "m") == "" || strings.Trim(lower, "s") == "":
+		s.add(token{kind: tokenElapsed, text: lower[:1], n: len(lower)})
+		return nil
+	case strings.HasPrefix(lower, "color"):
+		n, err := strconv.Atoi(lower[len("color"):])
+		if err != nil || n < 1 || n > 56 {
+			return fmt.Errorf("invalid color [%s] in number format", text)
+		}
+		if n <= len(colors) {
+			s.color = colors[n-1]
+		}
+		return nil
+	}
+	for _, c := range colors {
+		if lower == c {
+			s.color = c
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown [%s] in number format", text)
+}
+
+// classify detects the kind of the section and assigns the digit placeholders to the parts
+// of the number. Tokens which have no meaning for the kind become literals.
+func (s *section) classify() {
+	has := make(map[int]bool)
+	for _, t := range s.tokens {
+		has[t.kind] = true
+	}
+	switch {
+	case has[tokenDate] || has[tokenElapsed] || has[tokenAMPM]:
+		s.kind = sectionDate
+		s.classifyDate()
+	case has[tokenDigit] && s.fractionSlash() >= 0:
+		s.kind = sectionFraction
+		s.classifyFraction()
+	case has[tokenDigit]:
+		s.kind = sectionNumber
+		s.classifyNumber()
+	case has[tokenText]:
+		s.kind = sectionText
+		s.literalize(tokenPoint, tokenComma, tokenPercent, tokenExp)
+	default:
+		s.kind = sectionGeneral
+		s.literalize(tokenPoint, tokenComma, tokenPercent, tokenExp)
+	}
+}
+
+// literalize turns the tokens of the kinds into literals.
+func (s *section) literalize(kinds ...int) {
+	for i, t := range s.tokens {
+		for _, k := range kinds {
+			if t.kind == k {
+				s.tokens[i] = token{kind: tokenLiteral, text: t.text}
+			}
+		}
+	}
+}
+
+func (s *section) classifyNumber() {
+	part := partInt
+	for i := 0; i < len(s.tokens); i++ {
+		t := &s.tokens[i]
+		switch t.kind {
+		case tokenDigit:
+			t.part = part
+		case tokenPoint:
+			if part != partInt {
+				t.kind = tokenLiteral
+			}
+			part = partFrac
+		case tokenExp:
+			part = partExp
+		case tokenPercent:
+			s.percent++
+		case tokenComma:
+			j := i
+			for j < len(s.tokens) && s.tokens[j].kind == tokenComma {
+				j++
+			}
+			prevDigit := i > 0 && s.tokens[i-1].kind == tokenDigit
+			nextDigit := j < len(s.tokens) && s.tokens[j].kind == tokenDigit
+			switch {
+			case prevDigit && nextDigit && part == partInt:
+				s.grouping = true
+				s.dropTokens(i, j)
+			case prevDigit && part != partExp:
+				s.scale += j - i
+				s.dropTokens(i, j)
+			default:
+				for k := i; k < j; k++ {
+					s.tokens[k].kind = tokenLiteral
+				}
+			}
+			i--
+		case tokenText, tokenGeneral:
+			t.kind, t.text = tokenLiteral, ""
+		}
+	}
+}
+
+// dropTokens removes the tokens from i up to j.
+func (s *section) dropTokens(i, j int) {
+	s.tokens = append(s.tokens[:i], s.tokens[j:]...)
+}
+
+// fractionSlash returns the position of the slash between digit placeholders, or -1.
+func (s *section) fractionSlash() int {
+	for i := 1; i+1 < len(s.tokens); i++ {
+		if s.tokens[i].kind == tokenLiteral && s.tokens[i].text == "/" && s.tokens[i-1].kind == tokenDigit {
+			next := s.tokens[i+1]
+			if next.kind == tokenDigit || (next.kind == tokenLiteral && next.text >= "1" && next.text <= "9") {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (s *section) classifyFraction() {
+	slash := s.fractionSlash()
+	// the numerator is the run of placeholders before the slash, the integer part
+	// is the placeholders before it
+	i := slash - 1
+	for i >= 0 && s.tokens[i].kind == tokenDigit {
+		s.tokens[i].part = partNum
+		i--
+	}
+	for ; i >= 0; i-- {
+		if s.tokens[i].kind == tokenDigit {
+			s.tokens[i].part = partInt
+		}
+	}
+	if s.tokens[slash+1].kind == tokenLiteral {
+		// fixed denominator
+		j := slash + 1
+		var den bytes.Buffer
+		for j < len(s.tokens) && (s.tokens[j].kind == tokenDigit && s.tokens[j].text == "0" ||
+			s.tokens[j].kind == tokenLiteral && len(s.tokens[j].text) == 1 && s.tokens[j].text >= "0" && s.tokens[j].text <= "9") {
+			den.WriteString(s.tokens[j].text)
+			j++
+		}
+		s.denominator, _ = strconv.Atoi(den.String())
+		s.tokens[slash+1] = token{kind: tokenDigit, text: "0", part: partDen}
+		s.dropTokens(slash+2, j)
+	} else {
+		for j := slash + 1; j < len(s.tokens) && s.tokens[j].kind == tokenDigit; j++ {
+			s.tokens[j].part = partDen
+		}
+	}
+	s.literalize(tokenPoint, tokenComma, tokenPercent, tokenExp, tokenText, tokenGeneral)
+}
+
+func (s *section) classifyDate() {
+	for i := 0; i < len(s.tokens); i++ {
+		t := &s.tokens[i]
+		switch t.kind {
+		case tokenDate:
+			if t.text == "m" && (s.prevTime(i) == "h" || s.nextTime(i) == "s") {
+				t.text = "min"
+			}
+		case tokenPoint:
+			// fractions of a second
+			n := 0
+			for i+1+n < len(s.tokens) && s.tokens[i+1+n].kind == tokenDigit && s.tokens[i+1+n].text == "0" {
+				n++
+			}
+			if n > 0 && s.prevTime(i) == "s" {
+				t.kind, t.n = tokenSubsec, n
+				s.dropTokens(i+1, i+1+n)
+			}
+		}
+	}
+	s.literalize(tokenPoint, tokenComma, tokenPercent, tokenExp, tokenText, tokenGeneral, tokenDigit)
+}
+
+// prevTime returns the letter of the date part before the token I.
+func (s *section) prevTime(i int) string {
+	for i--; i >= 0; i-- {
+		switch s.tokens[i].kind {
+		case tokenDate, tokenElapsed:
+			return s.tokens[i].text
+		}
+	}
+	return ""
+}
+
+// nextTime returns the letter of the date part after the token I.
+func (s *section) nextTime(i int) string {
+	for i++; i < len(s.tokens); i++ {
+		switch s.tokens[i].kind {
+		case tokenDate, tokenElapsed:
+			return s.tokens[i].text
+		}
+	}
+	return ""
+}
+
+// String returns the code of the format.
+func (f *Format) String() string {
+	return f.code
+}
+
+// textSection returns the section applied to text, or nil if text is shown as is.
+func (f *Format) textSection() *section {
+	switch {
+	case len(f.sections) == 4:
+		return f.sections[3]
+	case f.sections[0].kind == sectionText:
+		return f.sections[0]
+	}
+	return nil
+}
+
+// numberSection chooses the section for the number. Returns the section and whether
+// the minus of negative number is to be shown.
+func (f *Format) numberSection(v decimal.Decimal) (*section, bool) {
+	sections := f.sections
+	if len(sections) == 4 {
+		sections = sections[:3]
+	}
+	conditional := false
+	for _, s := range sections {
+		if s.cond != nil {
+			conditional = true
+			if s.cond.match(v) {
+				return s, true
+			}
+		}
+	}
+	if conditional {
+		for _, s := range sections {
+			if s.cond == nil {
+				return s, true
+			}
+		}
+		return nil, true
+	}
+	switch {
+	case len(sections) == 1:
+		return sections[0], true
+	case v.Sign() < 0:
+		return sections[1], false
+	case v.Sign() == 0 && len(sections) == 3:
+		return sections[2], false
+	}
+	return sections[0], false
+}
+
+func (c *condition) match(v decimal.Decimal) bool {
+	cmp := v.Cmp(c.value)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "=":
+		return cmp == 0
+	}
+	return cmp != 0
+}
+
+// Number formats the number. Returns the text and the color of the section, if any.
+func (f *Format) Number(v decimal.Decimal) (string, string) {
+	s, minus := f.numberSection(v)
+	if s == nil || s.kind == sectionText {
+		return v.String(), ""
+	}
+	var text string
+	switch s.kind {
+	case sectionNumber:
+		text = s.number(v.Abs())
+	case sectionFraction:
+		text = s.fraction(v.Abs())
+	case sectionDate:
+		if v.Sign() < 0 || v.GreaterThanOrEqual(maxDate) {
+			// like Excel does for the dates it can not show
+			return "#######", s.color
+		}
+		text = s.date(v)
+	default:
+		text = s.general(v.Abs())
+	}
+	if minus && v.Sign() < 0 && s.kind != sectionDate && strings.ContainsAny(text, "123456789") {
+		text = "-" + text
+	}
+	return text, s.color
+}
+
+// Text formats the text. Returns the text and the color of the section, if any.
+func (f *Format) Text(v string) (string, string) {
+	s := f.textSection()
+	if s == nil {
+		return v, ""
+	}
+	var buf bytes.Buffer
+	for _, t := range s.tokens {
+		switch t.kind {
+		case tokenText:
+			buf.WriteString(v)
+		case tokenLiteral:
+			buf.WriteString(t.text)
+		}
+	}
+	return buf.String(), s.color
+}
+
+// general outputs the literals of the section with the number in place of General.
+func (s *section) general(v decimal.Decimal) string {
+	var buf bytes.Buffer
+	for _, t := range s.tokens {
+		switch t.kind {
+		case tokenGeneral:
+			buf.WriteString(v.String())
+		case tokenLiteral:
+			buf.WriteString(t.text)
+		}
+	}
+	return buf.String()
+}
+
+// placeholders returns the placeholder chars of the part of the number.
+func (s *section) placeholders(part int) []byte {
+	var p []byte
+	for _, t := range s.tokens {
+		if t.kind == tokenDigit && t.part == part {
+			p = append(p, t.text[0])
+		}
+	}
+	return p
+}
+
+// fill returns the text of the placeholder which has no digit.
+func fill(placeholder byte) string {
+	switch placeholder {
+	case '0':
+		return "0"
+	case '?':
+		return " "
+	}
+	return ""
+}
+
+// fillInt places the digits into the placeholders aligning them to the right. Digits
+// which do not fit go to the first placeholder.
+func fillInt(digits string, placeholders []byte) []string {
+	out := make([]string, len(placeholders))
+	j := len(digits) - 1
+	for i := len(placeholders) - 1; i >= 0; i-- {
+		if j >= 0 {
+			out[i] = digits[j : j+1]
+			j--
+		} else {
+			out[i] = fill(placeholders[i])
+		}
+	}
+	if len(out) > 0 && j >= 0 {
+		out[0] = digits[:j+1] + out[0]
+	}
+	return out
+}
+
+// fillFrac places the digits into the placeholders of the fraction part. Trailing zeros
+// are dropped for # and replaced by spaces for ?.
+func fillFrac(digits string, placeholders []byte) []string {
+	out := make([]string, len(placeholders))
+	trailing := true
+	for i := len(placeholders) - 1; i >= 0; i-- {
+		if trailing && digits[i] == '0' && placeholders[i] != '0' {
+			out[i] = fill(placeholders[i])
+			continue
+		}
+		trailing = false
+		out[i] = digits[i : i+1]
+	}
+	return out
+}
+
+// group inserts thousands separators into the digits, leading spaces are kept.
+func group(digits string) string {
+	trimmed := strings.TrimLeft(digits, " ")
+	var buf bytes.Buffer
+	buf.WriteString(digits[:len(digits)-len(trimmed)])
+	for i, c := range trimmed {
+		if i > 0 && (len(trimmed)-i)%3 == 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+// number formats the absolute value of the number in the section with digit placeholders.
+func (s *section) number(v decimal.Decimal) string {
+	for i := 0; i < s.percent; i++ {
+		v = v.Mul(hundred)
+	}
+	for i := 0; i < s.scale; i++ {
+		v = v.Div(thousand)
+	}
+	intP, fracP, expP := s.placeholders(partInt), s.placeholders(partFrac), s.placeholders(partExp)
+	exp := 0
+	hasExp := false
+	for _, t := range s.tokens {
+		hasExp = hasExp || t.kind == tokenExp
+	}
+	if hasExp && !v.IsZero() {
+		// the exponent is a multiple of the number of integer placeholders
+		step := len(intP)
+		if step < 1 {
+			step = 1
+		}
+		exp = len(v.Coefficient().String()) - 1 + int(v.Exponent())
+		exp -= ((exp % step) + step) % step
+		m := v.Shift(int32(-exp)).Round(int32(len(fracP)))
+		if m.Cmp(decimal.New(1, int32(step))) >= 0 {
+			exp += step
+		}
+		v = v.Shift(int32(-exp))
+	}
+	fixed := v.StringFixed(int32(len(fracP)))
+	intDigits, fracDigits := fixed, ""
+	if i := strings.IndexByte(fixed, '.'); i >= 0 {
+		intDigits, fracDigits = fixed[:i], fixed[i+1:]
+	}
+	if intDigits == "0" {
+		intDigits = ""
+	}
+	ints := fillInt(intDigits, intP)
+	if s.grouping {
+		ints = append([]string{group(strings.Join(ints, ""))}, make([]string, len(ints)-1)...)
+	}
+	fracs := fillFrac(fracDigits, fracP)
+	expDigits := strconv.Itoa(exp)
+	if exp < 0 {
+		expDigits = expDigits[1:]
+	}
+	exps := fillInt(expDigits, expP)
+
+	var buf bytes.Buffer
+	n := map[int]int{}
+	for _, t := range s.tokens {
+		switch t.kind {
+		case tokenDigit:
+			switch t.part {
+			case partInt:
+				buf.WriteString(ints[n[partInt]])
+			case partFrac:
+				buf.WriteString(fracs[n[partFrac]])
+			case partExp:
+				buf.WriteString(exps[n[partExp]])
+			}
+			n[t.part]++
+		case tokenExp:
+			switch {
+			case exp < 0:
+				buf.WriteString("E-")
+			case t.text == "E+":
+				buf.WriteString("E+")
+			default:
+				buf.WriteString("E")
+			}
+		default:
+			buf.WriteString(t.text)
+		}
+	}
+	return buf.String()
+}
+
+// fraction formats the absolute value of the number as a simple fraction.
+func (s *section) fraction(v decimal.Decimal) string {
+	intP, numP, denP := s.placeholders(partInt), s.placeholders(partNum), s.placeholders(partDen)
+	f, _ := v.Float64()
+	whole := 0.0
+	if len(intP) > 0 {
+		whole = math.Floor(f)
+		f -= whole
+	}
+	maxDen := math.Pow10(len(denP))
+	if s.denominator > 0 {
+		maxDen = float64(s.denominator)
+	}
+	if f*maxDen >= 1<<53 {
+		// the numerator does not fit exactly, the number is shown as General
+		return v.String()
+	}
+	num, den := 0, 1
+	if s.denominator > 0 {
+		den = s.denominator
+		num = int(math.Round(f * float64(den)))
+	} else {
+		best := math.Inf(1)
+		for d := 1; d < int(math.Pow10(len(denP))); d++ {
+			n := int(math.Round(f * float64(d)))
+			if e := math.Abs(f - float64(n)/float64(d)); e < best {
+				best, num, den = e, n, d
+			}
+		}
+	}
+	if len(intP) > 0 && num == den {
+		whole, num = whole+1, 0
+	}
+	intDigits := strconv.FormatFloat(whole, 'f', 0, 64)
+	if intDigits == "0" {
+		intDigits = ""
+	}
+	ints := fillInt(intDigits, intP)
+	blank := num == 0 && len(intP) > 0
+	if blank && intDigits == "" {
+		ints = fillInt("0", intP)
+	}
+	numText := strings.Join(fillInt(strconv.Itoa(num), numP), "")
+	denText := strconv.Itoa(den)
+	if s.denominator == 0 {
+		denText += strings.Repeat(" ", len(denP)-len(denText))
+	}
+
+	var buf bytes.Buffer
+	n := map[int]int{}
+	numDone, denDone, afterSlash := false, false, false
+	for _, t := range s.tokens {
+		switch {
+		case t.kind == tokenDigit && t.part == partInt:
+			buf.WriteString(ints[n[partInt]])
+			n[partInt]++
+		case t.kind == tokenDigit && t.part == partNum:
+			if !numDone {
+				buf.WriteString(blankText(numText, blank))
+				numDone = true
+			}
+		case t.kind == tokenDigit && t.part == partDen:
+			if !denDone {
+				buf.WriteString(blankText(denText, blank))
+				denDone = true
+			}
+		case numDone && !afterSlash && t.text == "/":
+			buf.WriteString(blankText("/", blank))
+			afterSlash = true
+		default:
+			buf.WriteString(t.text)
+		}
+	}
+	return buf.String()
+}
+
+// blankText returns the text or the spaces of the same width if blank is set.
+func blankText(text string, blank bool) string {
+	if blank {
+		return strings.Repeat(" ", len(text))
+	}
+	return text
+}
+
+// date formats the number as the date and time.
+func (s *section) date(v decimal.Decimal) string {
+	subsec := 0
+	for _, t := range s.tokens {
+		if t.kind == tokenSubsec && t.n > subsec {
+			subsec = t.n
+		}
+	}
+	// whole days are added separately, so the duration of the time does not overflow
+	days := v.Floor()
+	f, _ := v.Sub(days).Float64()
+	scale := math.Pow10(subsec)
+	units := math.Round(f * 86400 * scale)
+	if units >= 86400*scale {
+		days, units = days.Add(decimal.New(1, 0)), 0
+	}
+	t := epoch.AddDate(0, 0, int(days.IntPart())).Add(time.Duration(units) * (time.Second / time.Duration(scale)))
+	if days.IntPart() < 60 {
+		t = t.AddDate(0, 0, 1)
+	}
+	seconds := days.IntPart()*86400 + int64(units/scale)
+
+	var buf bytes.Buffer
+	for _, tk := range s.tokens {
+		switch tk.kind {
+		case tokenDate:
+			buf.WriteString(s.datePart(t, tk))
+		case tokenSubsec:
+			frac := t.Nanosecond() / int(math.Pow10(9-tk.n))
+			fmt.Fprintf(&buf, ".%0*d", tk.n, frac)
+		case tokenAMPM:
+			parts := strings.Split(tk.text, "/")
+			if t.Hour() < 12 {
+				buf.WriteString(parts[0])
+			} else {
+				buf.WriteString(parts[1])
+			}
+		case tokenElapsed:
+			n := seconds
+			switch tk.text {
+			case "h":
+				n /= 3600
+			case "m":
+				n /= 60
+			}
+			fmt.Fprintf(&buf, "%0*d", tk.n, n)
+		default:
+			buf.WriteString(tk.text)
+		}
+	}
+	return buf.String()
+}
+
+// datePart outputs the part of the date.
+func (s *section) datePart(t time.Time, tk token) string {
+	pad := func(n int) string {
+		if tk.n >= 2 {
+			return fmt.Sprintf("%02d", n)
+		}
+		return strconv.Itoa(n)
+	}
+	switch tk.text {
+	case "y":
+		if tk.n <= 2 {
+			return fmt.Sprintf("%02d", t.Year()%100)
+		}
+		return fmt.Sprintf("%04d", t.Year())
+	case "m":
+		name := t.Month().String()
+		switch tk.n {
+		case 1, 2:
+			return pad(int(t.Month()))
+		case 3:
+			return name[:3]
+		case 4:
+			return name
+		}
+		return name[:1]
+	case "d":
+		name := t.Weekday().String()
+		switch tk.n {
+		case 1, 2:
+			return pad(t.Day())
+		case 3:
+			return name[:3]
+		}
+		return name
+	case "h":
+		h := t.Hour()
+		if s.ampm {
+			if h %= 12; h == 0 {
+				h = 12
+			}
+		}
+		return pad(h)
+	case "min":
+		return pad(t.Minute())
+	}
+	return pad(t.Second())
+}
@@ -0,0 +1,127 @@
+package numfmt
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumber(t *testing.T) {
+	testCases := []struct {
+		code  string
+		value string
+		text  string
+		color string
+	}{
+		{"General", "1234.5", "1234.5", ""},
+		{"0", "1234.5", "1235", ""},
+		{"0.00", "0.5", "0.50", ""},
+		{"#.##", "0.5", ".5", ""},
+		{"#.##", "12", "12.", ""},
+		{"0.0#", "1.234", "1.23", ""},
+		{"0.0#", "1.2", "1.2", ""},
+		{"#,##0", "1234567", "1,234,567", ""},
+		{"#,##0.00", "-1234.567", "-1,234.57", ""},
+		{"#,##0", "0", "0", ""},
+		{"#,##0,", "1234567", "1,235", ""},
+		{"0.0,,\"M\"", "12345678", "12.3M", ""},
+		{"0%", "0.125", "13%", ""},
+		{"0.00%", "-0.5", "-50.00%", ""},
+		{"000-00-0000", "123456789", "123-45-6789", ""},
+		{"00000", "42", "00042", ""},
+		{"??0.0", "1.5", "  1.5", ""},
+		{"$#,##0.00", "-5", "-$5.00", ""},
+		{"[$€-407] #,##0.00", "1000", "€ 1,000.00", ""},
+		{"0.00E+00", "12345", "1.23E+04", ""},
+		{"0.00E+00", "0.00012", "1.20E-04", ""},
+		{"##0.0E+0", "12345", "12.3E+3", ""},
+		{"0.00;[Red]-0.00", "-3", "-3.00", "red"},
+		{"0.00;[Red]-0.00", "3", "3.00", ""},
+		{"[Red]-0.00", "3", "-3.00", "red"},
+		{"#,##0;(#,##0);\"zero\"", "-1234", "(1,234)", ""},
+		{"#,##0;(#,##0);\"zero\"", "0", "zero", ""},
+		{"[Blue][>100]0;[Red][<0]0;0.0", "150", "150", "blue"},
+		{"[Blue][>100]0;[Red][<0]0;0.0", "-5", "-5", "red"},
+		{"[Blue][>100]0;[Red][<0]0;0.0", "5", "5.0", ""},
+		{"0_);(0)", "5", "5 ", ""},
+		{"\\#0", "5", "#5", ""},
+		{"0", "-0.2", "0", ""},
+		{"# ?/?", "1.25", "1 1/4", ""},
+		{"# ??/??", "3.14159", "3 14/99", ""},
+		{"# ?/8", "0.5", " 4/8", ""},
+		{"# ?/?", "2", "2    ", ""},
+		{"?/?", "0.75", "3/4", ""},
+		{"?/?", "1e20", "100000000000000000000", ""},
+		{"?/?", "-1e20", "-100000000000000000000", ""},
+		{"?/8", "-1e20", "-100000000000000000000", ""},
+		{"# ?/?", "-1e20", "-100000000000000000000    ", ""},
+		{"@", "12", "12", ""},
+		{"yyyy-mm-dd", "43831", "2020-01-01", ""},
+		{"d-mmm-yy", "43832.75", "2-Jan-20", ""},
+		{"dddd, mmmm d", "43831", "Wednesday, January 1", ""},
+		{"m/d/yy h:mm", "43831.5", "1/1/20 12:00", ""},
+		{"h:mm AM/PM", "0.25", "6:00 AM", ""},
+		{"h:mm:ss a/p", "0.75", "6:00:00 p", ""},
+		{"hh:mm:ss", "0.5000116", "12:00:01", ""},
+		{"mm:ss.00", "0.000011574", "00:01.00", ""},
+		{"[h]:mm:ss", "1.5", "36:00:00", ""},
+		{"[mm]:ss", "0.5", "720:00", ""},
+		{"yyyy-mm-dd", "1", "1900-01-01", ""},
+		{"yyyy-mm-dd", "61", "1900-03-01", ""},
+		{"yyyy-mm-dd", "2958465", "9999-12-31", ""},
+		{"yyyy-mm-dd hh:mm", "2958465.75", "9999-12-31 18:00", ""},
+		{"[h]:mm", "2958465.5", "71003172:00", ""},
+		{"yyyy-mm-dd", "2958466", "#######", ""},
+		{"yyyy-mm-dd", "1e20", "#######", ""},
+		{"[h]:mm", "1e20", "#######", ""},
+		{"yyyy-mm-dd", "-1", "#######", ""},
+		{"[Red]yyyy-mm-dd", "-0.5", "#######", "red"},
+	}
+	for _, c := range testCases {
+		f, err := Parse(c.code)
+		if !assert.NoErrorf(t, err, "case %s", c.code) {
+			continue
+		}
+		text, color := f.Number(decimal.RequireFromString(c.value))
+		assert.Equalf(t, c.text, text, "case %s %s", c.code, c.value)
+		assert.Equalf(t, c.color, color, "color %s %s", c.code, c.value)
+	}
+}
+
+func TestText(t *testing.T) {
+	testCases := []struct {
+		code  string
+		value string
+		text  string
+	}{
+		{"0.00", "abc", "abc"},
+		{"@", "abc", "abc"},
+		{"\"<\"@\">\"", "abc", "<abc>"},
+		{"0;-0;0;[Green]\"text: \"@", "abc", "text: abc"},
+	}
+	for _, c := range testCases {
+		f, err := Parse(c.code)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		text, _ := f.Text(c.value)
+		assert.Equalf(t, c.text, text, "case %s", c.code)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, code := range []string{`"abc`, `0[Red`, `[Foo]0`, `[>abc]0`, `0;0;0;0;0`, `0\`} {
+		_, err := Parse(code)
+		assert.Errorf(t, err, "case %s", code)
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	assert.Equal(t, "#,##0.00", Builtin(4))
+	assert.Equal(t, "", Builtin(100))
+	for id, code := range builtin {
+		_, err := Parse(code)
+		assert.NoErrorf(t, err, "builtin %d", id)
+	}
+}
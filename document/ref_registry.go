@@ -4,29 +4,16 @@ import (
 	"xl/document/eval"
 
 	"bytes"
+	"regexp"
 
 	"github.com/shopspring/decimal"
 )
 
-func (d *Document) AddRef(cell eval.CellReference) {
-	// existing link?
-	for _, r := range d.refRegistry {
-		if r == cell {
-			return
-		}
-	}
-	d.refRegistry = append(d.refRegistry, cell)
-}
-
 func (d *Document) FromAddress(cell eval.CellReference) (string, string, error) {
 	s := d.sheetByIdx(cell.SheetIdx)
 	if s == nil {
 		return "", "", eval.NewError(eval.ErrorKindName, "sheet does not exist")
 	}
-	var sheetTitle string
-	if s != d.CurrentSheet {
-		sheetTitle = s.Title
-	}
 	var buf bytes.Buffer
 	if cell.AnchoredX {
 		buf.WriteString("$")
@@ -36,23 +23,25 @@ func (d *Document) FromAddress(cell eval.CellReference) (string, string, error)
 		buf.WriteString("$")
 	}
 	buf.WriteString(RowName(cell.Y))
-	return sheetTitle, buf.String(), nil
+	return s.Title, buf.String(), nil
+}
+
+func (d *Document) SheetTitle(sheetIdx int) (string, error) {
+	s := d.sheetByIdx(sheetIdx)
+	if s == nil {
+		return "", eval.NewError(eval.ErrorKindName, "sheet does not exist")
+	}
+	return s.Title, nil
 }
 
 func (d *Document) ToAddress(sheetTitle, cellName string) (eval.CellReference, error) {
 	sheetIdx := d.CurrentSheet.Idx
 	if sheetTitle != "" {
-		found := false
-		for i := range d.Sheets {
-			if d.Sheets[i].Title == sheetTitle {
-				sheetIdx = d.Sheets[i].Idx
-				found = true
-				break
-			}
-		}
-		if !found {
+		s := d.SheetByTitle(sheetTitle)
+		if s == nil {
 			return eval.CellReference{}, eval.NewError(eval.ErrorKindRef, "sheet not found")
 		}
+		sheetIdx = s.Idx
 	}
 	x, y, anchoredX, anchoredY, err := CellAxis(cellName)
 	if err != nil {
@@ -85,6 +74,9 @@ func (d *Document) Value(ec *eval.Context, cell eval.CellAddress) (eval.Value, e
 	}
 	l := ec.AddVisited(cell)
 	defer ec.ResetVisited(l)
+	// formula of the cell is evaluated in context of its own sheet
+	prevSheetIdx := ec.SetCurrentSheet(cell.SheetIdx)
+	defer ec.SetCurrentSheet(prevSheetIdx)
 	return c.Value(ec)
 }
 
@@ -102,6 +94,9 @@ func (d *Document) BoolValue(ec *eval.Context, cell eval.CellAddress) (bool, err
 	}
 	l := ec.AddVisited(cell)
 	defer ec.ResetVisited(l)
+	// formula of the cell is evaluated in context of its own sheet
+	prevSheetIdx := ec.SetCurrentSheet(cell.SheetIdx)
+	defer ec.SetCurrentSheet(prevSheetIdx)
 	return c.BoolValue(ec)
 }
 
@@ -119,6 +114,9 @@ func (d *Document) DecimalValue(ec *eval.Context, cell eval.CellAddress) (decima
 	}
 	l := ec.AddVisited(cell)
 	defer ec.ResetVisited(l)
+	// formula of the cell is evaluated in context of its own sheet
+	prevSheetIdx := ec.SetCurrentSheet(cell.SheetIdx)
+	defer ec.SetCurrentSheet(prevSheetIdx)
 	return c.DecimalValue(ec)
 }
 
@@ -136,22 +134,41 @@ func (d *Document) StringValue(ec *eval.Context, cell eval.CellAddress) (string,
 	}
 	l := ec.AddVisited(cell)
 	defer ec.ResetVisited(l)
+	// formula of the cell is evaluated in context of its own sheet
+	prevSheetIdx := ec.SetCurrentSheet(cell.SheetIdx)
+	defer ec.SetCurrentSheet(prevSheetIdx)
 	return c.StringValue(ec)
 }
 
+// iterate calls f for every cell of the range. Range is bounded by the sheet size, so
+// references to whole columns and rows do not go through empty cells beyond the sheet.
+// If corners of the range lay on different sheets, the range is iterated on each sheet
+// between them.
 func (d *Document) iterate(ec *eval.Context, cell, cellTo eval.CellAddress, f func(eval.CellAddress) error) error {
-	if cell.SheetIdx != cellTo.SheetIdx {
-		// cross-sheets ranges are not allowed
-		return eval.NewError(eval.ErrorKindRef, "cross-sheets ranges are not allowed")
-	}
 	if cell.X > cellTo.X || cell.Y > cellTo.Y {
 		return eval.NewError(eval.ErrorKindRef, "invalid range bounds")
 	}
-	for x := cell.X; x <= cellTo.X; x++ {
-		for y := cell.Y; y <= cellTo.Y; y++ {
-			err := f(eval.CellAddress{SheetIdx: cell.SheetIdx, X: x, Y: y})
-			if err != nil {
-				return err
+	first, last := d.sheetPos(cell.SheetIdx), d.sheetPos(cellTo.SheetIdx)
+	if first < 0 || last < 0 {
+		return eval.NewError(eval.ErrorKindName, "sheet does not exist")
+	}
+	if first > last {
+		first, last = last, first
+	}
+	for _, s := range d.Sheets[first : last+1] {
+		maxX, maxY := cellTo.X, cellTo.Y
+		if maxX > s.Size.MaxX() {
+			maxX = s.Size.MaxX()
+		}
+		if maxY > s.Size.MaxY() {
+			maxY = s.Size.MaxY()
+		}
+		for x := cell.X; x <= maxX; x++ {
+			for y := cell.Y; y <= maxY; y++ {
+				err := f(eval.CellAddress{SheetIdx: s.Idx, X: x, Y: y})
+				if err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -188,34 +205,47 @@ func (d *Document) IterateStringValues(ec *eval.Context, cell, cellTo eval.CellA
 	})
 }
 
-func (d *Document) moveRefsRight(n int) {
-	for _, r := range d.refRegistry {
-		if r.SheetIdx == d.CurrentSheet.Idx && r.X >= n {
-			r.X++
-		}
-	}
-}
+// Наибольшая длина цепочки ссылок, которая разрешается при получении значения ячейки.
+const maxRefChain = 1000
 
-func (d *Document) moveRefsLeft(n int) {
-	for _, r := range d.refRegistry {
-		if r.SheetIdx == d.CurrentSheet.Idx && r.X > n {
-			r.X--
-		}
-	}
-}
+// Формулы промежуточных итогов, которые пропускаются по флагу SkipSubtotals.
+var subtotalFormula = regexp.MustCompile(`(?i)^=.*\b(SUBTOTAL|AGGREGATE)\s*\(`)
 
-func (d *Document) moveRefsDown(n int) {
-	for _, r := range d.refRegistry {
-		if r.SheetIdx == d.CurrentSheet.Idx && r.Y >= n {
-			r.Y++
+func (d *Document) IterateValues(ec *eval.Context, cell, cellTo eval.CellAddress, flags int, f func(eval.Value) error) error {
+	return d.iterate(ec, cell, cellTo, func(cell eval.CellAddress) error {
+		s := d.sheetByIdx(cell.SheetIdx)
+		if flags&eval.SkipHidden != 0 && s.IsRowHidden(cell.Y) {
+			return nil
 		}
-	}
+		if flags&(eval.SkipHidden|eval.SkipFiltered) != 0 && s.IsRowFiltered(cell.Y) {
+			return nil
+		}
+		if flags&eval.SkipSubtotals != 0 && subtotalFormula.MatchString(d.CellRawValue(s, cell.X, cell.Y)) {
+			return nil
+		}
+		v, err := d.resolvedValue(ec, cell)
+		if err != nil {
+			if flags&eval.SkipErrors != 0 {
+				return nil
+			}
+			return err
+		}
+		return f(v)
+	})
 }
 
-func (d *Document) moveRefsUp(n int) {
-	for _, r := range d.refRegistry {
-		if r.SheetIdx == d.CurrentSheet.Idx && r.Y > n {
-			r.Y--
+// resolvedValue returns the value of the cell following references, so the value is
+// either empty or one of the static types.
+func (d *Document) resolvedValue(ec *eval.Context, cell eval.CellAddress) (eval.Value, error) {
+	v, err := ec.DataProvider.Value(ec, cell)
+	for i := 0; err == nil && v.Type() == eval.TypeRef; i++ {
+		if i == maxRefChain {
+			return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindRef, "circular reference")
 		}
+		v, err = ec.DataProvider.Value(ec, v.Cell().CellAddress)
+	}
+	if err == nil && v.Type() == eval.TypeRangeRef {
+		return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindCasting, "unable to use range as value")
 	}
+	return v, err
 }
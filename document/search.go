@@ -0,0 +1,205 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/sheet"
+
+	"math"
+	"regexp"
+)
+
+// Поиск и замена. Искать можно по отображаемым значениям ячеек или по исходным
+// значениям (формулам), регулярным выражением или строкой, в пределах листа, выделения
+// или всего документа. Поиск продолжается с начала области, когда достигнут ее конец.
+// Замена работает с исходными значениями ячеек.
+
+// Области поиска.
+const (
+	SearchSheet = iota
+	SearchSelection
+	SearchDocument
+)
+
+// Ответы на запрос подтверждения замены.
+const (
+	ReplaceYes = iota
+	ReplaceNo
+	ReplaceAll
+	ReplaceQuit
+)
+
+// SearchOptions define what to search for and where.
+type SearchOptions struct {
+	Pattern string
+	// Regexp makes the pattern a regular expression instead of plain text.
+	Regexp     bool
+	IgnoreCase bool
+	// Formulas makes the search look into raw values of cells instead of displayed ones.
+	Formulas bool
+	Scope    int
+	// Rect limits the search on the sheet the search starts from when Scope is SearchSelection.
+	Rect     sheet.Rect
+	Backward bool
+}
+
+// compile returns the regular expression for the pattern.
+func (o SearchOptions) compile() (*regexp.Regexp, error) {
+	p := o.Pattern
+	if !o.Regexp {
+		p = regexp.QuoteMeta(p)
+	}
+	if o.IgnoreCase {
+		p = "(?i)" + p
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, eval.NewError(eval.ErrorKindFormula, "invalid pattern: %s", err)
+	}
+	return re, nil
+}
+
+// Search finds the cell matching the options next to the cell X, Y of the sheet,
+// continuing from the beginning of the scope (or the end for backward search) when
+// the end is reached. Cells of hidden rows and columns are skipped. Returns nil sheet
+// if nothing is found.
+func (d *Document) Search(s *sheet.Sheet, x, y int, opts SearchOptions) (*sheet.Sheet, int, int, error) {
+	re, err := opts.compile()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	sheets := []*sheet.Sheet{s}
+	if opts.Scope == SearchDocument {
+		sheets = d.sheetsFrom(s, opts.Backward)
+	}
+	// the sheet of the start is searched once more from its edge up to the start
+	sheets = append(sheets, s)
+	for i, ss := range sheets {
+		fromX, fromY := x, y
+		if i > 0 {
+			fromX, fromY = searchEdge(opts.Backward)
+		}
+		match := func(x, y int, c *sheet.Cell) bool {
+			return ss.IsRowVisible(y) && !ss.IsColHidden(x) && re.MatchString(d.searchText(ss, x, y, c, opts.Formulas))
+		}
+		if fx, fy, ok := ss.NextCell(fromX, fromY, opts.Backward, d.searchRect(ss, s, opts), match); ok {
+			return ss, fx, fy, nil
+		}
+	}
+	return nil, 0, 0, nil
+}
+
+// Replace replaces matches of the options pattern in raw values of the cells of the scope
+// with repl, all matches of a cell or only the first one. For regular expressions $1 or
+// ${name} in repl stand for submatches. When the search is done over displayed values,
+// formulas are left as is. Confirm is asked before every replacement unless it is nil.
+// Returns the number of changed cells.
+func (d *Document) Replace(s *sheet.Sheet, opts SearchOptions, repl string, all bool, confirm func(s *sheet.Sheet, x, y int) int) (int, error) {
+	re, err := opts.compile()
+	if err != nil {
+		return 0, err
+	}
+	d.BeginGroup()
+	defer d.EndGroup()
+	opts.Backward = false
+	sheets := []*sheet.Sheet{s}
+	if opts.Scope == SearchDocument {
+		sheets = d.Sheets
+	}
+	n := 0
+	for _, ss := range sheets {
+		match := func(x, y int, c *sheet.Cell) bool {
+			if !opts.Formulas && sheet.IsFormula(d.CellRawValue(ss, x, y)) {
+				return false
+			}
+			return re.MatchString(d.searchText(ss, x, y, c, opts.Formulas))
+		}
+		x, y := searchEdge(false)
+		for {
+			var ok bool
+			x, y, ok = ss.NextCell(x, y, false, d.searchRect(ss, s, opts), match)
+			if !ok {
+				break
+			}
+			if confirm != nil {
+				switch confirm(ss, x, y) {
+				case ReplaceNo:
+					continue
+				case ReplaceAll:
+					confirm = nil
+				case ReplaceQuit:
+					return n, nil
+				}
+			}
+			raw := d.CellRawValue(ss, x, y)
+			value := replaceMatches(re, raw, repl, all, opts.Regexp)
+			if value != raw {
+				d.SetCellValue(ss, x, y, value)
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+// replaceMatches replaces the first or all matches of the expression in the text.
+func replaceMatches(re *regexp.Regexp, text, repl string, all, expand bool) string {
+	if all {
+		if expand {
+			return re.ReplaceAllString(text, repl)
+		}
+		return re.ReplaceAllLiteralString(text, repl)
+	}
+	m := re.FindStringSubmatchIndex(text)
+	if m == nil {
+		return text
+	}
+	r := repl
+	if expand {
+		r = string(re.ExpandString(nil, repl, text, m))
+	}
+	return text[:m[0]] + r + text[m[1]:]
+}
+
+// searchText returns the text of the cell the pattern is matched against.
+func (d *Document) searchText(s *sheet.Sheet, x, y int, c *sheet.Cell, formulas bool) string {
+	if formulas {
+		return d.CellRawValue(s, x, y)
+	}
+	v, err := c.StringValue(eval.NewContext(d, s.Idx))
+	if err != nil {
+		return err.Error()
+	}
+	return v
+}
+
+// searchRect returns the part of the sheet to search in. Selection limits the search
+// only on the sheet the search starts from.
+func (d *Document) searchRect(s, start *sheet.Sheet, opts SearchOptions) sheet.Rect {
+	if opts.Scope == SearchSelection && s == start {
+		return opts.Rect
+	}
+	return sheet.Rect{X: 0, Y: 0, Width: s.Size.MaxX() + 1, Height: s.Size.MaxY() + 1}
+}
+
+// sheetsFrom returns all the sheets of the document in order starting with the one
+// following the sheet, or preceding it if backward is set.
+func (d *Document) sheetsFrom(s *sheet.Sheet, backward bool) []*sheet.Sheet {
+	pos := d.sheetPos(s.Idx)
+	sheets := []*sheet.Sheet{s}
+	for i := 1; i < len(d.Sheets); i++ {
+		j := pos + i
+		if backward {
+			j = pos - i + len(d.Sheets)
+		}
+		sheets = append(sheets, d.Sheets[j%len(d.Sheets)])
+	}
+	return sheets
+}
+
+// searchEdge returns the position before the first cell of a sheet in the search order.
+func searchEdge(backward bool) (int, int) {
+	if backward {
+		return math.MaxInt32, math.MaxInt32
+	}
+	return -1, -1
+}
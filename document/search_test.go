@@ -0,0 +1,170 @@
+package document
+
+import (
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSearchTestDoc() *Document {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	d.SetCellValue(s, 0, 0, "apple")
+	d.SetCellValue(s, 2, 0, "Pear")
+	d.SetCellValue(s, 1, 1, "pineapple")
+	d.SetCellValue(s, 0, 2, "=B2")
+	d.SetCellValue(s, 1, 3, "10")
+	return d
+}
+
+func TestSearch(t *testing.T) {
+	d := newSearchTestDoc()
+	s := d.CurrentSheet
+	tests := []struct {
+		x, y   int
+		opts   SearchOptions
+		sx, sy int
+	}{
+		{0, 0, SearchOptions{Pattern: "apple"}, 1, 1},
+		{1, 1, SearchOptions{Pattern: "apple"}, 0, 2},
+		// search continues from the beginning
+		{0, 2, SearchOptions{Pattern: "apple"}, 0, 0},
+		{1, 1, SearchOptions{Pattern: "apple", Backward: true}, 0, 0},
+		{0, 0, SearchOptions{Pattern: "apple", Backward: true}, 0, 2},
+		{0, 0, SearchOptions{Pattern: "pear"}, -1, -1},
+		{0, 0, SearchOptions{Pattern: "pear", IgnoreCase: true}, 2, 0},
+		{0, 0, SearchOptions{Pattern: "^p.*e$", Regexp: true}, 1, 1},
+		{0, 0, SearchOptions{Pattern: "^p.*e$"}, -1, -1},
+		{0, 0, SearchOptions{Pattern: "B2", Formulas: true}, 0, 2},
+		{0, 0, SearchOptions{Pattern: "B2"}, -1, -1},
+		{0, 0, SearchOptions{Pattern: "a", Scope: SearchSelection, Rect: sheet.Rect{X: 1, Y: 0, Width: 2, Height: 4}}, 2, 0},
+		{2, 0, SearchOptions{Pattern: "a", Scope: SearchSelection, Rect: sheet.Rect{X: 1, Y: 0, Width: 2, Height: 4}}, 1, 1},
+		{1, 1, SearchOptions{Pattern: "a", Scope: SearchSelection, Rect: sheet.Rect{X: 1, Y: 0, Width: 2, Height: 4}}, 2, 0},
+	}
+	for _, test := range tests {
+		found, x, y, err := d.Search(s, test.x, test.y, test.opts)
+		if !assert.NoError(t, err, test.opts.Pattern) {
+			continue
+		}
+		if test.sx < 0 {
+			assert.Nil(t, found, test.opts.Pattern)
+			continue
+		}
+		assert.Equal(t, s, found, test.opts.Pattern)
+		assert.Equal(t, []int{test.sx, test.sy}, []int{x, y}, test.opts.Pattern)
+	}
+
+	_, _, _, err := d.Search(s, 0, 0, SearchOptions{Pattern: "(", Regexp: true})
+	assert.Error(t, err)
+
+	d.HideRows(s, 1, 1, true)
+	_, x, y, _ := d.Search(s, 0, 0, SearchOptions{Pattern: "apple"})
+	assert.Equal(t, []int{0, 2}, []int{x, y})
+}
+
+func TestSearchDocument(t *testing.T) {
+	d := newSearchTestDoc()
+	s1 := d.CurrentSheet
+	s2, _ := d.NewSheet("second")
+	s3, _ := d.NewSheet("third")
+	d.SetCellValue(s2, 3, 3, "apple tree")
+	d.SetCellValue(s3, 0, 0, "apple pie")
+
+	opts := SearchOptions{Pattern: "apple", Scope: SearchDocument}
+	found, x, y, _ := d.Search(s1, 0, 2, opts)
+	assert.Equal(t, s2, found)
+	assert.Equal(t, []int{3, 3}, []int{x, y})
+	found, _, _, _ = d.Search(s3, 0, 0, opts)
+	assert.Equal(t, s1, found)
+
+	opts.Backward = true
+	found, x, y, _ = d.Search(s1, 0, 0, opts)
+	assert.Equal(t, s3, found)
+	assert.Equal(t, []int{0, 0}, []int{x, y})
+}
+
+func TestSearchXSegment(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	for y := 0; y < 1000; y++ {
+		d.SetCellValue(s, 0, y, "1")
+	}
+	d.SetCellValue(s, 0, 500, "7")
+	d.SetCellValue(s, 1, 0, "=A1*2")
+	d.Fill(s, sheet.Rect{X: 1, Y: 0, Width: 1, Height: 1000}, FillDown)
+	segments := len(s.Segments)
+
+	found, x, y, _ := d.Search(s, 1, 0, SearchOptions{Pattern: "14"})
+	assert.NotNil(t, found)
+	assert.Equal(t, []int{1, 500}, []int{x, y})
+	_, x, y, _ = d.Search(s, 0, 0, SearchOptions{Pattern: "A777", Formulas: true})
+	assert.Equal(t, []int{1, 776}, []int{x, y})
+	assert.Equal(t, segments, len(s.Segments))
+}
+
+func TestSearchCoveredCells(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	d.SetCellValue(s, 0, 0, "=B1+1")
+	d.SetCellValue(s, 0, 2, "stale")
+	d.Fill(s, sheet.Rect{X: 0, Y: 0, Width: 1, Height: 3}, FillDown)
+	d.SetCellValue(s, 0, 3, "stale")
+
+	// the value lying under the extrapolation segment is not shown, so it is not found
+	found, x, y, _ := d.Search(s, 0, 0, SearchOptions{Pattern: "stale"})
+	assert.NotNil(t, found)
+	assert.Equal(t, []int{0, 3}, []int{x, y})
+}
+
+func TestReplace(t *testing.T) {
+	d := newSearchTestDoc()
+	s := d.CurrentSheet
+	n, err := d.Replace(s, SearchOptions{Pattern: "p"}, "P", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "aPple", d.CellRawValue(s, 0, 0))
+	assert.Equal(t, "Pineapple", d.CellRawValue(s, 1, 1))
+	// formulas are not changed when searching in displayed values
+	assert.Equal(t, "=B2", d.CellRawValue(s, 0, 2))
+
+	n, _ = d.Replace(s, SearchOptions{Pattern: "(p+)", Regexp: true, IgnoreCase: true}, "[$1]", true, nil)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "a[Pp]le", d.CellRawValue(s, 0, 0))
+	assert.Equal(t, "[P]inea[pp]le", d.CellRawValue(s, 1, 1))
+
+	n, _ = d.Replace(s, SearchOptions{Pattern: "B2", Formulas: true}, "B4", true, nil)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "10", cellValue(t, d, 0, 2))
+
+	// the whole replacement is undone at once
+	assert.True(t, d.Undo())
+	assert.True(t, d.Undo())
+	assert.Equal(t, "aPple", d.CellRawValue(s, 0, 0))
+}
+
+func TestReplaceConfirm(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	for y := 0; y < 5; y++ {
+		d.SetCellValue(s, 0, y, "x")
+	}
+	answers := []int{ReplaceYes, ReplaceNo, ReplaceYes, ReplaceQuit}
+	var asked [][]int
+	n, _ := d.Replace(s, SearchOptions{Pattern: "x"}, "y", true, func(_ *sheet.Sheet, x, y int) int {
+		asked = append(asked, []int{x, y})
+		a := answers[0]
+		answers = answers[1:]
+		return a
+	})
+	assert.Equal(t, 2, n)
+	assert.Equal(t, [][]int{{0, 0}, {0, 1}, {0, 2}, {0, 3}}, asked)
+	assert.Equal(t, "x", d.CellRawValue(s, 0, 1))
+	assert.Equal(t, "x", d.CellRawValue(s, 0, 4))
+
+	n, _ = d.Replace(s, SearchOptions{Pattern: "x"}, "y", true, func(_ *sheet.Sheet, x, y int) int {
+		return ReplaceAll
+	})
+	assert.Equal(t, 3, n)
+}
@@ -4,6 +4,7 @@ import (
 	"strconv"
 
 	"xl/document/eval"
+	"xl/document/numfmt"
 	"xl/formula"
 
 	"github.com/shopspring/decimal"
@@ -41,6 +42,8 @@ type Cell struct {
 	rawValue string
 	// Внутренняя структура ячейки. Разная для разных типов.
 	v interface{}
+	// Числовой формат, с которым выводится значение, или nil, если значение выводится как есть.
+	format *numfmt.Format
 }
 
 type untypedCell struct{}
@@ -94,17 +97,172 @@ func NewCellAsCopyWithOffset(sourceCell *Cell, offsetX, offsetY int) *Cell {
 				offsetX:      offsetX,
 				offsetY:      offsetY,
 			},
+			format: sourceCell.format,
 		}
 	} else {
 		return sourceCell
 	}
 }
 
+// OffsetRawValue returns raw value of the formula with relative references moved by the offset,
+// as if the formula were copied to the cell that far from the original one.
+// References which move out of the sheet become #REF!. Values other than formulas are returned as is.
+func OffsetRawValue(ec *eval.Context, rawValue string, offsetX, offsetY int) string {
+	c := NewCellUntyped(rawValue)
+	if err := c.evaluateType(ec); err != nil {
+		return rawValue
+	}
+	v, ok := c.v.(formulaCell)
+	if !ok {
+		return rawValue
+	}
+	refs := make([]ref, len(v.Refs))
+	for i, r := range v.Refs {
+		refs[i] = r
+		if r.Invalid {
+			continue
+		}
+		refs[i].Cell = applyOffsetToRef(r.Cell, offsetX, offsetY)
+		if r.CellTo != nil {
+			cellTo := applyOffsetToRef(*r.CellTo, offsetX, offsetY)
+			refs[i].CellTo = &cellTo
+			refs[i].Invalid = !refInSheet(cellTo)
+		}
+		refs[i].Invalid = refs[i].Invalid || !refInSheet(refs[i].Cell)
+	}
+	if err := updateVars(ec, v.Expression, refs, 0, 0); err != nil {
+		return rawValue
+	}
+	return v.Expression.String()
+}
+
+// Корректирует Ссылки формулы, записанной текстом, после вставки или удаления строки
+// или колонки (см. adjustRefs). Возвращает новый текст формулы, если Ссылки изменились.
+func adjustFormula(ec *eval.Context, formula string, change, sheetIdx, n int) (string, bool) {
+	c := NewCellUntyped(formula)
+	if _, ok := c.adjustRefs(ec, change, sheetIdx, n); !ok {
+		return formula, false
+	}
+	return c.rawValue, true
+}
+
+// Корректирует Ссылки формулы правила с прямоугольником rect (см. adjustFormula).
+// Относительные Ссылки формулы заданы для левой верхней ячейки прямоугольника, поэтому
+// при удалении его первой строки или колонки формула переносится на следующую.
+func adjustRuleFormula(ec *eval.Context, formula string, rect Rect, change, sheetIdx, n int) (string, bool) {
+	anchored := formula
+	if ec.CurrentSheetIdx == sheetIdx {
+		switch {
+		case change == ChangeDeleteRow && rect.Y == n && rect.Height > 1:
+			anchored = OffsetRawValue(ec, formula, 0, 1)
+		case change == ChangeDeleteCol && rect.X == n && rect.Width > 1:
+			anchored = OffsetRawValue(ec, formula, 1, 0)
+		}
+	}
+	f, _ := adjustFormula(ec, anchored, change, sheetIdx, n)
+	return f, f != formula
+}
+
+// Применяет изменение change к Ссылкам формулы, записанной текстом. Возвращает новый текст
+// формулы, если Ссылки изменились.
+func changeFormula(ec *eval.Context, formula string, change func(r *ref) bool) (string, bool) {
+	c := NewCellUntyped(formula)
+	if _, ok := c.changeRefs(ec, change); !ok {
+		return formula, false
+	}
+	return c.rawValue, true
+}
+
+// Заменяет в формуле, записанной текстом, название листа renamed на title. Формула
+// разбирается со старым названием листа, а выводится с новым.
+func renameFormula(ec *eval.Context, formula string, renamed *Sheet, title string) (string, bool) {
+	c := NewCellUntyped(formula)
+	if !c.parseFormula(ec) {
+		return formula, false
+	}
+	before := renamed.Title
+	renamed.Title = title
+	defer func() {
+		renamed.Title = before
+	}()
+	if _, ok := c.changeRefs(ec, func(r *ref) bool {
+		return r.onSheet(renamed.Idx)
+	}); !ok {
+		return formula, false
+	}
+	return c.rawValue, true
+}
+
+// Делает копию ячейки, которая не разделяет с оригиналом Ссылки формулы.
+func (c *Cell) clone() Cell {
+	if v, ok := c.v.(formulaCell); ok {
+		v.Refs = copyRefs(v.Refs)
+		return Cell{rawValue: c.rawValue, v: v}
+	}
+	return *c
+}
+
+// IsFormula reports whether the raw value is a formula.
+func IsFormula(rawValue string) bool {
+	t, _ := guessCellType(rawValue)
+	return t == cellValueTypeFormula
+}
+
 // RawValue returns raw cell value as string. No evaluation performed.
 func (c *Cell) RawValue() string {
 	return c.rawValue
 }
 
+// IsEmpty reports whether the cell has no value.
+// Copies of formula cell have no raw value, but they are not empty.
+func (c *Cell) IsEmpty() bool {
+	if _, ok := c.v.(formulaCell); ok {
+		return false
+	}
+	return c.rawValue == ""
+}
+
+// Format returns the number format of the cell, or nil if the value is shown as is.
+func (c *Cell) Format() *numfmt.Format {
+	return c.format
+}
+
+// SetFormat sets the number format of the cell, nil makes the value be shown as is.
+func (c *Cell) SetFormat(f *numfmt.Format) {
+	c.format = f
+}
+
+// Значение ячейки в том виде, в котором оно выводится.
+type Display struct {
+	Text string
+	// Цвет, заданный числовым форматом, например "red", или пустая строка.
+	Color string
+	// Значение является числом, по умолчанию числа выравниваются вправо.
+	Number bool
+}
+
+// DisplayValue returns the value of the cell formatted with its number format and the color
+// the format gives to the value, if any. Values which look like numbers are formatted
+// as numbers, the others as text.
+func (c *Cell) DisplayValue(ec *eval.Context) (Display, error) {
+	v, err := c.StringValue(ec)
+	if err != nil {
+		return Display{Text: v}, err
+	}
+	d, err := decimal.NewFromString(v)
+	number := err == nil
+	if c.format == nil {
+		return Display{Text: v, Number: number}, nil
+	}
+	var text, color string
+	if number {
+		text, color = c.format.Number(d)
+	} else {
+		text, color = c.format.Text(v)
+	}
+	return Display{Text: text, Color: color, Number: number}, nil
+}
+
 // Возвращает выражение, построееное по формуле.
 // Если в формуле есть Переменные, то они обновляются по актуальным значениям Ссылок.
 func (c *Cell) Expression(ec *eval.Context) *formula.Expression {
@@ -144,7 +302,11 @@ func (c *Cell) BoolValue(ec *eval.Context) (bool, error) {
 	case decimalCell:
 		return !v.Value.Equal(decimal.Zero), nil
 	case formulaCell:
-		val, err := v.FormulaValue(ec, refsToValues(v.Refs, v.offsetX, v.offsetY))
+		values, err := refsToValues(v.Refs, v.offsetX, v.offsetY)
+		if err != nil {
+			return false, err
+		}
+		val, err := v.FormulaValue(ec, values)
 		if err != nil {
 			return false, err
 		}
@@ -174,7 +336,11 @@ func (c *Cell) DecimalValue(ec *eval.Context) (decimal.Decimal, error) {
 	case decimalCell:
 		return v.Value, nil
 	case formulaCell:
-		val, err := v.FormulaValue(ec, refsToValues(v.Refs, v.offsetX, v.offsetY))
+		values, err := refsToValues(v.Refs, v.offsetX, v.offsetY)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		val, err := v.FormulaValue(ec, values)
 		if err != nil {
 			return decimal.Zero, err
 		}
@@ -204,7 +370,11 @@ func (c *Cell) StringValue(ec *eval.Context) (string, error) {
 	case decimalCell:
 		return c.rawValue, nil
 	case formulaCell:
-		val, err := v.FormulaValue(ec, refsToValues(v.Refs, v.offsetX, v.offsetY))
+		values, err := refsToValues(v.Refs, v.offsetX, v.offsetY)
+		if err != nil {
+			return "", err
+		}
+		val, err := v.FormulaValue(ec, values)
 		if err != nil {
 			return "", err
 		}
@@ -234,14 +404,17 @@ func (c *Cell) Value(ec *eval.Context) (eval.Value, error) {
 	case decimalCell:
 		return eval.NewDecimalValue(v.Value), nil
 	case formulaCell:
-		return v.FormulaValue(ec, refsToValues(v.Refs, v.offsetX, v.offsetY))
+		values, err := refsToValues(v.Refs, v.offsetX, v.offsetY)
+		if err != nil {
+			return eval.NewEmptyValue(), err
+		}
+		return v.FormulaValue(ec, values)
 	default:
 		panic("unsupported type")
 	}
 }
 
 // Сбрасывает значение ячейки на пустое.
-// FIXME: оставляет осиротевшие Ссылки в refRegistry.
 func (c *Cell) SetValueEmpty() {
 	c.rawValue = ""
 	c.v = nil
@@ -254,6 +427,78 @@ func (c *Cell) SetValueUntyped(v string) {
 	c.v = untypedCell{}
 }
 
+// Парсит формулу, которая еще не была распарсена. Сообщает, является ли значение ячейки
+// правильной формулой.
+func (c *Cell) parseFormula(ec *eval.Context) bool {
+	if _, ok := c.v.(untypedCell); ok {
+		if t, _ := guessCellType(c.rawValue); t != cellValueTypeFormula {
+			return false
+		}
+		if err := c.evaluateType(ec); err != nil {
+			// broken formula can not have correct references
+			return false
+		}
+	}
+	_, ok := c.v.(formulaCell)
+	return ok
+}
+
+// Корректирует Ссылки формулы после изменения структуры листа и обновляет сырое значение,
+// чтобы оно соответствовало новым Ссылкам. Формула, которая еще не была распарсена,
+// предварительно парсится. Если Ссылки изменились, возвращает их прежнее состояние.
+func (c *Cell) adjustRefs(ec *eval.Context, change, sheetIdx, n int) (RefsState, bool) {
+	return c.changeRefs(ec, func(r *ref) bool {
+		return r.adjust(ec, change, sheetIdx, n)
+	})
+}
+
+// Перемещает Ссылки формулы вслед за строками, переставленными внутри прямоугольника
+// листа sheetIdx (см. ref.move). Если Ссылки изменились, возвращает их прежнее состояние.
+func (c *Cell) moveRefs(ec *eval.Context, sheetIdx int, rect Rect, rows map[int]int) (RefsState, bool) {
+	return c.changeRefs(ec, func(r *ref) bool {
+		return r.move(sheetIdx, rect, rows)
+	})
+}
+
+// Применяет изменение change к каждой Ссылке формулы и обновляет сырое значение.
+// Если хотя бы одна Ссылка изменилась, возвращает прежнее состояние Ссылок.
+func (c *Cell) changeRefs(ec *eval.Context, change func(r *ref) bool) (RefsState, bool) {
+	if !c.parseFormula(ec) {
+		return RefsState{}, false
+	}
+	v, ok := c.v.(formulaCell)
+	if !ok {
+		return RefsState{}, false
+	}
+	state := RefsState{
+		rawValue: c.rawValue,
+		refs:     copyRefs(v.Refs),
+	}
+	changed := false
+	for i := range v.Refs {
+		if change(&v.Refs[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return RefsState{}, false
+	}
+	if err := updateVars(ec, v.Expression, v.Refs, v.offsetX, v.offsetY); err == nil {
+		c.rawValue = v.Expression.String()
+	}
+	return state, true
+}
+
+// Восстанавливает Ссылки формулы и сырое значение из сохраненного состояния.
+func (c *Cell) restoreRefs(state RefsState) {
+	v, ok := c.v.(formulaCell)
+	if !ok || len(v.Refs) != len(state.refs) {
+		return
+	}
+	copy(v.Refs, copyRefs(state.refs))
+	c.rawValue = state.rawValue
+}
+
 // Вычисляет тип ячейки на осное ее сырого значение и крнвертирует внутреннюю структуру в нужный тип.
 func (c *Cell) evaluateType(ec *eval.Context) error {
 	t, castedV := guessCellType(c.rawValue)
@@ -0,0 +1,104 @@
+package sheet
+
+import (
+	"xl/document/eval"
+)
+
+// Условное форматирование. Правило привязано к прямоугольнику и задает оформление,
+// которое получают ячейки прямоугольника, удовлетворяющие правилу. Как и автофильтр,
+// правила сами себя не проверяют: значения ячеек вычисляет документ.
+
+// Виды правил условного форматирования.
+const (
+	// Значение ячейки удовлетворяет сравнению Op с Operand (как в условии автофильтра).
+	CondValue = iota
+	// Формула в Operand истинна. Формула записана для левой верхней ячейки прямоугольника,
+	// для остальных ячеек относительные ссылки сдвигаются, как при копировании.
+	CondFormula
+	// Число ячейки входит в N наибольших (или наименьших) чисел прямоугольника.
+	CondTop
+	CondBottom
+	// Цвет фона меняется от MinColor у наименьшего числа прямоугольника до MaxColor
+	// у наибольшего, Style не используется.
+	CondScale
+)
+
+type CondRule struct {
+	Kind    int
+	Rect    Rect
+	Op      string
+	Operand string
+	N       int
+
+	MinColor string
+	MaxColor string
+
+	Style Style
+}
+
+// CondRules returns the copy of the conditional formatting rules of the sheet
+// in order of their priority.
+func (s *Sheet) CondRules() []CondRule {
+	return append([]CondRule(nil), s.condRules...)
+}
+
+// SetCondRules replaces the conditional formatting rules of the sheet.
+func (s *Sheet) SetCondRules(rules []CondRule) {
+	s.condRules = append([]CondRule(nil), rules...)
+}
+
+// AdjustCondRefs corrects references in the formulas of the rules after a line
+// of the sheet with sheetIdx is inserted or deleted, like AdjustRefs does for cells.
+// The formula of the rule which loses its first line is moved to the new top left cell.
+// Reports whether any formula has changed.
+func (s *Sheet) AdjustCondRefs(ec *eval.Context, change, sheetIdx, n int) bool {
+	return s.changeCondFormulas(func(f string, rect Rect) (string, bool) {
+		return adjustRuleFormula(ec, f, rect, change, sheetIdx, n)
+	})
+}
+
+// RenameCondRefs updates formulas of the rules referring to the renamed sheet, so they show
+// the new title. It must be called before the sheet gets the title. Reports whether any
+// formula has changed.
+func (s *Sheet) RenameCondRefs(ec *eval.Context, renamed *Sheet, title string) bool {
+	return s.changeCondFormulas(func(f string, rect Rect) (string, bool) {
+		return renameFormula(ec, f, renamed, title)
+	})
+}
+
+// InvalidateCondRefs makes references in the formulas of the rules to the sheet with
+// sheetIdx invalid before the sheet is deleted. Reports whether any formula has changed.
+func (s *Sheet) InvalidateCondRefs(ec *eval.Context, sheetIdx int) bool {
+	return s.changeCondFormulas(func(f string, rect Rect) (string, bool) {
+		return changeFormula(ec, f, invalidateOn(sheetIdx))
+	})
+}
+
+// Заменяет формулы правил результатом change, который получает формулу и прямоугольник
+// правила. Сообщает, изменилась ли хотя бы одна формула.
+func (s *Sheet) changeCondFormulas(change func(f string, rect Rect) (string, bool)) bool {
+	changed := false
+	for i := range s.condRules {
+		r := &s.condRules[i]
+		if r.Kind != CondFormula {
+			continue
+		}
+		if f, ok := change(r.Operand, r.Rect); ok {
+			r.Operand = f
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Сдвигает прямоугольники правил при вставке (delta = 1) или удалении (delta = -1)
+// строки или колонки N. Правила, от прямоугольников которых ничего не осталось, удаляются.
+func (s *Sheet) shiftCondRules(cols bool, n, delta int) {
+	var rules []CondRule
+	for _, r := range s.condRules {
+		if shiftRect(&r.Rect, cols, n, delta) {
+			rules = append(rules, r)
+		}
+	}
+	s.condRules = rules
+}
@@ -0,0 +1,184 @@
+package sheet
+
+// Автофильтр. Первая строка прямоугольника фильтра - заголовок, остальные - данные.
+// Для колонок задаются условия, и строки данных, значения которых не удовлетворяют
+// условиям хотя бы одной колонки, скрываются. Скрытые фильтром строки учитываются отдельно
+// от скрытых пользователем, так как функции SUBTOTAL и AGGREGATE различают их.
+//
+// Фильтр не применяется сам при изменении значений: проверкой строк занимается документ,
+// который умеет вычислять значения ячеек.
+
+type AutoFilter struct {
+	// Прямоугольник фильтра вместе со строкой заголовка.
+	Rect Rect
+	// Условия по колонкам листа.
+	Conditions map[int]FilterCondition
+}
+
+// Условие фильтра для колонки. Значение должно быть одним из Values, если они заданы,
+// и удовлетворять сравнению Op с Operand, если оно задано.
+type FilterCondition struct {
+	Values []string
+	// Сравнение: = <> < <= > >= contains !contains begins ends.
+	Op      string
+	Operand string
+}
+
+// NewAutoFilter creates the filter over the rect with the header in its first row.
+func NewAutoFilter(r Rect) *AutoFilter {
+	return &AutoFilter{
+		Rect:       r,
+		Conditions: make(map[int]FilterCondition),
+	}
+}
+
+// Copy returns the copy of the filter which does not share conditions with the original.
+func (f *AutoFilter) Copy() *AutoFilter {
+	if f == nil {
+		return nil
+	}
+	c := NewAutoFilter(f.Rect)
+	for col, cond := range f.Conditions {
+		cond.Values = append([]string(nil), cond.Values...)
+		c.Conditions[col] = cond
+	}
+	return c
+}
+
+// DataRows returns the rect of the filter without the header.
+func (f *AutoFilter) DataRows() Rect {
+	r := f.Rect
+	r.Y, r.Height = r.Y+1, r.Height-1
+	return r
+}
+
+// Сдвигает или расширяет фильтр после вставки строки.
+func (f *AutoFilter) insertRow(y int) {
+	if f == nil {
+		return
+	}
+	switch {
+	case y <= f.Rect.Y:
+		f.Rect.Y++
+	case y <= f.Rect.MaxY()+1:
+		f.Rect.Height++
+	}
+}
+
+// Сдвигает или сужает фильтр после удаления строки. Возвращает true, если удален заголовок.
+func (f *AutoFilter) deleteRow(y int) bool {
+	if f == nil {
+		return false
+	}
+	switch {
+	case y == f.Rect.Y:
+		return true
+	case y < f.Rect.Y:
+		f.Rect.Y--
+	case y <= f.Rect.MaxY():
+		f.Rect.Height--
+	}
+	return false
+}
+
+// Сдвигает или расширяет фильтр после вставки колонки.
+func (f *AutoFilter) insertCol(x int) {
+	if f == nil {
+		return
+	}
+	switch {
+	case x <= f.Rect.X:
+		f.Rect.X++
+	case x <= f.Rect.MaxX():
+		f.Rect.Width++
+	default:
+		return
+	}
+	f.shiftConditions(x, 1)
+}
+
+// Сдвигает или сужает фильтр после удаления колонки. Возвращает true, если удалены
+// все колонки фильтра.
+func (f *AutoFilter) deleteCol(x int) bool {
+	if f == nil {
+		return false
+	}
+	switch {
+	case x < f.Rect.X:
+		f.Rect.X--
+	case x <= f.Rect.MaxX():
+		f.Rect.Width--
+		if f.Rect.Width == 0 {
+			return true
+		}
+	default:
+		return false
+	}
+	f.shiftConditions(x, -1)
+	return false
+}
+
+// Сдвигает условия колонок после вставки (delta 1) или удаления (delta -1) колонки N.
+func (f *AutoFilter) shiftConditions(n, delta int) {
+	conditions := make(map[int]FilterCondition, len(f.Conditions))
+	for col, cond := range f.Conditions {
+		switch {
+		case col < n:
+			conditions[col] = cond
+		case col == n && delta < 0:
+		default:
+			conditions[col+delta] = cond
+		}
+	}
+	f.Conditions = conditions
+}
+
+// Состояние видимости строк и колонок листа вместе с автофильтром и закрепленными
+// областями. Сохраняется, чтобы изменение видимости можно было отменить.
+type Visibility struct {
+	hiddenRows   map[int]bool
+	hiddenCols   map[int]bool
+	filteredRows map[int]bool
+	filter       *AutoFilter
+	frozenRows   int
+	frozenCols   int
+}
+
+// Visibility returns the copy of hidden lines, the autofilter and frozen panes of the sheet.
+func (s *Sheet) Visibility() Visibility {
+	return Visibility{
+		hiddenRows:   copyLines(s.hiddenRows),
+		hiddenCols:   copyLines(s.hiddenCols),
+		filteredRows: copyLines(s.filteredRows),
+		filter:       s.Filter.Copy(),
+		frozenRows:   s.FrozenRows,
+		frozenCols:   s.FrozenCols,
+	}
+}
+
+// SetVisibility brings hidden lines, the autofilter and frozen panes back to the saved state.
+func (s *Sheet) SetVisibility(v Visibility) {
+	s.hiddenRows = copyLines(v.hiddenRows)
+	s.hiddenCols = copyLines(v.hiddenCols)
+	s.filteredRows = copyLines(v.filteredRows)
+	s.Filter = v.filter.Copy()
+	s.FrozenRows, s.FrozenCols = v.frozenRows, v.frozenCols
+}
+
+// ClearFilteredRows shows all the rows hidden by the autofilter.
+func (s *Sheet) ClearFilteredRows() {
+	s.filteredRows = make(map[int]bool)
+}
+
+// FilteredRows returns the number of rows hidden by the autofilter.
+func (s *Sheet) FilteredRows() int {
+	return len(s.filteredRows)
+}
+
+func copyLines(lines map[int]bool) map[int]bool {
+	c := make(map[int]bool, len(lines))
+	for l := range lines {
+		c[l] = true
+	}
+	return c
+}
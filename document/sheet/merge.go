@@ -0,0 +1,85 @@
+package sheet
+
+// Объединенные ячейки. Объединение - прямоугольник, который показывается как одна ячейка
+// со значением его левой верхней ячейки. Значения остальных ячеек прямоугольника
+// сохраняются, но не видны. Объединения листа не пересекаются.
+
+// MergeAt returns the merged region the cell belongs to.
+func (s *Sheet) MergeAt(x, y int) (Rect, bool) {
+	for _, m := range s.merges {
+		if m.Contains(x, y) {
+			return m, true
+		}
+	}
+	return Rect{}, false
+}
+
+// Merges returns the copy of the merged regions of the sheet.
+func (s *Sheet) Merges() []Rect {
+	return append([]Rect(nil), s.merges...)
+}
+
+// SetMerges replaces the merged regions of the sheet.
+func (s *Sheet) SetMerges(merges []Rect) {
+	s.merges = append([]Rect(nil), merges...)
+}
+
+// Merge merges the cells of rect r. The regions r intersects are merged into it,
+// so r grows to cover them entirely. Returns the merged region.
+func (s *Sheet) Merge(r Rect) Rect {
+	for grown := true; grown; {
+		grown = false
+		for _, m := range s.merges {
+			if m.Intersect(r).Width > 0 && m.Intersect(r) != m {
+				r = boundingRect(r, m)
+				grown = true
+			}
+		}
+	}
+	s.Unmerge(r)
+	if r.Width > 1 || r.Height > 1 {
+		s.merges = append(s.merges, r)
+	}
+	return r
+}
+
+// Unmerge splits the merged regions rect r intersects back into separate cells.
+func (s *Sheet) Unmerge(r Rect) {
+	var merges []Rect
+	for _, m := range s.merges {
+		if m.Intersect(r).Width == 0 {
+			merges = append(merges, m)
+		}
+	}
+	s.merges = merges
+}
+
+// Возвращает наименьший прямоугольник, охватывающий оба прямоугольника.
+func boundingRect(a, b Rect) Rect {
+	x, y, maxX, maxY := a.X, a.Y, a.MaxX(), a.MaxY()
+	if b.X < x {
+		x = b.X
+	}
+	if b.Y < y {
+		y = b.Y
+	}
+	if b.MaxX() > maxX {
+		maxX = b.MaxX()
+	}
+	if b.MaxY() > maxY {
+		maxY = b.MaxY()
+	}
+	return Rect{X: x, Y: y, Width: maxX - x + 1, Height: maxY - y + 1}
+}
+
+// Сдвигает объединения при вставке (delta = 1) или удалении (delta = -1) строки или
+// колонки N. Объединения, от которых осталась одна ячейка, удаляются.
+func (s *Sheet) shiftMerges(cols bool, n, delta int) {
+	var merges []Rect
+	for _, m := range s.merges {
+		if shiftRect(&m, cols, n, delta) && (m.Width > 1 || m.Height > 1) {
+			merges = append(merges, m)
+		}
+	}
+	s.merges = merges
+}
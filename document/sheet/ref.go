@@ -3,34 +3,228 @@ package sheet
 import (
 	"xl/document/eval"
 	"xl/formula"
+
+	"regexp"
+	"strings"
+)
+
+// Виды ссылок: на ячейку или диапазон, на целые колонки, на целые строки.
+const (
+	refKindCells = iota
+	refKindCols
+	refKindRows
+)
+
+var cellNameParts = regexp.MustCompile(`^(\$?[A-Z]+)(\$?[0-9]+)$`)
+
+// Изменения структуры листа, при которых корректируются ссылки.
+const (
+	ChangeInsertRow = iota
+	ChangeInsertCol
+	ChangeDeleteRow
+	ChangeDeleteCol
 )
 
 // Ссылка на другую ячейку или диапазон ячеек.
 // Если задана только Cell, то это ссылка на ячейку.
 // Если заданы оба Cell и CellTo, то это ссылка на диапазон, где Cell - левый верхний угол,
-// CellTo - правый нижний.
+// CellTo - правый нижний. Ссылки на целые колонки и строки хранятся как диапазоны до
+// границы листа. Если Cell и CellTo лежат на разных листах, то ссылка охватывает
+// все листы между ними (3D-ссылка).
+// Ссылка, ячейки которой были удалены, становится недействительной (Invalid) и
+// выводится в формуле как #REF!.
 type ref struct {
-	Cell   eval.CellReference
-	CellTo *eval.CellReference
+	Cell    eval.CellReference
+	CellTo  *eval.CellReference
+	Kind    int
+	Is3D    bool
+	Invalid bool
+}
+
+// RefsState хранит Ссылки формулы ячейки до изменения структуры листа, чтобы их можно было
+// восстановить при отмене изменения.
+type RefsState struct {
+	segment  Segment
+	x        int
+	y        int
+	rawValue string
+	refs     []ref
+}
+
+// Делает копию Ссылок, не разделяющую с оригиналом углы диапазонов.
+func copyRefs(refs []ref) []ref {
+	c := make([]ref, len(refs))
+	for i, r := range refs {
+		c[i] = r
+		if r.CellTo != nil {
+			cellTo := *r.CellTo
+			c[i].CellTo = &cellTo
+		}
+	}
+	return c
+}
+
+// Корректирует ссылку после вставки или удаления строки или колонки N на листе sheetIdx.
+// При вставке ссылка сдвигается, а диапазон, который пересекает вставленная линия,
+// расширяется. При удалении диапазон сужается, а ссылка, все ячейки которой удалены,
+// становится недействительной. 3D-ссылка корректируется, если лист лежит среди листов,
+// которые она охватывает. Возвращает true, если ссылка изменилась.
+func (r *ref) adjust(ec *eval.Context, change, sheetIdx, n int) bool {
+	if r.Invalid || !r.covers(ec, sheetIdx) {
+		return false
+	}
+	cols := change == ChangeInsertCol || change == ChangeDeleteCol
+	if (cols && r.Kind == refKindRows) || (!cols && r.Kind == refKindCols) {
+		// whole rows are not affected by columns and vice versa
+		return false
+	}
+	coord := func(c *eval.CellReference) *int {
+		if cols {
+			return &c.X
+		}
+		return &c.Y
+	}
+	from := coord(&r.Cell)
+	var to *int
+	if r.CellTo != nil {
+		to = coord(r.CellTo)
+	}
+	changed := false
+	switch change {
+	case ChangeInsertRow, ChangeInsertCol:
+		if *from >= n {
+			*from++
+			changed = true
+		}
+		if to != nil && *to >= n {
+			*to++
+			changed = true
+		}
+	case ChangeDeleteRow, ChangeDeleteCol:
+		if *from == n && (to == nil || *to == n) {
+			r.Invalid = true
+			return true
+		}
+		if *from > n {
+			*from--
+			changed = true
+		}
+		if to != nil && *to >= n {
+			*to--
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Проверяет, указывает ли ссылка на лист sheetIdx. 3D-ссылка указывает на листы,
+// которыми она начинается и заканчивается.
+func (r *ref) onSheet(sheetIdx int) bool {
+	if r.Invalid {
+		return false
+	}
+	return r.Cell.SheetIdx == sheetIdx || (r.CellTo != nil && r.CellTo.SheetIdx == sheetIdx)
+}
+
+// Проверяет, лежит ли лист sheetIdx среди листов, на которые указывает ссылка. Если
+// положение листов неизвестно, 3D-ссылка охватывает только листы, которыми она
+// начинается и заканчивается.
+func (r *ref) covers(ec *eval.Context, sheetIdx int) bool {
+	if !r.Is3D || r.CellTo == nil {
+		return r.Cell.SheetIdx == sheetIdx
+	}
+	p, ok := ec.DataProvider.(eval.SheetPositioner)
+	if !ok {
+		return r.onSheet(sheetIdx)
+	}
+	pos, first, last := p.SheetPos(sheetIdx), p.SheetPos(r.Cell.SheetIdx), p.SheetPos(r.CellTo.SheetIdx)
+	if first > last {
+		first, last = last, first
+	}
+	return pos >= 0 && pos >= first && pos <= last
+}
+
+// Перемещает ссылку на ячейку прямоугольника rect листа sheetIdx вслед за строкой,
+// которая переставлена на новое место внутри прямоугольника; rows отображает прежний
+// номер строки в новый. Диапазон перемещается, только если он лежит в одной строке,
+// иначе его ячейки разошлись бы по разным местам. Возвращает true, если ссылка изменилась.
+func (r *ref) move(sheetIdx int, rect Rect, rows map[int]int) bool {
+	if r.Invalid || r.Is3D || r.Kind != refKindCells || r.Cell.SheetIdx != sheetIdx {
+		return false
+	}
+	if !rect.Contains(r.Cell.X, r.Cell.Y) {
+		return false
+	}
+	if r.CellTo != nil && (r.CellTo.Y != r.Cell.Y || !rect.Contains(r.CellTo.X, r.CellTo.Y)) {
+		return false
+	}
+	y, ok := rows[r.Cell.Y]
+	if !ok || y == r.Cell.Y {
+		return false
+	}
+	r.Cell.Y = y
+	if r.CellTo != nil {
+		r.CellTo.Y = y
+	}
+	return true
 }
 
 // Преобразовывает распарсенное лист!имя ячейки из формулы в ее адрес.
-func toAddress(ec *eval.Context, c *formula.Cell) (eval.CellReference, error) {
-	var sheetTitle string
+// Если лист не указан, то ячейка лежит на листе, для которого вычисляется формула.
+func toAddress(ec *eval.Context, sheetTitle string, c *formula.Cell) (eval.CellReference, error) {
 	if c.Sheet != nil {
 		sheetTitle = string(*c.Sheet)
 	}
+	if sheetTitle == "" {
+		var err error
+		if sheetTitle, err = ec.DataProvider.SheetTitle(ec.CurrentSheetIdx); err != nil {
+			return eval.CellReference{}, err
+		}
+	}
 	return ec.DataProvider.ToAddress(sheetTitle, c.CellName)
 }
 
+// Преобразовывает ссылку на целые колонки или строки в адреса углов диапазона.
+func linesToAddress(ec *eval.Context, sheetTitle string, l *formula.Lines) (eval.CellReference, eval.CellReference, int, error) {
+	if l.Sheet != nil {
+		sheetTitle = string(*l.Sheet)
+	}
+	if sheetTitle == "" {
+		var err error
+		if sheetTitle, err = ec.DataProvider.SheetTitle(ec.CurrentSheetIdx); err != nil {
+			return eval.CellReference{}, eval.CellReference{}, refKindCells, err
+		}
+	}
+	lines := strings.Split(l.Lines, ":")
+	if strings.TrimLeft(lines[0], "$0123456789") == "" {
+		// whole rows
+		from, err := ec.DataProvider.ToAddress(sheetTitle, "$A"+lines[0])
+		if err != nil {
+			return from, from, refKindRows, err
+		}
+		to, err := ec.DataProvider.ToAddress(sheetTitle, "$A"+lines[1])
+		to.X = eval.MaxCols - 1
+		return from, to, refKindRows, err
+	}
+	// whole columns
+	from, err := ec.DataProvider.ToAddress(sheetTitle, lines[0]+"$1")
+	if err != nil {
+		return from, from, refKindCols, err
+	}
+	to, err := ec.DataProvider.ToAddress(sheetTitle, lines[1]+"$1")
+	to.Y = eval.MaxRows - 1
+	return from, to, refKindCols, err
+}
+
 // Преобразовывает адрес ячейки обратно в ее лист!имя, из которго можно составить формулу.
+// Лист не указывается, если ячейка лежит на листе, для которого вычисляется формула.
 func fromAddress(ec *eval.Context, ca eval.CellReference) (*formula.Cell, error) {
 	sheetTitle, cellName, err := ec.DataProvider.FromAddress(ca)
 	if err != nil {
 		return nil, err
 	}
 	var s *formula.Sheet
-	if sheetTitle != "" {
+	if ca.SheetIdx != ec.CurrentSheetIdx {
 		fs := formula.Sheet(sheetTitle)
 		s = &fs
 	}
@@ -45,31 +239,76 @@ func fromAddress(ec *eval.Context, ca eval.CellReference) (*formula.Cell, error)
 func makeRefs(ec *eval.Context, vars []*formula.Variable) ([]ref, error) {
 	refs := make([]ref, len(vars))
 	for i, v := range vars {
-		ca, err := toAddress(ec, v.Cell)
+		r, err := makeRef(ec, v)
 		if err != nil {
 			return nil, err
 		}
-		ec.DataProvider.AddRef(ca)
-		ref := ref{
-			Cell: ca,
+		refs[i] = r
+	}
+	return refs, nil
+}
+
+// Делает ссылку из Переменной.
+func makeRef(ec *eval.Context, v *formula.Variable) (ref, error) {
+	if v.RefError {
+		return ref{Invalid: true}, nil
+	}
+	var sheetFrom, sheetTo string
+	if v.Sheets != nil {
+		if (v.Lines != nil && v.Lines.Sheet != nil) || (v.Cell != nil && v.Cell.Sheet != nil) ||
+			(v.CellTo != nil && v.CellTo.Sheet != nil) {
+			return ref{}, eval.NewError(eval.ErrorKindRef, "malformed 3D reference")
+		}
+		sheetFrom, sheetTo = string(v.Sheets.From), string(v.Sheets.To)
+	}
+	r := ref{
+		Kind: refKindCells,
+		Is3D: v.Sheets != nil,
+	}
+	var to eval.CellReference
+	var err error
+	if v.Lines != nil {
+		r.Cell, to, r.Kind, err = linesToAddress(ec, sheetFrom, v.Lines)
+		if err != nil {
+			return ref{}, err
+		}
+		if r.Is3D {
+			_, to, _, err = linesToAddress(ec, sheetTo, v.Lines)
+		}
+	} else {
+		if v.Sheets == nil && v.Cell.Sheet != nil {
+			// the second corner of range lays on the same sheet unless specified explicitly
+			sheetTo = string(*v.Cell.Sheet)
+		}
+		if r.Cell, err = toAddress(ec, sheetFrom, v.Cell); err != nil {
+			return ref{}, err
 		}
 		if v.CellTo != nil {
-			ca, err = toAddress(ec, v.CellTo)
-			if err != nil {
-				return nil, err
-			}
-			ec.DataProvider.AddRef(ca)
-			ref.CellTo = &ca
+			to, err = toAddress(ec, sheetTo, v.CellTo)
+		} else if r.Is3D {
+			to, err = toAddress(ec, sheetTo, v.Cell)
+		} else {
+			return r, nil
 		}
-		refs[i] = ref
 	}
-	return refs, nil
+	if err != nil {
+		return ref{}, err
+	}
+	if !r.Is3D && to.SheetIdx != r.Cell.SheetIdx {
+		return ref{}, eval.NewError(eval.ErrorKindRef, "cross-sheets ranges are not allowed")
+	}
+	r.CellTo = &to
+	return r, nil
 }
 
 // Делает массив Значений из массива Ссылок.
-func refsToValues(refs []ref, offsetX, offsetY int) []eval.Value {
+// Если среди Ссылок есть недействительная, то вычислить формулу невозможно.
+func refsToValues(refs []ref, offsetX, offsetY int) ([]eval.Value, error) {
 	values := make([]eval.Value, len(refs))
 	for i, r := range refs {
+		if r.Invalid {
+			return nil, eval.NewError(eval.ErrorKindRef, "#REF!")
+		}
 		cell := applyOffsetToRef(r.Cell, offsetX, offsetY)
 		var cellTo *eval.CellReference
 		if r.CellTo != nil {
@@ -79,31 +318,78 @@ func refsToValues(refs []ref, offsetX, offsetY int) []eval.Value {
 		}
 		values[i] = eval.NewRefValue(cell, cellTo)
 	}
-	return values
+	return values, nil
 }
 
 // Изменяет Переменные в Выражении так, чтобы они получили значение в соответствии с акутальным
 // значением ссылки.
 func updateVars(ec *eval.Context, x *formula.Expression, refs []ref, offsetX, offsetY int) error {
 	for i, v := range x.Variables() {
-		cell := applyOffsetToRef(refs[i].Cell, offsetX, offsetY)
+		r := refs[i]
+		if r.Invalid {
+			v.RefError, v.Sheets, v.Lines, v.Cell, v.CellTo = true, nil, nil, nil, nil
+			continue
+		}
+		cell := applyOffsetToRef(r.Cell, offsetX, offsetY)
 		c, err := fromAddress(ec, cell)
 		if err != nil {
 			return err
 		}
-		v.Cell = c
-		if refs[i].CellTo != nil {
-			cellTo := applyOffsetToRef(*refs[i].CellTo, offsetX, offsetY)
-			c, err = fromAddress(ec, cellTo)
+		v.RefError, v.Sheets, v.Lines, v.Cell, v.CellTo = false, nil, nil, c, nil
+		if r.CellTo == nil {
+			continue
+		}
+		cellTo := applyOffsetToRef(*r.CellTo, offsetX, offsetY)
+		cTo, err := fromAddress(ec, cellTo)
+		if err != nil {
+			return err
+		}
+		// sheet of the second corner is the same as the first one's
+		cTo.Sheet = nil
+		if r.Is3D {
+			from, err := ec.DataProvider.SheetTitle(cell.SheetIdx)
+			if err != nil {
+				return err
+			}
+			to, err := ec.DataProvider.SheetTitle(cellTo.SheetIdx)
 			if err != nil {
 				return err
 			}
-			v.CellTo = c
+			v.Sheets = &formula.SheetRange{
+				From: formula.Sheet(from),
+				To:   formula.Sheet(to),
+			}
+			c.Sheet = nil
+		}
+		switch r.Kind {
+		case refKindCols:
+			v.Lines = &formula.Lines{
+				Sheet: c.Sheet,
+				Lines: cellNameParts.ReplaceAllString(c.CellName, "$1") + ":" +
+					cellNameParts.ReplaceAllString(cTo.CellName, "$1"),
+			}
+			v.Cell = nil
+		case refKindRows:
+			v.Lines = &formula.Lines{
+				Sheet: c.Sheet,
+				Lines: cellNameParts.ReplaceAllString(c.CellName, "$2") + ":" +
+					cellNameParts.ReplaceAllString(cTo.CellName, "$2"),
+			}
+			v.Cell = nil
+		default:
+			if !r.Is3D || cell.X != cellTo.X || cell.Y != cellTo.Y {
+				v.CellTo = cTo
+			}
 		}
 	}
 	return nil
 }
 
+// Проверяет, что ячейка, на которую указывает ссылка, не вышла за границы листа.
+func refInSheet(cell eval.CellReference) bool {
+	return cell.X >= 0 && cell.X < eval.MaxCols && cell.Y >= 0 && cell.Y < eval.MaxRows
+}
+
 // Применяет смещение к ссылке. Смещение использутеся в экстраполяционном сегменте для
 // указания, насколько запрашиваемая ячейка отстоит от ключевой.
 func applyOffsetToRef(cell eval.CellReference, offsetX, offsetY int) eval.CellReference {
@@ -0,0 +1,69 @@
+package sheet
+
+// Поиск ячеек. Лист перебирается не по всем клеткам своего прямоугольника, а по сегментам:
+// пустые места между сегментами пропускаются, а в каждом сегменте просматриваются только
+// клетки после стартовой позиции и до лучшего из уже найденных совпадений. Ячейки
+// экстраполяционных сегментов создаются по одной в момент проверки, так что поиск
+// не материализует сегмент целиком.
+
+// NextCell finds the first non-empty cell of the rect following the position X, Y in the
+// reading order (left to right, top to bottom), or preceding it if backward is set, for
+// which match returns true. The position itself is not checked; to search from the edge
+// of the sheet pass the position outside of it.
+func (s *Sheet) NextCell(x, y int, backward bool, r Rect, match func(x, y int, c *Cell) bool) (int, int, bool) {
+	step := 1
+	if backward {
+		step = -1
+	}
+	// whether the cell A goes before the cell B in the search order
+	precedes := func(ax, ay, bx, by int) bool {
+		if ay != by {
+			return (ay-by)*step < 0
+		}
+		return (ax-bx)*step < 0
+	}
+	found := false
+	var foundX, foundY int
+	for i, segment := range s.Segments {
+		area := segment.Size().Intersect(r)
+		if area.Width == 0 {
+			continue
+		}
+		// segments added later may cover this one
+		above := s.segmentsAbove(i, area)
+		firstY, lastY := area.Y, area.MaxY()
+		firstX, lastX := area.X, area.MaxX()
+		if backward {
+			firstY, lastY = lastY, firstY
+			firstX, lastX = lastX, firstX
+		}
+		// rows before the start position are not searched
+		if (y-firstY)*step > 0 {
+			firstY = y
+		}
+	rows:
+		for cy := firstY; (lastY-cy)*step >= 0; cy += step {
+			if found && precedes(foundX, foundY, firstX, cy) {
+				break
+			}
+			for cx := firstX; (lastX-cx)*step >= 0; cx += step {
+				if !precedes(x, y, cx, cy) {
+					continue
+				}
+				if found && !precedes(cx, cy, foundX, foundY) {
+					break rows
+				}
+				if covered(above, cx, cy) {
+					continue
+				}
+				c := segment.Cell(cx, cy)
+				if c == nil || c.IsEmpty() || !match(cx, cy, c) {
+					continue
+				}
+				found, foundX, foundY = true, cx, cy
+				break rows
+			}
+		}
+	}
+	return foundX, foundY, found
+}
@@ -19,6 +19,9 @@ type Segment interface {
 	InsertEmptyCol(x int)
 	DeleteRow(y int)
 	DeleteCol(x int)
+	// StoredCells calls f for every cell which value is stored in the segment
+	// passing sheet coordinates of the cell.
+	StoredCells(f func(x, y int, c *Cell))
 }
 
 // Base segment.
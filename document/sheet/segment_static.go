@@ -34,6 +34,15 @@ func (s *staticSegment) SetCell(x, y int, cell *Cell) {
 	s.Cells[x-s.size.X][y-s.size.Y] = *cell
 }
 
+// StoredCells calls f for every cell of the segment.
+func (s *staticSegment) StoredCells(f func(x, y int, c *Cell)) {
+	for x := range s.Cells {
+		for y := range s.Cells[x] {
+			f(s.size.X+x, s.size.Y+y, &s.Cells[x][y])
+		}
+	}
+}
+
 func (s *staticSegment) InsertEmptyRow(y int) {
 	for x := 0; x < s.size.Width; x++ {
 		s.Cells[x] = append(s.Cells[x], Cell{})
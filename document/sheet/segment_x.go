@@ -1,5 +1,9 @@
 package sheet
 
+import (
+	"xl/document/eval"
+)
+
 // Extrapolation segment.
 
 // Когда пользователь растягивает ячейки вниз, вправо, влево или вверх, на месте
@@ -8,6 +12,14 @@ package sheet
 // вычисляются в момент запроса; для вычисления произвольной клетки сегмента берется
 // значение его ключевой ячейки, ссылки в которой сдвинуты соразмерно смещению
 // запрошенной ячейки относительно ключевой.
+//
+// Ключевая ячейка может лежать и за пределами сегмента, например, когда сегмент
+// продолжает ячейку, из которой он растянут. Тогда ключевая ячейка не принадлежит
+// сегменту и служит только образцом для его ячеек.
+//
+// Сегмент не умеет вставлять и удалять линии посреди себя: перед изменением структуры листа
+// сегмент разделяется (см. Sheet.SplitXSegments) так, чтобы изменяемая линия попала
+// в статичную часть или на границу частей.
 
 type xSegment struct {
 	baseSegment
@@ -20,7 +32,7 @@ type xSegment struct {
 	keyCell Cell
 }
 
-func newXSegment(x, y, width, height, keyX, keyY int, keyCell Cell) Segment {
+func newXSegment(x, y, width, height, keyX, keyY int, keyCell Cell) *xSegment {
 	return &xSegment{
 		baseSegment: baseSegment{
 			size: Rect{
@@ -50,22 +62,173 @@ func (s *xSegment) SetCell(x, y int, cell *Cell) {
 	localX, localY := x-s.size.X, y-s.size.Y
 	if localX == s.keyX && localY == s.keyY {
 		s.keyCell = *cell
+		return
 	}
 	panic("writing cell is possible only for key cell")
 }
 
+// StoredCells calls f for the key cell only, since other cells are its copies.
+func (s *xSegment) StoredCells(f func(x, y int, c *Cell)) {
+	f(s.size.X+s.keyX, s.size.Y+s.keyY, &s.keyCell)
+}
+
+// InsertEmptyRow shifts the segment down when the row is inserted right above it.
+// The segment must be split before a row is inserted into its middle.
 func (s *xSegment) InsertEmptyRow(y int) {
-	panic("not supported")
+	if y != 0 {
+		panic("extrapolation segment must be split before inserting row")
+	}
+	s.size.Y++
 }
 
+// InsertEmptyCol shifts the segment right when the column is inserted right before it.
+// The segment must be split before a column is inserted into its middle.
 func (s *xSegment) InsertEmptyCol(x int) {
-	panic("not supported")
+	if x != 0 {
+		panic("extrapolation segment must be split before inserting column")
+	}
+	s.size.X++
 }
 
+// DeleteRow removes the only row of the segment.
+// The segment of several rows must be split before deleting one of them.
 func (s *xSegment) DeleteRow(y int) {
-	panic("not supported")
+	if s.size.Height != 1 {
+		panic("extrapolation segment must be split before deleting row")
+	}
+	s.size.Height = 0
 }
 
+// DeleteCol removes the only column of the segment.
+// The segment of several columns must be split before deleting one of them.
 func (s *xSegment) DeleteCol(x int) {
-	panic("not supported")
+	if s.size.Width != 1 {
+		panic("extrapolation segment must be split before deleting column")
+	}
+	s.size.Width = 0
+}
+
+// Возвращает координаты ключевой ячейки на листе.
+func (s *xSegment) keyPos() (int, int) {
+	return s.size.X + s.keyX, s.size.Y + s.keyY
+}
+
+// Делает сегмент из части ячеек данного. Ключевая ячейка остается на прежнем месте,
+// так что ячейки части сохраняют значения.
+func (s *xSegment) part(r Rect) *xSegment {
+	kx, ky := s.keyPos()
+	return newXSegment(r.X, r.Y, r.Width, r.Height, kx-r.X, ky-r.Y, s.keyCell.clone())
+}
+
+// Делает сегмент из части ячеек данного, ключевой ячейкой которого становится его левая
+// верхняя ячейка.
+func (s *xSegment) rekeyed(ec *eval.Context, r Rect) *xSegment {
+	kx, ky := s.keyPos()
+	keyCell := NewCellUntyped(OffsetRawValue(ec, s.keyCell.RawValue(), r.X-kx, r.Y-ky))
+	keyCell.SetFormat(s.keyCell.format)
+	// copies of the key cell are offset only when it is typed
+	keyCell.Expression(ec)
+	return newXSegment(r.X, r.Y, r.Width, r.Height, 0, 0, *keyCell)
+}
+
+// Делает статичный сегмент из части ячеек данного. Значения ячеек записываются явно.
+func (s *xSegment) materialize(ec *eval.Context, r Rect) *staticSegment {
+	kx, ky := s.keyPos()
+	raw := s.keyCell.RawValue()
+	cells := make([][]Cell, r.Width)
+	for x := range cells {
+		cells[x] = make([]Cell, r.Height)
+		for y := range cells[x] {
+			cells[x][y] = *NewCellUntyped(OffsetRawValue(ec, raw, r.X+x-kx, r.Y+y-ky))
+			cells[x][y].format = s.keyCell.format
+		}
+	}
+	return newStaticSegment(r.X, r.Y, r.Width, r.Height, cells).(*staticSegment)
+}
+
+// Делит сегмент на части, которые не пересекаются с прямоугольником r: строки выше и ниже
+// него, а также ячейки слева и справа от него в его строках.
+func (s *xSegment) cut(r Rect) []Segment {
+	var parts []Segment
+	for _, part := range s.size.Subtract(r) {
+		parts = append(parts, s.part(part))
+	}
+	return parts
+}
+
+// Возвращает полосу строк (или колонок), ячейки которых ссылаются через линию N на листе
+// sheetIdx или лежат на ней. Если строка вставляется или удаляется внутри полосы,
+// ссылки ячеек сегмента корректируются по-разному, и такие ячейки не могут быть копиями
+// одной ключевой ячейки. Сообщает также, есть ли у ключевой ячейки относительные ссылки
+// на лист sheetIdx.
+func (s *xSegment) band(ec *eval.Context, sheetIdx int, cols bool, n int) (int, int, bool) {
+	kx, ky := s.keyPos()
+	maxPos, minNeg := 0, 0
+	s.keyCell.Expression(ec)
+	v, ok := s.keyCell.v.(formulaCell)
+	if !ok {
+		return n, n, false
+	}
+	relative := false
+	distance := func(c eval.CellReference) {
+		d := c.Y - ky
+		anchored := c.AnchoredY
+		if cols {
+			d, anchored = c.X-kx, c.AnchoredX
+		}
+		if anchored {
+			return
+		}
+		relative = true
+		if d > maxPos {
+			maxPos = d
+		}
+		if d < minNeg {
+			minNeg = d
+		}
+	}
+	for _, r := range v.Refs {
+		if r.Invalid || !r.covers(ec, sheetIdx) {
+			continue
+		}
+		distance(r.Cell)
+		if r.CellTo != nil {
+			distance(*r.CellTo)
+		}
+	}
+	return n - maxPos, n - minNeg, relative
+}
+
+// Проверяет, ссылается ли какая-нибудь ячейка сегмента на прямоугольник r листа sheetIdx.
+func (s *xSegment) refersTo(ec *eval.Context, sheetIdx int, r Rect) bool {
+	s.keyCell.Expression(ec)
+	v, ok := s.keyCell.v.(formulaCell)
+	if !ok {
+		return false
+	}
+	// bounds of the cells which the corner refers to from all cells of the segment
+	bounds := func(c eval.CellReference) Rect {
+		b := Rect{X: c.X, Y: c.Y, Width: 1, Height: 1}
+		if !c.AnchoredX {
+			b.X, b.Width = c.X-s.keyX, s.size.Width
+		}
+		if !c.AnchoredY {
+			b.Y, b.Height = c.Y-s.keyY, s.size.Height
+		}
+		return b
+	}
+	for _, ref := range v.Refs {
+		if ref.Invalid || ref.Is3D || ref.Cell.SheetIdx != sheetIdx {
+			continue
+		}
+		b := bounds(ref.Cell)
+		if ref.CellTo != nil {
+			to := bounds(*ref.CellTo)
+			b.Width, b.Height = to.MaxX()-b.X+1, to.MaxY()-b.Y+1
+		}
+		if b.Intersect(r).Width > 0 {
+			return true
+		}
+	}
+	return false
 }
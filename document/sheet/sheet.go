@@ -1,5 +1,10 @@
 package sheet
 
+import (
+	"xl/document/eval"
+	"xl/document/numfmt"
+)
+
 // Лист состоит из сегментов. Имеет такой размер, чтобы границы листа охватывали все
 // его сегменты. Задача листа - добавлять и удалять сегменты, а также следить,
 // чтобы они не пересекались.
@@ -9,6 +14,8 @@ const (
 	CellDefaultHeight = 10
 	CellMaxWidth      = CellDefaultWidth * 10
 	CellMaxHeight     = CellDefaultHeight * 10
+	// Высота строки текста, строки листа меняют высоту на целое число строк текста.
+	CellLineHeight = 20
 )
 
 type Cursor struct {
@@ -28,6 +35,51 @@ type Rect struct {
 	Height int
 }
 
+// Режимы выделения: прямоугольник ячеек, строки или колонки целиком.
+const (
+	SelectionNone = iota
+	SelectionBlock
+	SelectionRows
+	SelectionCols
+)
+
+// Выделение начинается с ячейки Anchor, вторым углом выделенного прямоугольника
+// является курсор.
+type Selection struct {
+	Mode   int
+	Anchor Cursor
+}
+
+// Contains reports whether the cell belongs to rect.
+func (r *Rect) Contains(x, y int) bool {
+	return x >= r.X && x <= r.MaxX() && y >= r.Y && y <= r.MaxY()
+}
+
+// Intersect returns the part of rect which belongs to other rect too.
+// If rects do not intersect, the returned rect is empty.
+func (r Rect) Intersect(other Rect) Rect {
+	res := Rect{X: r.X, Y: r.Y}
+	if other.X > res.X {
+		res.X = other.X
+	}
+	if other.Y > res.Y {
+		res.Y = other.Y
+	}
+	maxX, maxY := r.MaxX(), r.MaxY()
+	if other.MaxX() < maxX {
+		maxX = other.MaxX()
+	}
+	if other.MaxY() < maxY {
+		maxY = other.MaxY()
+	}
+	res.Width = maxX - res.X + 1
+	res.Height = maxY - res.Y + 1
+	if res.Width <= 0 || res.Height <= 0 {
+		return Rect{}
+	}
+	return res
+}
+
 // MaxX returns maximum X belonging to rect.
 func (r *Rect) MaxX() int {
 	return r.X + r.Width - 1
@@ -46,8 +98,32 @@ type Sheet struct {
 	Size     Rect
 	Segments []Segment
 
+	Selection Selection
+
+	// Автофильтр листа или nil.
+	Filter *AutoFilter
+
+	// Число закрепленных строк сверху и колонок слева, они не прокручиваются вместе
+	// с остальным листом.
+	FrozenRows int
+	FrozenCols int
+
 	colSizes map[int]int
 	rowSizes map[int]int
+
+	// Строки и колонки, скрытые пользователем, и строки, скрытые автофильтром.
+	hiddenRows   map[int]bool
+	hiddenCols   map[int]bool
+	filteredRows map[int]bool
+
+	// Оформление ячеек: непересекающиеся прямоугольники.
+	styles []StyleRange
+	// Правила условного форматирования в порядке убывания приоритета.
+	condRules []CondRule
+	// Правила проверки вводимых значений, прямоугольники правил не пересекаются.
+	validations []Validation
+	// Объединенные ячейки.
+	merges []Rect
 }
 
 func New(idx int, name string) *Sheet {
@@ -60,6 +136,10 @@ func New(idx int, name string) *Sheet {
 
 		colSizes: make(map[int]int),
 		rowSizes: make(map[int]int),
+
+		hiddenRows:   make(map[int]bool),
+		hiddenCols:   make(map[int]bool),
+		filteredRows: make(map[int]bool),
 	}
 }
 
@@ -87,6 +167,183 @@ func (s *Sheet) RowSize(n int) int {
 	return CellDefaultHeight
 }
 
+// RowLines returns the number of lines of text the row of the height shows.
+func RowLines(size int) int {
+	if n := size / CellLineHeight; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// LinesHeight returns the height of the row showing N lines of text. The row of one line
+// has the default height, the height is limited by the maximum height of a row.
+func LinesHeight(n int) int {
+	if n <= 1 {
+		return CellDefaultHeight
+	}
+	if size := n * CellLineHeight; size < CellMaxHeight {
+		return size
+	}
+	return CellMaxHeight
+}
+
+// SetRowSize sets the new height for a row in pixels.
+func (s *Sheet) SetRowSize(n, size int) {
+	if size < 1 || size > CellMaxHeight {
+		return
+	}
+	s.rowSizes[n] = size
+}
+
+// IsRowHidden reports whether the row is hidden by user.
+func (s *Sheet) IsRowHidden(n int) bool {
+	return s.hiddenRows[n]
+}
+
+// IsRowFiltered reports whether the row is hidden by the autofilter.
+func (s *Sheet) IsRowFiltered(n int) bool {
+	return s.filteredRows[n]
+}
+
+// IsRowVisible reports whether the row is neither hidden nor filtered out.
+func (s *Sheet) IsRowVisible(n int) bool {
+	return !s.hiddenRows[n] && !s.filteredRows[n]
+}
+
+// IsColHidden reports whether the column is hidden by user.
+func (s *Sheet) IsColHidden(n int) bool {
+	return s.hiddenCols[n]
+}
+
+// SetRowHidden hides or shows the row.
+func (s *Sheet) SetRowHidden(n int, hidden bool) {
+	setLine(s.hiddenRows, n, hidden)
+}
+
+// SetColHidden hides or shows the column.
+func (s *Sheet) SetColHidden(n int, hidden bool) {
+	setLine(s.hiddenCols, n, hidden)
+}
+
+// SetRowFiltered marks the row as hidden by the autofilter or not.
+func (s *Sheet) SetRowFiltered(n int, filtered bool) {
+	setLine(s.filteredRows, n, filtered)
+}
+
+// UnhideAll shows all the rows or columns hidden by user.
+func (s *Sheet) UnhideAll(cols bool) {
+	if cols {
+		s.hiddenCols = make(map[int]bool)
+	} else {
+		s.hiddenRows = make(map[int]bool)
+	}
+}
+
+// NextVisibleRow returns the nearest visible row N steps (positive or negative) away from Y.
+// Rows above the first visible one are not passed.
+func (s *Sheet) NextVisibleRow(y, n int) int {
+	return nextVisible(y, n, s.IsRowVisible)
+}
+
+// NextVisibleCol returns the nearest visible column N steps (positive or negative) away from X.
+func (s *Sheet) NextVisibleCol(x, n int) int {
+	return nextVisible(x, n, func(x int) bool {
+		return !s.hiddenCols[x]
+	})
+}
+
+// Возвращает линию, которая отстоит от pos на n видимых линий. Если в сторону начала
+// листа видимых линий не хватает, возвращает последнюю найденную.
+func nextVisible(pos, n int, visible func(int) bool) int {
+	step := 1
+	if n < 0 {
+		step, n = -1, -n
+	}
+	for ; n > 0; n-- {
+		next := pos + step
+		for next >= 0 && !visible(next) {
+			next += step
+		}
+		if next < 0 {
+			break
+		}
+		pos = next
+	}
+	return pos
+}
+
+// Отмечает линию в наборе или убирает ее оттуда.
+func setLine(lines map[int]bool, n int, on bool) {
+	if on {
+		lines[n] = true
+	} else {
+		delete(lines, n)
+	}
+}
+
+// Сдвигает линии набора после вставки (delta 1) или удаления (delta -1) линии N.
+func shiftLines(lines map[int]bool, n, delta int) {
+	shifted := make(map[int]bool, len(lines))
+	for l := range lines {
+		switch {
+		case l < n:
+			shifted[l] = true
+		case l == n && delta < 0:
+			// the line is deleted
+		default:
+			shifted[l+delta] = true
+		}
+	}
+	for l := range lines {
+		delete(lines, l)
+	}
+	for l := range shifted {
+		lines[l] = true
+	}
+}
+
+// Сдвигает размеры линий после вставки (delta 1) или удаления (delta -1) линии N,
+// чтобы размер оставался у той же строки или колонки.
+func shiftSizes(sizes map[int]int, n, delta int) {
+	shifted := make(map[int]int, len(sizes))
+	for l, size := range sizes {
+		switch {
+		case l < n:
+			shifted[l] = size
+		case l == n && delta < 0:
+			// the line is deleted
+		default:
+			shifted[l+delta] = size
+		}
+	}
+	for l := range sizes {
+		delete(sizes, l)
+	}
+	for l, size := range shifted {
+		sizes[l] = size
+	}
+}
+
+// Меняет число закрепленных линий после вставки или удаления линии N: закрепленная
+// область растет, если линия вставлена внутрь нее, и уменьшается, если из нее удалена.
+func shiftFrozen(frozen *int, n, delta int) {
+	if n < *frozen {
+		*frozen += delta
+	}
+}
+
+// Freeze fixes the rows above the cell X, Y and the columns left of it so they stay
+// on the screen while the rest of the sheet scrolls. Freeze(0, 0) unfreezes the panes.
+func (s *Sheet) Freeze(x, y int) {
+	s.FrozenRows, s.FrozenCols = y, x
+	if s.Viewport.Top < y {
+		s.Viewport.Top = y
+	}
+	if s.Viewport.Left < x {
+		s.Viewport.Left = x
+	}
+}
+
 // AddStaticSegment creates a new Static segment and will with the given cells matrix.
 // TODO(high): check intersections
 // TODO(med): new segment needs to be merged with the existing if possible
@@ -100,14 +357,217 @@ func (s *Sheet) AddStaticSegment(x, y, width, height int, cells [][]Cell) Segmen
 	return segment
 }
 
+// ReplaceSegments removes old segments from the sheet and puts new ones instead of them.
+// New segments take the place of the first old one, or the beginning of the list if
+// there are no old segments, so they cover other segments laying under them.
+func (s *Sheet) ReplaceSegments(old, new []Segment) {
+	pos := 0
+	if len(old) > 0 {
+		pos = -1
+	}
+	kept := make([]Segment, 0, len(s.Segments)+len(new))
+	for _, segment := range s.Segments {
+		removed := false
+		for _, o := range old {
+			if segment == o {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			kept = append(kept, segment)
+		} else if pos < 0 {
+			pos = len(kept)
+		}
+	}
+	if pos < 0 {
+		pos = len(kept)
+	}
+	s.Segments = append(kept[:pos], append(new, kept[pos:]...)...)
+	for _, segment := range new {
+		size := segment.Size()
+		s.adjustSheetSize(size.X, size.Y, size.Width, size.Height)
+	}
+}
+
+// AddXSegment creates extrapolation segment. The key cell is at keyX, keyY relative
+// to the segment and may lay outside of it. The segment covers cells laying under it.
 // TODO(high): check intersections
 func (s *Sheet) AddXSegment(x, y, width, height, keyX, keyY int, keyCell Cell) Segment {
 	segment := newXSegment(x, y, width, height, keyX, keyY, keyCell)
-	s.Segments = append(s.Segments, segment)
-	s.adjustSheetSize(x, y, width, height)
+	s.ReplaceSegments(nil, []Segment{segment})
 	return segment
 }
 
+// CutXSegments frees cells of the rect from extrapolation segments. Each segment
+// intersecting the rect is replaced with its parts laying around the rect.
+// Returns removed segments and their parts.
+func (s *Sheet) CutXSegments(r Rect) (removed, added []Segment) {
+	for _, segment := range append([]Segment(nil), s.Segments...) {
+		xs, ok := segment.(*xSegment)
+		if !ok || xs.size.Intersect(r).Width == 0 {
+			continue
+		}
+		parts := xs.cut(r)
+		s.ReplaceSegments([]Segment{xs}, parts)
+		removed = append(removed, xs)
+		added = append(added, parts...)
+	}
+	return removed, added
+}
+
+// SplitXSegments prepares extrapolation segments to insertion or deletion of row or
+// column N of the sheet with sheetIdx (see Change* constants), which is this sheet or
+// the one the segments refer to. Cells of a segment around line N, which references
+// would be corrected differently, are written to a static segment, the rest of
+// the segment is split into parts before and after them.
+// Returns removed segments and the segments replacing them.
+func (s *Sheet) SplitXSegments(ec *eval.Context, sheetIdx, change, n int) (removed, added []Segment) {
+	cols := change == ChangeInsertCol || change == ChangeDeleteCol
+	for _, segment := range append([]Segment(nil), s.Segments...) {
+		xs, ok := segment.(*xSegment)
+		if !ok {
+			continue
+		}
+		size := xs.Size()
+		from, to := size.Y, size.MaxY()
+		if cols {
+			from, to = size.X, size.MaxX()
+		}
+		lo, hi, relative := xs.band(ec, sheetIdx, cols, n)
+		crosses := lo <= to && hi >= from
+		if sheetIdx != s.Idx && (!relative || !crosses) {
+			// the segment itself stays in place when the line of another sheet changes
+			continue
+		}
+		if !crosses && size.Contains(xs.keyPos()) {
+			// all cells of the segment and its key are on the same side of the line
+			continue
+		}
+		// lines of the segment from a to b
+		lines := func(a, b int) Rect {
+			if cols {
+				return Rect{X: a, Y: size.Y, Width: b - a + 1, Height: size.Height}
+			}
+			return Rect{X: size.X, Y: a, Width: size.Width, Height: b - a + 1}
+		}
+		var parts []Segment
+		if crosses {
+			if lo < from {
+				lo = from
+			}
+			if hi > to {
+				hi = to
+			}
+			if lo > from {
+				parts = append(parts, xs.rekeyed(ec, lines(from, lo-1)))
+			}
+			parts = append(parts, xs.materialize(ec, lines(lo, hi)))
+			if hi < to {
+				parts = append(parts, xs.rekeyed(ec, lines(hi+1, to)))
+			}
+		} else {
+			// key outside of the segment may be on the other side of the line
+			parts = append(parts, xs.rekeyed(ec, size))
+		}
+		s.ReplaceSegments([]Segment{xs}, parts)
+		removed = append(removed, xs)
+		added = append(added, parts...)
+	}
+	return removed, added
+}
+
+// MaterializeXSegments replaces with static cells the parts of extrapolation segments laying
+// within rect r of the sheet sheetIdx, and the whole segments which cells refer to that rect.
+// Returns removed segments and the segments replacing them.
+func (s *Sheet) MaterializeXSegments(ec *eval.Context, sheetIdx int, r Rect) (removed, added []Segment) {
+	for _, segment := range append([]Segment(nil), s.Segments...) {
+		xs, ok := segment.(*xSegment)
+		if !ok {
+			continue
+		}
+		var parts []Segment
+		switch {
+		case xs.refersTo(ec, sheetIdx, r):
+			parts = []Segment{xs.materialize(ec, xs.size)}
+		case s.Idx == sheetIdx && xs.size.Intersect(r).Width > 0:
+			parts = append(xs.cut(r), xs.materialize(ec, xs.size.Intersect(r)))
+		default:
+			continue
+		}
+		s.ReplaceSegments([]Segment{xs}, parts)
+		removed = append(removed, xs)
+		added = append(added, parts...)
+	}
+	return removed, added
+}
+
+// MaterializeXSegment replaces the extrapolation segment containing the cell with
+// a static segment of the same values. Returns removed and added segments, or nils if
+// there is no extrapolation segment at the cell.
+func (s *Sheet) MaterializeXSegment(ec *eval.Context, x, y int) (removed, added []Segment) {
+	for _, segment := range s.Segments {
+		if xs, ok := segment.(*xSegment); ok && xs.Contains(x, y) {
+			removed, added = []Segment{xs}, []Segment{xs.materialize(ec, xs.Size())}
+			s.ReplaceSegments(removed, added)
+			return removed, added
+		}
+	}
+	return nil, nil
+}
+
+// Select starts selection of given mode at the cursor position.
+func (s *Sheet) Select(mode int) {
+	s.Selection = Selection{
+		Mode:   mode,
+		Anchor: s.Cursor,
+	}
+}
+
+// Unselect drops the selection.
+func (s *Sheet) Unselect() {
+	s.Selection = Selection{}
+}
+
+// IsSelected reports whether the sheet has a selection.
+func (s *Sheet) IsSelected() bool {
+	return s.Selection.Mode != SelectionNone
+}
+
+// SelectedRect returns the rect between selection anchor and cursor.
+// Rows are selected up to the right border of the sheet, columns up to the bottom one.
+// If nothing is selected, returns the rect of the cell under cursor.
+func (s *Sheet) SelectedRect() Rect {
+	r := Rect{X: s.Cursor.X, Y: s.Cursor.Y, Width: 1, Height: 1}
+	if s.Selection.Mode == SelectionNone {
+		return r
+	}
+	anchor := s.Selection.Anchor
+	if anchor.X < r.X {
+		r.X, r.Width = anchor.X, r.X-anchor.X+1
+	} else {
+		r.Width = anchor.X - r.X + 1
+	}
+	if anchor.Y < r.Y {
+		r.Y, r.Height = anchor.Y, r.Y-anchor.Y+1
+	} else {
+		r.Height = anchor.Y - r.Y + 1
+	}
+	if s.Selection.Mode == SelectionRows {
+		r.X, r.Width = 0, s.Size.X+s.Size.Width
+		if r.Width < 1 {
+			r.Width = 1
+		}
+	}
+	if s.Selection.Mode == SelectionCols {
+		r.Y, r.Height = 0, s.Size.Y+s.Size.Height
+		if r.Height < 1 {
+			r.Height = 1
+		}
+	}
+	return r
+}
+
 // Cell returns the cell for given X and Y.
 func (s *Sheet) Cell(x, y int) *Cell {
 	if segment := s.FindSegment(x, y); segment != nil {
@@ -141,14 +601,34 @@ func (s *Sheet) CellUnderCursor() *Cell {
 // If no such cell exists yet, created a new segment.
 func (s *Sheet) SetCell(x, y int, cell *Cell) {
 	if segment := s.FindSegment(x, y); segment != nil {
+		if xs, ok := segment.(*xSegment); ok && (x != xs.size.X+xs.keyX || y != xs.size.Y+xs.keyY) {
+			// other cells of extrapolation segment are copies of the key
+			s.CutXSegments(Rect{X: x, Y: y, Width: 1, Height: 1})
+			s.SetCell(x, y, cell)
+			return
+		}
 		segment.SetCell(x, y, cell)
-		// TODO: other types of Segments
 	} else {
 		// create new Segment
 		s.AddStaticSegment(x, y, 1, 1, [][]Cell{{*cell}})
 	}
 }
 
+// SetCellFormat sets the number format of the cell, creating an empty cell if there is none.
+// Extrapolation segment containing the cell is materialized, since its cells share
+// the format of the key cell. Returns removed segments and the segments replacing them.
+func (s *Sheet) SetCellFormat(ec *eval.Context, x, y int, f *numfmt.Format) (removed, added []Segment) {
+	removed, added = s.MaterializeXSegment(ec, x, y)
+	if c := s.Cell(x, y); c != nil {
+		c.format = f
+		return removed, added
+	}
+	c := NewCellEmpty()
+	c.format = f
+	s.SetCell(x, y, c)
+	return removed, added
+}
+
 // FindSegment iterates over segments to find one containing cell with given X and Y.
 func (s *Sheet) FindSegment(x, y int) Segment {
 	for _, segment := range s.Segments {
@@ -159,7 +639,244 @@ func (s *Sheet) FindSegment(x, y int) Segment {
 	return nil
 }
 
+// ShownCells calls f for every cell of the segments which the sheet shows, the cell of
+// the segment found first is taken like FindSegment does. Cells of the segments lying
+// under other ones are skipped. Stops at the first error returned by f.
+func (s *Sheet) ShownCells(f func(x, y int, c *Cell) error) error {
+	for i, segment := range s.Segments {
+		size := segment.Size()
+		above := s.segmentsAbove(i, size)
+		for x := size.X; x <= size.MaxX(); x++ {
+			for y := size.Y; y <= size.MaxY(); y++ {
+				if covered(above, x, y) {
+					continue
+				}
+				if err := f(x, y, segment.Cell(x, y)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Возвращает сегменты, которые предшествуют сегменту N и пересекают прямоугольник r.
+// Клетки r, лежащие в них, сегмент N не показывает: FindSegment находит их раньше.
+func (s *Sheet) segmentsAbove(n int, r Rect) []Segment {
+	var above []Segment
+	for _, segment := range s.Segments[:n] {
+		if segment.Size().Intersect(r).Width > 0 {
+			above = append(above, segment)
+		}
+	}
+	return above
+}
+
+// Сообщает, лежит ли ячейка в одном из сегментов.
+func covered(segments []Segment, x, y int) bool {
+	for _, segment := range segments {
+		if segment.Contains(x, y) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdjustRefs corrects references in all formulas of the sheet after row or column N
+// of the sheet with sheetIdx is inserted or deleted (see Change* constants).
+// Returns previous state of references which were changed.
+func (s *Sheet) AdjustRefs(ec *eval.Context, change, sheetIdx, n int) []RefsState {
+	var states []RefsState
+	for _, segment := range s.Segments {
+		segment.StoredCells(func(x, y int, c *Cell) {
+			if state, ok := c.adjustRefs(ec, change, sheetIdx, n); ok {
+				state.segment, state.x, state.y = segment, x, y
+				states = append(states, state)
+			}
+		})
+	}
+	return states
+}
+
+// MoveRefs corrects references in all formulas of the sheet to the cells of rect r of the sheet
+// with sheetIdx after the rows of the rect are reordered, rows maps old row to the new one.
+// Returns previous state of references which were changed.
+func (s *Sheet) MoveRefs(ec *eval.Context, sheetIdx int, r Rect, rows map[int]int) []RefsState {
+	var states []RefsState
+	for _, segment := range s.Segments {
+		segment.StoredCells(func(x, y int, c *Cell) {
+			if state, ok := c.moveRefs(ec, sheetIdx, r, rows); ok {
+				state.segment, state.x, state.y = segment, x, y
+				states = append(states, state)
+			}
+		})
+	}
+	return states
+}
+
+// ParseFormulas parses the formulas of the sheet which have not been parsed yet, so their
+// references are resolved against the current titles of the sheets.
+func (s *Sheet) ParseFormulas(ec *eval.Context) {
+	for _, segment := range s.Segments {
+		segment.StoredCells(func(x, y int, c *Cell) {
+			c.parseFormula(ec)
+		})
+	}
+}
+
+// RenameRefs updates formulas of the sheet referring to the sheet with sheetIdx after it is
+// renamed, so they show its new title. Formulas have to be parsed before the renaming.
+// Returns previous state of references which were changed.
+func (s *Sheet) RenameRefs(ec *eval.Context, sheetIdx int) []RefsState {
+	return s.changeSheetRefs(ec, func(r *ref) bool {
+		return r.onSheet(sheetIdx)
+	})
+}
+
+// InvalidateRefs makes references in all formulas of the sheet to the sheet with sheetIdx
+// invalid before the sheet is deleted. Returns previous state of references which were changed.
+func (s *Sheet) InvalidateRefs(ec *eval.Context, sheetIdx int) []RefsState {
+	return s.changeSheetRefs(ec, invalidateOn(sheetIdx))
+}
+
+// Возвращает изменение, которое делает недействительными Ссылки на лист sheetIdx.
+func invalidateOn(sheetIdx int) func(r *ref) bool {
+	return func(r *ref) bool {
+		if !r.onSheet(sheetIdx) {
+			return false
+		}
+		r.Invalid = true
+		return true
+	}
+}
+
+// Применяет изменение change к Ссылкам всех формул листа. Возвращает прежнее состояние
+// изменившихся Ссылок.
+func (s *Sheet) changeSheetRefs(ec *eval.Context, change func(r *ref) bool) []RefsState {
+	var states []RefsState
+	for _, segment := range s.Segments {
+		segment.StoredCells(func(x, y int, c *Cell) {
+			if state, ok := c.changeRefs(ec, change); ok {
+				state.segment, state.x, state.y = segment, x, y
+				states = append(states, state)
+			}
+		})
+	}
+	return states
+}
+
+// Copy returns the copy of the sheet with new index and title. Formulas are copied as text,
+// so references without sheet title point to the cells of the copy.
+func (s *Sheet) Copy(ec *eval.Context, idx int, title string) *Sheet {
+	c := New(idx, title)
+	c.Size = s.Size
+	c.Cursor, c.Viewport = s.Cursor, s.Viewport
+	for col, size := range s.colSizes {
+		c.colSizes[col] = size
+	}
+	for row, size := range s.rowSizes {
+		c.rowSizes[row] = size
+	}
+	c.SetVisibility(s.Visibility())
+	c.styles = s.Styles()
+	c.condRules = s.CondRules()
+	c.validations = s.Validations()
+	c.merges = s.Merges()
+	for _, segment := range s.Segments {
+		size := segment.Size()
+		switch segment := segment.(type) {
+		case *staticSegment:
+			cells := make([][]Cell, len(segment.Cells))
+			for x := range segment.Cells {
+				cells[x] = make([]Cell, len(segment.Cells[x]))
+				for y := range segment.Cells[x] {
+					cells[x][y] = textCopy(ec, &segment.Cells[x][y])
+				}
+			}
+			c.Segments = append(c.Segments, newStaticSegment(size.X, size.Y, size.Width, size.Height, cells))
+		case *xSegment:
+			c.Segments = append(c.Segments, newXSegment(size.X, size.Y, size.Width, size.Height,
+				segment.keyX, segment.keyY, textCopy(ec, &segment.keyCell)))
+		}
+	}
+	return c
+}
+
+// Делает копию ячейки, которая заново будет распарсена в контексте другого листа.
+// Ячейки, сдвинутые в экстраполяционном сегменте, не хранят формулу в виде текста,
+// поэтому она восстанавливается из Выражения.
+func textCopy(ec *eval.Context, c *Cell) Cell {
+	raw := c.rawValue
+	if _, ok := c.v.(formulaCell); ok && raw == "" {
+		if expr := c.Expression(ec); expr != nil {
+			raw = expr.String()
+		}
+	}
+	copied := NewCellUntyped(raw)
+	copied.format = c.format
+	return *copied
+}
+
+// PermuteRows moves the cells of rect r so that row Y of the rect becomes row rows[Y].
+// Rows missing in the map stay in place.
+func (s *Sheet) PermuteRows(r Rect, rows map[int]int) {
+	type movedCell struct {
+		x, y int
+		cell *Cell
+	}
+	var moved []movedCell
+	for y, to := range rows {
+		for x := r.X; x <= r.MaxX(); x++ {
+			var cell *Cell
+			if c := s.Cell(x, y); c != nil {
+				copied := *c
+				cell = &copied
+			}
+			moved = append(moved, movedCell{x: x, y: to, cell: cell})
+		}
+	}
+	for _, m := range moved {
+		switch {
+		case m.cell != nil:
+			s.SetCell(m.x, m.y, m.cell)
+		case s.Cell(m.x, m.y) != nil:
+			s.SetCell(m.x, m.y, NewCellEmpty())
+		}
+	}
+}
+
+// RestoreRefs brings references back to the state saved by AdjustRefs.
+// Cells must be on the same positions they were at the moment of saving.
+func (s *Sheet) RestoreRefs(states []RefsState) {
+	for _, state := range states {
+		var c *Cell
+		if xs, ok := state.segment.(*xSegment); ok {
+			// key cell may lay outside of its segment
+			c = &xs.keyCell
+		} else if state.segment.Contains(state.x, state.y) {
+			c = state.segment.Cell(state.x, state.y)
+		} else {
+			c = s.Cell(state.x, state.y)
+		}
+		if c != nil {
+			c.restoreRefs(state)
+		}
+	}
+}
+
 func (s *Sheet) InsertEmptyRow(y int) {
+	if y < s.Size.Y+s.Size.Height {
+		s.Size.Height++
+	}
+	shiftLines(s.hiddenRows, y, 1)
+	shiftSizes(s.rowSizes, y, 1)
+	shiftLines(s.filteredRows, y, 1)
+	shiftFrozen(&s.FrozenRows, y, 1)
+	s.Filter.insertRow(y)
+	s.shiftStyles(false, y, 1)
+	s.shiftCondRules(false, y, 1)
+	s.shiftValidations(false, y, 1)
+	s.shiftMerges(false, y, 1)
 	for _, segment := range s.Segments {
 		size := segment.Size()
 		if segment.ContainsY(y) {
@@ -173,6 +890,17 @@ func (s *Sheet) InsertEmptyRow(y int) {
 }
 
 func (s *Sheet) InsertEmptyCol(x int) {
+	if x < s.Size.X+s.Size.Width {
+		s.Size.Width++
+	}
+	shiftLines(s.hiddenCols, x, 1)
+	shiftSizes(s.colSizes, x, 1)
+	shiftFrozen(&s.FrozenCols, x, 1)
+	s.Filter.insertCol(x)
+	s.shiftStyles(true, x, 1)
+	s.shiftCondRules(true, x, 1)
+	s.shiftValidations(true, x, 1)
+	s.shiftMerges(true, x, 1)
 	for _, segment := range s.Segments {
 		size := segment.Size()
 		if segment.ContainsX(x) {
@@ -186,6 +914,22 @@ func (s *Sheet) InsertEmptyCol(x int) {
 }
 
 func (s *Sheet) DeleteRow(y int) {
+	if y < s.Size.Y+s.Size.Height {
+		s.Size.Height--
+	}
+	shiftLines(s.hiddenRows, y, -1)
+	shiftSizes(s.rowSizes, y, -1)
+	shiftLines(s.filteredRows, y, -1)
+	shiftFrozen(&s.FrozenRows, y, -1)
+	s.shiftStyles(false, y, -1)
+	s.shiftCondRules(false, y, -1)
+	s.shiftValidations(false, y, -1)
+	s.shiftMerges(false, y, -1)
+	if s.Filter.deleteRow(y) {
+		// header of the filter is deleted
+		s.Filter = nil
+		s.filteredRows = make(map[int]bool)
+	}
 	for _, segment := range s.Segments {
 		size := segment.Size()
 		if segment.ContainsY(y) {
@@ -199,6 +943,21 @@ func (s *Sheet) DeleteRow(y int) {
 }
 
 func (s *Sheet) DeleteCol(x int) {
+	if x < s.Size.X+s.Size.Width {
+		s.Size.Width--
+	}
+	shiftLines(s.hiddenCols, x, -1)
+	shiftSizes(s.colSizes, x, -1)
+	shiftFrozen(&s.FrozenCols, x, -1)
+	s.shiftStyles(true, x, -1)
+	s.shiftCondRules(true, x, -1)
+	s.shiftValidations(true, x, -1)
+	s.shiftMerges(true, x, -1)
+	if s.Filter.deleteCol(x) {
+		// all columns of the filter are deleted
+		s.Filter = nil
+		s.filteredRows = make(map[int]bool)
+	}
 	for _, segment := range s.Segments {
 		size := segment.Size()
 		if segment.ContainsX(x) {
@@ -2,6 +2,8 @@ package sheet
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestAddXSegment(t *testing.T) {
@@ -29,3 +31,128 @@ func TestAddXSegment(t *testing.T) {
 	//	assert.Equalf(t, c.castedValue, castedValue, "case %s", c.value)
 	//}
 }
+
+func TestSelectedRect(t *testing.T) {
+	s := New(0, "Sheet1")
+	s.Size = Rect{0, 0, 5, 10}
+	s.Cursor = Cursor{2, 3}
+	assert.Equal(t, Rect{2, 3, 1, 1}, s.SelectedRect())
+
+	s.Select(SelectionBlock)
+	s.Cursor = Cursor{1, 5}
+	assert.Equal(t, Rect{1, 3, 2, 3}, s.SelectedRect())
+	s.Cursor = Cursor{4, 0}
+	assert.Equal(t, Rect{2, 0, 3, 4}, s.SelectedRect())
+
+	s.Select(SelectionRows)
+	s.Cursor = Cursor{0, 1}
+	assert.Equal(t, Rect{0, 0, 5, 2}, s.SelectedRect())
+	assert.True(t, s.IsSelected())
+
+	s.Unselect()
+	assert.False(t, s.IsSelected())
+	assert.Equal(t, Rect{0, 1, 1, 1}, s.SelectedRect())
+
+	s.Select(SelectionCols)
+	s.Cursor = Cursor{3, 1}
+	assert.Equal(t, Rect{0, 0, 4, 10}, s.SelectedRect())
+}
+
+func TestRectIntersect(t *testing.T) {
+	r := Rect{1, 1, 3, 3}
+	assert.Equal(t, Rect{2, 2, 2, 2}, r.Intersect(Rect{2, 2, 5, 5}))
+	assert.Equal(t, Rect{1, 1, 3, 3}, r.Intersect(Rect{0, 0, 10, 10}))
+	assert.Equal(t, Rect{}, r.Intersect(Rect{4, 0, 1, 10}))
+	assert.True(t, r.Contains(3, 1))
+	assert.False(t, r.Contains(4, 1))
+}
+
+func TestFreeze(t *testing.T) {
+	s := New(0, "Sheet1")
+	s.Viewport = Viewport{Left: 0, Top: 5}
+	s.Freeze(1, 2)
+	assert.Equal(t, 2, s.FrozenRows)
+	assert.Equal(t, 1, s.FrozenCols)
+	assert.Equal(t, Viewport{Left: 1, Top: 5}, s.Viewport)
+
+	s.InsertEmptyRow(1)
+	s.InsertEmptyRow(3)
+	assert.Equal(t, 3, s.FrozenRows)
+	s.InsertEmptyCol(0)
+	assert.Equal(t, 2, s.FrozenCols)
+
+	v := s.Visibility()
+	s.DeleteRow(0)
+	s.DeleteCol(5)
+	assert.Equal(t, 2, s.FrozenRows)
+	assert.Equal(t, 2, s.FrozenCols)
+	s.SetVisibility(v)
+	assert.Equal(t, 3, s.FrozenRows)
+
+	s.Freeze(0, 0)
+	assert.Equal(t, 0, s.FrozenRows)
+	assert.Equal(t, 0, s.FrozenCols)
+}
+
+func TestSetStyle(t *testing.T) {
+	s := New(0, "Sheet1")
+	bold := func(st *Style) { st.Bold = true }
+	s.SetStyle(Rect{0, 0, 3, 3}, bold)
+	s.SetStyle(Rect{1, 1, 3, 3}, func(st *Style) { st.Fg = "#ff0000" })
+	assert.Equal(t, Style{Bold: true}, s.CellStyle(0, 0))
+	assert.Equal(t, Style{Bold: true, Fg: "#ff0000"}, s.CellStyle(2, 2))
+	assert.Equal(t, Style{Fg: "#ff0000"}, s.CellStyle(3, 3))
+	assert.Equal(t, Style{}, s.CellStyle(0, 3))
+
+	// ranges of the same style are joined back
+	s.SetStyle(Rect{0, 0, 4, 4}, func(st *Style) { *st = Style{} })
+	assert.Empty(t, s.Styles())
+	for y := 0; y < 4; y++ {
+		s.SetStyle(Rect{0, y, 2, 1}, bold)
+	}
+	assert.Equal(t, []StyleRange{{Rect{0, 0, 2, 4}, Style{Bold: true}}}, s.Styles())
+}
+
+func TestStylesOnLineChange(t *testing.T) {
+	s := New(0, "Sheet1")
+	s.SetStyle(Rect{1, 1, 2, 2}, func(st *Style) { st.Italic = true })
+	s.InsertEmptyRow(2)
+	assert.Equal(t, []StyleRange{{Rect{1, 1, 2, 3}, Style{Italic: true}}}, s.Styles())
+	s.InsertEmptyCol(0)
+	assert.Equal(t, []StyleRange{{Rect{2, 1, 2, 3}, Style{Italic: true}}}, s.Styles())
+	s.DeleteRow(1)
+	s.DeleteRow(1)
+	s.DeleteCol(3)
+	assert.Equal(t, []StyleRange{{Rect{2, 1, 1, 1}, Style{Italic: true}}}, s.Styles())
+	s.DeleteRow(1)
+	assert.Empty(t, s.Styles())
+}
+
+func TestMerge(t *testing.T) {
+	s := New(0, "test")
+	s.Merge(Rect{X: 0, Y: 0, Width: 3, Height: 1})
+	m, ok := s.MergeAt(2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, Rect{X: 0, Y: 0, Width: 3, Height: 1}, m)
+	_, ok = s.MergeAt(0, 1)
+	assert.False(t, ok)
+
+	// the region intersecting the new one is merged into it
+	assert.Equal(t, Rect{X: 0, Y: 0, Width: 3, Height: 2}, s.Merge(Rect{X: 2, Y: 0, Width: 1, Height: 2}))
+	assert.Len(t, s.Merges(), 1)
+
+	s.InsertEmptyCol(1)
+	assert.Equal(t, []Rect{{X: 0, Y: 0, Width: 4, Height: 2}}, s.Merges())
+	s.InsertEmptyRow(0)
+	assert.Equal(t, []Rect{{X: 0, Y: 1, Width: 4, Height: 2}}, s.Merges())
+	s.DeleteRow(1)
+	assert.Equal(t, []Rect{{X: 0, Y: 1, Width: 4, Height: 1}}, s.Merges())
+
+	// the region of a single cell is not merged any more
+	s.Merge(Rect{X: 5, Y: 5, Width: 2, Height: 1})
+	s.DeleteCol(6)
+	assert.Len(t, s.Merges(), 1)
+
+	s.Unmerge(Rect{X: 3, Y: 1, Width: 1, Height: 1})
+	assert.Empty(t, s.Merges())
+}
@@ -0,0 +1,208 @@
+package sheet
+
+import (
+	"sort"
+)
+
+// Оформление ячеек. Хранится не в ячейках, а списком непересекающихся прямоугольников
+// с одинаковым оформлением, так что оформление целой колонки или пустых ячеек ничего
+// не стоит. Изменение оформления прямоугольника разрезает задетые им прямоугольники,
+// после чего соседние прямоугольники с одинаковым оформлением снова объединяются.
+
+// Горизонтальное выравнивание. Общее выравнивание прижимает числа вправо, а текст влево.
+const (
+	AlignGeneral = iota
+	AlignLeft
+	AlignCenter
+	AlignRight
+)
+
+// Границы ячейки.
+const (
+	BorderLeft = 1 << iota
+	BorderRight
+	BorderTop
+	BorderBottom
+)
+
+// Оформление ячейки. Цвета хранятся в виде "#rrggbb", пустая строка означает цвет
+// по умолчанию. Нулевое значение - оформление по умолчанию.
+type Style struct {
+	Fg        string
+	Bg        string
+	Bold      bool
+	Italic    bool
+	Underline bool
+	Align     int
+	Wrap      bool
+	Borders   int
+}
+
+// Прямоугольник ячеек с одинаковым оформлением.
+type StyleRange struct {
+	Rect  Rect
+	Style Style
+}
+
+// Overlay returns the style with the attributes set in other style replacing its own.
+func (st Style) Overlay(other Style) Style {
+	if other.Fg != "" {
+		st.Fg = other.Fg
+	}
+	if other.Bg != "" {
+		st.Bg = other.Bg
+	}
+	st.Bold = st.Bold || other.Bold
+	st.Italic = st.Italic || other.Italic
+	st.Underline = st.Underline || other.Underline
+	if other.Align != AlignGeneral {
+		st.Align = other.Align
+	}
+	st.Wrap = st.Wrap || other.Wrap
+	st.Borders |= other.Borders
+	return st
+}
+
+// Subtract returns the parts of rect which do not belong to other rect.
+func (r Rect) Subtract(other Rect) []Rect {
+	i := r.Intersect(other)
+	if i.Width == 0 {
+		return []Rect{r}
+	}
+	var parts []Rect
+	add := func(x, y, maxX, maxY int) {
+		if maxX >= x && maxY >= y {
+			parts = append(parts, Rect{X: x, Y: y, Width: maxX - x + 1, Height: maxY - y + 1})
+		}
+	}
+	add(r.X, r.Y, r.MaxX(), i.Y-1)
+	add(r.X, i.Y, i.X-1, i.MaxY())
+	add(i.MaxX()+1, i.Y, r.MaxX(), i.MaxY())
+	add(r.X, i.MaxY()+1, r.MaxX(), r.MaxY())
+	return parts
+}
+
+// CellStyle returns the style of the cell.
+func (s *Sheet) CellStyle(x, y int) Style {
+	for i := range s.styles {
+		if s.styles[i].Rect.Contains(x, y) {
+			return s.styles[i].Style
+		}
+	}
+	return Style{}
+}
+
+// SetStyle changes the style of all the cells of rect r with the function.
+func (s *Sheet) SetStyle(r Rect, change func(st *Style)) {
+	var styles []StyleRange
+	uncovered := []Rect{r}
+	for _, sr := range s.styles {
+		i := sr.Rect.Intersect(r)
+		if i.Width == 0 {
+			styles = append(styles, sr)
+			continue
+		}
+		for _, part := range sr.Rect.Subtract(r) {
+			styles = append(styles, StyleRange{Rect: part, Style: sr.Style})
+		}
+		st := sr.Style
+		change(&st)
+		styles = append(styles, StyleRange{Rect: i, Style: st})
+		var rest []Rect
+		for _, u := range uncovered {
+			rest = append(rest, u.Subtract(i)...)
+		}
+		uncovered = rest
+	}
+	var st Style
+	change(&st)
+	for _, u := range uncovered {
+		styles = append(styles, StyleRange{Rect: u, Style: st})
+	}
+	s.SetStyles(styles)
+}
+
+// Styles returns the copy of the style ranges of the sheet.
+func (s *Sheet) Styles() []StyleRange {
+	return append([]StyleRange(nil), s.styles...)
+}
+
+// SetStyles replaces the style ranges of the sheet. The ranges must not intersect,
+// adjacent ranges of the same style are joined.
+func (s *Sheet) SetStyles(styles []StyleRange) {
+	s.styles = nil
+	for _, sr := range styles {
+		if sr.Style != (Style{}) && sr.Rect.Width > 0 && sr.Rect.Height > 0 {
+			s.styles = append(s.styles, sr)
+		}
+	}
+	s.joinStyles(true)
+	s.joinStyles(false)
+}
+
+// Объединяет прямоугольники одного оформления, которые соседствуют по вертикали
+// (или по горизонтали) и имеют одинаковую ширину (высоту).
+func (s *Sheet) joinStyles(vertical bool) {
+	key := func(r Rect) (int, int, int) {
+		if vertical {
+			return r.X, r.Width, r.Y
+		}
+		return r.Y, r.Height, r.X
+	}
+	sort.Slice(s.styles, func(i, j int) bool {
+		a1, a2, a3 := key(s.styles[i].Rect)
+		b1, b2, b3 := key(s.styles[j].Rect)
+		if a1 != b1 {
+			return a1 < b1
+		}
+		if a2 != b2 {
+			return a2 < b2
+		}
+		return a3 < b3
+	})
+	var joined []StyleRange
+	for _, sr := range s.styles {
+		if n := len(joined); n > 0 && joined[n-1].Style == sr.Style {
+			last := &joined[n-1].Rect
+			if vertical && last.X == sr.Rect.X && last.Width == sr.Rect.Width && last.MaxY()+1 == sr.Rect.Y {
+				last.Height += sr.Rect.Height
+				continue
+			}
+			if !vertical && last.Y == sr.Rect.Y && last.Height == sr.Rect.Height && last.MaxX()+1 == sr.Rect.X {
+				last.Width += sr.Rect.Width
+				continue
+			}
+		}
+		joined = append(joined, sr)
+	}
+	s.styles = joined
+}
+
+// Сдвигает оформление при вставке (delta = 1) или удалении (delta = -1) строки
+// или колонки N.
+func (s *Sheet) shiftStyles(cols bool, n, delta int) {
+	var styles []StyleRange
+	for _, sr := range s.styles {
+		if shiftRect(&sr.Rect, cols, n, delta) {
+			styles = append(styles, sr)
+		}
+	}
+	s.styles = styles
+}
+
+// Сдвигает прямоугольник при вставке (delta = 1) или удалении (delta = -1) строки
+// или колонки N. Вставленная внутрь прямоугольника линия расширяет его. Возвращает false,
+// если от прямоугольника ничего не осталось.
+func shiftRect(r *Rect, cols bool, n, delta int) bool {
+	pos, size := &r.Y, &r.Height
+	if cols {
+		pos, size = &r.X, &r.Width
+	}
+	switch {
+	case *pos > n || *pos == n && delta > 0:
+		*pos += delta
+	case *pos+*size > n:
+		*size += delta
+	}
+	return *size > 0
+}
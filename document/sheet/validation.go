@@ -0,0 +1,148 @@
+package sheet
+
+import (
+	"xl/document/eval"
+)
+
+// Проверка вводимых значений. Правило проверки привязано к прямоугольнику, у ячейки
+// может быть только одно правило: новое правило вытесняет прежние из своего
+// прямоугольника. Как и правила условного форматирования, правила проверки сами
+// значения не вычисляют, этим занимается документ.
+
+// Виды правил проверки.
+const (
+	// Число, целое, если задано Integer, в пределах Min и Max.
+	ValidateNumber = iota
+	// Одно из значений Values или значений ячеек прямоугольника Source листа.
+	ValidateList
+	// Дата в пределах Min и Max.
+	ValidateDate
+	// Текст, длина которого в пределах Min и Max.
+	ValidateLength
+	// Формула в Formula истинна. Формула записана для левой верхней ячейки прямоугольника,
+	// для остальных ячеек относительные ссылки сдвигаются, как при копировании.
+	ValidateFormula
+)
+
+type Validation struct {
+	Kind int
+	Rect Rect
+	// Границы, пустая граница не ограничивает значение.
+	Min     string
+	Max     string
+	Integer bool
+	Values  []string
+	Source  Rect
+	Formula string
+	// Недопустимое значение только вызывает предупреждение, но сохраняется.
+	Warning bool
+}
+
+// Validation returns the validation rule of the cell, or nil if the cell has none.
+func (s *Sheet) Validation(x, y int) *Validation {
+	for i := range s.validations {
+		if s.validations[i].Rect.Contains(x, y) {
+			v := s.validations[i]
+			return &v
+		}
+	}
+	return nil
+}
+
+// Validations returns the copy of the validation rules of the sheet.
+func (s *Sheet) Validations() []Validation {
+	return append([]Validation(nil), s.validations...)
+}
+
+// SetValidations replaces the validation rules of the sheet.
+func (s *Sheet) SetValidations(rules []Validation) {
+	s.validations = append([]Validation(nil), rules...)
+}
+
+// SetValidation makes the rule the only validation of the cells of rect r, nil removes
+// the validation of the cells. Remaining parts of the rules which applied to the cells
+// keep checking their cells, their formulas are moved to the new top left cells.
+func (s *Sheet) SetValidation(ec *eval.Context, r Rect, rule *Validation) {
+	var rules []Validation
+	for _, v := range s.validations {
+		if v.Rect.Intersect(r).Width == 0 {
+			rules = append(rules, v)
+			continue
+		}
+		for _, part := range v.Rect.Subtract(r) {
+			p := v
+			p.Rect = part
+			if v.Kind == ValidateFormula {
+				p.Formula = OffsetRawValue(ec, v.Formula, part.X-v.Rect.X, part.Y-v.Rect.Y)
+			}
+			rules = append(rules, p)
+		}
+	}
+	if rule != nil {
+		v := *rule
+		v.Rect = r
+		rules = append(rules, v)
+	}
+	s.validations = rules
+}
+
+// AdjustValidationRefs corrects references in the formulas of the validation rules after
+// a line of the sheet with sheetIdx is inserted or deleted. The formula of the rule which
+// loses its first line is moved to the new top left cell. Reports whether any formula
+// has changed.
+func (s *Sheet) AdjustValidationRefs(ec *eval.Context, change, sheetIdx, n int) bool {
+	return s.changeValidationFormulas(func(f string, rect Rect) (string, bool) {
+		return adjustRuleFormula(ec, f, rect, change, sheetIdx, n)
+	})
+}
+
+// RenameValidationRefs updates formulas of the validation rules referring to the renamed
+// sheet, so they show the new title. It must be called before the sheet gets the title.
+// Reports whether any formula has changed.
+func (s *Sheet) RenameValidationRefs(ec *eval.Context, renamed *Sheet, title string) bool {
+	return s.changeValidationFormulas(func(f string, rect Rect) (string, bool) {
+		return renameFormula(ec, f, renamed, title)
+	})
+}
+
+// InvalidateValidationRefs makes references in the formulas of the validation rules to
+// the sheet with sheetIdx invalid before the sheet is deleted. Reports whether any formula
+// has changed.
+func (s *Sheet) InvalidateValidationRefs(ec *eval.Context, sheetIdx int) bool {
+	return s.changeValidationFormulas(func(f string, rect Rect) (string, bool) {
+		return changeFormula(ec, f, invalidateOn(sheetIdx))
+	})
+}
+
+// Заменяет формулы правил проверки результатом change, который получает формулу
+// и прямоугольник правила. Сообщает, изменилась ли хотя бы одна формула.
+func (s *Sheet) changeValidationFormulas(change func(f string, rect Rect) (string, bool)) bool {
+	changed := false
+	for i := range s.validations {
+		v := &s.validations[i]
+		if v.Kind != ValidateFormula {
+			continue
+		}
+		if f, ok := change(v.Formula, v.Rect); ok {
+			v.Formula = f
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Сдвигает прямоугольники правил и ячейки списков допустимых значений при вставке
+// (delta = 1) или удалении (delta = -1) строки или колонки N. Правила, от прямоугольников
+// которых ничего не осталось, удаляются.
+func (s *Sheet) shiftValidations(cols bool, n, delta int) {
+	var rules []Validation
+	for _, v := range s.validations {
+		if v.Source.Width > 0 && !shiftRect(&v.Source, cols, n, delta) {
+			v.Source = Rect{}
+		}
+		if shiftRect(&v.Rect, cols, n, delta) {
+			rules = append(rules, v)
+		}
+	}
+	s.validations = rules
+}
@@ -0,0 +1,241 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/sheet"
+
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// Сортировка строк диапазона по значениям ключевых колонок. Строки переставляются
+// физически, а Ссылки на ячейки переставленных строк (в том числе из самих этих строк)
+// перемещаются вслед за ними, так что формулы продолжают указывать на те же данные.
+//
+// Как и в других табличных редакторах, при сортировке по возрастанию числа идут перед
+// текстом, текст перед логическими значениями, а те перед ошибками. По убыванию порядок
+// обратный. Пустые ячейки всегда оказываются в конце.
+
+// Способы сравнения значений при сортировке.
+const (
+	// Текст сравнивается без учета регистра, а числа внутри текста - по значению,
+	// так что "item2" идет перед "item10".
+	CollationNatural = iota
+	// Текст, похожий на число, сравнивается как число.
+	CollationNumeric
+	// Текст сравнивается по правилам языка SortOptions.Locale.
+	CollationLocale
+)
+
+// Ключ сортировки: колонка листа и направление.
+type SortKey struct {
+	Col  int
+	Desc bool
+}
+
+type SortOptions struct {
+	Keys []SortKey
+	// Первая строка диапазона является заголовком и не сортируется.
+	Header    bool
+	Collation int
+	// Язык для CollationLocale в виде BCP 47, например "ru" или "de-DE".
+	Locale string
+}
+
+// Виды значений в порядке сортировки по возрастанию.
+const (
+	sortKindNumber = iota
+	sortKindText
+	sortKindBool
+	sortKindError
+	sortKindBlank
+)
+
+type sortValue struct {
+	kind int
+	num  decimal.Decimal
+	str  string
+}
+
+// Sort reorders rows of the rect on the sheet by values of the key columns.
+// References to the moved cells follow them.
+func (d *Document) Sort(s *sheet.Sheet, r sheet.Rect, opts SortOptions) error {
+	if len(opts.Keys) == 0 {
+		return eval.NewError(eval.ErrorKindName, "no sort keys")
+	}
+	for _, k := range opts.Keys {
+		if k.Col < r.X || k.Col > r.MaxX() {
+			return eval.NewError(eval.ErrorKindRef, "sort key column %s is out of range", ColName(k.Col))
+		}
+	}
+	var coll *collate.Collator
+	if opts.Collation == CollationLocale {
+		tag, err := language.Parse(opts.Locale)
+		if err != nil {
+			return eval.NewError(eval.ErrorKindName, "unknown locale %s", opts.Locale)
+		}
+		coll = collate.New(tag, collate.IgnoreCase)
+	}
+	if opts.Header {
+		r.Y, r.Height = r.Y+1, r.Height-1
+	}
+	if r.Height < 2 {
+		return nil
+	}
+
+	ec := eval.NewContext(d, s.Idx)
+	values := make([][]sortValue, r.Height)
+	order := make([]int, r.Height)
+	for i := range values {
+		order[i] = i
+		values[i] = make([]sortValue, len(opts.Keys))
+		for j, k := range opts.Keys {
+			values[i][j] = newSortValue(ec, s.Cell(k.Col, r.Y+i), opts.Collation)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := values[order[i]], values[order[j]]
+		for k, key := range opts.Keys {
+			c := compareSortValues(a[k], b[k], coll)
+			if c == 0 {
+				continue
+			}
+			if a[k].kind == sortKindBlank || b[k].kind == sortKindBlank {
+				// blanks are last in both directions
+				return c < 0
+			}
+			if key.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	rows := make(map[int]int)
+	for to, from := range order {
+		if to != from {
+			rows[r.Y+from] = r.Y + to
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	d.BeginGroup()
+	defer d.EndGroup()
+	// extrapolated cells can not follow the rows separately from each other
+	for _, rs := range d.Sheets {
+		if removed, added := rs.MaterializeXSegments(eval.NewContext(d, rs.Idx), s.Idx, r); len(removed) > 0 {
+			d.record(&segmentsAction{sheetIdx: rs.Idx, removed: removed, added: added})
+		}
+	}
+	d.record(d.moveRows(s.Idx, r, rows))
+	return nil
+}
+
+// newSortValue evaluates the cell for comparison.
+func newSortValue(ec *eval.Context, c *sheet.Cell, collation int) sortValue {
+	if c == nil || c.IsEmpty() {
+		return sortValue{kind: sortKindBlank}
+	}
+	v, err := c.Value(ec)
+	if err != nil {
+		return sortValue{kind: sortKindError, str: err.Error()}
+	}
+	switch v.Type() {
+	case eval.TypeEmpty:
+		return sortValue{kind: sortKindBlank}
+	case eval.TypeBool:
+		b, _ := v.BoolValue(ec)
+		if b {
+			return sortValue{kind: sortKindBool, str: "1"}
+		}
+		return sortValue{kind: sortKindBool, str: "0"}
+	case eval.TypeDecimal:
+		n, _ := v.DecimalValue(ec)
+		return sortValue{kind: sortKindNumber, num: n}
+	}
+	str, err := c.StringValue(ec)
+	if err != nil {
+		return sortValue{kind: sortKindError, str: err.Error()}
+	}
+	if v.Type() != eval.TypeString || collation == CollationNumeric {
+		// value of reference or numeric text
+		if n, err := decimal.NewFromString(strings.TrimSpace(str)); err == nil {
+			return sortValue{kind: sortKindNumber, num: n}
+		}
+	}
+	if str == "" {
+		return sortValue{kind: sortKindBlank}
+	}
+	return sortValue{kind: sortKindText, str: str}
+}
+
+// compareSortValues returns -1, 0 or 1 if a goes before, together with or after b in
+// ascending order. Text is compared with the collator if it is given.
+func compareSortValues(a, b sortValue, coll *collate.Collator) int {
+	switch {
+	case a.kind != b.kind:
+		if a.kind < b.kind {
+			return -1
+		}
+		return 1
+	case a.kind == sortKindNumber:
+		return a.num.Cmp(b.num)
+	case a.kind == sortKindText && coll != nil:
+		return coll.CompareString(a.str, b.str)
+	case a.kind == sortKindText:
+		return naturalCompare(a.str, b.str)
+	}
+	return strings.Compare(a.str, b.str)
+}
+
+// naturalCompare compares strings ignoring case, numbers within the strings are compared by value.
+func naturalCompare(a, b string) int {
+	la, lb := strings.ToLower(a), strings.ToLower(b)
+	for la != "" && lb != "" {
+		ca, cb := naturalChunk(la), naturalChunk(lb)
+		la, lb = la[len(ca):], lb[len(cb):]
+		if isDigit(ca[0]) && isDigit(cb[0]) {
+			na, nb := strings.TrimLeft(ca, "0"), strings.TrimLeft(cb, "0")
+			if len(na) != len(nb) {
+				if len(na) < len(nb) {
+					return -1
+				}
+				return 1
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			continue
+		}
+		if c := strings.Compare(ca, cb); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case la != "":
+		return 1
+	case lb != "":
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// naturalChunk returns leading digits or leading non-digits of the string.
+func naturalChunk(s string) string {
+	digits := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+	return s[:i]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,96 @@
+package document
+
+import (
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSortTestDoc() *Document {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	rows := [][]string{
+		{"Name", "Score", "Ten times"},
+		{"item10", "3", "=B2*10"},
+		{"item2", "1", "=B3*10"},
+		{"Item1", "", "=B4*10"},
+		{"b", "2", "=B5*10"},
+	}
+	for y, row := range rows {
+		for x, v := range row {
+			s.SetCell(x, y, sheet.NewCellUntyped(v))
+		}
+	}
+	s.SetCell(4, 0, sheet.NewCellUntyped("=B3"))
+	s.SetCell(5, 0, sheet.NewCellUntyped("=SUM(B2:B5)"))
+	return d
+}
+
+func columnValues(t *testing.T, d *Document, x int) []string {
+	var values []string
+	for y := 1; y < 5; y++ {
+		values = append(values, cellValue(t, d, x, y))
+	}
+	return values
+}
+
+func TestSort(t *testing.T) {
+	d := newSortTestDoc()
+	s := d.CurrentSheet
+	r := sheet.Rect{X: 0, Y: 0, Width: 3, Height: 5}
+	assert.NoError(t, d.Sort(s, r, SortOptions{Keys: []SortKey{{Col: 1}}, Header: true}))
+	assert.Equal(t, "Name", cellValue(t, d, 0, 0))
+	assert.Equal(t, []string{"item2", "b", "item10", "Item1"}, columnValues(t, d, 0))
+	assert.Equal(t, []string{"10", "20", "30", "0"}, columnValues(t, d, 2))
+	assert.Equal(t, "=B2*10", d.CellRawValue(s, 2, 1))
+	// references follow the moved data
+	assert.Equal(t, "=B2", d.CellRawValue(s, 4, 0))
+	assert.Equal(t, "1", cellValue(t, d, 4, 0))
+	assert.Equal(t, "=SUM(B2:B5)", d.CellRawValue(s, 5, 0))
+
+	assert.True(t, d.Undo())
+	assert.Equal(t, []string{"item10", "item2", "Item1", "b"}, columnValues(t, d, 0))
+	assert.Equal(t, "=B3", d.CellRawValue(s, 4, 0))
+	assert.Equal(t, "=B3*10", d.CellRawValue(s, 2, 2))
+
+	// blanks stay last in descending order
+	assert.NoError(t, d.Sort(s, r, SortOptions{Keys: []SortKey{{Col: 1, Desc: true}}, Header: true}))
+	assert.Equal(t, []string{"item10", "b", "item2", "Item1"}, columnValues(t, d, 0))
+
+	assert.NoError(t, d.Sort(s, r, SortOptions{Keys: []SortKey{{Col: 0}}, Header: true}))
+	assert.Equal(t, []string{"b", "Item1", "item2", "item10"}, columnValues(t, d, 0))
+
+	assert.Error(t, d.Sort(s, r, SortOptions{Keys: []SortKey{{Col: 4}}}))
+}
+
+func TestSortCollation(t *testing.T) {
+	values := func(opts SortOptions, raw ...string) []string {
+		d := NewWithEmptySheet()
+		for y, v := range raw {
+			d.CurrentSheet.SetCell(0, y, sheet.NewCellUntyped(v))
+		}
+		opts.Keys = []SortKey{{Col: 0}}
+		assert.NoError(t, d.Sort(d.CurrentSheet, sheet.Rect{Width: 1, Height: len(raw)}, opts))
+		var res []string
+		for y := range raw {
+			res = append(res, cellValue(t, d, 0, y))
+		}
+		return res
+	}
+	assert.Equal(t, []string{"2", "10", " 3", "x"}, values(SortOptions{}, "x", "10", " 3", "2"))
+	assert.Equal(t, []string{"2", " 3", "10", "x"}, values(SortOptions{Collation: CollationNumeric}, "x", "10", " 3", "2"))
+	assert.Equal(t, []string{"öl", "zebra"}, values(SortOptions{Collation: CollationLocale, Locale: "en"}, "zebra", "öl"))
+	assert.Equal(t, []string{"zebra", "öl"}, values(SortOptions{Collation: CollationLocale, Locale: "sv"}, "zebra", "öl"))
+}
+
+func TestSortMaterializesXSegment(t *testing.T) {
+	d := newSortTestDoc()
+	s := d.CurrentSheet
+	d.SetCellValue(s, 6, 1, "=A2")
+	d.Fill(s, sheet.Rect{X: 6, Y: 1, Width: 1, Height: 4}, FillDown)
+	assert.NoError(t, d.Sort(s, sheet.Rect{X: 0, Y: 1, Width: 3, Height: 4}, SortOptions{Keys: []SortKey{{Col: 1}}}))
+	assert.Equal(t, []string{"item10", "item2", "Item1", "b"}, columnValues(t, d, 6))
+	assert.Equal(t, "=A4", s.Cell(6, 1).RawValue())
+}
@@ -0,0 +1,16 @@
+package document
+
+import (
+	"xl/document/sheet"
+)
+
+// Оформление ячеек. Хранится листом по прямоугольникам, изменение записывается в журнал
+// как прежний и новый набор прямоугольников листа.
+
+// SetStyle changes the style of the cells of rect r of the sheet with the function.
+func (d *Document) SetStyle(s *sheet.Sheet, r sheet.Rect, change func(st *sheet.Style)) {
+	a := &styleAction{sheetIdx: s.Idx, rect: r, before: s.Styles()}
+	s.SetStyle(r, change)
+	a.after = s.Styles()
+	d.record(a)
+}
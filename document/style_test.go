@@ -0,0 +1,35 @@
+package document
+
+import (
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetStyle(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	d.SetStyle(s, sheet.Rect{X: 0, Y: 0, Width: 2, Height: 2}, func(st *sheet.Style) { st.Align = sheet.AlignCenter })
+	d.SetStyle(s, sheet.Rect{X: 1, Y: 1, Width: 1, Height: 1}, func(st *sheet.Style) { st.Bg = "#0000ff" })
+	assert.Equal(t, sheet.Style{Align: sheet.AlignCenter, Bg: "#0000ff"}, s.CellStyle(1, 1))
+	assert.True(t, d.Undo())
+	assert.Equal(t, sheet.Style{Align: sheet.AlignCenter}, s.CellStyle(1, 1))
+	assert.True(t, d.Undo())
+	assert.Equal(t, sheet.Style{}, s.CellStyle(0, 0))
+	assert.True(t, d.Redo())
+	assert.Equal(t, sheet.Style{Align: sheet.AlignCenter}, s.CellStyle(0, 1))
+}
+
+func TestDeleteRowKeepsStyleForUndo(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	d.SetCellValue(s, 0, 0, "a")
+	d.SetStyle(s, sheet.Rect{X: 0, Y: 0, Width: 1, Height: 1}, func(st *sheet.Style) { st.Bold = true })
+	s.Cursor.Y = 0
+	d.DeleteRow()
+	assert.Equal(t, sheet.Style{}, s.CellStyle(0, 0))
+	assert.True(t, d.Undo())
+	assert.Equal(t, sheet.Style{Bold: true}, s.CellStyle(0, 0))
+}
@@ -0,0 +1,242 @@
+package document
+
+import (
+	"xl/document/eval"
+	"xl/document/sheet"
+
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shopspring/decimal"
+)
+
+// Проверка вводимых значений. Правила хранит лист, документ проверяет по ним значения
+// ячеек. Пустые ячейки допустимы всегда. Значение, не прошедшее проверку, не сохраняется,
+// если только правило не ограничивается предупреждением.
+
+// Даты сравниваются как числа дней, прошедших с 30 декабря 1899 года, как в Excel.
+var dateEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// ValidationError describes the value breaking the validation rule of the cell.
+type ValidationError struct {
+	Rule  sheet.Validation
+	Value string
+}
+
+func (e *ValidationError) Error() string {
+	r := e.Rule
+	switch r.Kind {
+	case sheet.ValidateNumber:
+		if r.Integer {
+			return "value must be a whole number" + describeBounds(r)
+		}
+		return "value must be a number" + describeBounds(r)
+	case sheet.ValidateList:
+		return fmt.Sprintf("value %s is not in the list", e.Value)
+	case sheet.ValidateDate:
+		return "value must be a date" + describeBounds(r)
+	case sheet.ValidateLength:
+		return "text length must be" + describeBounds(r)
+	default:
+		return fmt.Sprintf("value %s does not satisfy %s", e.Value, r.Formula)
+	}
+}
+
+// describeBounds returns the limits of the rule for the error message.
+func describeBounds(r sheet.Validation) string {
+	switch {
+	case r.Min != "" && r.Max != "":
+		return fmt.Sprintf(" between %s and %s", r.Min, r.Max)
+	case r.Min != "":
+		return " at least " + r.Min
+	case r.Max != "":
+		return " at most " + r.Max
+	}
+	return ""
+}
+
+// SetValidation makes the rule the validation of the cells of rect r of the sheet,
+// nil removes the validation of the cells.
+func (d *Document) SetValidation(s *sheet.Sheet, r sheet.Rect, rule *sheet.Validation) error {
+	ec := eval.NewContext(d, s.Idx)
+	if rule != nil {
+		if err := d.checkValidation(ec, *rule); err != nil {
+			return err
+		}
+	}
+	a := &validationAction{sheetIdx: s.Idx, rect: r, before: s.Validations()}
+	s.SetValidation(ec, r, rule)
+	a.after = s.Validations()
+	d.record(a)
+	return nil
+}
+
+// checkValidation checks that the bounds and the formula of the rule make sense.
+func (d *Document) checkValidation(ec *eval.Context, rule sheet.Validation) error {
+	for _, bound := range []string{rule.Min, rule.Max} {
+		if bound == "" {
+			continue
+		}
+		parse := decimal.NewFromString
+		if rule.Kind == sheet.ValidateDate {
+			parse = parseDate
+		}
+		if _, err := parse(bound); err != nil {
+			return eval.NewError(eval.ErrorKindCasting, "invalid bound %s", bound)
+		}
+	}
+	if rule.Kind == sheet.ValidateFormula {
+		c := sheet.NewCellUntyped(rule.Formula)
+		if !sheet.IsFormula(rule.Formula) || c.Expression(ec) == nil {
+			return eval.NewError(eval.ErrorKindFormula, "malformed formula %s", rule.Formula)
+		}
+	}
+	return nil
+}
+
+// SetCellValueChecked sets the value of the cell like SetCellValue and checks it against
+// the validation rule of the cell. If the value breaks the rule, the error is returned
+// and the cell keeps its previous value, unless the rule is a warning only.
+func (d *Document) SetCellValueChecked(s *sheet.Sheet, x, y int, value string) error {
+	d.BeginGroup()
+	defer d.EndGroup()
+	mark, redo := len(d.journal.group), d.journal.redo
+	d.SetCellValue(s, x, y, value)
+	rule := s.Validation(x, y)
+	if rule == nil || d.isValid(eval.NewContext(d, s.Idx), s, rule, x, y) {
+		return nil
+	}
+	if !rule.Warning {
+		// отвергнутое значение не должно лишать возможности повторить отмененные шаги
+		d.rollback(mark)
+		d.journal.redo = redo
+	}
+	return &ValidationError{Rule: *rule, Value: value}
+}
+
+// IsCellValid reports whether the value of the cell satisfies its validation rule.
+func (d *Document) IsCellValid(s *sheet.Sheet, x, y int) bool {
+	rule := s.Validation(x, y)
+	return rule == nil || d.isValid(eval.NewContext(d, s.Idx), s, rule, x, y)
+}
+
+// InvalidCells returns the cells of the sheet breaking their validation rules.
+func (d *Document) InvalidCells(s *sheet.Sheet) []sheet.Cursor {
+	var cells []sheet.Cursor
+	ec := eval.NewContext(d, s.Idx)
+	bounds := sheet.Rect{Width: s.Size.X + s.Size.Width, Height: s.Size.Y + s.Size.Height}
+	for _, rule := range s.Validations() {
+		r := rule.Rect.Intersect(bounds)
+		if r.Width == 0 {
+			continue
+		}
+		for y := r.Y; y <= r.MaxY(); y++ {
+			for x := r.X; x <= r.MaxX(); x++ {
+				if !d.isValid(ec, s, &rule, x, y) {
+					cells = append(cells, sheet.Cursor{X: x, Y: y})
+				}
+			}
+		}
+	}
+	return cells
+}
+
+// ValidationChoices returns the allowed values of the cell having the list validation,
+// or nil for other cells.
+func (d *Document) ValidationChoices(s *sheet.Sheet, x, y int) []string {
+	rule := s.Validation(x, y)
+	if rule == nil || rule.Kind != sheet.ValidateList {
+		return nil
+	}
+	return d.listValues(eval.NewContext(d, s.Idx), s, rule)
+}
+
+// listValues returns the values of the list rule followed by the distinct values of its
+// source cells.
+func (d *Document) listValues(ec *eval.Context, s *sheet.Sheet, rule *sheet.Validation) []string {
+	values := append([]string(nil), rule.Values...)
+	seen := make(map[string]bool)
+	for _, v := range values {
+		seen[v] = true
+	}
+	src := rule.Source
+	for y := src.Y; y < src.Y+src.Height; y++ {
+		for x := src.X; x < src.X+src.Width; x++ {
+			if v := cellString(ec, s, x, y); v != "" && !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// isValid reports whether the value of the cell satisfies the rule. Empty cells
+// are always valid.
+func (d *Document) isValid(ec *eval.Context, s *sheet.Sheet, rule *sheet.Validation, x, y int) bool {
+	v := cellString(ec, s, x, y)
+	if v == "" {
+		return true
+	}
+	switch rule.Kind {
+	case sheet.ValidateNumber:
+		n, err := decimal.NewFromString(v)
+		if err != nil || rule.Integer && !n.Equal(n.Truncate(0)) {
+			return false
+		}
+		return inBounds(n, rule, decimal.NewFromString)
+	case sheet.ValidateDate:
+		n, err := parseDate(v)
+		return err == nil && inBounds(n, rule, parseDate)
+	case sheet.ValidateLength:
+		return inBounds(decimal.New(int64(utf8.RuneCountInString(v)), 0), rule, decimal.NewFromString)
+	case sheet.ValidateList:
+		for _, allowed := range d.listValues(ec, s, rule) {
+			if strings.EqualFold(allowed, v) {
+				return true
+			}
+		}
+		return false
+	case sheet.ValidateFormula:
+		key := sheet.NewCellUntyped(rule.Formula)
+		if key.Expression(ec) == nil {
+			return false
+		}
+		c := sheet.NewCellAsCopyWithOffset(key, x-rule.Rect.X, y-rule.Rect.Y)
+		ok, err := c.BoolValue(ec)
+		return err == nil && ok
+	}
+	return true
+}
+
+// inBounds reports whether the number lies within the bounds of the rule parsed
+// with the function.
+func inBounds(n decimal.Decimal, rule *sheet.Validation, parse func(string) (decimal.Decimal, error)) bool {
+	if rule.Min != "" {
+		if min, err := parse(rule.Min); err == nil && n.LessThan(min) {
+			return false
+		}
+	}
+	if rule.Max != "" {
+		if max, err := parse(rule.Max); err == nil && n.GreaterThan(max) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDate returns the number of days since the epoch for the date written in one
+// of the layouts used by fill, or for the number of days itself.
+func parseDate(v string) (decimal.Decimal, error) {
+	if n, err := decimal.NewFromString(v); err == nil {
+		return n, nil
+	}
+	for _, layout := range fillDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return decimal.New(int64(t.Sub(dateEpoch)/(24*time.Hour)), 0), nil
+		}
+	}
+	return decimal.Zero, eval.NewError(eval.ErrorKindCasting, "unable to cast %s to date", v)
+}
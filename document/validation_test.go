@@ -0,0 +1,147 @@
+package document
+
+import (
+	"xl/document/sheet"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCellValueChecked(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	col := sheet.Rect{X: 0, Y: 0, Width: 1, Height: 10}
+	assert.NoError(t, d.SetValidation(s, col, &sheet.Validation{Kind: sheet.ValidateNumber, Min: "1", Max: "10", Integer: true}))
+
+	assert.NoError(t, d.SetCellValueChecked(s, 0, 0, "5"))
+	err := d.SetCellValueChecked(s, 0, 0, "5.5")
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Equal(t, "value must be a whole number between 1 and 10", err.Error())
+	assert.Equal(t, "5", d.CellRawValue(s, 0, 0))
+	assert.Error(t, d.SetCellValueChecked(s, 0, 0, "abc"))
+	assert.Error(t, d.SetCellValueChecked(s, 0, 0, "11"))
+	// cells outside the rule and empty values are not checked
+	assert.NoError(t, d.SetCellValueChecked(s, 1, 0, "abc"))
+	assert.NoError(t, d.SetCellValueChecked(s, 0, 1, ""))
+
+	// rejected values are not in the history
+	for i := 0; i < 3; i++ {
+		assert.True(t, d.Undo())
+	}
+	assert.Equal(t, "", d.CellRawValue(s, 0, 0))
+	assert.Error(t, d.SetCellValueChecked(s, 0, 0, "0"))
+	assert.True(t, d.Redo())
+	assert.Equal(t, "5", d.CellRawValue(s, 0, 0))
+
+	// warning keeps the value
+	assert.NoError(t, d.SetValidation(s, col, &sheet.Validation{Kind: sheet.ValidateLength, Max: "3", Warning: true}))
+	assert.Error(t, d.SetCellValueChecked(s, 0, 2, "abcd"))
+	assert.Equal(t, "abcd", d.CellRawValue(s, 0, 2))
+	assert.False(t, d.IsCellValid(s, 0, 2))
+	assert.Equal(t, []sheet.Cursor{{X: 0, Y: 2}}, d.InvalidCells(s))
+
+	assert.Error(t, d.SetValidation(s, col, &sheet.Validation{Kind: sheet.ValidateNumber, Min: "abc"}))
+	assert.Error(t, d.SetValidation(s, col, &sheet.Validation{Kind: sheet.ValidateFormula, Formula: "=A1>"}))
+}
+
+func TestValidationList(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	for y, v := range []string{"red", "green", "red"} {
+		d.SetCellValue(s, 2, y, v)
+	}
+	r := sheet.Rect{X: 0, Y: 0, Width: 1, Height: 5}
+	rule := &sheet.Validation{Kind: sheet.ValidateList, Values: []string{"blue"}, Source: sheet.Rect{X: 2, Y: 0, Width: 1, Height: 3}}
+	assert.NoError(t, d.SetValidation(s, r, rule))
+	assert.Equal(t, []string{"blue", "red", "green"}, d.ValidationChoices(s, 0, 1))
+	assert.Nil(t, d.ValidationChoices(s, 1, 1))
+	assert.NoError(t, d.SetCellValueChecked(s, 0, 0, "Green"))
+	assert.Error(t, d.SetCellValueChecked(s, 0, 1, "black"))
+
+	// the source follows the inserted row
+	s.Cursor.Y = 0
+	d.InsertEmptyRow(0)
+	assert.Equal(t, sheet.Rect{X: 2, Y: 1, Width: 1, Height: 3}, s.Validations()[0].Source)
+	assert.Equal(t, []string{"blue", "red", "green"}, d.ValidationChoices(s, 0, 2))
+}
+
+func TestValidationFormulaAndDate(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	for y, v := range []string{"10", "20"} {
+		d.SetCellValue(s, 0, y, v)
+	}
+	col := sheet.Rect{X: 1, Y: 0, Width: 1, Height: 2}
+	assert.NoError(t, d.SetValidation(s, col, &sheet.Validation{Kind: sheet.ValidateFormula, Formula: "=B1<A1"}))
+	assert.NoError(t, d.SetCellValueChecked(s, 1, 1, "15"))
+	assert.Error(t, d.SetCellValueChecked(s, 1, 0, "15"))
+
+	// the rest of the rule keeps its formula for its own cells
+	assert.NoError(t, d.SetValidation(s, sheet.Rect{X: 1, Y: 0, Width: 1, Height: 1}, nil))
+	assert.Equal(t, []sheet.Validation{{Kind: sheet.ValidateFormula, Rect: sheet.Rect{X: 1, Y: 1, Width: 1, Height: 1}, Formula: "=B2<A2"}}, s.Validations())
+	s.Cursor.Y = 0
+	d.InsertEmptyRow(0)
+	assert.Equal(t, "=B3<A3", s.Validations()[0].Formula)
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=B2<A2", s.Validations()[0].Formula)
+	assert.True(t, d.Undo())
+	assert.Len(t, s.Validations(), 1)
+	assert.Equal(t, col, s.Validations()[0].Rect)
+
+	date := sheet.Rect{X: 3, Y: 0, Width: 1, Height: 1}
+	assert.NoError(t, d.SetValidation(s, date, &sheet.Validation{Kind: sheet.ValidateDate, Min: "2020-01-01", Max: "2020-12-31"}))
+	assert.NoError(t, d.SetCellValueChecked(s, 3, 0, "2020-03-15"))
+	assert.NoError(t, d.SetCellValueChecked(s, 3, 0, "15.03.2020"))
+	assert.Error(t, d.SetCellValueChecked(s, 3, 0, "2021-01-01"))
+	assert.Error(t, d.SetCellValueChecked(s, 3, 0, "tomorrow"))
+}
+
+func TestValidationSheetRefs(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	s2, _ := d.NewSheet("sh2")
+	d.SetCellValue(s2, 0, 0, "10")
+	col := sheet.Rect{X: 0, Y: 0, Width: 1, Height: 2}
+	assert.NoError(t, d.SetValidation(s, col, &sheet.Validation{Kind: sheet.ValidateFormula, Formula: "=A1<sh2!$A$1"}))
+
+	// the formula shows the new title of the sheet
+	assert.NoError(t, d.RenameSheet(s2, "other"))
+	assert.Equal(t, "=A1<other!$A$1", s.Validations()[0].Formula)
+	assert.NoError(t, d.SetCellValueChecked(s, 0, 0, "5"))
+	assert.Error(t, d.SetCellValueChecked(s, 0, 1, "15"))
+	assert.True(t, d.Undo())
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=A1<sh2!$A$1", s.Validations()[0].Formula)
+	assert.True(t, d.Redo())
+	assert.Equal(t, "=A1<other!$A$1", s.Validations()[0].Formula)
+
+	// references to the deleted sheet become invalid
+	assert.NoError(t, d.DeleteSheet(s2))
+	assert.Equal(t, "=A1<#REF!", s.Validations()[0].Formula)
+	assert.Error(t, d.SetCellValueChecked(s, 0, 0, "5"))
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=A1<other!$A$1", s.Validations()[0].Formula)
+	assert.NoError(t, d.SetCellValueChecked(s, 0, 0, "5"))
+}
+
+func TestValidationDeleteFirstLine(t *testing.T) {
+	d := NewWithEmptySheet()
+	s := d.CurrentSheet
+	for y, v := range []string{"10", "20", "30"} {
+		d.SetCellValue(s, 0, y, v)
+	}
+	col := sheet.Rect{X: 1, Y: 0, Width: 1, Height: 3}
+	assert.NoError(t, d.SetValidation(s, col, &sheet.Validation{Kind: sheet.ValidateFormula, Formula: "=B1<A1"}))
+
+	// the formula refers to the new top left cell of the rule
+	s.Cursor.Y = 0
+	d.DeleteRow()
+	assert.Equal(t, "=B1<A1", s.Validations()[0].Formula)
+	assert.NoError(t, d.SetCellValueChecked(s, 1, 1, "25"))
+	assert.Error(t, d.SetCellValueChecked(s, 1, 0, "25"))
+	assert.True(t, d.Undo())
+	assert.True(t, d.Undo())
+	assert.Equal(t, "=B1<A1", s.Validations()[0].Formula)
+	assert.Equal(t, col, s.Validations()[0].Rect)
+}
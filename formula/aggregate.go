@@ -0,0 +1,357 @@
+package formula
+
+import (
+	"xl/document/eval"
+
+	"math"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// Функции промежуточных итогов SUBTOTAL и AGGREGATE. Обе вычисляют одну из статистических
+// функций над диапазонами, пропуская скрытые строки и ячейки с другими промежуточными
+// итогами, чтобы итог таблицы не включал итоги ее частей. AGGREGATE дополнительно
+// умеет пропускать ошибки.
+
+// Значения диапазонов, над которыми вычисляется итог.
+type aggregateValues struct {
+	// Числа диапазонов и числовые аргументы.
+	numbers []decimal.Decimal
+	// Количество непустых значений.
+	count int
+}
+
+// Функция итога над собранными значениями. K - дополнительный аргумент функций
+// LARGE, SMALL, PERCENTILE и QUARTILE.
+type aggregateFunction func(v aggregateValues, k decimal.Decimal) (decimal.Decimal, error)
+
+// Функции по номерам, общим для SUBTOTAL и AGGREGATE. Номера 12 и больше есть только
+// у AGGREGATE, и функции с номерами 14 и больше принимают дополнительный аргумент.
+var aggregateFunctions = map[int]aggregateFunction{
+	1:  aggregateAverage,
+	2:  aggregateCount,
+	3:  aggregateCountA,
+	4:  aggregateMax,
+	5:  aggregateMin,
+	6:  aggregateProduct,
+	7:  aggregateStdev(1),
+	8:  aggregateStdev(0),
+	9:  aggregateSum,
+	10: aggregateVar(1),
+	11: aggregateVar(0),
+	12: aggregateMedian,
+	13: aggregateMode,
+	14: aggregateLarge,
+	15: aggregateSmall,
+	16: aggregatePercentile(false, 1),
+	17: aggregatePercentile(false, 4),
+	18: aggregatePercentile(true, 1),
+	19: aggregatePercentile(true, 4),
+}
+
+// Номер первой функции AGGREGATE, которая принимает дополнительный аргумент.
+const aggregateWithK = 14
+
+// SUBTOTAL [Math and trigonometry] Returns a subtotal in a list or database
+func subtotal(ec *eval.Context, args []eval.Value) (eval.Value, error) {
+	n, err := intArg(ec, args[0])
+	if err != nil {
+		return eval.NewEmptyValue(), err
+	}
+	// rows hidden by the filter are always skipped, 101-111 skip rows hidden by the user too
+	flags := eval.SkipFiltered | eval.SkipSubtotals
+	if n > 100 {
+		n -= 100
+		flags |= eval.SkipHidden
+	}
+	f, ok := aggregateFunctions[n]
+	if !ok || n >= 12 {
+		return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindFormula, "invalid function number %d", n)
+	}
+	return aggregateValue(ec, f, args[1:], flags, decimal.Zero)
+}
+
+// AGGREGATE [Math and trigonometry] Returns an aggregate in a list or database
+func aggregate(ec *eval.Context, args []eval.Value) (eval.Value, error) {
+	n, err := intArg(ec, args[0])
+	if err != nil {
+		return eval.NewEmptyValue(), err
+	}
+	f, ok := aggregateFunctions[n]
+	if !ok {
+		return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindFormula, "invalid function number %d", n)
+	}
+	option, err := intArg(ec, args[1])
+	if err != nil {
+		return eval.NewEmptyValue(), err
+	}
+	if option < 0 || option > 7 {
+		return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindFormula, "invalid option %d", option)
+	}
+	// options 0-3 skip nested subtotals, odd options skip hidden rows,
+	// options 2, 3, 6, 7 skip errors
+	flags := 0
+	if option < 4 {
+		flags |= eval.SkipSubtotals
+	}
+	if option%2 == 1 {
+		flags |= eval.SkipHidden | eval.SkipFiltered
+	}
+	if option%4 >= 2 {
+		flags |= eval.SkipErrors
+	}
+	refs := args[2:]
+	k := decimal.Zero
+	if n >= aggregateWithK {
+		if len(args) != 4 {
+			return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindFormula, "function %d requires array and k", n)
+		}
+		if k, err = args[3].DecimalValue(ec); err != nil {
+			return eval.NewEmptyValue(), err
+		}
+		refs = args[2:3]
+	}
+	return aggregateValue(ec, f, refs, flags, k)
+}
+
+// aggregateValue collects values of the arguments skipping cells by the flags and
+// evaluates the function over them.
+func aggregateValue(ec *eval.Context, f aggregateFunction, args []eval.Value, flags int, k decimal.Decimal) (eval.Value, error) {
+	var values aggregateValues
+	add := func(v eval.Value) error {
+		switch v.Type() {
+		case eval.TypeEmpty:
+		case eval.TypeDecimal:
+			d, _ := v.DecimalValue(ec)
+			values.numbers = append(values.numbers, d)
+			values.count++
+		default:
+			values.count++
+		}
+		return nil
+	}
+	for i := range args {
+		switch args[i].Type() {
+		case eval.TypeRef, eval.TypeRangeRef:
+			cell, cellTo := args[i].Cell().CellAddress, args[i].Cell().CellAddress
+			if args[i].Type() == eval.TypeRangeRef {
+				cellTo = args[i].CellTo().CellAddress
+			}
+			var err error
+			if it, ok := ec.DataProvider.(eval.ValuesIterator); ok {
+				err = it.IterateValues(ec, cell, cellTo, flags, add)
+			} else {
+				// the data provider cannot skip cells, so all numbers are taken
+				err = ec.DataProvider.IterateDecimalValues(ec, cell, cellTo, func(d decimal.Decimal) error {
+					return add(eval.NewDecimalValue(d))
+				})
+			}
+			if err != nil {
+				return eval.NewEmptyValue(), err
+			}
+		default:
+			d, err := args[i].DecimalValue(ec)
+			if err != nil {
+				if flags&eval.SkipErrors != 0 {
+					continue
+				}
+				return eval.NewEmptyValue(), err
+			}
+			add(eval.NewDecimalValue(d))
+		}
+	}
+	d, err := f(values, k)
+	if err != nil {
+		return eval.NewEmptyValue(), err
+	}
+	return eval.NewDecimalValue(d), nil
+}
+
+// intArg returns the argument as an integer number.
+func intArg(ec *eval.Context, v eval.Value) (int, error) {
+	d, err := v.DecimalValue(ec)
+	if err != nil {
+		return 0, err
+	}
+	return int(d.IntPart()), nil
+}
+
+func aggregateSum(v aggregateValues, _ decimal.Decimal) (decimal.Decimal, error) {
+	s := decimal.Zero
+	for _, n := range v.numbers {
+		s = s.Add(n)
+	}
+	return s, nil
+}
+
+func aggregateAverage(v aggregateValues, k decimal.Decimal) (decimal.Decimal, error) {
+	if len(v.numbers) == 0 {
+		return decimal.Zero, eval.NewError(eval.ErrorKindDiv0, "no numbers to average")
+	}
+	s, _ := aggregateSum(v, k)
+	return s.Div(decimal.New(int64(len(v.numbers)), 0)), nil
+}
+
+func aggregateCount(v aggregateValues, _ decimal.Decimal) (decimal.Decimal, error) {
+	return decimal.New(int64(len(v.numbers)), 0), nil
+}
+
+func aggregateCountA(v aggregateValues, _ decimal.Decimal) (decimal.Decimal, error) {
+	return decimal.New(int64(v.count), 0), nil
+}
+
+func aggregateMax(v aggregateValues, _ decimal.Decimal) (decimal.Decimal, error) {
+	if len(v.numbers) == 0 {
+		return decimal.Zero, nil
+	}
+	m := v.numbers[0]
+	for _, n := range v.numbers[1:] {
+		if n.GreaterThan(m) {
+			m = n
+		}
+	}
+	return m, nil
+}
+
+func aggregateMin(v aggregateValues, _ decimal.Decimal) (decimal.Decimal, error) {
+	if len(v.numbers) == 0 {
+		return decimal.Zero, nil
+	}
+	m := v.numbers[0]
+	for _, n := range v.numbers[1:] {
+		if n.LessThan(m) {
+			m = n
+		}
+	}
+	return m, nil
+}
+
+func aggregateProduct(v aggregateValues, _ decimal.Decimal) (decimal.Decimal, error) {
+	if len(v.numbers) == 0 {
+		return decimal.Zero, nil
+	}
+	p := decimal.New(1, 0)
+	for _, n := range v.numbers {
+		p = p.Mul(n)
+	}
+	return p, nil
+}
+
+// aggregateVar returns the variance of the sample (ddof 1) or of the population (ddof 0).
+func aggregateVar(ddof int) aggregateFunction {
+	return func(v aggregateValues, k decimal.Decimal) (decimal.Decimal, error) {
+		if len(v.numbers) <= ddof {
+			return decimal.Zero, eval.NewError(eval.ErrorKindDiv0, "not enough numbers for variance")
+		}
+		mean, _ := aggregateAverage(v, k)
+		s := decimal.Zero
+		for _, n := range v.numbers {
+			d := n.Sub(mean)
+			s = s.Add(d.Mul(d))
+		}
+		return s.Div(decimal.New(int64(len(v.numbers)-ddof), 0)), nil
+	}
+}
+
+func aggregateStdev(ddof int) aggregateFunction {
+	variance := aggregateVar(ddof)
+	return func(v aggregateValues, k decimal.Decimal) (decimal.Decimal, error) {
+		d, err := variance(v, k)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		f, _ := d.Float64()
+		return decimal.NewFromFloat(math.Sqrt(f)), nil
+	}
+}
+
+// sortedNumbers returns the numbers in ascending order.
+func sortedNumbers(v aggregateValues) []decimal.Decimal {
+	nums := append([]decimal.Decimal(nil), v.numbers...)
+	sort.Slice(nums, func(i, j int) bool {
+		return nums[i].LessThan(nums[j])
+	})
+	return nums
+}
+
+func aggregateMedian(v aggregateValues, _ decimal.Decimal) (decimal.Decimal, error) {
+	return percentile(sortedNumbers(v), decimal.New(5, -1), false)
+}
+
+// aggregateMode returns the most frequent number; if several numbers are equally
+// frequent, the first of them.
+func aggregateMode(v aggregateValues, _ decimal.Decimal) (decimal.Decimal, error) {
+	best, bestCount := decimal.Zero, 1
+	for i, n := range v.numbers {
+		count := 0
+		for _, m := range v.numbers[i:] {
+			if m.Equal(n) {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = n, count
+		}
+	}
+	if bestCount == 1 {
+		return decimal.Zero, eval.NewError(eval.ErrorKindFormula, "no repeated numbers")
+	}
+	return best, nil
+}
+
+func aggregateLarge(v aggregateValues, k decimal.Decimal) (decimal.Decimal, error) {
+	nums := sortedNumbers(v)
+	i := int(k.IntPart())
+	if i < 1 || i > len(nums) {
+		return decimal.Zero, eval.NewError(eval.ErrorKindFormula, "k is out of range")
+	}
+	return nums[len(nums)-i], nil
+}
+
+func aggregateSmall(v aggregateValues, k decimal.Decimal) (decimal.Decimal, error) {
+	nums := sortedNumbers(v)
+	i := int(k.IntPart())
+	if i < 1 || i > len(nums) {
+		return decimal.Zero, eval.NewError(eval.ErrorKindFormula, "k is out of range")
+	}
+	return nums[i-1], nil
+}
+
+// aggregatePercentile returns percentile (parts 1) or quartile (parts 4) function,
+// inclusive or exclusive.
+func aggregatePercentile(exclusive bool, parts int64) aggregateFunction {
+	return func(v aggregateValues, k decimal.Decimal) (decimal.Decimal, error) {
+		if parts > 1 {
+			if !k.Equal(decimal.New(k.IntPart(), 0)) || k.IntPart() < 0 || k.IntPart() > parts {
+				return decimal.Zero, eval.NewError(eval.ErrorKindFormula, "invalid quartile %s", k)
+			}
+			k = k.Div(decimal.New(parts, 0))
+		}
+		return percentile(sortedNumbers(v), k, exclusive)
+	}
+}
+
+// percentile interpolates the k-th percentile of the sorted numbers.
+func percentile(nums []decimal.Decimal, k decimal.Decimal, exclusive bool) (decimal.Decimal, error) {
+	n := int64(len(nums))
+	if n == 0 || k.LessThan(decimal.Zero) || k.GreaterThan(decimal.New(1, 0)) {
+		return decimal.Zero, eval.NewError(eval.ErrorKindFormula, "percentile is out of range")
+	}
+	var rank decimal.Decimal
+	if exclusive {
+		// rank counts from 1 and must fall between the first and the last number
+		rank = k.Mul(decimal.New(n+1, 0)).Sub(decimal.New(1, 0))
+		if rank.LessThan(decimal.Zero) || rank.GreaterThan(decimal.New(n-1, 0)) {
+			return decimal.Zero, eval.NewError(eval.ErrorKindFormula, "percentile is out of range")
+		}
+	} else {
+		rank = k.Mul(decimal.New(n-1, 0))
+	}
+	i := rank.IntPart()
+	frac := rank.Sub(decimal.New(i, 0))
+	if i+1 >= n {
+		return nums[i], nil
+	}
+	return nums[i].Add(nums[i+1].Sub(nums[i]).Mul(frac)), nil
+}
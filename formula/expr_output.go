@@ -1,13 +1,22 @@
 package formula
 
 import (
+	"regexp"
 	"strconv"
+	"strings"
 )
 
 // Методы, позволящие восстановить текст формулы из Выражения.
 
 type OutputFunc func(string, int)
 
+// Название листа, которое можно не заключать в кавычки.
+var plainSheetTitle = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Название листа, похожее на имя ячейки или логическое значение. Без кавычек лексер
+// прочитает его как ячейку (например, первый лист 3D-ссылки Sheet1:Sheet3!A1).
+var cellLikeSheetTitle = regexp.MustCompile(`^(?i)(\$?[A-Z]+\$?[1-9][0-9]*|TRUE|FALSE)$`)
+
 const (
 	OutputTypeSymbol = iota
 	OutputTypeWhitespace
@@ -113,6 +122,20 @@ func (e *Func) Output(of OutputFunc) {
 }
 
 func (e *Variable) Output(of OutputFunc) {
+	if e.RefError {
+		of("#REF!", OutputTypeCell)
+		return
+	}
+	if e.Sheets != nil {
+		outputSheet(of, e.Sheets.From)
+		of(":", OutputTypeSymbol)
+		outputSheet(of, e.Sheets.To)
+		of("!", OutputTypeSymbol)
+	}
+	if e.Lines != nil {
+		e.Lines.Output(of)
+		return
+	}
 	e.Cell.Output(of)
 	if e.CellTo != nil {
 		of(":", OutputTypeSymbol)
@@ -120,13 +143,29 @@ func (e *Variable) Output(of OutputFunc) {
 	}
 }
 
+func (e *Lines) Output(of OutputFunc) {
+	if e.Sheet != nil {
+		outputSheet(of, *e.Sheet)
+		of("!", OutputTypeSymbol)
+	}
+	of(e.Lines, OutputTypeCell)
+}
+
 func (e *Cell) Output(of OutputFunc) {
 	if e.Sheet != nil {
-		// FIXME: use '' only if necessary
-		of("'", OutputTypeSymbol)
-		of(string(*e.Sheet), OutputTypeSheet)
-		of("'", OutputTypeSymbol)
+		outputSheet(of, *e.Sheet)
 		of("!", OutputTypeSymbol)
 	}
 	of(e.CellName, OutputTypeCell)
 }
+
+// outputSheet outputs sheet title wrapping it in quotes if necessary.
+func outputSheet(of OutputFunc, s Sheet) {
+	if plainSheetTitle.MatchString(string(s)) && !cellLikeSheetTitle.MatchString(string(s)) {
+		of(string(s), OutputTypeSheet)
+		return
+	}
+	of("'", OutputTypeSymbol)
+	of(strings.Replace(string(s), "'", "''", -1), OutputTypeSheet)
+	of("'", OutputTypeSymbol)
+}
@@ -14,16 +14,46 @@ const maxArguments = 1000
 
 type Function func(*eval.Context, []eval.Value) (eval.Value, error)
 
-type functionDef struct {
-	F       Function
-	MinArgs int
-	MaxArgs int
-}
-
-var functions = map[string]functionDef{
-	"TRIM": {trim, 1, 1},
-	"SUM":  {sum, 1, maxArguments},
-	"IF":   {if_, 3, 3},
+var functions = map[string]FunctionDef{
+	"TRIM": {
+		F:        trim,
+		MinArgs:  1,
+		MaxArgs:  1,
+		ArgTypes: []int{ArgTypeString},
+		ArgNames: []string{"text"},
+		Help:     "Removes spaces from text",
+	},
+	"SUM": {
+		F:        sum,
+		MinArgs:  1,
+		MaxArgs:  maxArguments,
+		ArgNames: []string{"number1", "number2"},
+		Help:     "Adds its arguments",
+	},
+	"IF": {
+		F:        if_,
+		MinArgs:  3,
+		MaxArgs:  3,
+		ArgTypes: []int{ArgTypeBool, ArgTypeAny},
+		ArgNames: []string{"test", "then", "else"},
+		Help:     "Specifies a logical test to perform",
+	},
+	"SUBTOTAL": {
+		F:        subtotal,
+		MinArgs:  2,
+		MaxArgs:  maxArguments,
+		ArgTypes: []int{ArgTypeDecimal, ArgTypeAny},
+		ArgNames: []string{"function_num", "ref1", "ref2"},
+		Help:     "Returns a subtotal in a list or database",
+	},
+	"AGGREGATE": {
+		F:        aggregate,
+		MinArgs:  3,
+		MaxArgs:  maxArguments,
+		ArgTypes: []int{ArgTypeDecimal, ArgTypeDecimal, ArgTypeAny},
+		ArgNames: []string{"function_num", "options", "ref1", "ref2"},
+		Help:     "Returns an aggregate in a list or database",
+	},
 	// ABS [Math and trigonometry] Returns the absolute value of a number
 	// ACCRINT [Financial] Returns the accrued interest for a security that pays periodic interest
 	// ACCRINTM [Financial] Returns the accrued interest for a security that pays interest at maturity
@@ -31,7 +61,6 @@ var functions = map[string]functionDef{
 	// ACOSH [Math and trigonometry] Returns the inverse hyperbolic cosine of a number
 	// ACOT [Math and trigonometry] Returns the arccotangent of a number
 	// ACOTH [Math and trigonometry] Returns the hyperbolic arccotangent of a number
-	// AGGREGATE [Math and trigonometry] Returns an aggregate in a list or database
 	// ADDRESS [Lookup and reference] Returns a reference as text to a single cell in a worksheet
 	// AMORDEGRC [Financial] Returns the depreciation for each accounting period by using a depreciation coefficient
 	// AMORLINC [Financial] Returns the depreciation for each accounting period
@@ -432,7 +461,6 @@ var functions = map[string]functionDef{
 	// STDEVPA [Statistical] Calculates standard deviation based on the entire population, including numbers, text, and logical values
 	// STEYX [Statistical] Returns the standard error of the predicted y-value for each x in the regression
 	// SUBSTITUTE [Text] Substitutes new text for old text in a text string
-	// SUBTOTAL [Math and trigonometry] Returns a subtotal in a list or database
 	// SUM [Math and trigonometry] Adds its arguments
 	// SUMIF [Math and trigonometry] Adds the cells specified by a given criteria
 	// SUMIFS [Math and trigonometry] Adds the cells in a range that meet multiple criteria
@@ -0,0 +1,240 @@
+package formula
+
+import (
+	"xl/document/eval"
+
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Нотация R1C1. Строки и колонки в ней нумеруются числами, а относительные ссылки записываются
+// как смещение от ячейки, содержащей формулу: R[-1]C[2] - ячейка строкой выше и на две колонки
+// правее. Абсолютные ссылки записываются без скобок: R1C1 - то же, что $A$1.
+// Внутри Выражения ссылки всегда хранятся в нотации A1, а R1C1 используется только при вводе
+// формулы и ее выводе, поэтому одна и та же формула выглядит в R1C1 одинаково во всех ячейках
+// экстраполяционного сегмента.
+
+const (
+	NotationA1 = iota
+	NotationR1C1
+)
+
+var (
+	r1c1Cell   = regexp.MustCompile(`^(?i)R(\[-?\d+\]|\d+)?C(\[-?\d+\]|\d+)?`)
+	r1c1Rows   = regexp.MustCompile(`^(?i)R(\[-?\d+\]|\d+)?:R(\[-?\d+\]|\d+)?`)
+	r1c1Cols   = regexp.MustCompile(`^(?i)C(\[-?\d+\]|\d+)?:C(\[-?\d+\]|\d+)?`)
+	a1CellName = regexp.MustCompile(`^(\$?)([A-Z]+)(\$?)([0-9]+)$`)
+	a1Cols     = regexp.MustCompile(`^(\$?)([A-Z]+):(\$?)([A-Z]+)$`)
+	a1Rows     = regexp.MustCompile(`^(\$?)([0-9]+):(\$?)([0-9]+)$`)
+)
+
+// ParseR1C1 parses the formula written in R1C1 notation. Relative references are resolved
+// against the cell with X and Y coordinates, so resulting Expression is the same as if
+// the formula was written in A1 notation.
+func ParseR1C1(source string, x, y int) (*Expression, error) {
+	a1, err := r1c1ToA1(source, x, y)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(a1)
+}
+
+// R1C1Output wraps the output function so that references are output in R1C1 notation
+// relative to the cell with X and Y coordinates.
+func R1C1Output(of OutputFunc, x, y int) OutputFunc {
+	return func(s string, t int) {
+		if t == OutputTypeCell {
+			s = a1ToR1C1(s, x, y)
+		}
+		of(s, t)
+	}
+}
+
+// R1C1String returns text of the formula in R1C1 notation relative to the cell
+// with X and Y coordinates.
+func (e *Expression) R1C1String(x, y int) string {
+	var buf bytes.Buffer
+	e.Output(R1C1Output(func(s string, i int) {
+		buf.WriteString(s)
+	}, x, y))
+	return buf.String()
+}
+
+// r1c1ToA1 replaces references written in R1C1 notation with their A1 equivalents.
+// String literals and quoted sheet titles are left as is.
+func r1c1ToA1(source string, x, y int) (string, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(source); {
+		c := source[i]
+		if c == '"' || c == '\'' {
+			// skip quoted text up to the closing quote, doubled quotes are escaped ones
+			j := i + 1
+			for j < len(source) {
+				if source[j] == c {
+					if j+1 < len(source) && source[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j < len(source) {
+				j++
+			}
+			buf.WriteString(source[i:j])
+			i = j
+			continue
+		}
+		if i == 0 || !isNameChar(source[i-1]) {
+			converted, n, err := convertR1C1Ref(source[i:], x, y)
+			if err != nil {
+				return "", err
+			}
+			if n > 0 {
+				buf.WriteString(converted)
+				i += n
+				continue
+			}
+		}
+		buf.WriteByte(c)
+		i++
+	}
+	return buf.String(), nil
+}
+
+// convertR1C1Ref converts the reference at the beginning of s to A1 notation.
+// Returns converted reference and length of the source reference or zero length
+// if s does not start with a reference.
+func convertR1C1Ref(s string, x, y int) (string, int, error) {
+	if m := r1c1Rows.FindStringSubmatch(s); m != nil && isRefEnd(s, len(m[0])) {
+		from, err := r1c1Part(m[1], y)
+		if err != nil {
+			return "", 0, err
+		}
+		to, err := r1c1Part(m[2], y)
+		if err != nil {
+			return "", 0, err
+		}
+		return from.anchor() + strconv.Itoa(from.n+1) + ":" + to.anchor() + strconv.Itoa(to.n+1), len(m[0]), nil
+	}
+	if m := r1c1Cols.FindStringSubmatch(s); m != nil && isRefEnd(s, len(m[0])) {
+		from, err := r1c1Part(m[1], x)
+		if err != nil {
+			return "", 0, err
+		}
+		to, err := r1c1Part(m[2], x)
+		if err != nil {
+			return "", 0, err
+		}
+		return from.anchor() + colName(from.n) + ":" + to.anchor() + colName(to.n), len(m[0]), nil
+	}
+	if m := r1c1Cell.FindStringSubmatch(s); m != nil && isRefEnd(s, len(m[0])) {
+		row, err := r1c1Part(m[1], y)
+		if err != nil {
+			return "", 0, err
+		}
+		col, err := r1c1Part(m[2], x)
+		if err != nil {
+			return "", 0, err
+		}
+		return col.anchor() + colName(col.n) + row.anchor() + strconv.Itoa(row.n+1), len(m[0]), nil
+	}
+	return "", 0, nil
+}
+
+type r1c1Coord struct {
+	n        int
+	anchored bool
+}
+
+func (c r1c1Coord) anchor() string {
+	if c.anchored {
+		return "$"
+	}
+	return ""
+}
+
+// r1c1Part converts row or column part of R1C1 reference into zero-based coordinate.
+// Empty part and part in square brackets are relative to the base coordinate.
+func r1c1Part(part string, base int) (r1c1Coord, error) {
+	var c r1c1Coord
+	switch {
+	case part == "":
+		c.n = base
+	case part[0] == '[':
+		offset, _ := strconv.Atoi(part[1 : len(part)-1])
+		c.n = base + offset
+	default:
+		n, _ := strconv.Atoi(part)
+		c.n, c.anchored = n-1, true
+	}
+	if c.n < 0 {
+		return c, eval.NewError(eval.ErrorKindRef, "reference is out of sheet bounds")
+	}
+	return c, nil
+}
+
+// a1ToR1C1 converts cell name or whole rows/columns reference from A1 to R1C1 notation.
+func a1ToR1C1(s string, x, y int) string {
+	if m := a1CellName.FindStringSubmatch(s); m != nil {
+		row, _ := strconv.Atoi(m[4])
+		return "R" + a1Part(row-1, m[3] != "", y) + "C" + a1Part(colIndex(m[2]), m[1] != "", x)
+	}
+	if m := a1Cols.FindStringSubmatch(s); m != nil {
+		return "C" + a1Part(colIndex(m[2]), m[1] != "", x) + ":C" + a1Part(colIndex(m[4]), m[3] != "", x)
+	}
+	if m := a1Rows.FindStringSubmatch(s); m != nil {
+		from, _ := strconv.Atoi(m[2])
+		to, _ := strconv.Atoi(m[4])
+		return "R" + a1Part(from-1, m[1] != "", y) + ":R" + a1Part(to-1, m[3] != "", y)
+	}
+	return s
+}
+
+// a1Part outputs zero-based coordinate as row or column part of R1C1 reference.
+func a1Part(n int, anchored bool, base int) string {
+	if anchored {
+		return strconv.Itoa(n + 1)
+	}
+	if n == base {
+		return ""
+	}
+	return "[" + strconv.Itoa(n-base) + "]"
+}
+
+// isNameChar checks if the char can be a part of function name, sheet title or reference.
+func isNameChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == '$' || c == ']'
+}
+
+// isRefEnd checks if the reference of length n found in s is not followed by a char
+// making it a part of function name, sheet title or another token.
+func isRefEnd(s string, n int) bool {
+	if n == len(s) {
+		return true
+	}
+	c := s[n]
+	return !isNameChar(c) && c != '(' && c != '!' && c != '['
+}
+
+// colName returns name of column for given zero-based index.
+func colName(n int) string {
+	name := string(rune('A' + n%26))
+	for n /= 26; n > 0; n = (n - 1) / 26 {
+		name = string(rune('A'+(n-1)%26)) + name
+	}
+	return name
+}
+
+// colIndex returns zero-based index of column with given name.
+func colIndex(name string) int {
+	n := 0
+	for _, c := range strings.ToUpper(name) {
+		n = n*26 + int(c-'A'+1)
+	}
+	return n - 1
+}
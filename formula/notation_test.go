@@ -0,0 +1,52 @@
+package formula
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseR1C1(t *testing.T) {
+	testCases := []struct {
+		f  string
+		a1 string
+	}{
+		{`=RC[-1]+R[-1]C`, `=B3+C2`},
+		{`=R1C1+R[1]C[1]+rc`, `=$A$1+D4+C3`},
+		{`=R2C[-2]+R[-2]C2`, `=A$2+$B1`},
+		{`=SUM(R1C1:R[1]C[1])`, `=SUM($A$1:D4)`},
+		{`=SUM(C1:C[1])+SUM(R[-1]:R3)`, `=SUM($A:D)+SUM(2:$3)`},
+		{`=Sheet2!RC+'R1C1'!R1C1`, `='Sheet2'!C3+R1C1!$A$1`},
+		{`=ROUND(1)+"R1C1"`, `=ROUND(1)+"R1C1"`},
+	}
+	for _, c := range testCases {
+		expr, err := ParseR1C1(c.f, 2, 2)
+		if !assert.NoErrorf(t, err, "case %s: must not fail on parse %s", c.f, err) {
+			continue
+		}
+		assert.Equalf(t, c.a1, expr.String(), "case %s", c.f)
+	}
+
+	_, err := ParseR1C1(`=R[-3]C`, 2, 2)
+	assert.EqualError(t, err, "reference is out of sheet bounds")
+}
+
+func TestR1C1Output(t *testing.T) {
+	testCases := []struct {
+		f    string
+		r1c1 string
+	}{
+		{`=B3+C2`, `=RC[-1]+R[-1]C`},
+		{`=$A$1+D4+C3`, `=R1C1+R[1]C[1]+RC`},
+		{`=A$2+$B1`, `=R2C[-2]+R[-2]C2`},
+		{`=SUM($A:D)+SUM(2:$3)`, `=SUM(C1:C[1])+SUM(R[-1]:R3)`},
+		{`=SUM(Jan:Dec!AA10:AB11)`, `=SUM(Jan:Dec!R[7]C[24]:R[8]C[25])`},
+	}
+	for _, c := range testCases {
+		expr, err := Parse(c.f)
+		if !assert.NoErrorf(t, err, "case %s: must not fail on parse %s", c.f, err) {
+			continue
+		}
+		assert.Equalf(t, c.r1c1, expr.R1C1String(2, 2), "case %s", c.f)
+	}
+}
@@ -219,13 +219,13 @@ func evalStringOperator(op string, args []string) (eval.Value, error) {
 }
 
 func evalFunc(ec *eval.Context, name string, args []eval.Value) (eval.Value, error) {
-	if f, ok := functions[name]; ok {
-		if len(args) < f.MinArgs || len(args) > f.MaxArgs {
-			return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindFormula, "function %s accepts from %d to %d arguments, %d provided",
-				name, f.MinArgs, f.MaxArgs, len(args))
-		}
-		return f.F(ec, args)
-	} else {
+	f, ok := LookupFunction(name)
+	if !ok {
 		return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindFormula, "function %s does not exist", name)
 	}
+	args, err := f.prepareArgs(ec, name, args)
+	if err != nil {
+		return eval.NewEmptyValue(), err
+	}
+	return f.F(ec, args)
 }
@@ -16,6 +16,10 @@ import (
 
 type Boolean bool
 type Sheet string
+type SheetRange struct {
+	From Sheet
+	To   Sheet
+}
 type FuncName string
 type String string
 
@@ -37,6 +41,23 @@ func (s *Sheet) Capture(values []string) error {
 	return nil
 }
 
+func (s *SheetRange) Capture(values []string) error {
+	v := strings.TrimRight(values[0], "!")
+	// find colon outside of quotes
+	quoted := false
+	for i, r := range v {
+		if r == '\'' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			if err := s.From.Capture([]string{v[:i]}); err != nil {
+				return err
+			}
+			return s.To.Capture([]string{v[i+1:]})
+		}
+	}
+	return eval.NewError(eval.ErrorKindName, "malformed sheets range")
+}
+
 func (f *FuncName) Capture(values []string) error {
 	*f = FuncName(strings.TrimRight(values[0], "("))
 	return nil
@@ -112,9 +133,21 @@ type Func struct {
 	Arguments []*Equality `[ @@ { ";" @@ } ] ")"`
 }
 
+// Переменная - это ссылка на ячейку или диапазон ячеек. Диапазон может быть задан
+// углами (A1:B2), целыми колонками или строками (A:B, 1:2), а также охватывать один и тот же
+// диапазон на нескольких последовательных листах (Jan:Dec!A1:B2).
+// Ссылка на удаленные ячейки записывается как #REF!.
 type Variable struct {
-	Cell   *Cell `@@`
-	CellTo *Cell `[ ":" @@ ]`
+	RefError bool        `  @RefError`
+	Sheets   *SheetRange `| [ @SheetRange ]`
+	Lines    *Lines      `  ( @@`
+	Cell     *Cell       `  | @@`
+	CellTo   *Cell       `    [ ":" @@ ] )`
+}
+
+type Lines struct {
+	Sheet *Sheet `[ @Sheet ]`
+	Lines string `@Lines`
 }
 
 type Cell struct {
@@ -122,17 +155,24 @@ type Cell struct {
 	CellName string `@CellName`
 }
 
-var lex = lexer.Must(lexer.Regexp(
-	`(\s+)` +
-		`|^=` +
-		`|(?P<Operators><>|<=|>=|[-+*/()=<>;:\^])` +
-		`|(?P<Number>\d*\.?\d+([eE][-+]?\d+)?)` +
-		`|(?P<String>"([^"]|"")*")` +
-		`|(?P<Boolean>(?i)TRUE|FALSE)` +
-		`|(?P<FuncName>[A-Za-z][A-Za-z0-9\.]+)\(` +
-		`|(?P<Sheet>[A-Za-z0-9_]+|'([^']|'')*')!` +
-		`|(?P<CellName>\$?[A-Za-z]+\$?[1-9][0-9]*)`,
-))
+// Выражение лексера, по нему же разбивается на лексемы текст недописанной формулы.
+const lexPattern = `(\s+)` +
+	`|^=` +
+	`|(?P<RefError>#REF!)` +
+	`|(?P<Operators><>|<=|>=|[-+*/()=<>;:\^])` +
+	// first sheet of 3D reference can not look like a cell name, since it would be
+	// ambiguous with the range corner followed by a sheet (Sheet1!A1:Sheet1!B2)
+	`|(?P<SheetRange>([A-Za-z0-9]*_[A-Za-z0-9_]*|[A-Za-z]+|[0-9][A-Za-z0-9_]*|[A-Za-z]+[0-9]+[A-Za-z][A-Za-z0-9_]*|'([^']|'')*')` +
+	`:([A-Za-z0-9_]+|'([^']|'')*')!)` +
+	`|(?P<Lines>\$?[A-Za-z]+:\$?[A-Za-z]+|\$?\d+:\$?\d+)` +
+	`|(?P<Number>\d*\.?\d+([eE][-+]?\d+)?)` +
+	`|(?P<String>"([^"]|"")*")` +
+	`|(?P<Boolean>(?i)TRUE|FALSE)` +
+	`|(?P<FuncName>[A-Za-z][A-Za-z0-9\.]+)\(` +
+	`|(?P<Sheet>[A-Za-z0-9_]+|'([^']|'')*')!` +
+	`|(?P<CellName>\$?[A-Za-z]+\$?[1-9][0-9]*)`
+
+var lex = lexer.Must(lexer.Regexp(lexPattern))
 
 // Parse parses the formula, extracts variables from it and builds
 // functions chain that perform the expression representing by the formula..
@@ -142,7 +182,7 @@ func Parse(source string) (*Expression, error) {
 		&Expression{},
 		participle.Lexer(lex),
 		participle.CaseInsensitive("Boolean"),
-		participle.Upper("CellName"),
+		participle.Upper("CellName", "Lines"),
 	)
 	if err != nil {
 		panic(err)
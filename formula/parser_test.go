@@ -56,6 +56,12 @@ func TestParse(t *testing.T) {
 		{`=A1:B200+A1:C300`, "10", 2},
 		{`=$A$1:B$200+A$1:$C$300`, "10", 2},
 		{`='Sheet With Spaces'!A1:'Sheet With Spaces'!B200+Sheet2!A1:Sheet2!C300`, "10", 2},
+		{`=A:A+$B:C`, "10", 2},
+		{`=1:1+$2:3`, "10", 2},
+		{`=Sheet2!A:B+'Sheet With Spaces'!1:2`, "10", 2},
+		{`=Jan:Dec!B2+Jan:Dec!A1:C3`, "10", 2},
+		{`='Sheet 1':'Sheet 2'!A:A+'A1':B1!1:1`, "10", 2},
+		{`=#REF!+A1`, "10", 2},
 	}
 	for _, c := range testCases {
 		expr, err := Parse(c.f)
@@ -76,6 +82,32 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestOutput(t *testing.T) {
+	testCases := []struct {
+		f   string
+		out string
+	}{
+		{`=SUM(a:$b)`, `=SUM(A:$B)`},
+		{`=SUM(1:$3)`, `=SUM(1:$3)`},
+		{`='Sheet2'!A1`, `='Sheet2'!A1`},
+		{`='My_data'!A1`, `=My_data!A1`},
+		{`='Sheet1':Sheet3!A1`, `='Sheet1':'Sheet3'!A1`},
+		{`=SUM('Jan':'Feb'!B2)`, `=SUM(Jan:Feb!B2)`},
+		{`='TRUE'!A1+'false'!B2`, `='TRUE'!A1+'false'!B2`},
+		{`='Sheet ''2'''!A1`, `='Sheet ''2'''!A1`},
+		{`=SUM(Jan:'Dec 2019'!B2:C3)`, `=SUM(Jan:'Dec 2019'!B2:C3)`},
+	}
+	for _, c := range testCases {
+		expr, err := Parse(c.f)
+		assert.NoErrorf(t, err, "case %s: must not fail on parse %s", c.f, err)
+		assert.Equalf(t, c.out, expr.String(), "case %s", c.f)
+		// the output is parsed back into the same formula
+		again, err := Parse(expr.String())
+		assert.NoErrorf(t, err, "case %s: must not fail on parse of output %s", c.f, err)
+		assert.Equalf(t, c.out, again.String(), "case %s: round trip", c.f)
+	}
+}
+
 func TestParseErrors(t *testing.T) {
 	testCases := []struct {
 		f   string
@@ -0,0 +1,188 @@
+package formula
+
+import (
+	"xl/document/eval"
+
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Реестр функций, доступных в формулах. Помимо встроенных функций, в реестр можно
+// добавлять собственные функции: как из программ, использующих xl в качестве библиотеки,
+// так и из скриптов, подключаемых через .xlrc.
+
+// Ожидаемые типы аргументов функции. Аргументы скалярных типов приводятся к нужному
+// типу до вызова функции, так что функция получает уже готовое Значение.
+const (
+	ArgTypeAny = iota
+	ArgTypeBool
+	ArgTypeDecimal
+	ArgTypeString
+	ArgTypeRange
+)
+
+// FunctionDef describes a function which can be called from formulas.
+type FunctionDef struct {
+	// F performs the calculation.
+	F Function
+	// MinArgs and MaxArgs limit the number of arguments accepted.
+	MinArgs int
+	MaxArgs int
+	// ArgTypes lists expected types of arguments. The last type applies to all
+	// the remaining arguments. Empty list means any types are accepted.
+	ArgTypes []int
+	// ArgNames are used to build the signature shown to user.
+	ArgNames []string
+	// Volatile function returns different results on every call even if
+	// its arguments are the same (like RAND or NOW).
+	Volatile bool
+	// Help is a short description of what function does.
+	Help string
+}
+
+var (
+	functionsMu       sync.RWMutex
+	functionNameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9.]+$`)
+)
+
+// RegisterFunction adds a new function to the registry so it can be used in formulas.
+// Function names are case-insensitive. Registering a function under the name which is
+// already taken is an error.
+func RegisterFunction(name string, def FunctionDef) error {
+	if !functionNameRegex.MatchString(name) {
+		return eval.NewError(eval.ErrorKindName, "invalid function name %s", name)
+	}
+	if def.F == nil {
+		return eval.NewError(eval.ErrorKindFormula, "function %s has no implementation", name)
+	}
+	if def.MinArgs < 0 || def.MaxArgs < def.MinArgs || def.MaxArgs > maxArguments {
+		return eval.NewError(eval.ErrorKindFormula, "function %s has invalid number of arguments", name)
+	}
+	for i, t := range def.ArgTypes {
+		if t < ArgTypeAny || t > ArgTypeRange {
+			return eval.NewError(eval.ErrorKindFormula, "function %s has invalid type of argument %d", name, i+1)
+		}
+	}
+	name = strings.ToUpper(name)
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	if _, ok := functions[name]; ok {
+		return eval.NewError(eval.ErrorKindName, "function %s is already registered", name)
+	}
+	functions[name] = def
+	return nil
+}
+
+// UnregisterFunction removes the function from the registry.
+func UnregisterFunction(name string) {
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	delete(functions, strings.ToUpper(name))
+}
+
+// LookupFunction returns definition of the function with given name.
+func LookupFunction(name string) (FunctionDef, bool) {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	def, ok := functions[strings.ToUpper(name)]
+	return def, ok
+}
+
+// FunctionNames returns sorted names of all registered functions.
+func FunctionNames() []string {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Signature returns human readable signature of the function, e.g. IF(test; then; else).
+// Optional arguments are wrapped in square brackets.
+func (def FunctionDef) Signature(name string) string {
+	var buf bytes.Buffer
+	buf.WriteString(strings.ToUpper(name))
+	buf.WriteString("(")
+	for i := 0; i < len(def.ArgNames) && i < def.MaxArgs; i++ {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		if i >= def.MinArgs {
+			buf.WriteString("[" + def.ArgNames[i] + "]")
+		} else {
+			buf.WriteString(def.ArgNames[i])
+		}
+	}
+	if def.MaxArgs > len(def.ArgNames) && len(def.ArgNames) > 0 {
+		buf.WriteString("; ...")
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
+// argType returns expected type of Nth argument.
+func (def FunctionDef) argType(n int) int {
+	if len(def.ArgTypes) == 0 {
+		return ArgTypeAny
+	}
+	if n >= len(def.ArgTypes) {
+		return def.ArgTypes[len(def.ArgTypes)-1]
+	}
+	return def.ArgTypes[n]
+}
+
+// prepareArgs checks the arguments against function definition and casts
+// scalar arguments to the expected types.
+func (def FunctionDef) prepareArgs(ec *eval.Context, name string, args []eval.Value) ([]eval.Value, error) {
+	if len(args) < def.MinArgs || len(args) > def.MaxArgs {
+		return nil, eval.NewError(eval.ErrorKindFormula, "function %s accepts from %d to %d arguments, %d provided",
+			name, def.MinArgs, def.MaxArgs, len(args))
+	}
+	prepared := make([]eval.Value, len(args))
+	for i, a := range args {
+		t := def.argType(i)
+		if t == ArgTypeAny {
+			prepared[i] = a
+			continue
+		}
+		if t == ArgTypeRange {
+			if a.Type() != eval.TypeRangeRef {
+				return nil, eval.NewError(eval.ErrorKindFormula, "function %s expects range as argument %d", name, i+1)
+			}
+			prepared[i] = a
+			continue
+		}
+		if a.Type() == eval.TypeRangeRef {
+			return nil, eval.NewError(eval.ErrorKindFormula, "function %s does not accept range as argument %d", name, i+1)
+		}
+		switch t {
+		case ArgTypeBool:
+			b, err := a.BoolValue(ec)
+			if err != nil {
+				return nil, err
+			}
+			prepared[i] = eval.NewBoolValue(b)
+		case ArgTypeDecimal:
+			d, err := a.DecimalValue(ec)
+			if err != nil {
+				return nil, err
+			}
+			prepared[i] = eval.NewDecimalValue(d)
+		case ArgTypeString:
+			s, err := a.StringValue(ec)
+			if err != nil {
+				return nil, err
+			}
+			prepared[i] = eval.NewStringValue(s)
+		default:
+			return nil, eval.NewError(eval.ErrorKindFormula, "function %s has invalid type of argument %d", name, i+1)
+		}
+	}
+	return prepared, nil
+}
@@ -0,0 +1,95 @@
+package formula
+
+import (
+	"xl/document/eval"
+
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterFunction(t *testing.T) {
+	err := RegisterFunction("double", FunctionDef{
+		F: func(ec *eval.Context, args []eval.Value) (eval.Value, error) {
+			d, _ := args[0].DecimalValue(ec)
+			return eval.NewDecimalValue(d.Mul(decimal.New(2, 0))), nil
+		},
+		MinArgs:  1,
+		MaxArgs:  1,
+		ArgTypes: []int{ArgTypeDecimal},
+		ArgNames: []string{"number"},
+	})
+	assert.NoError(t, err)
+	defer UnregisterFunction("DOUBLE")
+
+	err = RegisterFunction("DOUBLE", FunctionDef{F: sum, MinArgs: 1, MaxArgs: 1})
+	assert.EqualError(t, err, "function DOUBLE is already registered")
+
+	expr, err := Parse(`=DOUBLE(TRUE)+DOUBLE(2)`)
+	assert.NoError(t, err)
+	f, _ := expr.BuildFunc()
+	ec := eval.NewContext(nil, 0)
+	v, err := f(ec, nil)
+	assert.NoError(t, err)
+	s, _ := v.StringValue(ec)
+	assert.Equal(t, "6", s)
+
+	def, ok := LookupFunction("Double")
+	assert.True(t, ok)
+	assert.Equal(t, "DOUBLE(number)", def.Signature("double"))
+}
+
+func TestRegisterFunctionErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		def  FunctionDef
+		err  string
+	}{
+		{`1X`, FunctionDef{F: sum, MaxArgs: 1}, `invalid function name 1X`},
+		{`NOIMPL`, FunctionDef{MaxArgs: 1}, `function NOIMPL has no implementation`},
+		{`BADARGS`, FunctionDef{F: sum, MinArgs: 2, MaxArgs: 1}, `function BADARGS has invalid number of arguments`},
+		{`BADTYPE`, FunctionDef{F: sum, MaxArgs: 2, ArgTypes: []int{ArgTypeDecimal, 42}}, `function BADTYPE has invalid type of argument 2`},
+		{`NEGTYPE`, FunctionDef{F: sum, MaxArgs: 1, ArgTypes: []int{-1}}, `function NEGTYPE has invalid type of argument 1`},
+	}
+	for _, c := range testCases {
+		err := RegisterFunction(c.name, c.def)
+		assert.EqualErrorf(t, err, c.err, "case %s", c.name)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	testCases := []struct {
+		name      string
+		signature string
+	}{
+		{`SUM`, `SUM(number1; [number2]; ...)`},
+		{`IF`, `IF(test; then; else)`},
+		{`TRIM`, `TRIM(text)`},
+	}
+	for _, c := range testCases {
+		def, ok := LookupFunction(c.name)
+		assert.Truef(t, ok, "case %s: must be registered", c.name)
+		assert.Equalf(t, c.signature, def.Signature(c.name), "case %s", c.name)
+	}
+}
+
+func TestFunctionArgTypes(t *testing.T) {
+	testCases := []struct {
+		f   string
+		err string
+	}{
+		{`=TRIM(A1:B2)`, `function TRIM does not accept range as argument 1`},
+		{`=IF(1; 2)`, `function IF accepts from 3 to 3 arguments, 2 provided`},
+	}
+	for _, c := range testCases {
+		expr, err := Parse(c.f)
+		assert.NoErrorf(t, err, "case %s: must not fail on parse %s", c.f, err)
+		f, _ := expr.BuildFunc()
+		ec := eval.NewContext(nil, 0)
+		_, err = f(ec, []eval.Value{
+			eval.NewRefValue(eval.CellReference{}, &eval.CellReference{}),
+		})
+		assert.EqualErrorf(t, err, c.err, "case %s", c.f)
+	}
+}
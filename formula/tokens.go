@@ -0,0 +1,274 @@
+package formula
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Разбиение текста формулы на лексемы для подсветки и подсказок в редакторе. В отличие
+// от Parse, работает и с недописанной формулой: нераспознанный текст становится
+// отдельными символами, а незакрытая строка тянется до конца текста.
+
+var lexRegexp = regexp.MustCompile(lexPattern)
+
+// Token is a piece of the formula text of one of OutputType kinds.
+type Token struct {
+	Text string
+	Type int
+	// Offset is the position of the token in the text in bytes.
+	Offset int
+}
+
+// Tokenize splits the text of the formula into tokens the way the parser does.
+func Tokenize(source string) []Token {
+	var tokens []Token
+	add := func(text string, t, offset int) {
+		tokens = append(tokens, Token{Text: text, Type: t, Offset: offset})
+	}
+	names := lexRegexp.SubexpNames()
+	for offset := 0; offset < len(source); {
+		rest := source[offset:]
+		m := lexRegexp.FindStringSubmatchIndex(rest)
+		if m == nil || m[0] != 0 || m[1] == 0 {
+			if rest[0] == '"' {
+				add(rest, OutputTypeString, offset)
+				break
+			}
+			_, n := utf8.DecodeRuneInString(rest)
+			add(rest[:n], OutputTypeSymbol, offset)
+			offset += n
+			continue
+		}
+		text := rest[:m[1]]
+		name := ""
+		for i := 2; i < len(m); i += 2 {
+			if m[i] != -1 {
+				name = names[i/2]
+				break
+			}
+		}
+		switch name {
+		case "":
+			t := OutputTypeSymbol
+			if strings.TrimSpace(text) == "" {
+				t = OutputTypeWhitespace
+			}
+			add(text, t, offset)
+		case "Operators":
+			t := OutputTypeOperator
+			if strings.Contains("();:", text) {
+				t = OutputTypeSymbol
+			}
+			add(text, t, offset)
+		case "FuncName":
+			add(text[:len(text)-1], OutputTypeFunction, offset)
+			add("(", OutputTypeSymbol, offset+len(text)-1)
+		case "Sheet", "SheetRange":
+			add(text[:len(text)-1], OutputTypeSheet, offset)
+			add("!", OutputTypeSymbol, offset+len(text)-1)
+		case "Number":
+			add(text, OutputTypeNumber, offset)
+		case "String":
+			add(text, OutputTypeString, offset)
+		case "Boolean":
+			add(text, OutputTypeBoolean, offset)
+		default:
+			add(text, OutputTypeCell, offset)
+		}
+		offset += m[1]
+	}
+	return tokens
+}
+
+// CallAt returns the name of the innermost function whose call encloses the position
+// of the formula text and the number of the argument at the position, counting from 0.
+// Reports false if the position is not inside a call.
+func CallAt(source string, pos int) (string, int, bool) {
+	type call struct {
+		name string
+		arg  int
+	}
+	var calls []call
+	tokens := Tokenize(source)
+	for i, t := range tokens {
+		if t.Offset >= pos {
+			break
+		}
+		switch {
+		case t.Type == OutputTypeFunction:
+		case t.Text == "(":
+			name := ""
+			if i > 0 && tokens[i-1].Type == OutputTypeFunction {
+				name = tokens[i-1].Text
+			}
+			calls = append(calls, call{name: name})
+		case t.Text == ")" && len(calls) > 0:
+			calls = calls[:len(calls)-1]
+		case t.Text == ";" && len(calls) > 0:
+			calls[len(calls)-1].arg++
+		}
+	}
+	for i := len(calls) - 1; i >= 0; i-- {
+		if calls[i].name != "" {
+			return calls[i].name, calls[i].arg, true
+		}
+	}
+	return "", 0, false
+}
+
+// MatchingParen returns the positions of the parenthesis of the formula text at pos,
+// or just before it, and of the one matching it. Reports false if there is no
+// parenthesis there or it has no pair.
+func MatchingParen(source string, pos int) (int, int, bool) {
+	tokens := Tokenize(source)
+	at := -1
+	for i, t := range tokens {
+		if (t.Text == "(" || t.Text == ")") && (t.Offset == pos || t.Offset == pos-1) {
+			at = i
+			if t.Offset == pos {
+				break
+			}
+		}
+	}
+	if at < 0 {
+		return 0, 0, false
+	}
+	step, depth := 1, 0
+	if tokens[at].Text == ")" {
+		step = -1
+	}
+	for i := at; i >= 0 && i < len(tokens); i += step {
+		switch tokens[i].Text {
+		case "(":
+			depth += step
+		case ")":
+			depth -= step
+		}
+		if depth == 0 {
+			return tokens[at].Offset, tokens[i].Offset, true
+		}
+	}
+	return 0, 0, false
+}
+
+// QuoteSheet returns the title of the sheet as it is written in references, wrapped
+// in quotes if necessary.
+func QuoteSheet(title string) string {
+	var b bytes.Buffer
+	outputSheet(func(s string, _ int) { b.WriteString(s) }, Sheet(title))
+	return b.String()
+}
+
+// Reference is a reference to a cell or a range of cells in the formula text.
+type Reference struct {
+	// Offset and Length locate the reference in the text in bytes.
+	Offset int
+	Length int
+	// Sheet is the title of the sheet of the reference, empty if it is not given.
+	Sheet string
+	// X, Y, MaxX and MaxY are the corners of the range, counted from 0.
+	X, Y, MaxX, MaxY int
+}
+
+// References returns the references to cells and ranges in the formula text in order
+// of their appearance.
+func References(source string) []Reference {
+	tokens := Tokenize(source)
+	// corner parses the reference to a cell, possibly with the sheet, at token i
+	corner := func(i int) (string, int, int, int, bool) {
+		var title Sheet
+		if i+1 < len(tokens) && tokens[i].Type == OutputTypeSheet && tokens[i+1].Text == "!" {
+			_ = title.Capture([]string{tokens[i].Text})
+			i += 2
+		}
+		if i >= len(tokens) || tokens[i].Type != OutputTypeCell {
+			return "", 0, 0, 0, false
+		}
+		x, y, ok := cellAxis(tokens[i].Text)
+		return string(title), x, y, i + 1, ok
+	}
+	var refs []Reference
+	for i := 0; i < len(tokens); {
+		title, x, y, next, ok := corner(i)
+		if !ok {
+			i++
+			continue
+		}
+		ref := Reference{Offset: tokens[i].Offset, Sheet: title, X: x, Y: y, MaxX: x, MaxY: y}
+		if next < len(tokens) && tokens[next].Text == ":" {
+			if title2, x2, y2, next2, ok := corner(next + 1); ok && (title2 == "" || title2 == title) {
+				if x2 < ref.X {
+					ref.X = x2
+				} else {
+					ref.MaxX = x2
+				}
+				if y2 < ref.Y {
+					ref.Y = y2
+				} else {
+					ref.MaxY = y2
+				}
+				next = next2
+			}
+		}
+		last := tokens[next-1]
+		ref.Length = last.Offset + len(last.Text) - ref.Offset
+		refs = append(refs, ref)
+		i = next
+	}
+	return refs
+}
+
+// ReferenceAt returns the reference of the formula text the position is inside of or
+// at the edge of.
+func ReferenceAt(source string, pos int) (Reference, bool) {
+	for _, ref := range References(source) {
+		if pos >= ref.Offset && pos <= ref.Offset+ref.Length {
+			return ref, true
+		}
+	}
+	return Reference{}, false
+}
+
+// OperandExpected reports whether a reference can be put at the position of the formula
+// text: an operand is expected after the token before it and the one after it, if any,
+// can follow an operand.
+func OperandExpected(source string, pos int) bool {
+	if !strings.HasPrefix(source, "=") || pos < 1 {
+		return false
+	}
+	var prev, next *Token
+	tokens := Tokenize(source)
+	for i := range tokens {
+		t := &tokens[i]
+		switch {
+		case t.Type == OutputTypeWhitespace:
+		case t.Offset+len(t.Text) <= pos:
+			prev = t
+		case t.Offset >= pos && next == nil:
+			next = t
+		case t.Offset < pos:
+			// the position is inside the token
+			return false
+		}
+	}
+	if prev == nil || prev.Type != OutputTypeOperator && !strings.Contains("=(;:&", prev.Text) {
+		return false
+	}
+	return next == nil || next.Type == OutputTypeOperator || strings.Contains(");&", next.Text)
+}
+
+// cellAxis returns the coordinates of the cell written in A1 notation.
+func cellAxis(name string) (int, int, bool) {
+	m := a1CellName.FindStringSubmatch(strings.ToUpper(name))
+	if m == nil {
+		return 0, 0, false
+	}
+	row, err := strconv.Atoi(m[4])
+	if err != nil || row < 1 {
+		return 0, 0, false
+	}
+	return colIndex(m[2]), row - 1, true
+}
@@ -0,0 +1,96 @@
+package formula
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	var types []int
+	var texts []string
+	for _, token := range Tokenize(`=SUM(Sheet2!A1:B2; 1.5) & "ab`) {
+		types = append(types, token.Type)
+		texts = append(texts, token.Text)
+	}
+	assert.Equal(t, []string{"=", "SUM", "(", "Sheet2", "!", "A1", ":", "B2", ";", " ", "1.5", ")", " ", "&", " ", `"ab`}, texts)
+	assert.Equal(t, []int{OutputTypeSymbol, OutputTypeFunction, OutputTypeSymbol, OutputTypeSheet, OutputTypeSymbol,
+		OutputTypeCell, OutputTypeSymbol, OutputTypeCell, OutputTypeSymbol, OutputTypeWhitespace, OutputTypeNumber,
+		OutputTypeSymbol, OutputTypeWhitespace, OutputTypeSymbol, OutputTypeWhitespace, OutputTypeString}, types)
+	tokens := Tokenize(`=A1>=TRUE`)
+	assert.Equal(t, Token{Text: ">=", Type: OutputTypeOperator, Offset: 3}, tokens[2])
+	assert.Equal(t, Token{Text: "TRUE", Type: OutputTypeBoolean, Offset: 5}, tokens[3])
+}
+
+func TestCallAt(t *testing.T) {
+	source := `=IF(A1>(1+2); SUM(B1; "a;b"`
+	name, arg, ok := CallAt(source, len(source))
+	assert.True(t, ok)
+	assert.Equal(t, "SUM", name)
+	assert.Equal(t, 1, arg)
+	name, arg, ok = CallAt(source, 9)
+	assert.True(t, ok)
+	assert.Equal(t, "IF", name)
+	assert.Equal(t, 0, arg)
+	_, _, ok = CallAt(`=SUM(1)+`, 8)
+	assert.False(t, ok)
+}
+
+func TestMatchingParen(t *testing.T) {
+	source := `=SUM((1+2)*")")`
+	from, to, ok := MatchingParen(source, 4)
+	assert.True(t, ok)
+	assert.Equal(t, 4, from)
+	assert.Equal(t, 14, to)
+	from, to, ok = MatchingParen(source, 10)
+	assert.True(t, ok)
+	assert.Equal(t, 9, from)
+	assert.Equal(t, 5, to)
+	_, _, ok = MatchingParen(`=SUM(1`, 4)
+	assert.False(t, ok)
+	_, _, ok = MatchingParen(source, 2)
+	assert.False(t, ok)
+}
+
+func TestQuoteSheet(t *testing.T) {
+	assert.Equal(t, "My_data", QuoteSheet("My_data"))
+	assert.Equal(t, "'Sheet1'", QuoteSheet("Sheet1"))
+	assert.Equal(t, "'My ''best'' sheet'", QuoteSheet("My 'best' sheet"))
+}
+
+func TestReferences(t *testing.T) {
+	refs := References(`=SUM(B3:a1; 'My sheet'!C2) + $D$4 + "E5" + Sheet2!A1:Sheet2!B2`)
+	assert.Equal(t, []Reference{
+		{Offset: 5, Length: 5, X: 0, Y: 0, MaxX: 1, MaxY: 2},
+		{Offset: 12, Length: 13, Sheet: "My sheet", X: 2, Y: 1, MaxX: 2, MaxY: 1},
+		{Offset: 29, Length: 4, X: 3, Y: 3, MaxX: 3, MaxY: 3},
+		{Offset: 43, Length: 19, Sheet: "Sheet2", X: 0, Y: 0, MaxX: 1, MaxY: 1},
+	}, refs)
+	ref, ok := ReferenceAt(`=A1+B2`, 6)
+	assert.True(t, ok)
+	assert.Equal(t, 4, ref.Offset)
+	_, ok = ReferenceAt(`=A1+ B2`, 4)
+	assert.False(t, ok)
+}
+
+func TestOperandExpected(t *testing.T) {
+	for source, expected := range map[string]bool{
+		"=|":          true,
+		"=SUM(|":      true,
+		"=SUM(A1;|)":  true,
+		"=A1+ | ":     true,
+		"=A1:|":       true,
+		"=A1|":        false,
+		"=SUM|(":      false,
+		"=1+|2":       false,
+		"=A1 |+":      false,
+		"|=":          false,
+		"A1+|":        false,
+		`="a|"`:       false,
+		"=(1+2)*| & ": true,
+	} {
+		pos := strings.Index(source, "|")
+		assert.Equal(t, expected, OperandExpected(strings.Replace(source, "|", "", 1), pos), source)
+	}
+}
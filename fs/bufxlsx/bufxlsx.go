@@ -2,10 +2,12 @@ package bufxlsx
 
 import (
 	"xl/document"
+	"xl/document/eval"
 	"xl/document/sheet"
 	"xl/fs"
 
-	"os"
+	"encoding/json"
+	"strconv"
 
 	"github.com/360EntSecGroup-Skylar/excelize"
 )
@@ -27,6 +29,11 @@ func (b *BufXLSX) Open() (*document.Document, error) {
 		return nil, err
 	}
 
+	styles, err := readStyles(b.filename)
+	if err != nil {
+		return nil, err
+	}
+
 	d := document.New()
 
 	for _, name := range xlsx.GetSheetMap() {
@@ -47,6 +54,13 @@ func (b *BufXLSX) Open() (*document.Document, error) {
 			cells[x] = make([]sheet.Cell, height)
 			for y := 0; y < height; y++ {
 				cells[x][y] = *sheet.NewCellUntyped(data[y][x])
+				if cf, ok := styles[name].formats[document.CellName(x, y)]; ok {
+					// the value of number is read as is, not formatted by excelize
+					if cf.number != "" {
+						cells[x][y].SetValueUntyped(cf.number)
+					}
+					cells[x][y].SetFormat(cf.format)
+				}
 			}
 		}
 
@@ -56,22 +70,171 @@ func (b *BufXLSX) Open() (*document.Document, error) {
 		}
 
 		s.AddStaticSegment(0, 0, width, height, cells)
+		s.SetStyles(styles[name].styles)
+
+		merges, err := readMerges(xlsx, name)
+		if err != nil {
+			return nil, err
+		}
+		s.SetMerges(merges)
 	}
 
 	return d, nil
 }
 
-// Write writes display values for the current sheet into CSV file.
+// Стиль XLSX, который получает ячейка: числовой формат и оформление.
+type cellStyle struct {
+	code  string
+	style sheet.Style
+}
+
+// Write writes the values of all sheets into XLSX file along with the number formats
+// and the styles of the cells. Formulas are written as their values, like in CSV.
 func (b *BufXLSX) Write(doc *document.Document) error {
-	file, err := os.Create(b.filename)
+	xlsx := excelize.NewFile()
+	styles := make(map[cellStyle]int)
+	for i, s := range doc.Sheets {
+		if i == 0 {
+			xlsx.SetSheetName(xlsx.GetSheetName(1), s.Title)
+		} else {
+			xlsx.NewSheet(s.Title)
+		}
+		ec := eval.NewContext(doc, s.Idx)
+		err := s.ShownCells(func(x, y int, c *sheet.Cell) error {
+			return b.writeCell(xlsx, s.Title, ec, c, x, y)
+		})
+		if err != nil {
+			return err
+		}
+		// styles are set by ranges, then the cells having number formats get
+		// the styles combining both
+		for _, sr := range s.Styles() {
+			r := sr.Rect
+			err := b.setStyle(xlsx, s.Title, r, cellStyle{style: sr.Style}, styles)
+			if err != nil {
+				return err
+			}
+		}
+		for _, m := range s.Merges() {
+			err := xlsx.MergeCell(s.Title, document.CellName(m.X, m.Y), document.CellName(m.MaxX(), m.MaxY()))
+			if err != nil {
+				return err
+			}
+		}
+		err = s.ShownCells(func(x, y int, c *sheet.Cell) error {
+			f := c.Format()
+			if f == nil {
+				return nil
+			}
+			cs := cellStyle{code: f.String(), style: s.CellStyle(x, y)}
+			return b.setStyle(xlsx, s.Title, sheet.Rect{X: x, Y: y, Width: 1, Height: 1}, cs, styles)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return xlsx.SaveAs(b.filename)
+}
+
+// readMerges returns the merged regions of the sheet.
+func readMerges(xlsx *excelize.File, name string) ([]sheet.Rect, error) {
+	cells, err := xlsx.GetMergeCells(name)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer func() {
-		_ = file.Close()
-	}()
+	var merges []sheet.Rect
+	for _, m := range cells {
+		x1, y1, _, _, err := document.CellAxis(m.GetStartAxis())
+		if err != nil {
+			return nil, err
+		}
+		x2, y2, _, _, err := document.CellAxis(m.GetEndAxis())
+		if err != nil {
+			return nil, err
+		}
+		merges = append(merges, sheet.Rect{X: x1, Y: y1, Width: x2 - x1 + 1, Height: y2 - y1 + 1})
+	}
+	return merges, nil
+}
 
-	// TODO
+// writeCell writes the value of the cell. Numbers are written as numbers, so the number
+// format applies to them.
+func (b *BufXLSX) writeCell(xlsx *excelize.File, sheetName string, ec *eval.Context, c *sheet.Cell, x, y int) error {
+	v, err := c.StringValue(ec)
+	if err != nil {
+		v = err.Error()
+	}
+	if v == "" {
+		return nil
+	}
+	var value interface{} = v
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		value = f
+	}
+	return xlsx.SetCellValue(sheetName, document.CellName(x, y), value)
+}
 
-	return nil
+// setStyle sets the style to the cells of the rect. XLSX styles are created once
+// for every combination of number format and style.
+func (b *BufXLSX) setStyle(xlsx *excelize.File, sheetName string, r sheet.Rect, cs cellStyle,
+	styles map[cellStyle]int) error {
+	id, ok := styles[cs]
+	if !ok {
+		js, err := json.Marshal(xlsxStyle(cs))
+		if err != nil {
+			return err
+		}
+		if id, err = xlsx.NewStyle(string(js)); err != nil {
+			return err
+		}
+		styles[cs] = id
+	}
+	return xlsx.SetCellStyle(sheetName, document.CellName(r.X, r.Y), document.CellName(r.MaxX(), r.MaxY()), id)
+}
+
+// xlsxStyle returns the style in the form excelize.NewStyle accepts.
+func xlsxStyle(cs cellStyle) map[string]interface{} {
+	st := cs.style
+	res := make(map[string]interface{})
+	if cs.code != "" {
+		res["custom_number_format"] = cs.code
+	}
+	font := make(map[string]interface{})
+	if st.Bold {
+		font["bold"] = true
+	}
+	if st.Italic {
+		font["italic"] = true
+	}
+	if st.Underline {
+		font["underline"] = "single"
+	}
+	if st.Fg != "" {
+		font["color"] = st.Fg
+	}
+	if len(font) > 0 {
+		res["font"] = font
+	}
+	if st.Bg != "" {
+		res["fill"] = map[string]interface{}{"type": "pattern", "pattern": 1, "color": []string{st.Bg}}
+	}
+	if st.Align != sheet.AlignGeneral || st.Wrap {
+		res["alignment"] = map[string]interface{}{
+			"horizontal": horizontalAlignments[st.Align],
+			"wrap_text":  st.Wrap,
+		}
+	}
+	var borders []map[string]interface{}
+	for _, side := range []struct {
+		name string
+		flag int
+	}{{"left", sheet.BorderLeft}, {"right", sheet.BorderRight}, {"top", sheet.BorderTop}, {"bottom", sheet.BorderBottom}} {
+		if st.Borders&side.flag != 0 {
+			borders = append(borders, map[string]interface{}{"type": side.name, "color": "000000", "style": 1})
+		}
+	}
+	if len(borders) > 0 {
+		res["border"] = borders
+	}
+	return res
 }
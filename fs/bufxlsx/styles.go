@@ -0,0 +1,277 @@
+package bufxlsx
+
+import (
+	"xl/document"
+	"xl/document/numfmt"
+	"xl/document/sheet"
+
+	"archive/zip"
+	"encoding/xml"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// Числовые форматы и оформление ячеек XLSX. excelize не дает доступа к кодам форматов
+// и стилям, а значения ячеек со встроенными форматами (даты, проценты) возвращает уже
+// отформатированными, поэтому стили и исходные значения числовых ячеек читаются
+// из архива напрямую.
+
+type xlsxStyleSheet struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	Fonts []struct {
+		Bold      *xlsxFlag `xml:"b"`
+		Italic    *xlsxFlag `xml:"i"`
+		Underline *xlsxFlag `xml:"u"`
+		Color     xlsxColor `xml:"color"`
+	} `xml:"fonts>font"`
+	Fills []struct {
+		Pattern struct {
+			Type    string    `xml:"patternType,attr"`
+			FgColor xlsxColor `xml:"fgColor"`
+		} `xml:"patternFill"`
+	} `xml:"fills>fill"`
+	Borders []struct {
+		Left   xlsxBorder `xml:"left"`
+		Right  xlsxBorder `xml:"right"`
+		Top    xlsxBorder `xml:"top"`
+		Bottom xlsxBorder `xml:"bottom"`
+	} `xml:"borders>border"`
+	CellXfs []struct {
+		NumFmtID  int `xml:"numFmtId,attr"`
+		FontID    int `xml:"fontId,attr"`
+		FillID    int `xml:"fillId,attr"`
+		BorderID  int `xml:"borderId,attr"`
+		Alignment struct {
+			Horizontal string `xml:"horizontal,attr"`
+			WrapText   string `xml:"wrapText,attr"`
+		} `xml:"alignment"`
+	} `xml:"cellXfs>xf"`
+}
+
+// Признак шрифта вроде <b/>, может быть выключен атрибутом val.
+type xlsxFlag struct {
+	Val string `xml:"val,attr"`
+}
+
+type xlsxColor struct {
+	RGB string `xml:"rgb,attr"`
+}
+
+type xlsxBorder struct {
+	Style string `xml:"style,attr"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxWorksheet struct {
+	Cells []struct {
+		Ref   string `xml:"r,attr"`
+		Style int    `xml:"s,attr"`
+		Type  string `xml:"t,attr"`
+		Value string `xml:"v"`
+	} `xml:"sheetData>row>c"`
+}
+
+// Числовой формат ячейки и ее исходное значение, если ячейка числовая.
+type cellFormat struct {
+	format *numfmt.Format
+	number string
+}
+
+// Числовые форматы ячеек листа по именам ячеек и оформление ячеек листа.
+type sheetStyles struct {
+	formats map[string]cellFormat
+	styles  []sheet.StyleRange
+}
+
+// readStyles returns the number formats of the cells having them and the styles
+// of the cells by sheet names.
+func readStyles(filename string) (map[string]sheetStyles, error) {
+	r, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	files := make(map[string]*zip.File, len(r.File))
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+
+	var ss xlsxStyleSheet
+	if err := readXML(files, "xl/styles.xml", &ss); err != nil {
+		return nil, err
+	}
+	codes := make(map[int]string)
+	for _, f := range ss.NumFmts {
+		codes[f.ID] = f.Code
+	}
+	// formats and styles by the indexes of XLSX styles
+	formats := make([]*numfmt.Format, len(ss.CellXfs))
+	styles := make([]sheet.Style, len(ss.CellXfs))
+	for i, xf := range ss.CellXfs {
+		styles[i] = xfStyle(&ss, i)
+		code, ok := codes[xf.NumFmtID]
+		if !ok {
+			code = numfmt.Builtin(xf.NumFmtID)
+		}
+		if code == "" || strings.EqualFold(code, "general") {
+			continue
+		}
+		// formats unknown to xl are ignored
+		formats[i], _ = numfmt.Parse(code)
+	}
+
+	var wb xlsxWorkbook
+	var rels xlsxRelationships
+	if err := readXML(files, "xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+	if err := readXML(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = rel.Target[1:]
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+
+	result := make(map[string]sheetStyles)
+	for _, s := range wb.Sheets {
+		var ws xlsxWorksheet
+		if err := readXML(files, targets[s.RID], &ws); err != nil {
+			return nil, err
+		}
+		sst := sheetStyles{formats: make(map[string]cellFormat)}
+		for _, c := range ws.Cells {
+			if c.Style <= 0 || c.Style >= len(formats) {
+				continue
+			}
+			if styles[c.Style] != (sheet.Style{}) {
+				x, y, _, _, err := document.CellAxis(c.Ref)
+				if err == nil {
+					sst.styles = append(sst.styles, sheet.StyleRange{
+						Rect:  sheet.Rect{X: x, Y: y, Width: 1, Height: 1},
+						Style: styles[c.Style],
+					})
+				}
+			}
+			if formats[c.Style] == nil {
+				continue
+			}
+			cf := cellFormat{format: formats[c.Style]}
+			if c.Type == "" || c.Type == "n" {
+				cf.number = c.Value
+			}
+			sst.formats[c.Ref] = cf
+		}
+		result[s.Name] = sst
+	}
+	return result, nil
+}
+
+// xfStyle returns the style of xl made of the XLSX style N. Colors given by theme
+// or palette index are not supported.
+func xfStyle(ss *xlsxStyleSheet, n int) sheet.Style {
+	var st sheet.Style
+	xf := ss.CellXfs[n]
+	if xf.FontID >= 0 && xf.FontID < len(ss.Fonts) {
+		font := ss.Fonts[xf.FontID]
+		st.Bold = font.Bold.on()
+		st.Italic = font.Italic.on()
+		st.Underline = font.Underline.on() && font.Underline.Val != "none"
+		st.Fg = font.Color.code()
+		// black is the default color of text in XLSX, but not in the terminal
+		if st.Fg == "#000000" {
+			st.Fg = ""
+		}
+	}
+	if xf.FillID >= 0 && xf.FillID < len(ss.Fills) {
+		if fill := ss.Fills[xf.FillID].Pattern; fill.Type == "solid" {
+			st.Bg = fill.FgColor.code()
+		}
+	}
+	if xf.BorderID >= 0 && xf.BorderID < len(ss.Borders) {
+		b := ss.Borders[xf.BorderID]
+		for _, side := range []struct {
+			border xlsxBorder
+			flag   int
+		}{{b.Left, sheet.BorderLeft}, {b.Right, sheet.BorderRight}, {b.Top, sheet.BorderTop}, {b.Bottom, sheet.BorderBottom}} {
+			if side.border.Style != "" && side.border.Style != "none" {
+				st.Borders |= side.flag
+			}
+		}
+	}
+	for align, name := range horizontalAlignments {
+		if name != "" && name == xf.Alignment.Horizontal {
+			st.Align = align
+		}
+	}
+	st.Wrap = xf.Alignment.WrapText == "1" || xf.Alignment.WrapText == "true"
+	return st
+}
+
+// Значения атрибута horizontal по выравниваниям xl.
+var horizontalAlignments = []string{
+	sheet.AlignGeneral: "",
+	sheet.AlignLeft:    "left",
+	sheet.AlignCenter:  "center",
+	sheet.AlignRight:   "right",
+}
+
+// on reports whether the flag is present and not turned off.
+func (f *xlsxFlag) on() bool {
+	return f != nil && f.Val != "0" && f.Val != "false"
+}
+
+// code returns the color as #rrggbb, ARGB colors of XLSX lose their alpha channel.
+func (c xlsxColor) code() string {
+	rgb := c.RGB
+	if len(rgb) == 8 {
+		rgb = rgb[2:]
+	}
+	if len(rgb) != 6 {
+		return ""
+	}
+	return "#" + strings.ToLower(rgb)
+}
+
+// readXML decodes the file of the archive. Missing file is left empty.
+func readXML(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, v)
+}
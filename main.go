@@ -29,6 +29,7 @@ func main() {
 		Input:  t.Input(),
 		Output: t.Output(),
 	})
+	defer a.Close()
 
 	flag.Parse()
 	args := flag.Args()
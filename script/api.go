@@ -0,0 +1,352 @@
+package script
+
+import (
+	"xl/document"
+	"xl/document/eval"
+	"xl/document/sheet"
+	"xl/formula"
+
+	"math"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+	"github.com/yuin/gopher-lua"
+)
+
+// Функции, доступные скриптам через таблицу xl.
+
+func (e *Engine) newAPI() *lua.LTable {
+	api := e.state.NewTable()
+	for name, f := range map[string]lua.LGFunction{
+		"sheets":         e.luaSheets,
+		"sheet":          e.luaSheet,
+		"newSheet":       e.luaNewSheet,
+		"size":           e.luaSize,
+		"cursor":         e.luaCursor,
+		"get":            e.luaGet,
+		"raw":            e.luaRaw,
+		"set":            e.luaSet,
+		"range":          e.luaRange,
+		"status":         e.luaStatus,
+		"defineFunction": e.luaDefineFunction,
+		"defineCommand":  e.luaDefineCommand,
+	} {
+		e.state.SetField(api, name, e.state.NewFunction(f))
+	}
+	return api
+}
+
+// xl.sheets() returns list of sheet titles.
+func (e *Engine) luaSheets(L *lua.LState) int {
+	t := L.NewTable()
+	for _, s := range e.host.Document().Sheets {
+		t.Append(lua.LString(s.Title))
+	}
+	L.Push(t)
+	return 1
+}
+
+// xl.sheet() returns title of the current sheet.
+func (e *Engine) luaSheet(L *lua.LState) int {
+	L.Push(lua.LString(e.host.Document().CurrentSheet.Title))
+	return 1
+}
+
+// xl.newSheet([title]) creates a new sheet and returns its title.
+func (e *Engine) luaNewSheet(L *lua.LState) int {
+	s, err := e.host.Document().NewSheet(L.OptString(1, ""))
+	if err != nil {
+		L.RaiseError(err.Error())
+	}
+	L.Push(lua.LString(s.Title))
+	return 1
+}
+
+// xl.size([sheet]) returns width and height of the sheet.
+func (e *Engine) luaSize(L *lua.LState) int {
+	s := e.checkSheet(L, 1)
+	L.Push(lua.LNumber(s.Size.X + s.Size.Width))
+	L.Push(lua.LNumber(s.Size.Y + s.Size.Height))
+	return 2
+}
+
+// xl.cursor() returns name of the cell under cursor.
+func (e *Engine) luaCursor(L *lua.LState) int {
+	s := e.host.Document().CurrentSheet
+	L.Push(lua.LString(document.CellName(s.Cursor.X, s.Cursor.Y)))
+	return 1
+}
+
+// xl.get(cell, [sheet]) returns evaluated value of the cell.
+// In case of evaluation error returns nil and error message.
+func (e *Engine) luaGet(L *lua.LState) int {
+	s, x, y := e.checkCell(L, 1, 2)
+	c := s.Cell(x, y)
+	if c == nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+	v, err := c.Value(eval.NewContext(e.host.Document(), s.Idx))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	lv, err := e.toLua(eval.NewContext(e.host.Document(), s.Idx), v)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lv)
+	return 1
+}
+
+// xl.raw(cell, [sheet]) returns raw value of the cell as it was entered.
+func (e *Engine) luaRaw(L *lua.LState) int {
+	s, x, y := e.checkCell(L, 1, 2)
+	c := s.Cell(x, y)
+	if c == nil {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	L.Push(lua.LString(c.RawValue()))
+	return 1
+}
+
+// xl.set(cell, value, [sheet]) writes new value to the cell.
+func (e *Engine) luaSet(L *lua.LState) int {
+	s, x, y := e.checkCell(L, 1, 3)
+	v := L.CheckAny(2)
+	var raw string
+	switch v.Type() {
+	case lua.LTNil:
+		raw = ""
+	case lua.LTBool:
+		raw = "FALSE"
+		if lua.LVAsBool(v) {
+			raw = "TRUE"
+		}
+	default:
+		raw = lua.LVAsString(v)
+	}
+	e.host.Document().SetCellValue(s, x, y, raw)
+	return 0
+}
+
+// xl.range(from, to, f, [sheet]) calls f(cell, value) for every cell of the range
+// row by row. Iteration stops if f returns false.
+func (e *Engine) luaRange(L *lua.LState) int {
+	s, x1, y1 := e.checkCell(L, 1, 4)
+	_, x2, y2 := e.checkCell(L, 2, 4)
+	f := L.CheckFunction(3)
+	if x1 > x2 || y1 > y2 {
+		L.ArgError(2, "invalid range bounds")
+	}
+	ec := eval.NewContext(e.host.Document(), s.Idx)
+	for y := y1; y <= y2; y++ {
+		for x := x1; x <= x2; x++ {
+			var lv lua.LValue = lua.LNil
+			if c := s.Cell(x, y); c != nil {
+				if v, err := c.Value(ec); err == nil {
+					lv, _ = e.toLua(ec, v)
+				}
+			}
+			err := L.CallByParam(lua.P{
+				Fn:      f,
+				NRet:    1,
+				Protect: true,
+			}, lua.LString(document.CellName(x, y)), lv)
+			if err != nil {
+				L.RaiseError(err.Error())
+			}
+			ret := L.Get(-1)
+			L.Pop(1)
+			if ret == lua.LFalse {
+				return 0
+			}
+		}
+	}
+	return 0
+}
+
+// xl.status(message) displays the message in status line.
+func (e *Engine) luaStatus(L *lua.LState) int {
+	e.host.ShowStatus(L.CheckString(1))
+	return 0
+}
+
+// xl.defineFunction(name, options, f) registers f as formula function.
+// Options is a table with optional fields: min, max (number of arguments), args (list of
+// argument names), help and volatile.
+func (e *Engine) luaDefineFunction(L *lua.LState) int {
+	name := L.CheckString(1)
+	opts := L.CheckTable(2)
+	f := L.CheckFunction(3)
+	def := formula.FunctionDef{
+		MinArgs:  intField(opts, "min", 0),
+		MaxArgs:  intField(opts, "max", 1),
+		Volatile: lua.LVAsBool(opts.RawGetString("volatile")),
+		Help:     lua.LVAsString(opts.RawGetString("help")),
+	}
+	if args, ok := opts.RawGetString("args").(*lua.LTable); ok {
+		args.ForEach(func(_, v lua.LValue) {
+			def.ArgNames = append(def.ArgNames, lua.LVAsString(v))
+		})
+	}
+	def.F = func(ec *eval.Context, args []eval.Value) (eval.Value, error) {
+		luaArgs := make([]lua.LValue, len(args))
+		for i, a := range args {
+			lv, err := e.toLua(ec, a)
+			if err != nil {
+				return eval.NewEmptyValue(), err
+			}
+			luaArgs[i] = lv
+		}
+		err := e.state.CallByParam(lua.P{
+			Fn:      f,
+			NRet:    1,
+			Protect: true,
+		}, luaArgs...)
+		if err != nil {
+			return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindFormula, err.Error())
+		}
+		ret := e.state.Get(-1)
+		e.state.Pop(1)
+		return fromLua(ret)
+	}
+	if err := formula.RegisterFunction(name, def); err != nil {
+		L.RaiseError(err.Error())
+	}
+	return 0
+}
+
+// xl.defineCommand(name, f) registers f as a command which can be typed after ':'.
+// Command arguments are passed to f as strings.
+func (e *Engine) luaDefineCommand(L *lua.LState) int {
+	name := L.CheckString(1)
+	e.commands[name] = L.CheckFunction(2)
+	return 0
+}
+
+// checkSheet returns sheet with title given as Nth argument or current sheet
+// if argument is absent.
+func (e *Engine) checkSheet(L *lua.LState, n int) *sheet.Sheet {
+	d := e.host.Document()
+	title := L.OptString(n, "")
+	if title == "" {
+		return d.CurrentSheet
+	}
+	s := d.SheetByTitle(title)
+	if s == nil {
+		L.ArgError(n, "sheet not found")
+	}
+	return s
+}
+
+// checkCell returns position of the cell with name given as Nth argument
+// on sheet given as sheetN argument.
+func (e *Engine) checkCell(L *lua.LState, n, sheetN int) (*sheet.Sheet, int, int) {
+	s := e.checkSheet(L, sheetN)
+	x, y, _, _, err := document.CellAxis(L.CheckString(n))
+	if err != nil {
+		L.ArgError(n, err.Error())
+	}
+	return s, x, y
+}
+
+// toLua converts formula Value to Lua value. Ranges are converted to
+// the list of rows, each row is a list of values.
+func (e *Engine) toLua(ec *eval.Context, v eval.Value) (lua.LValue, error) {
+	switch v.Type() {
+	case eval.TypeEmpty:
+		return lua.LNil, nil
+	case eval.TypeBool:
+		b, err := v.BoolValue(ec)
+		return lua.LBool(b), err
+	case eval.TypeDecimal:
+		d, err := v.DecimalValue(ec)
+		f, _ := d.Float64()
+		return lua.LNumber(f), err
+	case eval.TypeString:
+		s, err := v.StringValue(ec)
+		return lua.LString(s), err
+	case eval.TypeRef:
+		rv, err := ec.DataProvider.Value(ec, v.Cell().CellAddress)
+		if err != nil {
+			return lua.LNil, err
+		}
+		return e.toLua(ec, rv)
+	case eval.TypeRangeRef:
+		cell, cellTo := v.Cell(), v.CellTo()
+		if cell.SheetIdx != cellTo.SheetIdx {
+			return lua.LNil, eval.NewError(eval.ErrorKindRef, "3D ranges can not be passed to scripts")
+		}
+		s := e.host.Document().SheetByIdx(cell.SheetIdx)
+		if s == nil {
+			return lua.LNil, eval.NewError(eval.ErrorKindRef, "sheet not found")
+		}
+		// whole rows and columns end at the last cell of the sheet as when the range is iterated
+		maxX, maxY := cellTo.X, cellTo.Y
+		if maxX > s.Size.MaxX() {
+			maxX = s.Size.MaxX()
+		}
+		if maxY > s.Size.MaxY() {
+			maxY = s.Size.MaxY()
+		}
+		var values []lua.LValue
+		err := ec.DataProvider.IterateStringValues(ec, cell.CellAddress, cellTo.CellAddress, func(text string) error {
+			if f, err := strconv.ParseFloat(text, 64); err == nil {
+				values = append(values, lua.LNumber(f))
+			} else {
+				values = append(values, lua.LString(text))
+			}
+			return nil
+		})
+		if err != nil {
+			return lua.LNil, err
+		}
+		// values are iterated column by column, regroup them into rows
+		width := maxX - cell.X + 1
+		height := maxY - cell.Y + 1
+		t := e.state.NewTable()
+		for r := 0; r < height; r++ {
+			row := e.state.NewTable()
+			for c := 0; c < width && c*height+r < len(values); c++ {
+				row.Append(values[c*height+r])
+			}
+			t.Append(row)
+		}
+		return t, nil
+	default:
+		return lua.LNil, eval.NewError(eval.ErrorKindCasting, "unable to pass value of type %d to scripts", v.Type())
+	}
+}
+
+// fromLua converts value returned by Lua function to formula Value.
+func fromLua(lv lua.LValue) (eval.Value, error) {
+	switch v := lv.(type) {
+	case *lua.LNilType:
+		return eval.NewEmptyValue(), nil
+	case lua.LBool:
+		return eval.NewBoolValue(bool(v)), nil
+	case lua.LNumber:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindNum, "script returned %s, which is not a number", v.String())
+		}
+		return eval.NewDecimalValue(decimal.NewFromFloat(f)), nil
+	case lua.LString:
+		return eval.NewStringValue(string(v)), nil
+	default:
+		return eval.NewEmptyValue(), eval.NewError(eval.ErrorKindCasting, "unable to use %s as value", lv.Type().String())
+	}
+}
+
+// intField returns integer value of table field or default value if field is not set.
+func intField(t *lua.LTable, name string, d int) int {
+	if v, ok := t.RawGetString(name).(lua.LNumber); ok {
+		return int(v)
+	}
+	return d
+}
@@ -0,0 +1,85 @@
+package script
+
+import (
+	"xl/document"
+
+	"sort"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// Встроенный интерпретатор Lua. Скрипты получают доступ к документу через глобальную
+// таблицу xl: могут читать и изменять ячейки, обходить диапазоны, добавлять листы,
+// а также регистрировать собственные функции для формул и команды для строки ':'.
+
+// HostInterface is implemented by the application which runs scripts.
+type HostInterface interface {
+	// Document returns the document scripts operate on.
+	Document() *document.Document
+	// ShowStatus displays a message in status line.
+	ShowStatus(msg string)
+}
+
+type Engine struct {
+	host     HostInterface
+	state    *lua.LState
+	commands map[string]*lua.LFunction
+}
+
+func New(host HostInterface) *Engine {
+	e := &Engine{
+		host:     host,
+		state:    lua.NewState(),
+		commands: make(map[string]*lua.LFunction),
+	}
+	e.state.SetGlobal("xl", e.newAPI())
+	return e
+}
+
+// Close frees resources allocated by interpreter.
+func (e *Engine) Close() {
+	e.state.Close()
+}
+
+// DoFile executes script from the file.
+func (e *Engine) DoFile(filename string) error {
+	return e.state.DoFile(filename)
+}
+
+// DoString executes script given as a string.
+func (e *Engine) DoString(source string) error {
+	return e.state.DoString(source)
+}
+
+// HasCommand checks if a command with given name was registered by a script.
+func (e *Engine) HasCommand(name string) bool {
+	_, ok := e.commands[name]
+	return ok
+}
+
+// Commands returns sorted names of commands registered by scripts.
+func (e *Engine) Commands() []string {
+	names := make([]string, 0, len(e.commands))
+	for name := range e.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RunCommand calls the command registered by a script passing command arguments to it.
+func (e *Engine) RunCommand(name string, args []string) error {
+	f, ok := e.commands[name]
+	if !ok {
+		return nil
+	}
+	luaArgs := make([]lua.LValue, len(args))
+	for i, a := range args {
+		luaArgs[i] = lua.LString(a)
+	}
+	return e.state.CallByParam(lua.P{
+		Fn:      f,
+		NRet:    0,
+		Protect: true,
+	}, luaArgs...)
+}
@@ -0,0 +1,154 @@
+package script
+
+import (
+	"xl/document"
+	"xl/document/eval"
+	"xl/document/sheet"
+	"xl/formula"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testHost struct {
+	doc    *document.Document
+	status string
+}
+
+func (h *testHost) Document() *document.Document {
+	return h.doc
+}
+
+func (h *testHost) ShowStatus(msg string) {
+	h.status = msg
+}
+
+func TestScriptCells(t *testing.T) {
+	h := &testHost{doc: document.NewWithEmptySheet()}
+	e := New(h)
+	defer e.Close()
+
+	err := e.DoString(`
+		xl.set("A1", 2)
+		xl.set("A2", "=A1*10")
+		local v = xl.get("A2")
+		xl.set("B1", v + 1)
+		xl.status(xl.raw("A2"))
+	`)
+	assert.NoError(t, err)
+
+	v, err := h.doc.CurrentSheet.Cell(1, 0).StringValue(eval.NewContext(h.doc, h.doc.CurrentSheet.Idx))
+	assert.NoError(t, err)
+	assert.Equal(t, "21", v)
+	assert.Equal(t, "=A1*10", h.status)
+}
+
+func TestScriptFunction(t *testing.T) {
+	h := &testHost{doc: document.NewWithEmptySheet()}
+	e := New(h)
+	defer e.Close()
+
+	err := e.DoString(`
+		xl.defineFunction("TOTAL", {min = 1, max = 1, args = {"range"}, help = "Sums range"}, function(rows)
+			local s = 0
+			for _, row in ipairs(rows) do
+				for _, v in ipairs(row) do
+					s = s + v
+				end
+			end
+			return s
+		end)
+	`)
+	assert.NoError(t, err)
+	defer formula.UnregisterFunction("TOTAL")
+
+	h.doc.CurrentSheet.AddStaticSegment(0, 0, 2, 2, [][]sheet.Cell{
+		{*sheet.NewCellUntyped("1"), *sheet.NewCellUntyped("3")},
+		{*sheet.NewCellUntyped("2"), *sheet.NewCellUntyped("4")},
+	})
+	h.doc.CurrentSheet.SetCell(0, 2, sheet.NewCellUntyped("=TOTAL(A1:B2)"))
+
+	v, err := h.doc.CurrentSheet.Cell(0, 2).StringValue(eval.NewContext(h.doc, h.doc.CurrentSheet.Idx))
+	assert.NoError(t, err)
+	assert.Equal(t, "10", v)
+}
+
+func TestScriptFunctionLinesAnd3DRange(t *testing.T) {
+	h := &testHost{doc: document.NewWithEmptySheet()}
+	e := New(h)
+	defer e.Close()
+
+	err := e.DoString(`
+		xl.defineFunction("SHAPE", {min = 1, max = 1, args = {"range"}, help = "Size of range"}, function(rows)
+			local width = 0
+			for _, row in ipairs(rows) do
+				if #row > width then
+					width = #row
+				end
+			end
+			return #rows * 100 + width
+		end)
+	`)
+	assert.NoError(t, err)
+	defer formula.UnregisterFunction("SHAPE")
+
+	s := h.doc.CurrentSheet
+	for y := 0; y < 3; y++ {
+		s.SetCell(0, y, sheet.NewCellUntyped("1"))
+	}
+	s.SetCell(2, 0, sheet.NewCellUntyped("=SHAPE(A:A)"))
+	ec := eval.NewContext(h.doc, s.Idx)
+	v, err := s.Cell(2, 0).StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "301", v)
+
+	h.doc.NewSheet("Sheet2")
+	s.SetCell(3, 0, sheet.NewCellUntyped("=SHAPE('Sheet1':Sheet2!A1:A3)"))
+	_, err = s.Cell(3, 0).StringValue(ec)
+	assert.EqualError(t, err, "3D ranges can not be passed to scripts")
+}
+
+func TestScriptFunctionNaN(t *testing.T) {
+	h := &testHost{doc: document.NewWithEmptySheet()}
+	e := New(h)
+	defer e.Close()
+
+	err := e.DoString(`
+		xl.defineFunction("RATIO", {min = 2, max = 2, args = {"number", "number"}, help = "Divides"}, function(a, b)
+			return a / b
+		end)
+	`)
+	assert.NoError(t, err)
+	defer formula.UnregisterFunction("RATIO")
+
+	s := h.doc.CurrentSheet
+	ec := eval.NewContext(h.doc, s.Idx)
+	s.SetCell(0, 0, sheet.NewCellUntyped("=RATIO(1; 4)"))
+	v, err := s.Cell(0, 0).StringValue(ec)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.25", v)
+	for i, f := range []string{"=RATIO(0; 0)", "=RATIO(1; 0)", "=RATIO(-1; 0)"} {
+		s.SetCell(1, i, sheet.NewCellUntyped(f))
+		_, err = s.Cell(1, i).StringValue(ec)
+		if assert.Error(t, err, f) {
+			assert.Equal(t, eval.ErrorKindNum, err.(*eval.Error).Kind(), f)
+		}
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	h := &testHost{doc: document.NewWithEmptySheet()}
+	e := New(h)
+	defer e.Close()
+
+	err := e.DoString(`
+		xl.defineCommand("greet", function(name)
+			xl.status("hello " .. name)
+		end)
+	`)
+	assert.NoError(t, err)
+	assert.True(t, e.HasCommand("greet"))
+	assert.NoError(t, e.RunCommand("greet", []string{"world"}))
+	assert.Equal(t, "hello world", h.status)
+}
@@ -6,6 +6,7 @@ import (
 
 type InputInterface interface {
 	ReadKey() (InputEventInterface, error)
+	ReadClipboard() (string, error)
 }
 
 type InputEventInterface interface {
@@ -18,3 +19,13 @@ type KeyEvent struct {
 	Key tcell.Key
 	Ch  rune
 }
+
+// MouseEvent is a click, a move or a wheel scroll of the mouse at the screen position.
+// Buttons are empty when the buttons are released.
+type MouseEvent struct {
+	InputEventInterface
+
+	X, Y    int
+	Buttons tcell.ButtonMask
+	Mod     tcell.ModMask
+}
@@ -4,6 +4,8 @@ import (
 	"xl/document/sheet"
 	"xl/formula"
 
+	"errors"
+
 	"github.com/gdamore/tcell"
 )
 
@@ -23,6 +25,9 @@ const (
 	StatusFlagError = 1 << iota
 )
 
+// ErrCancelled is returned when user cancels the input.
+var ErrCancelled = errors.New("cancelled")
+
 type DirtyFlag int
 
 type OutputInterface interface {
@@ -31,35 +36,121 @@ type OutputInterface interface {
 	ViewportHeight() int
 	ViewportWidth() int
 	SetDirty(DirtyFlag)
-	InputCommand() (string, error)
-	EditCellValue(string) (string, error)
+	// InputCommand reads the command in status line. Tab replaces the command with
+	// the candidates returned by complete one after another.
+	InputCommand(complete func(string) []string) (string, error)
+	// InputSearch reads the search pattern in status line calling onChange as it is typed.
+	// Returns ErrCancelled if the input is cancelled with Esc.
+	InputSearch(prompt string, onChange func(string)) (string, error)
+	// EditCellValue edits the value in formula line. Formulas are highlighted and hints
+	// of the functions being called are shown.
+	EditCellValue(value string, opts EditOptions) (string, error)
 	SetStatus(string, int)
+	SetClipboard(string)
+	// Locate returns what is shown at the screen position on the last drawing.
+	Locate(x, y int) Location
 	Screen() tcell.Screen
 }
 
-type DataDelegateInterface interface {
-	DocView() *DocView
+// Параметры редактирования значения ячейки.
+type EditOptions struct {
+	// Допустимые значения ячейки, выводятся списком под строкой редактора, откуда
+	// их можно выбрать Up, Down и Enter.
+	Choices []string
+	// Варианты дополнения текста, стоящего перед курсором, по нажатию Tab.
+	Complete func(string) []string
+	// Если задан, ссылки формулы отмечаются на сетке, а стрелками в формулу вставляются
+	// ссылки на ячейки, указанные на сетке.
+	Point PointDelegateInterface
+}
+
+// PointDelegateInterface shows the references of the formula being edited on the grid
+// and moves the reference cursor of point mode over it.
+type PointDelegateInterface interface {
+	// SetFormula marks the cells referenced by the formula text.
+	SetFormula(text string)
+	// MovePoint moves the reference cursor by dx, dy cells and returns the reference
+	// to the cell under it, or to the range from the cell pointing started at if extend
+	// is set. Pointing starts at the cell of the reference ref which is replaced, or at
+	// the cell being edited if ref is empty.
+	MovePoint(ref string, dx, dy int, extend bool) string
+	// StopPoint hides the reference cursor.
+	StopPoint()
+}
+
+// Что находится в точке экрана.
+const (
+	LocationNone = iota
+	LocationCell
+	LocationRowHeader
+	LocationColHeader
+	// Правая граница колонки в горизонтальной линейке.
+	LocationColBorder
+	LocationSheetTab
+)
+
+// Location описывает, что находится в точке экрана: ячейка X, Y или заголовок строки Y
+// или колонки X в окне Window, либо ярлык листа Sheet в строке статуса.
+type Location struct {
+	Kind   int
+	Window int
+	X, Y   int
+	Sheet  int
+}
+
+// SheetDelegateInterface provides the data of the sheet shown in a window.
+type SheetDelegateInterface interface {
 	SheetView() *SheetView
 	CellView(x, y int) *CellView
 	RowView(n int) *RowView
 	ColView(n int) *ColView
 }
 
+// DataDelegateInterface provides the data of the document; the sheet methods work
+// with the current window.
+type DataDelegateInterface interface {
+	SheetDelegateInterface
+	DocView() *DocView
+	// WindowDelegate returns the delegate of the window N, windows are numbered
+	// in order of DocView.Layout leaves.
+	WindowDelegate(n int) SheetDelegateInterface
+}
+
 type CellView struct {
 	Name        string
 	DisplayText string
-	Error       *string
-	Expression  *formula.Expression
+	// Цвет значения, заданный числовым форматом ячейки, например "red", или пустая строка.
+	Color string
+	// Оформление ячейки. Общее выравнивание уже заменено на выравнивание по значению.
+	Style sheet.Style
+	// Значение ячейки отмечено командой :validate как не прошедшее проверку.
+	Invalid bool
+	// Объединение, в которое входит ячейка, или nil. Все ячейки объединения получают
+	// представление его левой верхней ячейки.
+	Merge *sheet.Rect
+	// Номер ссылки редактируемой формулы на ячейку, начиная с 1, или 0. Ссылки
+	// различаются цветом.
+	Ref int
+	// Ячейка под курсором указания ссылок или в указанном диапазоне.
+	Point      bool
+	Error      *string
+	Expression *formula.Expression
 }
 
 type RowView struct {
 	Name   string
 	Height int
+	// Скрытая строка не выводится.
+	Hidden bool
 }
 
 type ColView struct {
 	Name  string
 	Width int
+	// Скрытая колонка не выводится.
+	Hidden bool
+	// Для колонки задано условие автофильтра.
+	Filtered bool
 }
 
 type SheetView struct {
@@ -67,14 +158,37 @@ type SheetView struct {
 	Cursor          sheet.Cursor
 	Viewport        sheet.Viewport
 	FormulaLineView FormulaLineView
+	// Выделенные ячейки или nil, если ничего не выделено.
+	Selection *sheet.Rect
+	// Сводка по значениям выделенных ячеек.
+	SelectionStat string
+	// Состояние автофильтра, например "FILTER 12/40", или пустая строка, если фильтра нет.
+	FilterStat string
+	// Число закрепленных строк и колонок, они выводятся перед областью просмотра.
+	FrozenRows int
+	FrozenCols int
 }
 
 type FormulaLineView struct {
 	DisplayText string
 	Expression  *formula.Expression
+	// Выводить ли ссылки в нотации R1C1 относительно ячейки под курсором.
+	R1C1 bool
 }
 
 type DocView struct {
 	Sheets          []string
 	CurrentSheetIdx int
+	// Деление экрана на окна и номер текущего окна.
+	Layout        *Layout
+	CurrentWindow int
+}
+
+// Layout описывает деление экрана на окна. Лист дерева - окно с номером Window, узел
+// делит свою область поровну между Children: слева направо, если Vertical, иначе
+// сверху вниз.
+type Layout struct {
+	Window   int
+	Vertical bool
+	Children []*Layout
 }
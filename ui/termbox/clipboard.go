@@ -0,0 +1,94 @@
+package termbox
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell"
+)
+
+// Обмен текстом с системным буфером обмена идет через управляющую последовательность
+// OSC 52, которую поддерживает большинство терминалов, в том числе при работе через ssh.
+// Терминал кладет переданный ему текст в буфер обмена, а на запрос "?" отвечает
+// содержимым буфера в такой же последовательности. Ответ приходит как обычный ввод,
+// и tcell разбирает его на нажатия клавиш: ESC ] становится Alt+], завершающий BEL -
+// Ctrl+G, а завершающий ESC \ - Alt+\.
+
+const clipboardTimeout = time.Second
+
+// SetClipboard puts the text to the system clipboard.
+func (t *Termbox) SetClipboard(text string) {
+	_, _ = fmt.Fprintf(t.tty, "\x1b]52;c;%s\x07", base64.StdEncoding.EncodeToString([]byte(text)))
+}
+
+// ReadClipboard requests the text from the system clipboard.
+// Fails if the terminal does not answer in time.
+func (t *Termbox) ReadClipboard() (string, error) {
+	if _, err := io.WriteString(t.tty, "\x1b]52;c;?\x07"); err != nil {
+		return "", err
+	}
+	// event which interrupts waiting for the answer, it is recognized by its data
+	timeout := new(int)
+	timer := time.AfterFunc(clipboardTimeout, func() {
+		_ = t.screen.PostEvent(tcell.NewEventInterrupt(timeout))
+	})
+	var answer []rune
+	started := false
+	for {
+		switch ev := t.screen.PollEvent().(type) {
+		case nil:
+			return "", errors.New("screen is closed")
+		case *tcell.EventInterrupt:
+			if ev.Data() == timeout {
+				return "", errors.New("terminal does not answer clipboard request")
+			}
+		case *tcell.EventKey:
+			alt := ev.Modifiers()&tcell.ModAlt != 0
+			switch {
+			case alt && ev.Rune() == ']':
+				started, answer = true, answer[:0]
+			case !started:
+				// keys pressed before the answer are dropped
+			case ev.Key() == tcell.KeyCtrlG || (alt && ev.Rune() == '\\'):
+				if !timer.Stop() {
+					t.waitInterrupt(timeout)
+				}
+				return parseClipboardAnswer(string(answer))
+			case ev.Key() == tcell.KeyRune:
+				answer = append(answer, ev.Rune())
+			}
+		}
+	}
+}
+
+// waitInterrupt skips events until the interrupt event with given data, so it does not
+// get to the main loop.
+func (t *Termbox) waitInterrupt(data interface{}) {
+	for {
+		switch ev := t.screen.PollEvent().(type) {
+		case nil:
+			return
+		case *tcell.EventInterrupt:
+			if ev.Data() == data {
+				return
+			}
+		}
+	}
+}
+
+// parseClipboardAnswer decodes the text from the answer "52;c;<base64>".
+func parseClipboardAnswer(answer string) (string, error) {
+	parts := strings.SplitN(answer, ";", 3)
+	if len(parts) != 3 || parts[0] != "52" {
+		return "", errors.New("malformed clipboard answer")
+	}
+	text, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
@@ -1,14 +1,19 @@
 package termbox
 
 import (
+	"xl/formula"
 	"xl/ui"
 
-	"errors"
+	"bytes"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/gdamore/tcell"
 )
 
+// Наибольшее число вариантов, выводимых в списке под редактором.
+const maxChoiceLines = 10
+
 // Редактор рисует на экране окно для ввода текста.
 // Он формирует собственный цикл обработки событий, поэтому, пока редактор открыт,
 // вне его на экране ничего не изменяется. Внутренний цикл обработки событий слушает
@@ -30,12 +35,13 @@ func (t *Termbox) enterEditorMode(config *editorConfig) (string, error) {
 			return "", err
 		}
 		if keyEvent, ok := event.(ui.KeyEvent); ok {
+			if config.CancelOnEsc && keyEvent.Key == tcell.KeyEsc {
+				return "", ui.ErrCancelled
+			}
 			stop := e.OnKey(keyEvent)
 			if stop {
 				break
 			}
-		} else {
-			return "", errors.New("unknown event")
 		}
 	}
 	return e.Text(), nil
@@ -45,6 +51,16 @@ type ResizeEventDelegateInterface interface {
 	OnResize(newLines int)
 }
 
+// Делегат, который предлагает варианты дополнения текста по нажатию Tab.
+type CompleteDelegateInterface interface {
+	Complete(text string) []string
+}
+
+// Делегат, которому сообщается о каждом изменении текста в редакторе.
+type ChangeEventDelegateInterface interface {
+	OnChange(text string)
+}
+
 type editorConfig struct {
 	Tbox                *Termbox
 	X                   int
@@ -54,9 +70,26 @@ type editorConfig struct {
 	MaxRunes            int
 	MaxLines            int
 	ResizeEventDelegate ResizeEventDelegateInterface
-	FgColor             tcell.Color
-	BgColor             tcell.Color
-	Value               string
+	ChangeEventDelegate ChangeEventDelegateInterface
+	// Если задан, Tab и Shift-Tab заменяют текст вариантами дополнения по очереди.
+	CompleteDelegate CompleteDelegateInterface
+	// Esc прерывает ввод, и редактор возвращает ошибку ui.ErrCancelled.
+	CancelOnEsc bool
+	// Если заданы, под однострочным редактором выводится список вариантов, содержащих
+	// введенный текст; Up и Down выбирают вариант, Enter подставляет выбранный.
+	Choices []string
+	// Enter завершает ввод и в многострочном редакторе, новая строка вводится Alt-Enter.
+	NewLineOnAltEnter bool
+	// Текст, начинающийся с '=', подсвечивается как формула: лексемы выводятся своими
+	// цветами, выделяется пара скобок у курсора, а под редактором показывается
+	// сигнатура вызываемой функции.
+	Formula bool
+	// Если задан, ссылки формулы отмечаются на сетке, а стрелки вставляют в формулу
+	// ссылки на ячейки, указанные курсором указания.
+	PointDelegate ui.PointDelegateInterface
+	FgColor       tcell.Color
+	BgColor       tcell.Color
+	Value         string
 }
 
 type line struct {
@@ -84,33 +117,51 @@ type editor struct {
 	topLine    *line
 	linesCount int
 	window     window
+	// Текст, о котором последний раз сообщено делегату изменений.
+	lastText string
+	// Варианты дополнения и номер выбранного варианта, сбрасываются при вводе.
+	completions []string
+	completion  int
+	// Номер выбранного варианта в списке Choices, подходящих к тексту, или -1.
+	choice int
+	// Размеры списка или подсказки, выведенных под редактором в последний раз.
+	popupLines int
+	popupWidth int
+	// Идет ли указание ссылки и положение указываемой ссылки в тексте в байтах.
+	pointing  bool
+	pointFrom int
+	pointTo   int
+	// Сетку нужно перерисовать под редактором: изменились ссылки или курсор указания.
+	gridDirty bool
 }
 
 func newEditor(config *editorConfig) *editor {
-	l := line{
-		// FIXME: assuming single line
-		data: []byte(config.Value),
-	}
 	e := &editor{
-		config: config,
-		cursor: cursor{
-			line:        &l,
-			offsetRunes: utf8.RuneCountInString(config.Value),
-			offsetBytes: len(config.Value),
-		},
-		window: window{
-			topLine:   &l,
-			firstRune: 0,
-		},
-		firstLine:  &l,
-		lastLine:   &l,
-		linesCount: 1,
+		config:   config,
+		lastText: config.Value,
+		choice:   -1,
+	}
+	e.setText(config.Value)
+	if config.PointDelegate != nil {
+		config.PointDelegate.SetFormula(config.Value)
+		e.gridDirty = true
 	}
 	e.redraw()
 	return e
 }
 
 func (e *editor) OnKey(ev ui.KeyEvent) bool {
+	if ev.Key != tcell.KeyTab && ev.Key != tcell.KeyBacktab {
+		e.completions = nil
+	}
+	if len(e.config.Choices) > 0 && e.pickChoice(ev) {
+		return ev.Key == tcell.KeyEnter
+	}
+	if e.config.PointDelegate != nil && e.point(ev) {
+		e.notifyChange()
+		e.redraw()
+		return false
+	}
 	switch ev.Key {
 	case tcell.KeyCtrlF, tcell.KeyRight:
 		e.moveCursorForward()
@@ -133,7 +184,7 @@ func (e *editor) OnKey(ev ui.KeyEvent) bool {
 	//case termbox.KeyCtrlSlash:
 	//v.on_vcommand(vcommand_undo, 0)
 	case tcell.KeyEnter, tcell.KeyCtrlJ:
-		if e.config.MaxLines <= 1 {
+		if e.config.MaxLines <= 1 || e.config.NewLineOnAltEnter && ev.Mod&tcell.ModAlt == 0 {
 			// exit editor when in single-line mode
 			return true
 		} else if e.linesCount < e.config.MaxLines {
@@ -151,8 +202,12 @@ func (e *editor) OnKey(ev ui.KeyEvent) bool {
 		//v.on_vcommand(vcommand_kill_line, 0)
 	case tcell.KeyPgUp:
 		//v.on_vcommand(vcommand_move_view_half_backward, 0)
-	case tcell.KeyTab:
-		e.insertRune('\t')
+	case tcell.KeyTab, tcell.KeyBacktab:
+		if e.config.CompleteDelegate != nil {
+			e.complete(ev.Key == tcell.KeyBacktab)
+		} else if ev.Key == tcell.KeyTab {
+			e.insertRune('\t')
+		}
 	// case tcell.KeyCtrlSpace:
 	// 	if ev.Ch == 0 {
 	// 		v.set_mark()
@@ -170,14 +225,296 @@ func (e *editor) OnKey(ev ui.KeyEvent) bool {
 		}
 	}
 
+	e.notifyChange()
 	e.redraw()
 
 	return false
 }
 
+// notifyChange tells the delegates about the change of the text, if it is changed.
+func (e *editor) notifyChange() {
+	text := e.Text()
+	if text == e.lastText {
+		return
+	}
+	e.lastText = text
+	if e.config.ChangeEventDelegate != nil {
+		e.config.ChangeEventDelegate.OnChange(text)
+	}
+	if e.config.PointDelegate != nil {
+		e.config.PointDelegate.SetFormula(text)
+		e.gridDirty = true
+	}
+}
+
+// point moves the reference cursor with arrow keys and puts the reference to the pointed
+// cells at the cursor of the editor, Shift extends the pointed range. Pointing starts
+// where a reference can be inserted; Up and Down also start it over the reference
+// at the cursor to replace it. Other keys stop pointing. Reports whether the key
+// is handled.
+func (e *editor) point(ev ui.KeyEvent) bool {
+	dx, dy := 0, 0
+	switch ev.Key {
+	case tcell.KeyUp:
+		dy = -1
+	case tcell.KeyDown:
+		dy = 1
+	case tcell.KeyLeft:
+		dx = -1
+	case tcell.KeyRight:
+		dx = 1
+	}
+	if dx == 0 && dy == 0 {
+		if e.pointing {
+			e.pointing = false
+			e.config.PointDelegate.StopPoint()
+			e.gridDirty = true
+		}
+		return false
+	}
+	text := e.Text()
+	if !e.pointing {
+		offset := e.cursorOffset()
+		if !e.config.Formula || e.linesCount > 1 || !strings.HasPrefix(text, "=") {
+			return false
+		}
+		if formula.OperandExpected(text, offset) {
+			e.pointFrom, e.pointTo = offset, offset
+		} else if ref, ok := formula.ReferenceAt(text, offset); ok && dy != 0 {
+			e.pointFrom, e.pointTo = ref.Offset, ref.Offset+ref.Length
+		} else {
+			return false
+		}
+		e.pointing = true
+	}
+	ref := e.config.PointDelegate.MovePoint(text[e.pointFrom:e.pointTo], dx, dy, ev.Mod&tcell.ModShift != 0)
+	e.setText(text[:e.pointFrom] + ref + text[e.pointTo:])
+	e.pointTo = e.pointFrom + len(ref)
+	e.moveCursorToOffset(e.pointTo)
+	e.gridDirty = true
+	return true
+}
+
+// complete replaces the text before the cursor with the next variant of completion,
+// or the previous one if backward is set, the text after the cursor is kept. Variants
+// are asked for on the first Tab after the text is typed, the original text comes back
+// after the last variant.
+func (e *editor) complete(backward bool) {
+	head, tail := e.splitAtCursor()
+	if e.completions == nil {
+		variants := e.config.CompleteDelegate.Complete(head)
+		if len(variants) == 0 {
+			return
+		}
+		e.completions = append(variants, head)
+		e.completion = len(e.completions) - 1
+	}
+	n := len(e.completions)
+	if backward {
+		e.completion = (e.completion + n - 1) % n
+	} else {
+		e.completion = (e.completion + 1) % n
+	}
+	e.setText(e.completions[e.completion] + tail)
+	e.moveCursorToOffset(len(e.completions[e.completion]))
+}
+
+// pickChoice moves the selection in the list of choices or puts the selected choice
+// into the editor. Reports whether the key is handled.
+func (e *editor) pickChoice(ev ui.KeyEvent) bool {
+	choices := e.matchingChoices()
+	switch ev.Key {
+	case tcell.KeyDown, tcell.KeyCtrlN:
+		if e.choice < len(choices)-1 {
+			e.choice++
+		}
+	case tcell.KeyUp, tcell.KeyCtrlP:
+		if e.choice >= 0 {
+			e.choice--
+		}
+	case tcell.KeyEnter:
+		if e.choice < 0 || e.choice >= len(choices) {
+			return false
+		}
+		e.setText(choices[e.choice])
+	default:
+		e.choice = -1
+		return false
+	}
+	e.redraw()
+	return true
+}
+
+// matchingChoices returns the choices containing the text of the editor.
+func (e *editor) matchingChoices() []string {
+	text := strings.ToLower(e.Text())
+	var choices []string
+	for _, c := range e.config.Choices {
+		if strings.Contains(strings.ToLower(c), text) {
+			choices = append(choices, c)
+		}
+	}
+	return choices
+}
+
+// drawChoices draws the list of choices matching the text under the editor.
+func (e *editor) drawChoices() {
+	choices := e.matchingChoices()
+	if len(choices) > maxChoiceLines {
+		choices = choices[:maxChoiceLines]
+	}
+	width := 0
+	for _, c := range e.config.Choices {
+		if n := utf8.RuneCountInString(c); n > width {
+			width = n
+		}
+	}
+	width += 2
+	if width > e.config.Width {
+		width = e.config.Width
+	}
+	y := e.config.Y + e.config.Height
+	for i, c := range choices {
+		fg, bg := tcell.ColorWhite, tcell.Color238
+		if i == e.choice {
+			fg, bg = tcell.ColorBlack, tcell.ColorWhite
+		}
+		e.config.Tbox.drawCell(e.config.X, y+i, width, 1, " "+c, fg, bg)
+	}
+	e.popupLines, e.popupWidth = len(choices), width
+}
+
+// drawHint draws the signature of the function being called at the cursor under the
+// editor, the argument the cursor is at is highlighted.
+func (e *editor) drawHint() {
+	text := e.Text()
+	if !strings.HasPrefix(text, "=") {
+		return
+	}
+	name, arg, ok := formula.CallAt(text, e.cursorOffset())
+	if !ok {
+		return
+	}
+	def, ok := formula.LookupFunction(name)
+	if !ok {
+		return
+	}
+	hint := []rune(" " + def.Signature(name) + " ")
+	st := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.Color238)
+	styles := make([]tcell.Style, len(hint))
+	from, to := argumentSpan(hint, arg)
+	for i := range styles {
+		styles[i] = st
+		if i >= from && i < to {
+			styles[i] = st.Foreground(tcell.ColorYellow).Bold(true)
+		}
+	}
+	width := len(hint)
+	if width > e.config.Width {
+		width = e.config.Width
+	}
+	e.config.Tbox.drawRunes(e.config.X, e.config.Y+e.config.Height, width, hint, styles, st)
+	e.popupLines, e.popupWidth = 1, width
+}
+
+// clearPopup clears the lines drawn under the editor the last time.
+func (e *editor) clearPopup() {
+	for i := 0; i < e.popupLines; i++ {
+		e.config.Tbox.drawCell(e.config.X, e.config.Y+e.config.Height+i, e.popupWidth, 1, "", tcell.ColorWhite, tcell.ColorBlack)
+	}
+	e.popupLines, e.popupWidth = 0, 0
+}
+
+// argumentSpan returns the range of runes of the argument N in the function signature.
+// Arguments past the last named one fall on the trailing "...", if there is one.
+func argumentSpan(signature []rune, n int) (int, int) {
+	var spans [][2]int
+	start := -1
+loop:
+	for i, r := range signature {
+		switch {
+		case r == '(' && start < 0:
+			start = i + 1
+		case (r == ';' || r == ')') && start >= 0:
+			for start < i && signature[start] == ' ' {
+				start++
+			}
+			spans = append(spans, [2]int{start, i})
+			if r == ')' {
+				break loop
+			}
+			start = i + 1
+		}
+	}
+	if n >= len(spans) {
+		last := len(spans) - 1
+		if last < 0 || string(signature[spans[last][0]:spans[last][1]]) != "..." {
+			return 0, 0
+		}
+		n = last
+	}
+	return spans[n][0], spans[n][1]
+}
+
+// setText replaces the text of the editor and moves the cursor to its end.
+func (e *editor) setText(text string) {
+	e.firstLine, e.lastLine, e.linesCount = nil, nil, 0
+	for _, data := range strings.Split(text, "\n") {
+		l := &line{data: []byte(data), prev: e.lastLine}
+		if e.lastLine == nil {
+			e.firstLine = l
+		} else {
+			e.lastLine.next = l
+		}
+		e.lastLine = l
+		e.linesCount++
+	}
+	last := e.lastLine.data
+	e.cursor = cursor{line: e.lastLine, offsetBytes: len(last), offsetRunes: utf8.RuneCount(last)}
+	e.window = window{topLine: e.firstLine}
+	e.adjustWindow()
+}
+
+// Text returns the text of the editor, its lines are separated with "\n".
 func (e *editor) Text() string {
-	// FIXME: assuming single line
-	return string(e.firstLine.data)
+	var b bytes.Buffer
+	for l := e.firstLine; l != nil; l = l.next {
+		if l != e.firstLine {
+			b.WriteByte('\n')
+		}
+		b.Write(l.data)
+	}
+	return b.String()
+}
+
+// splitAtCursor returns the text before the cursor and after it.
+func (e *editor) splitAtCursor() (string, string) {
+	text := e.Text()
+	offset := e.cursorOffset()
+	return text[:offset], text[offset:]
+}
+
+// cursorOffset returns the position of the cursor in the text in bytes.
+func (e *editor) cursorOffset() int {
+	n := 0
+	for l := e.firstLine; l != nil && l != e.cursor.line; l = l.next {
+		n += len(l.data) + 1
+	}
+	return n + e.cursor.offsetBytes
+}
+
+// moveCursorToOffset moves the cursor to the position of the text in bytes.
+func (e *editor) moveCursorToOffset(offset int) {
+	l := e.firstLine
+	for l.next != nil && offset > len(l.data) {
+		offset -= len(l.data) + 1
+		l = l.next
+	}
+	if offset > len(l.data) {
+		offset = len(l.data)
+	}
+	e.cursor = cursor{line: l, offsetBytes: offset, offsetRunes: utf8.RuneCount(l.data[:offset])}
+	e.adjustWindow()
 }
 
 // insertRune inserts a rune 'r' at the current cursor position,
@@ -363,14 +700,28 @@ func (e *editor) insertLine() {
 }
 
 func (e *editor) redraw() {
+	if e.gridDirty {
+		// the windows are drawn over the list or the hint under the editor
+		e.config.Tbox.SetDirty(ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid)
+		e.config.Tbox.RefreshView()
+		e.gridDirty = false
+		e.popupLines = 0
+	}
 	y := e.config.Y
 	line := e.window.topLine
+	fill := tcell.StyleDefault.Foreground(e.config.FgColor).Background(e.config.BgColor)
 	for y-e.config.Y < e.config.Height {
-		text := ""
-		if line != nil && e.window.firstRune < len(string(line.data)) {
-			text = string(line.data)[e.window.firstRune:]
+		var runes []rune
+		var styles []tcell.Style
+		if line != nil {
+			runes, styles = e.lineStyles(line, fill)
 		}
-		e.config.Tbox.drawCell(e.config.X, y, e.config.Width, 1, text, e.config.FgColor, e.config.BgColor)
+		if e.window.firstRune < len(runes) {
+			runes, styles = runes[e.window.firstRune:], styles[e.window.firstRune:]
+		} else {
+			runes, styles = nil, nil
+		}
+		e.config.Tbox.drawRunes(e.config.X, y, e.config.Width, runes, styles, fill)
 		if line != nil {
 			if line == e.cursor.line {
 				e.config.Tbox.screen.ShowCursor(e.config.X+e.cursor.offsetRunes-e.window.firstRune, y)
@@ -380,17 +731,72 @@ func (e *editor) redraw() {
 		}
 		y++
 	}
+	e.clearPopup()
+	if len(e.config.Choices) > 0 {
+		e.drawChoices()
+	} else if e.config.Formula {
+		e.drawHint()
+	}
 	e.config.Tbox.screen.Show()
 }
 
+// lineStyles returns the runes of the line and their styles. A formula has its tokens
+// colored and the parenthesis at the cursor highlighted together with its pair.
+func (e *editor) lineStyles(l *line, fill tcell.Style) ([]rune, []tcell.Style) {
+	text := string(l.data)
+	runes := []rune(text)
+	if !e.config.Formula || e.linesCount > 1 || !strings.HasPrefix(text, "=") {
+		styles := make([]tcell.Style, len(runes))
+		for i := range styles {
+			styles[i] = fill
+		}
+		return runes, styles
+	}
+	styles := tokenStyles(text, fill)
+	if e.config.PointDelegate != nil {
+		// references are colored the same as the cells they mark on the grid
+		for i, ref := range formula.References(text) {
+			from := utf8.RuneCountInString(text[:ref.Offset])
+			to := from + utf8.RuneCountInString(text[ref.Offset:ref.Offset+ref.Length])
+			for j := from; j < to; j++ {
+				styles[j] = styles[j].Foreground(refColor(i + 1))
+			}
+		}
+	}
+	if from, to, ok := formula.MatchingParen(text, e.cursor.offsetBytes); ok {
+		for _, offset := range []int{from, to} {
+			i := utf8.RuneCountInString(text[:offset])
+			styles[i] = styles[i].Background(tcell.ColorTeal)
+		}
+	}
+	return runes, styles
+}
+
 func (e *editor) adjustWindow() {
 	if e.window.firstRune < e.cursor.offsetRunes-(e.config.Width-1) {
 		e.window.firstRune = e.cursor.offsetRunes - (e.config.Width - 1)
 	} else if e.window.firstRune > e.cursor.offsetRunes {
 		e.window.firstRune = e.cursor.offsetRunes
 	}
-	// TODO: adjust vertical position
-	//if e.cursor.lineNum
+	// the line of the cursor is kept inside the window
+	cursorLine, topLine := e.lineNum(e.cursor.line), e.lineNum(e.window.topLine)
+	switch {
+	case cursorLine < topLine:
+		e.window.topLine = e.cursor.line
+	case cursorLine >= topLine+e.config.Height:
+		for ; cursorLine >= topLine+e.config.Height; topLine++ {
+			e.window.topLine = e.window.topLine.next
+		}
+	}
+}
+
+// lineNum returns the number of the line of the text.
+func (e *editor) lineNum(l *line) int {
+	n := 0
+	for p := e.firstLine; p != nil && p != l; p = p.next {
+		n++
+	}
+	return n
 }
 
 func cloneBytes(s []byte) []byte {
@@ -23,14 +23,15 @@ func (t *Termbox) ReadKey() (ui.InputEventInterface, error) {
 	case *tcell.EventResize:
 	//handling resize
 	case *tcell.EventMouse:
-	//handling mouse
+		x, y := ev.Position()
+		return ui.MouseEvent{X: x, Y: y, Buttons: ev.Buttons(), Mod: ev.Modifiers()}, nil
 	case *tcell.EventError:
 		return nil, errors.New("unknown event")
 	}
 	return nil, nil
 }
 
-func (t *Termbox) EditCellValue(oldValue string) (string, error) {
+func (t *Termbox) EditCellValue(oldValue string, opts ui.EditOptions) (string, error) {
 	w, _ := t.screen.Size()
 	v, err := t.enterEditorMode(&editorConfig{
 		Tbox:     t,
@@ -38,10 +39,16 @@ func (t *Termbox) EditCellValue(oldValue string) (string, error) {
 		Y:        0,
 		Width:    w,
 		Height:   formulaLineHeight,
-		MaxLines: 1,
+		MaxLines: maxCellLines,
 		FgColor:  tcell.ColorWhite,
 		BgColor:  tcell.ColorBlack,
 		Value:    oldValue,
+		Choices:  opts.Choices,
+		Formula:  true,
+
+		CompleteDelegate:  completeFunc(opts.Complete),
+		PointDelegate:     opts.Point,
+		NewLineOnAltEnter: true,
 	})
 	if err != nil {
 		return "", err
@@ -49,7 +56,7 @@ func (t *Termbox) EditCellValue(oldValue string) (string, error) {
 	return v, nil
 }
 
-func (t *Termbox) InputCommand() (string, error) {
+func (t *Termbox) InputCommand(complete func(string) []string) (string, error) {
 	w, h := t.screen.Size()
 	t.drawCell(0, h-statusLineHeight, 1, statusLineHeight, ":", tcell.ColorWhite, tcell.ColorBlack)
 	v, err := t.enterEditorMode(&editorConfig{
@@ -61,9 +68,45 @@ func (t *Termbox) InputCommand() (string, error) {
 		MaxLines: 1,
 		FgColor:  tcell.ColorWhite,
 		BgColor:  tcell.ColorBlack,
+
+		CompleteDelegate: completeFunc(complete),
 	})
 	if err != nil {
 		return "", err
 	}
 	return v, nil
 }
+
+// completeFunc adapts a function to the editor completion delegate.
+type completeFunc func(string) []string
+
+func (f completeFunc) Complete(text string) []string {
+	if f == nil {
+		return nil
+	}
+	return f(text)
+}
+
+// changeFunc adapts a function to the editor change delegate.
+type changeFunc func(string)
+
+func (f changeFunc) OnChange(text string) {
+	f(text)
+}
+
+func (t *Termbox) InputSearch(prompt string, onChange func(string)) (string, error) {
+	w, h := t.screen.Size()
+	t.drawCell(0, h-statusLineHeight, w, statusLineHeight, prompt, tcell.ColorWhite, tcell.ColorBlack)
+	return t.enterEditorMode(&editorConfig{
+		Tbox:                t,
+		X:                   len(prompt),
+		Y:                   h - statusLineHeight,
+		Width:               w - len(prompt),
+		Height:              statusLineHeight,
+		MaxLines:            1,
+		FgColor:             tcell.ColorWhite,
+		BgColor:             tcell.ColorBlack,
+		ChangeEventDelegate: changeFunc(onChange),
+		CancelOnEsc:         true,
+	})
+}
@@ -1,7 +1,9 @@
 package termbox
 
 import (
-	"bytes"
+	"strings"
+	"xl/document/sheet"
+	"xl/formula"
 	"xl/ui"
 
 	"github.com/gdamore/tcell"
@@ -15,6 +17,9 @@ const (
 	statusLineHeight  = 1
 	hRulerHeight      = 1
 	formulaLineHeight = 1
+
+	// Наибольшее число строк значения, вводимого в ячейку.
+	maxCellLines = 100
 )
 
 func (t *Termbox) SetDataDelegate(delegate ui.DataDelegateInterface) {
@@ -40,71 +45,261 @@ func (t *Termbox) RefreshView() {
 		formulaLineView := sheetView.FormulaLineView
 		currentCellName := t.dataDelegate.CellView(sheetView.Cursor.X, sheetView.Cursor.Y).Name
 		t.drawCell(0, 0, t.screenWidth, formulaLineHeight, currentCellName, tcell.ColorYellow, tcell.ColorBlack)
-		text := formulaLineView.DisplayText
+		fill := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlack)
+		var runes []rune
+		var styles []tcell.Style
+		add := func(s string, st tcell.Style) {
+			// line breaks of multi-line values are shown as marks
+			for _, r := range strings.Replace(s, "\n", "↵", -1) {
+				runes = append(runes, r)
+				styles = append(styles, st)
+			}
+		}
 		if formulaLineView.Expression != nil {
-			var buf bytes.Buffer
-			formulaLineView.Expression.Output(func(s string, t int) {
-				buf.WriteString(s)
-			})
-			text = buf.String()
+			of := func(s string, t int) {
+				add(s, tokenStyle(t, fill))
+			}
+			if formulaLineView.R1C1 {
+				of = formula.R1C1Output(of, sheetView.Cursor.X, sheetView.Cursor.Y)
+			}
+			formulaLineView.Expression.Output(of)
+		} else {
+			add(formulaLineView.DisplayText, fill)
+		}
+		x := len(currentCellName) + 1
+		t.drawRunes(x, 0, t.screenWidth-x, runes, styles, fill)
+	}
+
+	// windows with rulers and grids
+	if t.dirty&(ui.DirtyHRuler|ui.DirtyVRuler|ui.DirtyGrid) > 0 {
+		area := rect{0, formulaLineHeight, t.screenWidth, t.screenHeight - formulaLineHeight - statusLineHeight}
+		windows := t.layoutWindows(docView.Layout, area, nil)
+		t.windows = make([]windowGeometry, len(windows))
+		for i := 0; i < len(windows); i++ {
+			t.windows[i] = t.drawWindow(t.dataDelegate.WindowDelegate(i), windows[i], i == docView.CurrentWindow)
+		}
+	}
+
+	// status line
+	if t.dirty&ui.DirtyStatusLine > 0 {
+		screenY := t.screenHeight - statusLineHeight
+		screenX := t.drawSheetTabs(docView, screenY, t.screenWidth/2)
+		fgColor := tcell.ColorWhite
+		bgColor := tcell.ColorBlack
+		if t.statusFlags&ui.StatusFlagError > 0 {
+			bgColor = tcell.ColorRed
+		}
+		t.drawCell(screenX, screenY, t.screenWidth-screenX, statusLineHeight, t.statusMessage, fgColor, bgColor)
+		// summary of the selection and the filter state are aligned to the right
+		// unless they overlap the message
+		stat := sheetView.SelectionStat
+		if sheetView.FilterStat != "" {
+			stat = strings.TrimSpace(sheetView.FilterStat + " " + stat)
+		}
+		if stat != "" {
+			statX := t.screenWidth - len(stat)
+			if statX > screenX+len([]rune(t.statusMessage)) {
+				t.drawCell(statX, screenY, len(stat), statusLineHeight, stat, tcell.ColorYellow, bgColor)
+			}
 		}
-		t.drawCell(len(currentCellName)+1, 0, t.screenWidth, formulaLineHeight, text, tcell.ColorWhite, tcell.ColorBlack)
+	}
+	t.dirty = 0
+	t.screen.Show()
+}
+
+// drawSheetTabs draws the tabs of the sheets at the beginning of the status line taking
+// no more than maxWidth chars. If not all tabs fit, they are scrolled so the current one
+// is visible, and arrows show that there are more tabs. Returns the width of the tab bar.
+func (t *Termbox) drawSheetTabs(docView *ui.DocView, y, maxWidth int) int {
+	widths := make([]int, len(docView.Sheets))
+	for i, s := range docView.Sheets {
+		widths[i] = len([]rune(s)) + 2
+		if widths[i] > sheetNameMaxWidth+2 {
+			widths[i] = sheetNameMaxWidth + 2
+		}
+	}
+	// the first tab is moved right until the current one fits along with the arrows
+	first := 0
+	for first < docView.CurrentSheetIdx {
+		width := 2
+		for i := first; i <= docView.CurrentSheetIdx; i++ {
+			width += widths[i]
+		}
+		if width <= maxWidth {
+			break
+		}
+		first++
+	}
+	t.tabs = t.tabs[:0]
+	x := 0
+	if first > 0 {
+		t.drawCell(x, y, 1, statusLineHeight, "<", tcell.ColorYellow, tcell.ColorBlack)
+		x++
+	}
+	for i := first; i < len(docView.Sheets); i++ {
+		if x+widths[i] > maxWidth {
+			t.drawCell(x, y, 1, statusLineHeight, ">", tcell.ColorYellow, tcell.ColorBlack)
+			return x + 1
+		}
+		bgColor := tcell.ColorBlack
+		fgColor := tcell.ColorWhite
+		if i == docView.CurrentSheetIdx {
+			bgColor = tcell.ColorWhite
+			fgColor = tcell.ColorBlack
+		}
+		t.drawCell(x, y, 1, statusLineHeight, "", fgColor, bgColor)
+		t.drawCell(x+1, y, widths[i]-2, statusLineHeight, docView.Sheets[i], fgColor, bgColor)
+		t.drawCell(x+widths[i]-1, y, 1, statusLineHeight, "", fgColor, bgColor)
+		t.tabs = append(t.tabs, sheetTab{i, x, widths[i]})
+		x += widths[i]
+	}
+	return x
+}
+
+// Прямоугольная область экрана.
+type rect struct {
+	x, y          int
+	width, height int
+}
+
+// Строка или колонка листа на экране: ее номер, позиция и размер в символах.
+type screenLine struct {
+	n    int
+	pos  int
+	size int
+}
+
+// Окно на экране: его область, ширина вертикальной линейки и видимые строки и колонки.
+type windowGeometry struct {
+	area        rect
+	vRulerWidth int
+	rows, cols  []screenLine
+}
+
+// Ярлык листа в строке статуса: номер листа, позиция и ширина.
+type sheetTab struct {
+	sheet int
+	pos   int
+	width int
+}
+
+// layoutWindows splits the area between the windows of the layout and draws separators
+// between side by side windows. Returns the areas of the windows by their numbers.
+func (t *Termbox) layoutWindows(l *ui.Layout, area rect, areas map[int]rect) map[int]rect {
+	if areas == nil {
+		areas = make(map[int]rect)
+	}
+	if l == nil {
+		areas[0] = area
+		return areas
+	}
+	if len(l.Children) == 0 {
+		areas[l.Window] = area
+		return areas
+	}
+	n := len(l.Children)
+	for i, c := range l.Children {
+		r := area
+		if l.Vertical {
+			// windows are separated by one column
+			r.x = area.x + i*(area.width+1)/n
+			r.width = area.x + (i+1)*(area.width+1)/n - 1 - r.x
+			if i < n-1 {
+				st := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlack)
+				for y := area.y; y < area.y+area.height; y++ {
+					t.screen.SetContent(r.x+r.width, y, tcell.RuneVLine, nil, st)
+				}
+			}
+		} else {
+			r.y = area.y + i*area.height/n
+			r.height = area.y + (i+1)*area.height/n - r.y
+		}
+		t.layoutWindows(c, r, areas)
+	}
+	return areas
+}
+
+// drawWindow draws the rulers and the grid of the sheet in the area of the window.
+// Frozen rows and columns are drawn before the viewport. Sizes of the viewport are
+// remembered for the current window only. Returns the placement of the window.
+func (t *Termbox) drawWindow(d ui.SheetDelegateInterface, area rect, current bool) windowGeometry {
+	sheetView := d.SheetView()
+	rows, nextRow := visibleLines(sheetView.FrozenRows, sheetView.Viewport.Top, area.y+hRulerHeight, area.y+area.height, func(n int) (int, bool) {
+		rowView := d.RowView(n)
+		return pixelsToCharsY(rowView.Height), rowView.Hidden
+	})
+	vRulerWidth := 0
+	for _, r := range rows {
+		if w := len(d.RowView(r.n).Name) + 1; w > vRulerWidth {
+			vRulerWidth = w
+		}
+	}
+	if vRulerWidth > area.width {
+		vRulerWidth = area.width
+	}
+	cols, nextCol := visibleLines(sheetView.FrozenCols, sheetView.Viewport.Left, area.x+vRulerWidth, area.x+area.width, func(n int) (int, bool) {
+		colView := d.ColView(n)
+		return pixelsToCharsX(colView.Width), colView.Hidden
+	})
+	if current {
+		t.calculatedViewportHeight = nextRow - sheetView.Viewport.Top
+		t.calculatedViewportWidth = nextCol - sheetView.Viewport.Left
 	}
 
 	// vertical ruler
 	if t.dirty&ui.DirtyVRuler > 0 {
-		screenY := formulaLineHeight + hRulerHeight
-		cellY := sheetView.Viewport.Top
-		t.vRulerWidth = 0
-		for screenY < t.screenHeight-statusLineHeight {
-			rowView := t.dataDelegate.RowView(cellY)
-			heightChars := pixelsToCharsY(rowView.Height)
+		for _, r := range rows {
 			fg := tcell.ColorWhite
-			if cellY == sheetView.Cursor.Y {
+			if r.n == sheetView.Cursor.Y {
 				fg = tcell.ColorYellow
 			}
-			t.drawCell(0, screenY, len(rowView.Name)+1+1, heightChars, rowView.Name, fg, tcell.ColorBlack)
-			if len(rowView.Name)+1 > t.vRulerWidth {
-				t.vRulerWidth = len(rowView.Name) + 1
-			}
-			cellY++
-			screenY += heightChars
+			t.drawCell(area.x, r.pos, vRulerWidth, r.size, d.RowView(r.n).Name, fg, tcell.ColorBlack)
 		}
-		t.calculatedViewportHeight = cellY - sheetView.Viewport.Top
 	}
 
 	// horizontal ruler
 	if t.dirty&ui.DirtyHRuler > 0 {
-		screenX := t.vRulerWidth
-		screenY := formulaLineHeight
-		cellX := sheetView.Viewport.Left
-		for screenX < t.screenWidth {
-			colView := t.dataDelegate.ColView(cellX)
-			widthChars := pixelsToCharsX(colView.Width)
+		t.drawCell(area.x, area.y, vRulerWidth, hRulerHeight, "", tcell.ColorWhite, tcell.ColorBlack)
+		for _, c := range cols {
+			colView := d.ColView(c.n)
 			fg := tcell.ColorWhite
-			if cellX == sheetView.Cursor.X {
+			if colView.Filtered {
+				fg = tcell.ColorAqua
+			}
+			if c.n == sheetView.Cursor.X {
 				fg = tcell.ColorYellow
 			}
-			t.drawCell(screenX, screenY, widthChars, hRulerHeight, colView.Name, fg, tcell.ColorBlack)
-			cellX++
-			screenX += widthChars
+			t.drawCell(c.pos, area.y, c.size, hRulerHeight, colView.Name, fg, tcell.ColorBlack)
 		}
-		t.calculatedViewportWidth = cellX - sheetView.Viewport.Left
 	}
 
 	// grid
 	if t.dirty&ui.DirtyGrid > 0 {
-		cellY := sheetView.Viewport.Top
-		screenY := formulaLineHeight + hRulerHeight
-		for screenY < t.screenHeight-statusLineHeight {
-			cellX := sheetView.Viewport.Left
-			screenX := t.vRulerWidth
-			heightChars := pixelsToCharsY(t.dataDelegate.RowView(cellY).Height)
-			for screenX < t.screenWidth {
-				widthChars := pixelsToCharsX(t.dataDelegate.ColView(cellX).Width)
-				c := t.dataDelegate.CellView(cellX, cellY)
-				text := c.DisplayText
+		if current {
+			// the cursor is shown again if its cell is visible
+			t.screen.HideCursor()
+		}
+		// merged region is drawn as one cell from its first visible cell, the part
+		// of the region separated by the frozen lines is drawn as another one
+		drawnMerges := make(map[sheet.Rect]mergedArea)
+		for i, r := range rows {
+			for j, c := range cols {
+				cellX, cellY := c.n, r.n
+				cellView := d.CellView(cellX, cellY)
+				text := cellView.DisplayText
+				width, height := c.size, r.size
+				if m := cellView.Merge; m != nil {
+					if drawn, ok := drawnMerges[*m]; ok && drawn.covers(i, j) {
+						continue
+					}
+					cellX, cellY = m.X, m.Y
+					area := mergedArea{row: i, col: j}
+					width, area.cols = mergedSize(cols[j:], m.X, m.MaxX())
+					height, area.rows = mergedSize(rows[i:], m.Y, m.MaxY())
+					drawnMerges[*m] = area
+				}
 
+				style := cellView.Style
 				bgColor := tcell.ColorBlack
 				if cellX%2 != 0 || cellY%2 == 0 {
 					bgColor = tcell.Color235
@@ -112,47 +307,208 @@ func (t *Termbox) RefreshView() {
 				if cellX%2 != 0 && cellY%2 == 0 {
 					bgColor = tcell.Color238
 				}
-				if cellX == sheetView.Cursor.X && cellY == sheetView.Cursor.Y {
-					t.lastCursorX = screenX
-					t.lastCursorY = screenY
-					t.screen.ShowCursor(screenX, screenY)
+				if style.Bg != "" {
+					bgColor = tcell.GetColor(style.Bg)
+				}
+				if cellView.Invalid {
+					bgColor = tcell.ColorMaroon
+				}
+				if sheetView.Selection != nil && sheetView.Selection.Contains(cellX, cellY) {
+					bgColor = tcell.ColorNavy
+				}
+				if cellView.Ref > 0 {
+					bgColor = refColor(cellView.Ref)
 				}
-				if c.Error != nil {
-					text = *c.Error
+				if current && cellX == sheetView.Cursor.X && cellY == sheetView.Cursor.Y {
+					t.lastCursorX = c.pos
+					t.lastCursorY = r.pos
+					t.screen.ShowCursor(c.pos, r.pos)
+				}
+				fgColor := tcell.ColorSilver
+				if style.Fg != "" {
+					fgColor = tcell.GetColor(style.Fg)
+				}
+				if cellView.Color != "" {
+					fgColor = tcell.GetColor(cellView.Color)
+				}
+				if cellView.Error != nil {
+					text = *cellView.Error
 					bgColor = tcell.ColorRed
 				}
-				t.drawCell(screenX, screenY, widthChars, heightChars, text, tcell.ColorSilver, bgColor)
-				cellX++
-				screenX += widthChars
+				if cellView.Point {
+					fgColor, bgColor = tcell.ColorBlack, tcell.ColorYellow
+				}
+				st := tcell.StyleDefault.Foreground(fgColor).Background(bgColor).
+					Bold(style.Bold).Italic(style.Italic)
+				t.drawStyledCell(c.pos, r.pos, width, height, text, st, style)
 			}
-			cellY++
-			screenY += heightChars
 		}
 	}
+	return windowGeometry{area, vRulerWidth, rows, cols}
+}
 
-	// status line
-	if t.dirty&ui.DirtyStatusLine > 0 {
-		screenX := 0
-		screenY := t.screenHeight - statusLineHeight
-		for i, s := range docView.Sheets {
-			bgColor := tcell.ColorBlack
-			fgColor := tcell.ColorWhite
-			if i == docView.CurrentSheetIdx {
-				bgColor = tcell.ColorWhite
-				fgColor = tcell.ColorBlack
+// Экранные строки и колонки, которые занимает нарисованная часть объединения: номера
+// первых из них в списках видимых строк и колонок окна и их количество.
+type mergedArea struct {
+	row, rows int
+	col, cols int
+}
+
+func (a mergedArea) covers(row, col int) bool {
+	return row >= a.row && row < a.row+a.rows && col >= a.col && col < a.col+a.cols
+}
+
+// mergedSize returns the size and the number of the screen lines following each other
+// from the first one which belong to the merged region spanning from line first to line last.
+func mergedSize(lines []screenLine, first, last int) (int, int) {
+	size, n := 0, 0
+	for i, l := range lines {
+		if l.n < first || l.n > last || i > 0 && l.pos != lines[i-1].pos+lines[i-1].size {
+			break
+		}
+		size += l.size
+		n++
+	}
+	return size, n
+}
+
+// Locate returns what is shown at the screen position: a cell or a header in one
+// of the windows, or a sheet tab in status line. The last char of a column header
+// is its border which can be dragged to resize the column.
+func (t *Termbox) Locate(x, y int) ui.Location {
+	return locate(x, y, t.screenHeight-statusLineHeight, t.windows, t.tabs)
+}
+
+// locate finds what is at the screen position among the windows and the sheet tabs
+// placed on the screen; statusY is the row of the status line.
+func locate(x, y, statusY int, windows []windowGeometry, tabs []sheetTab) ui.Location {
+	if y == statusY {
+		for _, tab := range tabs {
+			if x >= tab.pos && x < tab.pos+tab.width {
+				return ui.Location{Kind: ui.LocationSheetTab, Sheet: tab.sheet}
 			}
-			t.drawCell(screenX, screenY, sheetNameMaxWidth, statusLineHeight, s, fgColor, bgColor)
-			screenX += sheetNameMaxWidth
 		}
-		fgColor := tcell.ColorWhite
-		bgColor := tcell.ColorBlack
-		if t.statusFlags&ui.StatusFlagError > 0 {
-			bgColor = tcell.ColorRed
+		return ui.Location{}
+	}
+	for i, w := range windows {
+		a := w.area
+		if x < a.x || x >= a.x+a.width || y < a.y || y >= a.y+a.height {
+			continue
 		}
-		t.drawCell(screenX, screenY, t.screenWidth-screenX, statusLineHeight, t.statusMessage, fgColor, bgColor)
+		loc := ui.Location{Window: i, X: -1, Y: -1}
+		for _, c := range w.cols {
+			if x >= c.pos && x < c.pos+c.size {
+				loc.X = c.n
+				if y < a.y+hRulerHeight && x == c.pos+c.size-1 {
+					loc.Kind = ui.LocationColBorder
+					return loc
+				}
+			}
+		}
+		for _, r := range w.rows {
+			if y >= r.pos && y < r.pos+r.size {
+				loc.Y = r.n
+			}
+		}
+		switch {
+		case y < a.y+hRulerHeight && loc.X >= 0:
+			loc.Kind = ui.LocationColHeader
+		case x < a.x+w.vRulerWidth && loc.Y >= 0:
+			loc.Kind = ui.LocationRowHeader
+		case loc.X >= 0 && loc.Y >= 0:
+			loc.Kind = ui.LocationCell
+		}
+		return loc
+	}
+	return ui.Location{}
+}
+
+// visibleLines places the lines on the screen from pos up to end: the frozen lines first,
+// then the ones starting from the first line of the viewport. Hidden lines are skipped,
+// the last line is cut by end. Returns the lines and the number of the line following
+// the last one.
+func visibleLines(frozen, first, pos, end int, size func(n int) (int, bool)) ([]screenLine, int) {
+	var lines []screenLine
+	n := 0
+	for pos < end {
+		if n == frozen && n < first {
+			n = first
+		}
+		chars, hidden := size(n)
+		if !hidden {
+			if pos+chars > end {
+				chars = end - pos
+			}
+			lines = append(lines, screenLine{n, pos, chars})
+			pos += chars
+		}
+		n++
+	}
+	if n < first {
+		n = first
+	}
+	return lines, n
+}
+
+// Цвета лексем формулы в строке формул и в редакторе.
+var tokenColors = map[int]tcell.Color{
+	formula.OutputTypeOperator: tcell.ColorYellow,
+	formula.OutputTypeNumber:   tcell.ColorFuchsia,
+	formula.OutputTypeBoolean:  tcell.ColorFuchsia,
+	formula.OutputTypeString:   tcell.ColorOlive,
+	formula.OutputTypeFunction: tcell.ColorAqua,
+	formula.OutputTypeSheet:    tcell.ColorTeal,
+	formula.OutputTypeCell:     tcell.ColorLime,
+}
+
+// Цвета ссылок редактируемой формулы: ссылка в редакторе выводится тем же цветом,
+// что и ячейки, на которые она указывает.
+var refColors = []tcell.Color{
+	tcell.ColorRoyalBlue,
+	tcell.ColorSeaGreen,
+	tcell.ColorDarkViolet,
+	tcell.ColorDarkOrange,
+	tcell.ColorDarkCyan,
+	tcell.ColorSaddleBrown,
+}
+
+// refColor returns the color of the reference N of the formula, counting from 1.
+func refColor(n int) tcell.Color {
+	return refColors[(n-1)%len(refColors)]
+}
+
+// tokenStyle returns the style of the formula token of the type.
+func tokenStyle(t int, st tcell.Style) tcell.Style {
+	if c, ok := tokenColors[t]; ok {
+		return st.Foreground(c)
+	}
+	return st
+}
+
+// tokenStyles returns the styles of the runes of the formula text colored by its tokens.
+func tokenStyles(text string, st tcell.Style) []tcell.Style {
+	var styles []tcell.Style
+	for _, token := range formula.Tokenize(text) {
+		for range token.Text {
+			styles = append(styles, tokenStyle(token.Type, st))
+		}
+	}
+	return styles
+}
+
+// drawRunes draws the runes with their styles in a line of the width, the rest of the
+// line is filled with spaces. Text which does not fit is cut with '>' mark.
+func (t *Termbox) drawRunes(x, y, width int, runes []rune, styles []tcell.Style, fill tcell.Style) {
+	for i := 0; i < width; i++ {
+		char, st := ' ', fill
+		if i < len(runes) {
+			char, st = runes[i], styles[i]
+			if len(runes) > width && i == width-1 {
+				char, st = '>', fill.Foreground(tcell.ColorYellow)
+			}
+		}
+		t.screen.SetContent(x+i, y, char, nil, st)
 	}
-	t.dirty = 0
-	t.screen.Show()
 }
 
 func (t *Termbox) drawCell(x int, y int, width int, height int, text string, fg tcell.Color, bg tcell.Color) {
@@ -179,6 +535,68 @@ func (t *Termbox) drawCell(x int, y int, width int, height int, text string, fg
 	}
 }
 
+// drawStyledCell draws the value of a sheet cell with its style. Text which does not
+// fit is cut with '>' mark, wrapped text goes on the next lines of the cell.
+// Left and right borders take a column of the cell, bottom border underlines its last
+// line; top border can not be shown in the terminal.
+func (t *Termbox) drawStyledCell(x, y, width, height int, text string, st tcell.Style, style sheet.Style) {
+	textX, textWidth := x, width
+	if style.Borders&sheet.BorderLeft != 0 && textWidth > 1 {
+		t.screen.SetContent(x, y, '│', nil, st)
+		textX, textWidth = textX+1, textWidth-1
+	}
+	if style.Borders&sheet.BorderRight != 0 && textWidth > 1 {
+		textWidth--
+	}
+	lines := strings.Split(text, "\n")
+	if style.Wrap {
+		lines = ui.WrapText(text, textWidth)
+	}
+	for i := 0; i < height; i++ {
+		lineSt := st
+		if i == height-1 && style.Borders&sheet.BorderBottom != 0 {
+			lineSt = lineSt.Underline(true)
+		}
+		if textX > x {
+			t.screen.SetContent(x, y+i, '│', nil, lineSt)
+		}
+		if textX+textWidth < x+width {
+			t.screen.SetContent(textX+textWidth, y+i, '│', nil, lineSt)
+		}
+		var line []rune
+		cut := false
+		if i < len(lines) {
+			line, cut = ui.TextCells(lines[i], textWidth)
+		}
+		offset := 0
+		if len(line) < textWidth {
+			switch style.Align {
+			case sheet.AlignRight:
+				offset = textWidth - len(line)
+			case sheet.AlignCenter:
+				offset = (textWidth - len(line)) / 2
+			}
+		}
+		for cx := 0; cx < textWidth; cx++ {
+			char, charSt := ' ', lineSt
+			if n := cx - offset; n >= 0 && n < len(line) {
+				char = line[n]
+				if char == 0 {
+					// the second cell of the wide character drawn before
+					continue
+				}
+				if style.Underline {
+					charSt = charSt.Underline(true)
+				}
+				if cut && n == len(line)-1 {
+					charSt = charSt.Foreground(tcell.ColorYellow)
+				}
+			}
+			t.screen.SetContent(textX+cx, y+i, char, nil, charSt)
+		}
+	}
+}
+
 func pixelsToCharsX(pixels int) int {
 	res := pixels / pixelsInCharX
 	if res < 1 {
@@ -0,0 +1,54 @@
+package termbox
+
+import (
+	"xl/ui"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocate(t *testing.T) {
+	// two windows side by side on a 40x11 screen, the status line is the last row;
+	// in each window the vertical ruler takes 4 chars, columns are 10 chars wide
+	windows := []windowGeometry{
+		{
+			area:        rect{0, 0, 20, 10},
+			vRulerWidth: 4,
+			cols:        []screenLine{{0, 4, 10}, {1, 14, 6}},
+			rows:        []screenLine{{0, 1, 1}, {5, 2, 2}},
+		},
+		{
+			area:        rect{21, 0, 19, 10},
+			vRulerWidth: 4,
+			cols:        []screenLine{{3, 25, 10}},
+			rows:        []screenLine{{7, 1, 1}},
+		},
+	}
+	tabs := []sheetTab{{0, 0, 8}, {1, 8, 8}}
+	testCases := []struct {
+		x, y int
+		loc  ui.Location
+	}{
+		{5, 1, ui.Location{Kind: ui.LocationCell, Window: 0, X: 0, Y: 0}},
+		// the row 5 is two lines high
+		{15, 3, ui.Location{Kind: ui.LocationCell, Window: 0, X: 1, Y: 5}},
+		{2, 2, ui.Location{Kind: ui.LocationRowHeader, Window: 0, X: -1, Y: 5}},
+		{5, 0, ui.Location{Kind: ui.LocationColHeader, Window: 0, X: 0, Y: -1}},
+		// the last char of the column header is its border
+		{13, 0, ui.Location{Kind: ui.LocationColBorder, Window: 0, X: 0, Y: -1}},
+		{19, 0, ui.Location{Kind: ui.LocationColBorder, Window: 0, X: 1, Y: -1}},
+		{26, 1, ui.Location{Kind: ui.LocationCell, Window: 1, X: 3, Y: 7}},
+		// below the last row and in the corner of the rulers there are no cells
+		{26, 5, ui.Location{Kind: ui.LocationNone, Window: 1, X: 3, Y: -1}},
+		{1, 0, ui.Location{Kind: ui.LocationNone, Window: 0, X: -1, Y: -1}},
+		// the separator between the windows
+		{20, 1, ui.Location{}},
+		{3, 10, ui.Location{Kind: ui.LocationSheetTab, Sheet: 0}},
+		{9, 10, ui.Location{Kind: ui.LocationSheetTab, Sheet: 1}},
+		{20, 10, ui.Location{}},
+	}
+	for _, c := range testCases {
+		assert.Equal(t, c.loc, locate(c.x, c.y, 10, windows, tabs), "%d:%d", c.x, c.y)
+	}
+}
@@ -3,6 +3,9 @@ package termbox
 import (
 	"xl/ui"
 
+	"io"
+	"os"
+
 	"github.com/gdamore/tcell"
 )
 
@@ -15,17 +18,18 @@ type Termbox struct {
 	// Screen object
 	screen tcell.Screen
 
+	// Терминал, в который пишутся управляющие последовательности, не поддерживаемые tcell.
+	tty io.Writer
+
 	// Value of termbox.Size()
 	screenWidth  int
 	screenHeight int
 
-	// How many rows and columns are visible for last drawing iteration.
+	// How many rows and columns of the current window viewport are visible for last
+	// drawing iteration, frozen ones are not counted.
 	calculatedViewportWidth  int
 	calculatedViewportHeight int
 
-	// Length in chars of vertical ruler for last drawing iteration.
-	vRulerWidth int
-
 	// Cursor position for last drawing iteration.
 	lastCursorX int
 	lastCursorY int
@@ -33,6 +37,11 @@ type Termbox struct {
 	// What need to redrawn on next draw iteration.
 	dirty ui.DirtyFlag
 
+	// Windows and sheet tabs placed on the screen for last drawing iteration, they are
+	// used to find what is under the mouse.
+	windows []windowGeometry
+	tabs    []sheetTab
+
 	// Message displaying in status line and its decoration flags.
 	statusMessage string
 	statusFlags   int
@@ -47,9 +56,11 @@ func New() *Termbox {
 	} else if e = s.Init(); e != nil {
 		panic(e)
 	}
+	s.EnableMouse()
 	width, height := s.Size()
 	return &Termbox{
 		screen:       s,
+		tty:          os.Stdout,
 		screenWidth:  width,
 		screenHeight: height,
 		dirty:        ui.DirtyHRuler | ui.DirtyVRuler | ui.DirtyGrid | ui.DirtyFormulaLine | ui.DirtyStatusLine,
@@ -0,0 +1,68 @@
+package ui
+
+import (
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Разбиение текста ячеек на строки и знакоместа. Нужно и для отрисовки, и для подбора
+// размеров строк и колонок листа, поэтому не зависит от конкретной реализации вывода.
+// Иероглифы и другие широкие символы занимают по два знакоместа терминала.
+
+// WrapText splits the text into lines not wider than width cells of the terminal,
+// breaking them between words where possible.
+func WrapText(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line, lineWidth := []rune{}, 0
+		for _, word := range strings.Fields(paragraph) {
+			if lineWidth > 0 && lineWidth+1+runewidth.StringWidth(word) > width {
+				lines = append(lines, string(line))
+				line, lineWidth = line[:0:0], 0
+			}
+			if lineWidth > 0 {
+				line, lineWidth = append(line, ' '), lineWidth+1
+			}
+			for _, r := range word {
+				w := runewidth.RuneWidth(r)
+				if lineWidth > 0 && lineWidth+w > width {
+					lines = append(lines, string(line))
+					line, lineWidth = line[:0:0], 0
+				}
+				line, lineWidth = append(line, r), lineWidth+w
+			}
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// TextCells lays the line of text out in the cells of the terminal. The second cell
+// of a wide character is 0, characters of zero width are dropped. The line wider than
+// width is cut with '>' in its last cell, then reports true.
+func TextCells(line string, width int) ([]rune, bool) {
+	var cells []rune
+	for _, r := range line {
+		switch runewidth.RuneWidth(r) {
+		case 0:
+		case 2:
+			cells = append(cells, r, 0)
+		default:
+			cells = append(cells, r)
+		}
+	}
+	if len(cells) <= width {
+		return cells, false
+	}
+	cells = cells[:width]
+	if width > 1 && cells[width-1] == 0 {
+		// the wide character is not shown by half
+		cells[width-2] = ' '
+	}
+	cells[width-1] = '>'
+	return cells, true
+}
@@ -0,0 +1,35 @@
+package ui
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapText(t *testing.T) {
+	assert.Equal(t, []string{"one two", "three"}, WrapText("one two three", 7))
+	assert.Equal(t, []string{"abcd", "ef", "x"}, WrapText("abcdef\nx", 4))
+	// wide characters take two cells
+	assert.Equal(t, []string{"汉字", "表 a"}, WrapText("汉字表 a", 4))
+}
+
+func TestTextCells(t *testing.T) {
+	testCases := []struct {
+		line  string
+		width int
+		cells []rune
+		cut   bool
+	}{
+		{"abc", 5, []rune("abc"), false},
+		{"汉字", 4, []rune{'汉', 0, '字', 0}, false},
+		{"abcdef", 4, []rune("abc>"), true},
+		{"汉字表", 4, []rune{'汉', 0, ' ', '>'}, true},
+		{"汉字表", 5, []rune{'汉', 0, '字', 0, '>'}, true},
+		{"e\u0301", 2, []rune("e"), false},
+	}
+	for _, c := range testCases {
+		cells, cut := TextCells(c.line, c.width)
+		assert.Equalf(t, c.cells, cells, "case %s %d", c.line, c.width)
+		assert.Equalf(t, c.cut, cut, "case %s %d", c.line, c.width)
+	}
+}